@@ -1,6 +1,6 @@
 module github.com/yaanno/upload-store-process
 
-go 1.23.5
+go 1.25.0
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.2.1
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	storagev1 "github.com/yaanno/upload-store-process/gen/go/filestorage/v1"
 
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type FileUploadHandler interface {
@@ -19,6 +22,9 @@ type FileUploadHandler interface {
 	DeleteFile(w http.ResponseWriter, r *http.Request)
 	GetFileStatus(w http.ResponseWriter, r *http.Request)
 	GetFileMetadata(w http.ResponseWriter, r *http.Request)
+	GetUsage(w http.ResponseWriter, r *http.Request)
+	ListDeleted(w http.ResponseWriter, r *http.Request)
+	RestoreFile(w http.ResponseWriter, r *http.Request)
 }
 
 type FileUploadHandlerImpl struct {
@@ -51,6 +57,12 @@ func (h *FileUploadHandlerImpl) PrepareUpload(w http.ResponseWriter, r *http.Req
 	response, err := h.service.PrepareUpload(ctx, grpcRequest)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("gRPC prepareupload failed")
+		if status.Code(err) == codes.ResourceExhausted {
+			http.Error(w, "Storage quota exceeded", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to prepare upload", http.StatusInternalServerError)
+		return
 	}
 
 	// Log successful upload
@@ -129,14 +141,33 @@ func (h *FileUploadHandlerImpl) DeleteFile(w http.ResponseWriter, r *http.Reques
 
 	fileId := chi.URLParam(r, "id")
 
+	// Deleted files go to the trash unless force_delete=true is passed
+	forceDelete := false
+	if value := r.URL.Query().Get("force_delete"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid force_delete", http.StatusBadRequest)
+			return
+		}
+		forceDelete = parsed
+	}
+
 	grpcRequest := &storagev1.DeleteFileRequest{
 		FileId:      fileId,
-		ForceDelete: false,
+		ForceDelete: forceDelete,
 		UserId:      "1", // TODO: get user ID from JWT
 	}
 	response, err := h.service.DeleteFile(ctx, grpcRequest)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("gRPC delete file failed")
+		switch status.Code(err) {
+		case codes.NotFound:
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		case codes.FailedPrecondition:
+			http.Error(w, "File is under retention", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
 		return
 	}
@@ -150,6 +181,91 @@ func (h *FileUploadHandlerImpl) DeleteFile(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetUsage returns the user's storage usage and quota limits
+func (h *FileUploadHandlerImpl) GetUsage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	grpcRequest := &storagev1.GetUsageRequest{
+		UserId: "1", // TODO: get user ID from JWT
+	}
+
+	response, err := h.service.GetUsage(ctx, grpcRequest)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("gRPC get usage failed")
+		if status.Code(err) == codes.Unimplemented {
+			http.Error(w, "Storage quotas are not enabled", http.StatusNotImplemented)
+			return
+		}
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"used_bytes":     response.GetUsedBytes(),
+		"used_files":     response.GetUsedFiles(),
+		"reserved_bytes": response.GetReservedBytes(),
+		"reserved_files": response.GetReservedFiles(),
+		"max_bytes":      response.GetMaxBytes(),
+		"max_files":      response.GetMaxFiles(),
+	})
+}
+
+// ListDeleted lists the files in the user's trash
+func (h *FileUploadHandlerImpl) ListDeleted(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	grpcRequest := &storagev1.ListDeletedRequest{
+		UserId: "1", // TODO: get user ID from JWT
+	}
+
+	response, err := h.service.ListDeleted(ctx, grpcRequest)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("gRPC list deleted files failed")
+		http.Error(w, "Failed to list deleted files", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RestoreFile moves a file out of the user's trash
+func (h *FileUploadHandlerImpl) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	fileId := chi.URLParam(r, "id")
+	if fileId == "" {
+		http.Error(w, "Failed to read file ID", http.StatusBadRequest)
+		return
+	}
+
+	grpcRequest := &storagev1.RestoreFileRequest{
+		FileId: fileId,
+		UserId: "1", // TODO: get user ID from JWT
+	}
+
+	response, err := h.service.RestoreFile(ctx, grpcRequest)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("gRPC restore file failed")
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "File not found in trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to restore file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"file_id": response.GetFileId(),
+		"message": response.GetBaseResponse().GetMessage(),
+	})
+}
+
 func (h *FileUploadHandlerImpl) GetFileStatus(w http.ResponseWriter, r *http.Request) {
 	_, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
@@ -20,6 +20,9 @@ func SetupRouter(uploadHandler handler.FileUploadHandler, healthCheckHandler han
 		r.Get("/metadata/{id}", uploadHandler.GetFileMetadata)
 		r.Post("/prepare-upload", uploadHandler.PrepareUpload)
 		r.Delete("/delete/{id}", uploadHandler.DeleteFile)
+		r.Get("/usage", uploadHandler.GetUsage)
+		r.Get("/trash", uploadHandler.ListDeleted)
+		r.Post("/trash/{id}/restore", uploadHandler.RestoreFile)
 		// Other
 		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
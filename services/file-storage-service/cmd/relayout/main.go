@@ -0,0 +1,111 @@
+// Command relayout moves files stored flat by the local storage provider into
+// the sharded directory layout. The service can keep running meanwhile: reads
+// fall back to the flat path until a file has been moved. The base path and
+// layout are those of the storage configuration, so the service finds the
+// moved files, and the storage paths recorded in the database follow them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	database "github.com/yaanno/upload-store-process/services/file-storage-service/internal/database/sqlite"
+	repository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	storageProvider "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	filesystem "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/local"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/config"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const serviceName = "file-storage-service"
+
+func main() {
+	basePath := flag.String("base-path", "", "storage base path of the local provider; must match the configuration if set")
+	depth := flag.Int("shard-depth", 0, "number of shard directory levels; must match the configuration if set")
+	width := flag.Int("shard-width", 0, "hex characters per shard directory name; must match the configuration if set")
+	dryRun := flag.Bool("dry-run", false, "only report how many files would be moved")
+	flag.Parse()
+
+	cfg, err := config.Load(serviceName, &config.ServiceConfig{
+		Logging: logger.LoggerConfig{Level: "info", Development: true},
+		Database: config.DatabaseConfig{
+			Driver: "sqlite",
+			Path:   "/data/storage.db",
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "relayout: failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Logging)
+
+	if err := checkFlags(cfg.Storage, *basePath, *depth, *width); err != nil {
+		fmt.Fprintf(os.Stderr, "relayout: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.NewDatabase(ctx, cfg.Database.Path)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open database")
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	metadataRepository, err := repository.NewRepository(repository.SQLite, db, log)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to initialize metadata repository")
+		os.Exit(1)
+	}
+	metadataService := repository.NewMetadataService(metadataRepository, nil, nil, nil, log)
+
+	layout := filesystem.Layout{Depth: cfg.Storage.ShardDepth, Width: cfg.Storage.ShardWidth}
+	result, err := filesystem.MigrateLayout(ctx, cfg.Storage.BasePath, layout, metadataService, *dryRun, log)
+	if err != nil {
+		log.Error().Err(err).Msg("Layout migration failed")
+		os.Exit(1)
+	}
+	if len(result.Failed) > 0 {
+		log.Error().Strs("fileIds", result.Failed).Msg("Some files could not be migrated, rerun to retry")
+		os.Exit(1)
+	}
+}
+
+// checkFlags refuses to migrate into a layout the service does not read:
+// anything but a sharded local provider, or flags contradicting the
+// configuration
+func checkFlags(storageCfg config.Storage, basePath string, depth, width int) error {
+	if storageCfg.Provider != string(storageProvider.Local) {
+		return fmt.Errorf("the configured storage provider is %q, not local", storageCfg.Provider)
+	}
+	if storageCfg.BasePath == "" {
+		return errors.New("the configuration has no storage base path")
+	}
+	layout := filesystem.Layout{Depth: storageCfg.ShardDepth, Width: storageCfg.ShardWidth}
+	if layout.IsFlat() {
+		return errors.New("the configured layout is flat, set storage shard_depth and shard_width first")
+	}
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch {
+		case f.Name == "base-path" && basePath != storageCfg.BasePath:
+			err = fmt.Errorf("-base-path %s does not match the configured %s", basePath, storageCfg.BasePath)
+		case f.Name == "shard-depth" && depth != storageCfg.ShardDepth:
+			err = fmt.Errorf("-shard-depth %d does not match the configured %d", depth, storageCfg.ShardDepth)
+		case f.Name == "shard-width" && width != storageCfg.ShardWidth:
+			err = fmt.Errorf("-shard-width %d does not match the configured %d", width, storageCfg.ShardWidth)
+		}
+	})
+	return err
+}
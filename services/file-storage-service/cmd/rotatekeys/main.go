@@ -0,0 +1,86 @@
+// Command rotatekeys re-wraps the data keys of encrypted files with the
+// current master key from the storage encryption configuration. File bodies
+// are not rewritten. Once it reports no failures, retired master keys can be
+// removed from the configuration.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	database "github.com/yaanno/upload-store-process/services/file-storage-service/internal/database/sqlite"
+	repository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	storageProvider "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/config"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const serviceName = "file-storage-service"
+
+func main() {
+	batchSize := flag.Int("batch-size", 500, "number of data keys read per batch")
+	dryRun := flag.Bool("dry-run", false, "only check that every data key can be unwrapped")
+	flag.Parse()
+
+	cfg, err := config.Load(serviceName, &config.ServiceConfig{
+		Logging: logger.LoggerConfig{Level: "info", Development: true},
+		Database: config.DatabaseConfig{
+			Driver: "sqlite",
+			Path:   "/data/storage.db",
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rotatekeys: failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Logging)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	keyring, err := storageProvider.NewKeyring(cfg.Storage.Encryption.CurrentKeyID, cfg.Storage.Encryption.MasterKeys)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid encryption configuration")
+		os.Exit(1)
+	}
+
+	db, err := database.NewDatabase(ctx, cfg.Database.Path)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open database")
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	dataKeys, err := repository.NewDataKeyRepository(repository.SQLite, db, log)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to initialize data key repository")
+		os.Exit(1)
+	}
+
+	result, err := storageProvider.RotateDataKeys(ctx, dataKeys, keyring, *batchSize, *dryRun, log)
+	if err != nil {
+		log.Error().Err(err).Msg("Data key rotation failed")
+		os.Exit(1)
+	}
+	if len(result.Failed) > 0 {
+		log.Error().Strs("fileIds", result.Failed).Msg("Some data keys could not be rotated, rerun to retry")
+		os.Exit(1)
+	}
+	if result.Rotated == 0 && cfg.Storage.Encryption.Enabled {
+		// Nothing left to rotate is fine, no data keys at all means the
+		// database is not the one the server writes to
+		existing, err := dataKeys.ListDataKeys(ctx, "", "", 1)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to list data keys")
+			os.Exit(1)
+		}
+		if len(existing) == 0 {
+			log.Error().Str("path", cfg.Database.Path).Msg("No data keys found although encryption is enabled, check the database path")
+			os.Exit(1)
+		}
+	}
+}
@@ -2,31 +2,57 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 
 	storagev1 "github.com/yaanno/upload-store-process/gen/go/filestorage/v1"
 	"github.com/yaanno/upload-store-process/services/file-storage-service/interceptor"
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
 	database "github.com/yaanno/upload-store-process/services/file-storage-service/internal/database/sqlite"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
 	healthchecker "github.com/yaanno/upload-store-process/services/file-storage-service/internal/health"
 	repository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	memoryRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/memory"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
 	storageProvider "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/cas"
+	filesystem "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/local"
+	s3storage "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/s3"
 	grpcHandler "github.com/yaanno/upload-store-process/services/file-storage-service/internal/transport/grpc/handlers"
 	handler "github.com/yaanno/upload-store-process/services/file-storage-service/internal/transport/http/handlers"
 	router "github.com/yaanno/upload-store-process/services/file-storage-service/internal/transport/http/router"
 	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/auth"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/config"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 )
 
-const serviceName = "file-storage-service"
+const (
+	serviceName = "file-storage-service"
+
+	defaultRepairInterval  = time.Minute
+	defaultRepairBatchSize = 100
+
+	defaultTieringInterval  = time.Hour
+	defaultTieringBatchSize = 100
+
+	defaultScrubInterval = 24 * time.Hour
+
+	defaultReconcileInterval = 24 * time.Hour
+	defaultReconcileMinAge   = time.Hour
+
+	defaultTrashInterval = time.Hour
+)
 
 func main() {
 
@@ -45,35 +71,128 @@ func main() {
 	ctx := context.Background()
 
 	// 3. Initialize Database
-	db, err := database.InitializeTestDatabase(ctx)
+	// Wrapped data keys, quotas and blob references must outlive restarts
+	// and be visible to cmd/rotatekeys, so the database is the configured file
+	if err := os.MkdirAll(filepath.Dir(cfg.Database.Path), 0755); err != nil {
+		serviceLogger.Error().Err(err).Str("path", cfg.Database.Path).Msg("Failed to create database directory")
+		os.Exit(1)
+	}
+	db, err := database.NewDatabase(ctx, cfg.Database.Path)
 	if err != nil {
 		serviceLogger.Error().
 			Err(err).
-			Msg("Failed to initialize test database")
+			Str("path", cfg.Database.Path).
+			Msg("Failed to initialize database")
 		os.Exit(1)
 	}
+	defer db.Close()
+
+	// The memory driver keeps file metadata out of SQLite, along with the
+	// repositories that read it
+	metadataRepoType, metadataDb := repository.SQLite, interface{}(db)
+	if repository.RepositoryType(cfg.Database.Driver) == repository.Memory {
+		metadataRepoType, metadataDb = repository.Memory, memoryRepository.NewDatabase()
+		serviceLogger.Info().Msg("File metadata is kept in memory")
+	}
 
 	// 5. Initialize Repositories, Services, and Middleware
-	metadataRepository, err := repository.NewRepository("sqlite", db, &wrappedLogger)
+	metadataRepository, err := repository.NewRepository(metadataRepoType, metadataDb, &wrappedLogger)
 	if err != nil {
 		serviceLogger.Error().Err(err).Msg("Failed to initialize metadata repository, service exiting")
 		os.Exit(1)
 	}
-	metadataService := repository.NewMetadataService(metadataRepository, &wrappedLogger)
+	metadataRepository = repository.NewRetryingRepository(metadataRepository, retryConfig(cfg.Storage.Retry.Metadata), &wrappedLogger)
+	quotaService, err := initializeQuotaService(cfg.Storage.Quotas, db, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize storage quotas, service exiting")
+		os.Exit(1)
+	}
+	// Cleaning up expired uploads skips retained files, so retention lives
+	// beside the file metadata
+	retentionRepository, err := repository.NewRetentionRepository(metadataRepoType, metadataDb, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize retention repository, service exiting")
+		os.Exit(1)
+	}
+	// Buckets are checked against the file metadata, so they live beside it
+	bucketRepository, err := repository.NewBucketRepository(metadataRepoType, metadataDb, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize bucket repository, service exiting")
+		os.Exit(1)
+	}
+	metadataService := repository.NewMetadataService(metadataRepository, quotaService, retentionRepository, bucketRepository, &wrappedLogger)
 
 	// 4. Initialize Storage Provider
-	storage, err := initializeStorageProvider(cfg.Storage, metadataService, &wrappedLogger)
+	breakers := circuit.NewRegistry()
+	breakerCfg := circuitBreakerConfig(cfg.Storage.CircuitBreaker, breakers, &wrappedLogger)
+	storage, err := initializeStorageProvider(cfg.Storage, db, metadataService, breakerCfg, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize storage provider, service exiting")
+		os.Exit(1)
+	}
+
+	if mirror, ok := storage.(*storageProvider.MirroredProvider); ok {
+		go repairReplicas(ctx, mirror, cfg.Storage.Mirror, &wrappedLogger)
+	}
+	if tiered, ok := storage.(*storageProvider.TieredProvider); ok {
+		go applyTierPolicies(ctx, tiered, cfg.Storage.Tiering, &wrappedLogger)
+	}
+
+	// The scrubber checks the stored bytes, so it gets the undecorated provider
+	scrubber, err := initializeScrubber(metadataRepoType, metadataDb, storage, metadataService, cfg.Storage.Scrub, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize integrity scrubber, service exiting")
+		os.Exit(1)
+	}
+	if cfg.Storage.Scrub.Enabled {
+		go scrubPeriodically(ctx, scrubber, cfg.Storage.Scrub, &wrappedLogger)
+	}
+	reconciler, err := initializeReconciler(metadataRepoType, metadataDb, storage, metadataService, cfg.Storage.Reconcile, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize reconciler, service exiting")
+		os.Exit(1)
+	}
+	if cfg.Storage.Reconcile.Enabled {
+		go reconcilePeriodically(ctx, reconciler, cfg.Storage.Reconcile, &wrappedLogger)
+	}
+
+	storage, err = decorateStorageProvider(cfg.Storage, storage, metadataService, &wrappedLogger)
 	if err != nil {
 		serviceLogger.Error().Err(err).Msg("Failed to initialize storage provider, service exiting")
 		os.Exit(1)
 	}
+	// Versioning routes reads by file ID, so it wraps every other layer
+	if cfg.Storage.Versioning.Enabled {
+		storage, err = initializeVersioning(db, storage, metadataService, cfg.Storage.Versioning, &wrappedLogger)
+		if err != nil {
+			serviceLogger.Error().Err(err).Msg("Failed to initialize file versioning, service exiting")
+			os.Exit(1)
+		}
+	}
 
-	uploadService := upload.NewUploadService(metadataRepository, storage, &wrappedLogger)
+	// Retention guards every delete, including those of versioning and purging
+	storage = storageProvider.NewRetainingProvider(storage, retentionRepository, &wrappedLogger)
 
-	healthChecker := healthchecker.NewHealthChecker(db, cfg.Storage.BasePath)
+	sweepTempFiles(ctx, storage, &wrappedLogger)
+
+	// Purging removes every version of a file, so it gets the outermost provider
+	trashPurger, err := initializeTrashPurger(metadataRepoType, metadataDb, storage, metadataService, cfg.Storage.Trash, &wrappedLogger)
+	if err != nil {
+		serviceLogger.Error().Err(err).Msg("Failed to initialize trash purger, service exiting")
+		os.Exit(1)
+	}
+	go purgeTrashPeriodically(ctx, trashPurger, cfg.Storage.Trash, &wrappedLogger)
+
+	if err := upload.ValidateDigestAlgorithms(cfg.Upload.Digests); err != nil {
+		serviceLogger.Error().Err(err).Msg("Invalid upload digest configuration, service exiting")
+		os.Exit(1)
+	}
+	uploadService := upload.NewUploadService(metadataRepository, storage, quotaService, cfg.Upload.Digests, &wrappedLogger)
+
+	healthChecker := healthchecker.NewHealthChecker(db, storage, breakers)
 
 	// TODO: this should be the storageServiceServer because the handlers implement the same interface
-	fileOperationHandler := grpcHandler.NewFileOperationdHandler(metadataService, &wrappedLogger)
+	fileOperationHandler := grpcHandler.NewFileOperationdHandler(metadataService, storage, quotaService, &wrappedLogger)
 
 	// 7. Initialize gRPC Server
 	grpcServer, grpcListener, err := initializeGRPCServer(cfg, &wrappedLogger)
@@ -87,8 +206,12 @@ func main() {
 
 	uploadHandler := handler.NewFileUploadHandler(&wrappedLogger, uploadService)
 	healthHandler := handler.NewHealthHandler(&serviceLogger, healthChecker)
-	houseKeepingHandler := handler.NewHouseKeepingHandler(metadataService, &wrappedLogger)
-	router := router.SetupRouter(uploadHandler, healthHandler, houseKeepingHandler)
+	houseKeepingHandler := handler.NewHouseKeepingHandler(metadataService, trashPurger, &wrappedLogger)
+	scrubHandler := handler.NewScrubHandler(scrubber, &wrappedLogger)
+	reconcileHandler := handler.NewReconcileHandler(reconciler, &wrappedLogger)
+	retentionHandler := handler.NewRetentionHandler(metadataService, &wrappedLogger)
+	bucketHandler := handler.NewBucketHandler(metadataService, &wrappedLogger)
+	router := router.SetupRouter(uploadHandler, healthHandler, houseKeepingHandler, scrubHandler, reconcileHandler, retentionHandler, bucketHandler, auth.NewTokenGenerator(cfg.JWT.Secret, cfg.JWT.Issuer))
 
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", cfg.HttpServer.Host, cfg.HttpServer.Port),
@@ -152,26 +275,491 @@ func validateConfig(cfg *config.ServiceConfig) error {
 	if cfg.JWT.Secret == "" {
 		return errors.New("JWT secret must be configured")
 	}
-	if cfg.Storage.BasePath == "" {
-		return errors.New("storage base path must be configured")
+	if repository.RepositoryType(cfg.Database.Driver) == repository.Memory {
+		// These keep per-file records in SQLite, which would not match the
+		// files kept in memory
+		if cfg.Storage.Versioning.Enabled || cfg.Storage.Quotas.Enabled {
+			return errors.New("file versioning and storage quotas need the sqlite database driver")
+		}
+		switch storageProvider.ProviderType(cfg.Storage.Provider) {
+		case storageProvider.Tiered, storageProvider.ContentAddressed, storageProvider.Mirror:
+			return fmt.Errorf("the %s storage provider needs the sqlite database driver", cfg.Storage.Provider)
+		}
+	}
+	switch storageProvider.ProviderType(cfg.Storage.Provider) {
+	case storageProvider.Memory:
+	case storageProvider.S3:
+		if cfg.Storage.S3.Endpoint == "" || cfg.Storage.S3.Bucket == "" {
+			return errors.New("storage s3 endpoint and bucket must be configured")
+		}
+	case storageProvider.Mirror:
+		if len(cfg.Storage.Mirror.Replicas) == 0 {
+			return errors.New("storage mirror replicas must be configured")
+		}
+		for _, replica := range cfg.Storage.Mirror.Replicas {
+			if replica.Name == "" || replica.BasePath == "" {
+				return errors.New("storage mirror replicas need a name and base path")
+			}
+		}
+	case storageProvider.Tiered:
+		if len(cfg.Storage.Tiering.Tiers) < 2 {
+			return errors.New("storage tiering needs at least two tiers")
+		}
+		for _, tier := range cfg.Storage.Tiering.Tiers {
+			if tier.Name == "" || tier.BasePath == "" {
+				return errors.New("storage tiers need a name and base path")
+			}
+		}
+	case storageProvider.Local, storageProvider.ContentAddressed:
+		if cfg.Storage.BasePath == "" {
+			return errors.New("storage base path must be configured")
+		}
+	default:
+		return fmt.Errorf("unknown storage provider %q", cfg.Storage.Provider)
 	}
 	return nil
 }
 
-func initializeStorageProvider(storageCfg config.Storage, metadataService repository.MetadataService, logger *logger.Logger) (storageProvider.Provider, error) {
-	// if storageCfg.Provider == "local" {
-	providerConfig := &storageProvider.Config{
-		BasePath:        storageCfg.BasePath,
-		MetadataService: metadataService,
+// circuitBreakerConfig builds the storage circuit breaker settings, logging
+// every state change and reporting the breakers to breakers
+func circuitBreakerConfig(breakerCfg config.CircuitBreaker, breakers *circuit.Registry, logger *logger.Logger) circuit.Config {
+	cfg := circuit.Config{
+		Settings:   breakerSettings(breakerCfg.CircuitBreakerSettings),
+		Operations: make(map[string]circuit.Settings, len(breakerCfg.Operations)),
+		Registry:   breakers,
+	}
+	cfg.OnStateChange = func(name string, from, to circuit.State) {
+		logger.Warn().
+			Str("breaker", name).
+			Str("from", from.String()).
+			Str("to", to.String()).
+			Msg("Circuit breaker state changed")
+	}
+	for operation, settings := range breakerCfg.Operations {
+		cfg.Operations[operation] = breakerSettings(settings)
+	}
+	return cfg
+}
+
+func breakerSettings(settings config.CircuitBreakerSettings) circuit.Settings {
+	return circuit.Settings{
+		MaxFailures:    settings.MaxFailures,
+		ResetTimeout:   settings.ResetTimeout,
+		HalfOpenProbes: settings.HalfOpenProbes,
+	}
+}
+
+func retryConfig(policy config.RetryPolicy) retry.Config {
+	cfg := retry.Config{
+		Policy:     retryPolicy(policy.RetrySettings),
+		Operations: make(map[string]retry.Policy, len(policy.Operations)),
+	}
+	for operation, settings := range policy.Operations {
+		cfg.Operations[operation] = retryPolicy(settings)
+	}
+	return cfg
+}
+
+func retryPolicy(settings config.RetrySettings) retry.Policy {
+	return retry.Policy{
+		MaxAttempts:     settings.MaxAttempts,
+		InitialInterval: settings.InitialInterval,
+		MaxInterval:     settings.MaxInterval,
+		Multiplier:      settings.Multiplier,
+		MaxElapsedTime:  settings.MaxElapsedTime,
+	}
+}
+
+func initializeStorageProvider(storageCfg config.Storage, db *sql.DB, metadataService repository.MetadataService, breakerCfg circuit.Config, logger *logger.Logger) (storageProvider.Provider, error) {
+	switch storageProvider.ProviderType(storageCfg.Provider) {
+	case storageProvider.ContentAddressed:
+		blobRepository, err := repository.NewBlobRepository(repository.SQLite, db, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize blob repository: %w", err)
+		}
+		// Concurrent uploads find the blob tables locked, so retry like metadata
+		blobRepository = repository.NewRetryingBlobRepository(blobRepository, retryConfig(storageCfg.Retry.Metadata), logger)
+		providerConfig := &cas.Config{
+			BasePath:        storageCfg.BasePath,
+			BlobRepository:  blobRepository,
+			MetadataService: metadataService,
+			Breaker:         breakerCfg,
+		}
+		provider, err := storageProvider.NewProvider(storageProvider.ContentAddressed, providerConfig, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize content-addressed storage provider: %w", err)
+		}
+		logger.Info().Str("provider", storageCfg.Provider).Str("basePath", storageCfg.BasePath).Msg("Storage provider initialized")
+		return provider, nil
+	case storageProvider.S3:
+		providerConfig := &s3storage.Config{
+			Endpoint:        storageCfg.S3.Endpoint,
+			Region:          storageCfg.S3.Region,
+			Bucket:          storageCfg.S3.Bucket,
+			Prefix:          storageCfg.S3.Prefix,
+			AccessKeyID:     storageCfg.S3.AccessKeyID,
+			SecretAccessKey: storageCfg.S3.SecretAccessKey,
+			PartSize:        storageCfg.S3.PartSize,
+			Breaker:         breakerCfg,
+		}
+		provider, err := storageProvider.NewProvider(storageProvider.S3, providerConfig, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize s3 storage provider: %w", err)
+		}
+		logger.Info().Str("provider", storageCfg.Provider).Str("endpoint", storageCfg.S3.Endpoint).Str("bucket", storageCfg.S3.Bucket).Msg("Storage provider initialized")
+		return provider, nil
+	case storageProvider.Mirror:
+		replicaRepository, err := repository.NewReplicaRepository(repository.SQLite, db, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize replica repository: %w", err)
+		}
+		providerConfig := &storageProvider.MirrorConfig{
+			WriteQuorum:       storageCfg.Mirror.WriteQuorum,
+			ReplicaRepository: replicaRepository,
+		}
+		layout := filesystem.Layout{Depth: storageCfg.ShardDepth, Width: storageCfg.ShardWidth}
+		for _, replica := range storageCfg.Mirror.Replicas {
+			local, err := storageProvider.NewProvider(storageProvider.Local, &storageProvider.Config{
+				BasePath:        replica.BasePath,
+				MetadataService: metadataService,
+				Layout:          layout,
+				Breaker:         breakerCfg,
+			}, metadataService, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize mirror replica %s: %w", replica.Name, err)
+			}
+			providerConfig.Replicas = append(providerConfig.Replicas, storageProvider.Replica{Name: replica.Name, Provider: local})
+		}
+		provider, err := storageProvider.NewProvider(storageProvider.Mirror, providerConfig, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize mirror storage provider: %w", err)
+		}
+		logger.Info().Str("provider", storageCfg.Provider).Int("replicas", len(providerConfig.Replicas)).Int("writeQuorum", storageCfg.Mirror.WriteQuorum).Msg("Storage provider initialized")
+		return provider, nil
+	case storageProvider.Tiered:
+		tierRepository, err := repository.NewTierRepository(repository.SQLite, db, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize tier repository: %w", err)
+		}
+		providerConfig := &storageProvider.TieringConfig{
+			TierRepository:   tierRepository,
+			AccessResolution: storageCfg.Tiering.AccessResolution,
+		}
+		layout := filesystem.Layout{Depth: storageCfg.ShardDepth, Width: storageCfg.ShardWidth}
+		for _, tier := range storageCfg.Tiering.Tiers {
+			local, err := storageProvider.NewProvider(storageProvider.Local, &storageProvider.Config{
+				BasePath:        tier.BasePath,
+				MetadataService: metadataService,
+				Layout:          layout,
+				Breaker:         breakerCfg,
+			}, metadataService, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize storage tier %s: %w", tier.Name, err)
+			}
+			providerConfig.Tiers = append(providerConfig.Tiers, storageProvider.Tier{Name: tier.Name, Provider: local})
+		}
+		for _, policy := range storageCfg.Tiering.Policies {
+			providerConfig.Policies = append(providerConfig.Policies, storageProvider.TierPolicy{
+				Tier:    policy.Tier,
+				MinAge:  policy.MinAge,
+				MinIdle: policy.MinIdle,
+			})
+		}
+		provider, err := storageProvider.NewProvider(storageProvider.Tiered, providerConfig, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize tiered storage provider: %w", err)
+		}
+		logger.Info().Str("provider", storageCfg.Provider).Int("tiers", len(providerConfig.Tiers)).Int("policies", len(providerConfig.Policies)).Msg("Storage provider initialized")
+		return provider, nil
+	case storageProvider.Memory:
+		provider, err := storageProvider.NewProvider(storageProvider.Memory, nil, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize memory storage provider: %w", err)
+		}
+		logger.Warn().Str("provider", storageCfg.Provider).Msg("Storage provider initialized, files are lost when the service stops")
+		return provider, nil
+	case storageProvider.Local:
+		providerConfig := &storageProvider.Config{
+			BasePath:        storageCfg.BasePath,
+			MetadataService: metadataService,
+			Layout: filesystem.Layout{
+				Depth: storageCfg.ShardDepth,
+				Width: storageCfg.ShardWidth,
+			},
+			Breaker: breakerCfg,
+		}
+		provider, err := storageProvider.NewProvider(storageProvider.Local, providerConfig, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize local storage provider: %w", err)
+		}
+		logger.Info().Str("provider", storageCfg.Provider).Str("basePath", storageCfg.BasePath).Int("shardDepth", storageCfg.ShardDepth).Msg("Storage provider initialized")
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown storage provider %q", storageCfg.Provider)
 	}
-	provider, err := storageProvider.NewProvider("local", providerConfig, metadataService, logger)
+}
+
+// initializeQuotaService returns nil when quotas are disabled
+func initializeQuotaService(quotasCfg config.Quotas, db *sql.DB, logger *logger.Logger) (repository.QuotaService, error) {
+	if !quotasCfg.Enabled {
+		return nil, nil
+	}
+	quotaRepository, err := repository.NewQuotaRepository(repository.SQLite, db, logger)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize local storage provider: %w", err)
+		return nil, fmt.Errorf("failed to initialize quota repository: %w", err)
+	}
+
+	policy := repository.QuotaPolicy{
+		Default: domain.QuotaLimits(quotasCfg.Default),
+		Roles:   make(map[string]domain.QuotaLimits, len(quotasCfg.Roles)),
+		Users:   make(map[string]domain.QuotaLimits, len(quotasCfg.Users)),
+	}
+	for role, limits := range quotasCfg.Roles {
+		policy.Roles[role] = domain.QuotaLimits(limits)
+	}
+	for userID, limits := range quotasCfg.Users {
+		policy.Users[userID] = domain.QuotaLimits(limits)
+	}
+	logger.Info().Int64("maxBytes", policy.Default.MaxBytes).Int64("maxFiles", policy.Default.MaxFiles).Msg("Storage quotas enabled")
+	return repository.NewQuotaService(quotaRepository, policy, logger), nil
+}
+
+// decorateStorageProvider wraps the provider with the optional layers enabled
+// in the storage configuration
+func decorateStorageProvider(storageCfg config.Storage, provider storageProvider.Provider, metadataService repository.MetadataService, logger *logger.Logger) (storageProvider.Provider, error) {
+	// Retries wrap the provider itself, so a retry repeats a single call to it
+	provider = storageProvider.NewRetryingProvider(provider, retryConfig(storageCfg.Retry.Storage), logger)
+	// Encryption wraps next, so content is compressed before it is encrypted
+	if storageCfg.Encryption.Enabled {
+		keyring, err := storageProvider.NewKeyring(storageCfg.Encryption.CurrentKeyID, storageCfg.Encryption.MasterKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize encryption: %w", err)
+		}
+		logger.Info().Str("keyId", keyring.CurrentKeyID()).Msg("Storage encryption enabled")
+		provider = storageProvider.NewEncryptingProvider(provider, keyring, metadataService, logger)
+	}
+	if storageCfg.Compression.Enabled {
+		compressing, err := storageProvider.NewCompressingProvider(provider, storageProvider.CompressionConfig{
+			Codec:      storageCfg.Compression.Codec,
+			Level:      storageCfg.Compression.Level,
+			MinSavings: storageCfg.Compression.MinSavings,
+		}, metadataService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize compression: %w", err)
+		}
+		logger.Info().Str("codec", storageCfg.Compression.Codec).Msg("Storage compression enabled")
+		provider = compressing
 	}
-	logger.Info().Str("provider", storageCfg.Provider).Str("basePath", storageCfg.BasePath).Msg("Storage provider initialized")
 	return provider, nil
-	// }
+}
+
+func initializeVersioning(db *sql.DB, provider storageProvider.Provider, metadataService repository.MetadataService, versioningCfg config.Versioning, logger *logger.Logger) (*storageProvider.VersionedProvider, error) {
+	versionRepository, err := repository.NewVersionRepository(repository.SQLite, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize version repository: %w", err)
+	}
+	logger.Info().Int("retain", versioningCfg.Retain).Msg("File versioning enabled")
+	return storageProvider.NewVersionedProvider(provider, &storageProvider.VersioningConfig{
+		Retain:            versioningCfg.Retain,
+		VersionRepository: versionRepository,
+	}, metadataService, logger)
+}
+
+// sweepTempFiles removes and reports files left behind by writes that were
+// interrupted, e.g. by a crash, before the service accepts uploads
+func sweepTempFiles(ctx context.Context, storage storageProvider.Provider, logger *logger.Logger) {
+	sweeper, ok := storage.(storageProvider.TempFileSweeper)
+	if !ok {
+		return
+	}
+	removed, err := sweeper.SweepTempFiles(ctx)
+	for _, path := range removed {
+		logger.Warn().Str("path", path).Msg("Removed temp file left by an interrupted write")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to sweep temp files")
+		return
+	}
+	logger.Info().Int("removed", len(removed)).Msg("Temp file sweep completed")
+}
+
+// repairReplicas periodically copies files back onto mirror replicas that
+// missed them
+func repairReplicas(ctx context.Context, mirror *storageProvider.MirroredProvider, mirrorCfg config.MirrorStorage, logger *logger.Logger) {
+	interval := mirrorCfg.RepairInterval
+	if interval <= 0 {
+		interval = defaultRepairInterval
+	}
+	batchSize := mirrorCfg.RepairBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRepairBatchSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := mirror.Repair(ctx, batchSize)
+			if err != nil {
+				logger.Error().Err(err).Msg("Replica repair failed")
+				continue
+			}
+			if result.Repaired > 0 || result.Failed > 0 {
+				logger.Info().Int("repaired", result.Repaired).Int("failed", result.Failed).Msg("Replica repair completed")
+			}
+		}
+	}
+}
+
+// applyTierPolicies periodically moves files between storage tiers according
+// to the lifecycle policies
+func applyTierPolicies(ctx context.Context, tiered *storageProvider.TieredProvider, tieringCfg config.Tiering, logger *logger.Logger) {
+	interval := tieringCfg.Interval
+	if interval <= 0 {
+		interval = defaultTieringInterval
+	}
+	batchSize := tieringCfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultTieringBatchSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := tiered.ApplyPolicies(ctx, batchSize)
+			if err != nil {
+				logger.Error().Err(err).Msg("Applying tier policies failed")
+				continue
+			}
+			if result.Moved > 0 || result.Failed > 0 {
+				logger.Info().Int("moved", result.Moved).Int("failed", result.Failed).Msg("Tier policies applied")
+			}
+		}
+	}
+}
+
+func initializeScrubber(repoType repository.RepositoryType, db interface{}, provider storageProvider.Provider, metadataService repository.MetadataService, scrubCfg config.Scrub, logger *logger.Logger) (*storageProvider.Scrubber, error) {
+	quarantineRepository, err := repository.NewQuarantineRepository(repoType, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize quarantine repository: %w", err)
+	}
+	return storageProvider.NewScrubber(provider, &storageProvider.ScrubConfig{
+		BytesPerSecond:       scrubCfg.BytesPerSecond,
+		QuarantineRepository: quarantineRepository,
+	}, metadataService, logger)
+}
+
+// scrubPeriodically verifies every stored file against its checksum once per
+// interval, quarantining the ones that no longer match
+func scrubPeriodically(ctx context.Context, scrubber *storageProvider.Scrubber, scrubCfg config.Scrub, logger *logger.Logger) {
+	interval := scrubCfg.Interval
+	if interval <= 0 {
+		interval = defaultScrubInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := scrubber.Run(ctx); err != nil {
+				if errors.Is(err, storageProvider.ErrScrubInProgress) {
+					logger.Info().Msg("Skipping scheduled scrub, one is already running")
+					continue
+				}
+				logger.Error().Err(err).Msg("Integrity scrub failed")
+			}
+		}
+	}
+}
+
+func initializeReconciler(repoType repository.RepositoryType, db interface{}, provider storageProvider.Provider, metadataService repository.MetadataService, reconcileCfg config.Reconcile, logger *logger.Logger) (*storageProvider.Reconciler, error) {
+	reconcileRepository, err := repository.NewReconcileRepository(repoType, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize reconcile repository: %w", err)
+	}
+	minAge := reconcileCfg.MinAge
+	if minAge <= 0 {
+		minAge = defaultReconcileMinAge
+	}
+	return storageProvider.NewReconciler(provider, &storageProvider.ReconcileConfig{
+		MinAge:              minAge,
+		BatchSize:           reconcileCfg.BatchSize,
+		ReconcileRepository: reconcileRepository,
+	}, metadataService, logger)
+}
+
+// reconcilePeriodically removes orphaned files and dangling metadata once per
+// interval, or only reports them when configured for a dry run
+func reconcilePeriodically(ctx context.Context, reconciler *storageProvider.Reconciler, reconcileCfg config.Reconcile, logger *logger.Logger) {
+	interval := reconcileCfg.Interval
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := reconciler.Run(ctx, reconcileCfg.DryRun); err != nil {
+				if errors.Is(err, storageProvider.ErrReconcileInProgress) {
+					logger.Info().Msg("Skipping scheduled reconciliation, one is already running")
+					continue
+				}
+				logger.Error().Err(err).Msg("Reconciliation failed")
+			}
+		}
+	}
+}
 
+func initializeTrashPurger(repoType repository.RepositoryType, db interface{}, provider storageProvider.Provider, metadataService repository.MetadataService, trashCfg config.Trash, logger *logger.Logger) (*storageProvider.TrashPurger, error) {
+	trashRepository, err := repository.NewTrashRepository(repoType, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize trash repository: %w", err)
+	}
+	return storageProvider.NewTrashPurger(provider, &storageProvider.TrashConfig{
+		GracePeriod:     trashCfg.GracePeriod,
+		BatchSize:       trashCfg.BatchSize,
+		TrashRepository: trashRepository,
+	}, metadataService, logger)
+}
+
+// purgeTrashPeriodically permanently deletes the files whose grace period in
+// the trash is over, once per interval
+func purgeTrashPeriodically(ctx context.Context, purger *storageProvider.TrashPurger, trashCfg config.Trash, logger *logger.Logger) {
+	interval := trashCfg.Interval
+	if interval <= 0 {
+		interval = defaultTrashInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := purger.Purge(ctx); err != nil {
+				if errors.Is(err, storageProvider.ErrPurgeInProgress) {
+					logger.Info().Msg("Skipping scheduled trash purge, one is already running")
+					continue
+				}
+				logger.Error().Err(err).Msg("Trash purge failed")
+			}
+		}
+	}
 }
 
 func initializeGRPCServer(cfg *config.ServiceConfig, logger *logger.Logger) (*grpc.Server, net.Listener, error) {
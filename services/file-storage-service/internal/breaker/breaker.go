@@ -2,7 +2,7 @@ package circuit
 
 import (
 	"context"
-	"os"
+	"errors"
 	"sync"
 	"time"
 )
@@ -15,75 +15,221 @@ const (
 	StateHalfOpen
 )
 
-var ErrCircuitOpen = os.ErrDeadlineExceeded
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCircuitOpen is returned, without running the operation, while the
+// circuit is open or all half-open probes are in flight
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	defaultMaxFailures    = 3
+	defaultResetTimeout   = 10 * time.Second
+	defaultHalfOpenProbes = 1
+)
+
+// Settings configures a circuit breaker. Zero values take the defaults.
+type Settings struct {
+	// MaxFailures consecutive failures open the circuit, 3 by default
+	MaxFailures int
+	// ResetTimeout is how long the circuit stays open before it is probed,
+	// 10s by default
+	ResetTimeout time.Duration
+	// HalfOpenProbes is how many requests may probe a half-open circuit at a
+	// time, 1 by default. As many successes in a row close it again.
+	HalfOpenProbes int
+	// IsFailure decides which errors count against the circuit, so errors
+	// caused by the caller don't open it. Nil uses DefaultIsFailure.
+	IsFailure func(error) bool
+	// OnStateChange is called after the breaker changes state
+	OnStateChange func(name string, from, to State)
+}
+
+// DefaultIsFailure counts every error except cancellation by the caller
+func DefaultIsFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
+
+// merge returns s with its zero fields taken from base
+func (s Settings) merge(base Settings) Settings {
+	if s.MaxFailures <= 0 {
+		s.MaxFailures = base.MaxFailures
+	}
+	if s.ResetTimeout <= 0 {
+		s.ResetTimeout = base.ResetTimeout
+	}
+	if s.HalfOpenProbes <= 0 {
+		s.HalfOpenProbes = base.HalfOpenProbes
+	}
+	if s.IsFailure == nil {
+		s.IsFailure = base.IsFailure
+	}
+	if s.OnStateChange == nil {
+		s.OnStateChange = base.OnStateChange
+	}
+	return s
+}
+
+var defaultSettings = Settings{
+	MaxFailures:    defaultMaxFailures,
+	ResetTimeout:   defaultResetTimeout,
+	HalfOpenProbes: defaultHalfOpenProbes,
+	IsFailure:      DefaultIsFailure,
+}
+
+// stateChange is a transition to report once the breaker's lock is released
+type stateChange struct {
+	from, to State
+}
 
 type CircuitBreaker struct {
-	state        State
-	failureCount int
-	lastFailure  time.Time
-	mutex        sync.RWMutex
+	name     string
+	settings Settings
+	now      func() time.Time
 
-	maxFailures  int
-	resetTimeout time.Duration
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// generation is bumped on every state change, so results of requests
+	// admitted in an earlier state are ignored
+	generation uint64
+	// probes is the number of half-open requests in flight, successes the
+	// number that succeeded since the circuit became half-open
+	probes    int
+	successes int
 }
 
-func NewCircuitBreaker(maxFailures int, resetTimeout time.Duration) *CircuitBreaker {
+func NewCircuitBreaker(name string, settings Settings) *CircuitBreaker {
 	return &CircuitBreaker{
-		state:        StateClosed,
-		maxFailures:  maxFailures,
-		resetTimeout: resetTimeout,
+		name:     name,
+		settings: settings.merge(defaultSettings),
+		now:      time.Now,
+		state:    StateClosed,
+	}
+}
+
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State reports the breaker's state. An open circuit whose reset timeout has
+// passed is reported half-open, as the next request will probe it.
+func (cb *CircuitBreaker) State() State {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == StateOpen && cb.now().Sub(cb.openedAt) >= cb.settings.ResetTimeout {
+		return StateHalfOpen
 	}
+	return cb.state
 }
 
+// Execute runs operation unless the circuit is open, and records its result
 func (cb *CircuitBreaker) Execute(ctx context.Context, operation func() error) error {
-	if !cb.allowRequest() {
-		return ErrCircuitOpen
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	generation, err := cb.allowRequest()
+	if err != nil {
+		return err
 	}
 
-	err := operation()
-	cb.recordResult(err)
+	err = operation()
+	cb.recordResult(generation, err)
 	return err
 }
 
-func (cb *CircuitBreaker) allowRequest() bool {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
+// allowRequest admits a request, returning the generation it was admitted in
+func (cb *CircuitBreaker) allowRequest() (uint64, error) {
+	cb.mutex.Lock()
+	var changes []stateChange
+	defer func() {
+		cb.mutex.Unlock()
+		cb.notify(changes)
+	}()
 
 	switch cb.state {
 	case StateClosed:
-		return true
+		return cb.generation, nil
 	case StateOpen:
-		if time.Since(cb.lastFailure) > cb.resetTimeout {
-			cb.mutex.RUnlock()
-			cb.mutex.Lock()
-			cb.state = StateHalfOpen
-			cb.mutex.Unlock()
-			cb.mutex.RLock()
-			return true
+		if cb.now().Sub(cb.openedAt) < cb.settings.ResetTimeout {
+			return 0, ErrCircuitOpen
 		}
-		return false
-	case StateHalfOpen:
-		return true
-	default:
-		return false
+		changes = cb.setState(changes, StateHalfOpen)
+	}
+
+	if cb.probes >= cb.settings.HalfOpenProbes {
+		return 0, ErrCircuitOpen
 	}
+	cb.probes++
+	return cb.generation, nil
 }
 
-func (cb *CircuitBreaker) recordResult(err error) {
+func (cb *CircuitBreaker) recordResult(generation uint64, err error) {
 	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
-
-	if err != nil {
-		cb.failureCount++
-		cb.lastFailure = time.Now()
+	var changes []stateChange
+	defer func() {
+		cb.mutex.Unlock()
+		cb.notify(changes)
+	}()
 
-		if cb.failureCount >= cb.maxFailures {
-			cb.state = StateOpen
+	if generation != cb.generation {
+		return
+	}
+	failure := err != nil && cb.settings.IsFailure(err)
+	switch cb.state {
+	case StateHalfOpen:
+		cb.probes--
+		if failure {
+			changes = cb.open(changes)
+			return
 		}
-	} else {
-		if cb.state == StateHalfOpen {
-			cb.state = StateClosed
+		cb.successes++
+		if cb.successes >= cb.settings.HalfOpenProbes {
+			cb.failures = 0
+			changes = cb.setState(changes, StateClosed)
 		}
-		cb.failureCount = 0
+	case StateClosed:
+		if !failure {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.settings.MaxFailures {
+			changes = cb.open(changes)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) open(changes []stateChange) []stateChange {
+	cb.openedAt = cb.now()
+	return cb.setState(changes, StateOpen)
+}
+
+func (cb *CircuitBreaker) setState(changes []stateChange, to State) []stateChange {
+	from := cb.state
+	cb.state = to
+	cb.generation++
+	cb.probes = 0
+	cb.successes = 0
+	return append(changes, stateChange{from: from, to: to})
+}
+
+func (cb *CircuitBreaker) notify(changes []stateChange) {
+	if cb.settings.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		cb.settings.OnStateChange(cb.name, change.from, change.to)
 	}
 }
@@ -0,0 +1,158 @@
+package circuit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errBackend = errors.New("backend unavailable")
+
+func fail() error    { return errBackend }
+func succeed() error { return nil }
+
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	var transitions []string
+	cb := NewCircuitBreaker("test", Settings{
+		MaxFailures:    2,
+		ResetTimeout:   time.Second,
+		HalfOpenProbes: 2,
+		OnStateChange: func(name string, from, to State) {
+			transitions = append(transitions, from.String()+">"+to.String())
+		},
+	})
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	cb.Execute(ctx, fail)
+	cb.Execute(ctx, succeed)
+	cb.Execute(ctx, fail)
+	if cb.State() != StateClosed {
+		t.Fatalf("State() = %s after non-consecutive failures, want closed", cb.State())
+	}
+	cb.Execute(ctx, fail)
+	if cb.State() != StateOpen {
+		t.Fatalf("State() = %s, want open", cb.State())
+	}
+
+	ran := false
+	err := cb.Execute(ctx, func() error { ran = true; return nil })
+	if !errors.Is(err, ErrCircuitOpen) || ran {
+		t.Errorf("Execute() while open = %v, ran %v, want ErrCircuitOpen without running", err, ran)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("ErrCircuitOpen is reported as a deadline")
+	}
+
+	// Once half-open, it takes HalfOpenProbes successes to close again
+	clock = clock.Add(time.Second)
+	if cb.State() != StateHalfOpen {
+		t.Errorf("State() after the reset timeout = %s, want half-open", cb.State())
+	}
+	cb.Execute(ctx, succeed)
+	if cb.State() != StateHalfOpen {
+		t.Errorf("State() after one probe = %s, want half-open", cb.State())
+	}
+	cb.Execute(ctx, succeed)
+	if cb.State() != StateClosed {
+		t.Errorf("State() after two probes = %s, want closed", cb.State())
+	}
+
+	want := []string{"closed>open", "open>half-open", "half-open>closed"}
+	if !slices.Equal(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestCircuitBreaker_BoundsHalfOpenProbes(t *testing.T) {
+	cb := NewCircuitBreaker("test", Settings{MaxFailures: 1, ResetTimeout: time.Second, HalfOpenProbes: 2})
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	cb.Execute(ctx, fail)
+	clock = clock.Add(time.Second)
+
+	// Two probes are let through while a third is turned away
+	release := make(chan struct{})
+	var started, done sync.WaitGroup
+	started.Add(2)
+	done.Add(2)
+	for range 2 {
+		go func() {
+			defer done.Done()
+			cb.Execute(ctx, func() error {
+				started.Done()
+				<-release
+				return fail()
+			})
+		}()
+	}
+	started.Wait()
+	if err := cb.Execute(ctx, succeed); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Execute() with all probes in flight = %v, want ErrCircuitOpen", err)
+	}
+	close(release)
+	done.Wait()
+
+	// The first failed probe reopens the circuit; the second is stale
+	if cb.State() != StateOpen {
+		t.Errorf("State() after failed probes = %s, want open", cb.State())
+	}
+}
+
+func TestCircuitBreaker_ClassifiesErrors(t *testing.T) {
+	errMissing := errors.New("missing")
+	cb := NewCircuitBreaker("test", Settings{
+		MaxFailures: 1,
+		IsFailure: func(err error) bool {
+			return !errors.Is(err, errMissing) && DefaultIsFailure(err)
+		},
+	})
+	ctx := context.Background()
+
+	cb.Execute(ctx, func() error { return errMissing })
+	cb.Execute(ctx, func() error { return context.Canceled })
+	if cb.State() != StateClosed {
+		t.Errorf("State() after caller errors = %s, want closed", cb.State())
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	ran := false
+	if err := cb.Execute(canceled, func() error { ran = true; return nil }); !errors.Is(err, context.Canceled) || ran {
+		t.Errorf("Execute() with a canceled context = %v, ran %v, want canceled without running", err, ran)
+	}
+}
+
+func TestGroup_KeepsABreakerPerOperation(t *testing.T) {
+	registry := NewRegistry()
+	group := NewGroup("local", Config{
+		Settings:   Settings{MaxFailures: 1},
+		Operations: map[string]Settings{"list": {MaxFailures: 2}},
+		Registry:   registry,
+	})
+	ctx := context.Background()
+
+	group.Execute(ctx, "store", fail)
+	group.Execute(ctx, "list", fail)
+	if err := group.Execute(ctx, "retrieve", succeed); err != nil {
+		t.Errorf("Execute(retrieve) error = %v, want it unaffected by store", err)
+	}
+
+	want := map[string]State{"local.store": StateOpen, "local.list": StateClosed, "local.retrieve": StateClosed}
+	states := registry.States()
+	if len(states) != len(want) {
+		t.Errorf("States() = %v, want %v", states, want)
+	}
+	for name, state := range want {
+		if states[name] != state {
+			t.Errorf("%s = %s, want %s", name, states[name], state)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package circuit
+
+import (
+	"context"
+	"sync"
+)
+
+// Config configures a Group of circuit breakers
+type Config struct {
+	Settings
+	// Operations overrides Settings for individual operations
+	Operations map[string]Settings
+	// Registry, if set, reports the state of the group's breakers
+	Registry *Registry
+}
+
+// Group keeps a circuit breaker per operation, so one failing operation does
+// not cut off the others
+type Group struct {
+	name     string
+	cfg      Config
+	mutex    sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+func NewGroup(name string, cfg Config) *Group {
+	g := &Group{
+		name:     name,
+		cfg:      cfg,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+	if cfg.Registry != nil {
+		cfg.Registry.register(g)
+	}
+	return g
+}
+
+// Get returns the breaker of operation, created on first use
+func (g *Group) Get(operation string) *CircuitBreaker {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	breaker, ok := g.breakers[operation]
+	if !ok {
+		settings := g.cfg.Operations[operation].merge(g.cfg.Settings)
+		breaker = NewCircuitBreaker(g.name+"."+operation, settings)
+		g.breakers[operation] = breaker
+	}
+	return breaker
+}
+
+// Execute runs fn through the breaker of operation
+func (g *Group) Execute(ctx context.Context, operation string, fn func() error) error {
+	return g.Get(operation).Execute(ctx, fn)
+}
+
+// States reports the state of every breaker used so far, by breaker name
+func (g *Group) States() map[string]State {
+	g.mutex.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(g.breakers))
+	for _, breaker := range g.breakers {
+		breakers = append(breakers, breaker)
+	}
+	g.mutex.Unlock()
+
+	states := make(map[string]State, len(breakers))
+	for _, breaker := range breakers {
+		states[breaker.Name()] = breaker.State()
+	}
+	return states
+}
+
+// Registry collects groups of circuit breakers for health reporting
+type Registry struct {
+	mutex  sync.Mutex
+	groups []*Group
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(g *Group) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.groups = append(r.groups, g)
+}
+
+// States reports the state of every breaker of the registered groups
+func (r *Registry) States() map[string]State {
+	r.mutex.Lock()
+	groups := append([]*Group(nil), r.groups...)
+	r.mutex.Unlock()
+
+	states := make(map[string]State)
+	for _, g := range groups {
+		for name, state := range g.States() {
+			states[name] = state
+		}
+	}
+	return states
+}
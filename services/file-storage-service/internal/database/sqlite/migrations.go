@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -41,9 +42,13 @@ func (m *DatabaseMigrator) Migrate(ctx context.Context) error {
 		is_deleted BOOLEAN DEFAULT 0
 	)`
 
+	// Create index for faster cleanup queries
+	createStatusDatesIndexQuery := `
+	CREATE INDEX IF NOT EXISTS idx_file_metadata_status_dates
+	ON file_metadata (processing_status, created_at, updated_at)`
+
 	// Create index for faster user_id queries
 	createUserIdIndexQuery := `
-	CREATE INDEX IF NOT EXISTS idx_file_metadata_status_dates ON file_metadata(processing_status, created_at, updated_at),
 	CREATE INDEX IF NOT EXISTS idx_file_metadata_user_id 
 	ON file_metadata (user_id)`
 
@@ -60,6 +65,121 @@ func (m *DatabaseMigrator) Migrate(ctx context.Context) error {
 		FOREIGN KEY (file_metadata_id) REFERENCES file_metadata (id)
 	)`
 
+	// Create blobs table for content-addressed storage
+	createBlobsTableQuery := `
+	CREATE TABLE IF NOT EXISTS blobs (
+		hash TEXT PRIMARY KEY,
+		size_bytes INTEGER NOT NULL,
+		ref_count INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	)`
+
+	// Create file_blobs table mapping file IDs to content-addressed blobs
+	createFileBlobsTableQuery := `
+	CREATE TABLE IF NOT EXISTS file_blobs (
+		file_id TEXT PRIMARY KEY,
+		blob_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (blob_hash) REFERENCES blobs (hash)
+	)`
+
+	// Create missing_replicas table listing mirror replicas that need repair
+	createMissingReplicasTableQuery := `
+	CREATE TABLE IF NOT EXISTS missing_replicas (
+		file_id TEXT NOT NULL,
+		replica TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		recorded_at DATETIME NOT NULL,
+		PRIMARY KEY (file_id, replica)
+	)`
+
+	// Create quota_usage table with the bytes and files each user stores
+	createQuotaUsageTableQuery := `
+	CREATE TABLE IF NOT EXISTS quota_usage (
+		user_id TEXT PRIMARY KEY,
+		used_bytes INTEGER NOT NULL DEFAULT 0,
+		used_files INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME NOT NULL
+	)`
+
+	// Create quota_reservations table holding quota for uploads in progress
+	createQuotaReservationsTableQuery := `
+	CREATE TABLE IF NOT EXISTS quota_reservations (
+		file_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	)`
+
+	// Create index for summing a user's reservations
+	createQuotaReservationsUserIndexQuery := `
+	CREATE INDEX IF NOT EXISTS idx_quota_reservations_user_id
+	ON quota_reservations (user_id)`
+
+	// Create quarantined_files table listing files that failed scrubbing
+	createQuarantinedFilesTableQuery := `
+	CREATE TABLE IF NOT EXISTS quarantined_files (
+		file_id TEXT PRIMARY KEY,
+		expected_checksum TEXT NOT NULL,
+		actual_checksum TEXT NOT NULL,
+		quarantine_path TEXT NOT NULL,
+		detected_at DATETIME NOT NULL
+	)`
+
+	// Create file_versions table listing the versions of each versioned file
+	createFileVersionsTableQuery := `
+	CREATE TABLE IF NOT EXISTS file_versions (
+		file_id TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		is_current BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (file_id, version_id)
+	)`
+
+	// Create file_retention table with the files that may not be deleted
+	createFileRetentionTableQuery := `
+	CREATE TABLE IF NOT EXISTS file_retention (
+		file_id TEXT PRIMARY KEY,
+		retain_until DATETIME,
+		legal_hold BOOLEAN NOT NULL DEFAULT 0,
+		updated_at DATETIME NOT NULL
+	)`
+
+	// Create retention_audit table recording every change to file retention
+	createRetentionAuditTableQuery := `
+	CREATE TABLE IF NOT EXISTS retention_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_id TEXT NOT NULL,
+		retain_until DATETIME,
+		legal_hold BOOLEAN NOT NULL,
+		actor TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		changed_at DATETIME NOT NULL
+	)`
+
+	// Create index for listing the retention changes of a file
+	createRetentionAuditFileIndexQuery := `
+	CREATE INDEX IF NOT EXISTS idx_retention_audit_file_id
+	ON retention_audit (file_id)`
+
+	// Create buckets table with each bucket's owner and upload settings
+	createBucketsTableQuery := `
+	CREATE TABLE IF NOT EXISTS buckets (
+		name TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		allowed_types TEXT NOT NULL DEFAULT '',
+		max_file_size_bytes INTEGER NOT NULL DEFAULT 0,
+		retention_seconds INTEGER NOT NULL DEFAULT 0,
+		versioning BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`
+
+	// Create index for listing a user's buckets
+	createBucketsOwnerIndexQuery := `
+	CREATE INDEX IF NOT EXISTS idx_buckets_owner_id
+	ON buckets (owner_id)`
+
 	// Begin transaction
 	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -69,8 +189,22 @@ func (m *DatabaseMigrator) Migrate(ctx context.Context) error {
 	// Execute migrations
 	migrationQueries := []string{
 		createFileMetadataTableQuery,
+		createStatusDatesIndexQuery,
 		createUserIdIndexQuery,
 		createFilesTableQuery,
+		createBlobsTableQuery,
+		createFileBlobsTableQuery,
+		createMissingReplicasTableQuery,
+		createQuotaUsageTableQuery,
+		createQuotaReservationsTableQuery,
+		createQuotaReservationsUserIndexQuery,
+		createQuarantinedFilesTableQuery,
+		createFileVersionsTableQuery,
+		createFileRetentionTableQuery,
+		createRetentionAuditTableQuery,
+		createRetentionAuditFileIndexQuery,
+		createBucketsTableQuery,
+		createBucketsOwnerIndexQuery,
 	}
 
 	for _, query := range migrationQueries {
@@ -81,6 +215,11 @@ func (m *DatabaseMigrator) Migrate(ctx context.Context) error {
 		}
 	}
 
+	if err := applySchemaChanges(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit migration transaction: %w", err)
@@ -90,6 +229,101 @@ func (m *DatabaseMigrator) Migrate(ctx context.Context) error {
 	return nil
 }
 
+// schemaChange is a numbered step that alters tables created by an earlier
+// release. PRAGMA user_version holds the number of the last step applied, so
+// each step runs once per database, in order.
+type schemaChange struct {
+	version int
+	// columns are added to file_metadata
+	columns []string
+	queries []string
+}
+
+var schemaChanges = []schemaChange{
+	// Compression
+	{version: 1, columns: []string{
+		"compression TEXT NOT NULL DEFAULT ''",
+		"original_size_bytes INTEGER NOT NULL DEFAULT 0",
+		"stored_size_bytes INTEGER NOT NULL DEFAULT 0",
+	}},
+	// Envelope encryption, with an index for finding data keys to rotate
+	{version: 2, columns: []string{
+		"encryption_key_id TEXT NOT NULL DEFAULT ''",
+		"wrapped_data_key BLOB",
+	}, queries: []string{
+		`CREATE INDEX IF NOT EXISTS idx_file_metadata_encryption_key_id
+		ON file_metadata (encryption_key_id)`,
+	}},
+	// Storage tiers, with an index for finding files due to move between them
+	{version: 3, columns: []string{
+		"tier TEXT NOT NULL DEFAULT ''",
+		"last_accessed_at DATETIME",
+	}, queries: []string{
+		`CREATE INDEX IF NOT EXISTS idx_file_metadata_tier_created_at
+		ON file_metadata (tier, created_at)`,
+	}},
+	// Checksums for integrity scrubbing
+	{version: 4, columns: []string{"checksum TEXT NOT NULL DEFAULT ''"}},
+	// File versions
+	{version: 5, columns: []string{"version_of TEXT NOT NULL DEFAULT ''"}},
+	// Buckets, with an index for listing the files in one
+	{version: 6, columns: []string{
+		"bucket TEXT NOT NULL DEFAULT ''",
+	}, queries: []string{
+		`CREATE INDEX IF NOT EXISTS idx_file_metadata_bucket
+		ON file_metadata (bucket)`,
+	}},
+}
+
+// applySchemaChanges runs the schema changes the database has not seen yet.
+// Databases written before user_version was kept may already have some of
+// the columns, so columns are only added where missing.
+func applySchemaChanges(ctx context.Context, tx *sql.Tx) error {
+	var current int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, change := range schemaChanges {
+		if change.version <= current {
+			continue
+		}
+		for _, column := range change.columns {
+			if err := addColumn(ctx, tx, "file_metadata", column); err != nil {
+				return fmt.Errorf("failed to apply schema change %d: %w", change.version, err)
+			}
+		}
+		for _, query := range change.queries {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("failed to apply schema change %d: %w", change.version, err)
+			}
+		}
+		// PRAGMA takes no bound parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", change.version)); err != nil {
+			return fmt.Errorf("failed to record schema version %d: %w", change.version, err)
+		}
+	}
+	return nil
+}
+
+// addColumn adds the column defined by definition to table unless the table
+// already has a column of that name
+func addColumn(ctx context.Context, tx *sql.Tx, table, definition string) error {
+	name := strings.Fields(definition)[0]
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?", table, name).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up column %s.%s: %w", table, name, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, name, err)
+	}
+	return nil
+}
+
 // CreateTables is now deprecated, kept for backwards compatibility
 func (m *DatabaseMigrator) CreateTables() error {
 	return m.Migrate(context.Background())
@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fileMetadataColumns returns the column names of file_metadata
+func fileMetadataColumns(t *testing.T, db *sql.DB) map[string]bool {
+	t.Helper()
+	rows, err := db.Query("SELECT name FROM pragma_table_info('file_metadata')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns[name] = true
+	}
+	return columns
+}
+
+func TestMigrate_UpgradesExistingDatabases(t *testing.T) {
+	ctx := context.Background()
+	for name, table := range map[string]string{
+		// As created by the first release
+		"first release": `CREATE TABLE file_metadata (
+			id TEXT PRIMARY KEY, metadata_json TEXT NOT NULL, storage_path TEXT NOT NULL,
+			processing_status TEXT NOT NULL, user_id TEXT NOT NULL, created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL, deleted_at DATETIME, is_deleted BOOLEAN DEFAULT 0)`,
+		// As created by a release that added columns without recording a
+		// schema version
+		"unversioned": `CREATE TABLE file_metadata (
+			id TEXT PRIMARY KEY, metadata_json TEXT NOT NULL, storage_path TEXT NOT NULL,
+			processing_status TEXT NOT NULL, user_id TEXT NOT NULL, created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL, deleted_at DATETIME, is_deleted BOOLEAN DEFAULT 0,
+			compression TEXT NOT NULL DEFAULT '', original_size_bytes INTEGER NOT NULL DEFAULT 0,
+			stored_size_bytes INTEGER NOT NULL DEFAULT 0, checksum TEXT NOT NULL DEFAULT '')`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "storage.db")
+			old, err := sql.Open("sqlite3", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := old.Exec(table); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := old.Exec(`INSERT INTO file_metadata (id, metadata_json, storage_path, processing_status, user_id, created_at, updated_at)
+				VALUES ('file-a', '{}', '/data/file-a', 'COMPLETED', 'user-1', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`); err != nil {
+				t.Fatal(err)
+			}
+			old.Close()
+
+			// Opening twice applies each change once
+			for range 2 {
+				db, err := NewDatabase(ctx, path)
+				if err != nil {
+					t.Fatalf("NewDatabase() error = %v", err)
+				}
+				columns := fileMetadataColumns(t, db)
+				for _, change := range schemaChanges {
+					for _, column := range change.columns {
+						if name := strings.Fields(column)[0]; !columns[name] {
+							t.Errorf("column %s missing after migration", name)
+						}
+					}
+				}
+				var version int
+				if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != schemaChanges[len(schemaChanges)-1].version {
+					t.Errorf("user_version = %d, %v; want %d", version, err, schemaChanges[len(schemaChanges)-1].version)
+				}
+				var bucket string
+				if err := db.QueryRow("SELECT bucket FROM file_metadata WHERE id = 'file-a'").Scan(&bucket); err != nil || bucket != "" {
+					t.Errorf("bucket of existing row = %q, %v; want the default", bucket, err)
+				}
+				db.Close()
+			}
+		})
+	}
+}
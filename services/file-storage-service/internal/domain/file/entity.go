@@ -25,6 +25,9 @@ const (
 	StatusUploading FileStatus = "UPLOADING"
 	StatusComplete  FileStatus = "COMPLETE"
 	StatusFailed    FileStatus = "FAILED"
+	// StatusCorrupted marks a file whose stored content no longer matches its
+	// checksum
+	StatusCorrupted FileStatus = "CORRUPTED"
 )
 
 // NewFile creates a new File instance
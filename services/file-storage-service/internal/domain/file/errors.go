@@ -24,6 +24,16 @@ var (
 	// ErrFileAlreadyExists indicates that the file already exists
 	ErrFileAlreadyExists = errors.New("file already exists")
 
+	// ErrInvalidFileID indicates that a file ID cannot be used to store a file
+	ErrInvalidFileID = errors.New("invalid file ID")
+
+	// ErrIntegrityCheckFailed indicates that stored content no longer
+	// matches its recorded checksum
+	ErrIntegrityCheckFailed = errors.New("file integrity check failed")
+
+	// ErrInvalidRange indicates that a range starts outside the file
+	ErrInvalidRange = errors.New("invalid range")
+
 	// ErrFileSizeTooLarge indicates that the file size exceeds the limit
 	ErrFileSizeTooLarge = errors.New("file size exceeds the maximum allowed size")
 
@@ -0,0 +1,34 @@
+package file
+
+const (
+	// DefaultListPageSize is used when ListOptions leaves PageSize unset
+	DefaultListPageSize = 1000
+	// MaxListPageSize caps the page size a caller may ask for
+	MaxListPageSize = 10000
+)
+
+// ListOptions selects one page of stored file IDs. Pages are in lexical
+// order of file ID, unless the provider documents another list key.
+type ListOptions struct {
+	// Prefix restricts the listing to file IDs starting with it
+	Prefix string
+	// PageSize caps the number of file IDs returned
+	PageSize int
+	// ContinuationToken resumes the listing after the previous page
+	ContinuationToken string
+}
+
+// Limit returns the page size to use
+func (o ListOptions) Limit() int {
+	if o.PageSize <= 0 {
+		return DefaultListPageSize
+	}
+	return min(o.PageSize, MaxListPageSize)
+}
+
+// ListPage is one page of a listing
+type ListPage struct {
+	FileIDs []string
+	// NextContinuationToken is empty on the last page
+	NextContinuationToken string
+}
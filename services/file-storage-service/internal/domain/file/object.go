@@ -0,0 +1,17 @@
+package file
+
+import "time"
+
+// StorageClassStandard is reported by providers without storage classes
+const StorageClassStandard = "STANDARD"
+
+// ObjectInfo describes a stored object as reported by a storage provider
+type ObjectInfo struct {
+	FileID string
+	// Size is the size of the content returned on retrieval
+	Size    int64
+	ModTime time.Time
+	// Checksum is the SHA-256 recorded when the object was stored
+	Checksum     string
+	StorageClass string
+}
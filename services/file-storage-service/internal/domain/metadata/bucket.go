@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBucketNotFound is returned when a bucket does not exist
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// ErrBucketExists is returned when a bucket name is already taken
+	ErrBucketExists = errors.New("bucket already exists")
+
+	// ErrBucketNotEmpty is returned when a bucket still holding files, also
+	// in the trash, is deleted
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+
+	// ErrInvalidBucketName is returned for names ValidateBucketName rejects
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+)
+
+// bucketNamePattern allows 3 to 63 lowercase letters, digits, dots and
+// hyphens, starting and ending with a letter or digit
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Bucket is a namespace of files owned by one user. Bucket names are unique
+// across all users.
+type Bucket struct {
+	Name      string
+	OwnerID   string
+	Settings  BucketSettings
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BucketSettings apply to every upload into a bucket; the zero value
+// restricts nothing
+type BucketSettings struct {
+	// AllowedTypes lists the content types files may have, either exactly or
+	// as "image/*"; empty allows any type
+	AllowedTypes []string
+	// MaxFileSize caps the size of each file in bytes; zero is unlimited
+	MaxFileSize int64
+	// Retention keeps each file from being deleted for this long after its
+	// upload is prepared
+	Retention time.Duration
+	// Versioning lets uploads add new versions of the bucket's files; it
+	// excludes Retention
+	Versioning bool
+}
+
+// Allows reports whether files of contentType may be uploaded
+func (s BucketSettings) Allows(contentType string) bool {
+	if len(s.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the settings for values that cannot apply
+func (s BucketSettings) Validate() error {
+	if s.MaxFileSize < 0 {
+		return errors.New("maximum file size cannot be negative")
+	}
+	if s.Retention < 0 {
+		return errors.New("retention cannot be negative")
+	}
+	// Adding a version prunes earlier ones, so retained files get none
+	if s.Retention > 0 && s.Versioning {
+		return errors.New("retention and versioning cannot both be enabled")
+	}
+	for _, allowed := range s.AllowedTypes {
+		if !strings.Contains(allowed, "/") {
+			return fmt.Errorf("allowed type %q is not a content type", allowed)
+		}
+	}
+	return nil
+}
+
+// ValidateBucketName checks that name can be used for a bucket; names look
+// like DNS labels so they can later appear in hostnames
+func ValidateBucketName(name string) error {
+	if !bucketNamePattern.MatchString(name) || strings.Contains(name, "..") {
+		return fmt.Errorf("%w: %q", ErrInvalidBucketName, name)
+	}
+	return nil
+}
@@ -15,6 +15,54 @@ type FileMetadataRecord struct {
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 	Checksum         string
+	// Compression is the codec the stored content is encoded with; empty or
+	// "none" means it is stored as uploaded
+	Compression  string
+	OriginalSize int64
+	StoredSize   int64
+	// EncryptionKeyID names the master key WrappedDataKey is wrapped with;
+	// empty means the content is stored unencrypted
+	EncryptionKeyID string
+	WrappedDataKey  []byte
+	// Tier names the storage tier holding the content; empty means the
+	// first, hottest tier. LastAccessedAt is zero until the file is read.
+	Tier           string
+	LastAccessedAt time.Time
+	// VersionOf is the ID of the file this record is a version of; empty for
+	// the file itself. Versions are left out of file listings.
+	VersionOf string
+	// Bucket names the bucket holding the file; empty for files kept outside
+	// any bucket
+	Bucket string
+	// DeletedAt is set while the file is in the trash
+	DeletedAt time.Time
+}
+
+// MissingReplica records a mirror replica that lacks a valid copy of a file
+type MissingReplica struct {
+	FileID     string
+	Replica    string
+	Reason     string
+	RecordedAt time.Time
+}
+
+// QuarantinedFile records a file found corrupt by the integrity scrubber.
+// QuarantinePath is empty when the provider left the content in place.
+type QuarantinedFile struct {
+	FileID           string
+	ExpectedChecksum string
+	ActualChecksum   string
+	QuarantinePath   string
+	DetectedAt       time.Time
+}
+
+// FileVersion is one upload of a versioned file. The first version of a file
+// has the file's own ID; later ones are stored under their own IDs.
+type FileVersion struct {
+	FileID    string
+	VersionID string
+	Current   bool
+	CreatedAt time.Time
 }
 
 // FileMetadataListOptions provides filtering and pagination for file metadata listing
@@ -22,6 +70,10 @@ type FileMetadataListOptions struct {
 	UserID string
 	FileID string
 	Status string
+	// Bucket only matches files in the named bucket
+	Bucket string
+	// IncludeDeleted also matches files in the trash
+	IncludeDeleted bool
 }
 
 // NewFileMetadataListOptions creates a new FileMetadataListOptions instance
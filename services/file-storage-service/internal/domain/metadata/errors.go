@@ -1 +1,11 @@
 package metadata
+
+import "errors"
+
+// Every metadata repository reports missing records and invalid arguments
+// with errors wrapping these, so callers can tell them apart with errors.Is
+// whatever the implementation
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrInvalidInput = errors.New("invalid input")
+)
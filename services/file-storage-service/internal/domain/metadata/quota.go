@@ -0,0 +1,23 @@
+package metadata
+
+import "errors"
+
+// ErrQuotaExceeded is returned when an upload does not fit in the user's quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaLimits caps the bytes and files a user may store; zero means unlimited
+type QuotaLimits struct {
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// QuotaUsage is what a user stores, plus the space reserved by uploads that
+// have been prepared but not completed
+type QuotaUsage struct {
+	UserID        string
+	UsedBytes     int64
+	UsedFiles     int64
+	ReservedBytes int64
+	ReservedFiles int64
+	Limits        QuotaLimits
+}
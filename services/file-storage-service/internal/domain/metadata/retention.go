@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrFileRetained is returned when a file under retention or legal hold
+	// is deleted
+	ErrFileRetained = errors.New("file is under retention")
+
+	// ErrRetentionShortened is returned when a retention period that has not
+	// ended yet would be moved earlier
+	ErrRetentionShortened = errors.New("retention period cannot be shortened")
+)
+
+// Retention keeps a file from being deleted until RetainUntil, and for as
+// long as LegalHold is set
+type Retention struct {
+	FileID      string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+// Applies reports whether the file may not be deleted at now
+func (r *Retention) Applies(now time.Time) bool {
+	return r != nil && (r.LegalHold || now.Before(r.RetainUntil))
+}
+
+// RetentionChange is the audit record of a file's retention being set
+type RetentionChange struct {
+	FileID      string
+	RetainUntil time.Time
+	LegalHold   bool
+	Actor       string
+	Reason      string
+	ChangedAt   time.Time
+}
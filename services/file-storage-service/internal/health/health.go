@@ -3,9 +3,12 @@ package health
 import (
 	"context"
 	"database/sql"
-	"os"
+	"errors"
 	"sync"
 	"time"
+
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
 )
 
 type Status string
@@ -18,22 +21,41 @@ const (
 type HealthCheck struct {
 	Component string
 	Status    Status
+	// State is the state of a circuit breaker
+	State     string `json:",omitempty"`
 	Error     string
 	LastCheck time.Time
 }
 
+// storageProbeID names the file the storage check asks the provider about.
+// It need not exist; the provider only has to be able to answer.
+const storageProbeID = "healthz-probe"
+
+// ObjectStatter is the part of a storage provider the storage check uses
+type ObjectStatter interface {
+	Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error)
+}
+
+// BreakerStates reports the state of circuit breakers by name
+type BreakerStates interface {
+	States() map[string]circuit.State
+}
+
 type HealthChecker struct {
-	db          *sql.DB
-	storagePath string
-	mu          sync.RWMutex
-	checks      map[string]*HealthCheck
+	db       *sql.DB
+	storage  ObjectStatter
+	breakers BreakerStates
+	mu       sync.RWMutex
+	checks   map[string]*HealthCheck
 }
 
-func NewHealthChecker(db *sql.DB, storagePath string) *HealthChecker {
+// NewHealthChecker creates a health checker; breakers may be nil
+func NewHealthChecker(db *sql.DB, storage ObjectStatter, breakers BreakerStates) *HealthChecker {
 	return &HealthChecker{
-		db:          db,
-		storagePath: storagePath,
-		checks:      make(map[string]*HealthCheck),
+		db:       db,
+		storage:  storage,
+		breakers: breakers,
+		checks:   make(map[string]*HealthCheck),
 	}
 }
 
@@ -45,11 +67,32 @@ func (h *HealthChecker) CheckHealth(ctx context.Context) map[string]*HealthCheck
 	h.checks["database"] = h.checkDatabase(ctx)
 
 	// Check storage
-	h.checks["storage"] = h.checkStorage()
+	h.checks["storage"] = h.checkStorage(ctx)
+
+	// Report circuit breakers, which are down while open
+	if h.breakers != nil {
+		for name, state := range h.breakers.States() {
+			h.checks["breaker:"+name] = checkBreaker(name, state)
+		}
+	}
 
 	return h.checks
 }
 
+func checkBreaker(name string, state circuit.State) *HealthCheck {
+	check := &HealthCheck{
+		Component: "breaker:" + name,
+		Status:    StatusUp,
+		State:     state.String(),
+		LastCheck: time.Now(),
+	}
+	if state == circuit.StateOpen {
+		check.Status = StatusDown
+		check.Error = circuit.ErrCircuitOpen.Error()
+	}
+	return check
+}
+
 func (h *HealthChecker) checkDatabase(ctx context.Context) *HealthCheck {
 	check := &HealthCheck{
 		Component: "database",
@@ -67,30 +110,19 @@ func (h *HealthChecker) checkDatabase(ctx context.Context) *HealthCheck {
 	return check
 }
 
-func (h *HealthChecker) checkStorage() *HealthCheck {
+func (h *HealthChecker) checkStorage(ctx context.Context) *HealthCheck {
 	check := &HealthCheck{
 		Component: "storage",
 		LastCheck: time.Now(),
 	}
 
-	_, err := os.Stat(h.storagePath)
-	if err != nil {
+	_, err := h.storage.Stat(ctx, storageProbeID)
+	if err != nil && !errors.Is(err, file.ErrFileNotFound) {
 		check.Status = StatusDown
 		check.Error = err.Error()
 		return check
 	}
 
-	// Check if directory is writable
-	testFile := h.storagePath + "/.test"
-	f, err := os.Create(testFile)
-	if err != nil {
-		check.Status = StatusDown
-		check.Error = "storage not writable: " + err.Error()
-		return check
-	}
-	f.Close()
-	os.Remove(testFile)
-
 	check.Status = StatusUp
 	return check
 }
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+type MemoryBucketRepository struct {
+	db     *Database
+	logger *logger.Logger
+}
+
+func NewMemoryBucketRepository(db *Database, logger *logger.Logger) *MemoryBucketRepository {
+	return &MemoryBucketRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *MemoryBucketRepository) CreateBucket(ctx context.Context, bucket *domain.Bucket) error {
+	if bucket == nil || bucket.Name == "" || bucket.OwnerID == "" {
+		return fmt.Errorf("%w: bucket name and owner cannot be empty", domain.ErrInvalidInput)
+	}
+	if bucket.CreatedAt.IsZero() {
+		bucket.CreatedAt = time.Now().UTC()
+	}
+	bucket.UpdatedAt = bucket.CreatedAt
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if _, exists := r.db.buckets[bucket.Name]; exists {
+		return fmt.Errorf("%w: %s", domain.ErrBucketExists, bucket.Name)
+	}
+	r.db.buckets[bucket.Name] = cloneBucket(bucket)
+	return nil
+}
+
+func (r *MemoryBucketRepository) GetBucket(ctx context.Context, name string) (*domain.Bucket, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	bucket, exists := r.db.buckets[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", domain.ErrBucketNotFound, name)
+	}
+	return cloneBucket(bucket), nil
+}
+
+// ListBuckets returns the buckets owned by ownerID, by name
+func (r *MemoryBucketRepository) ListBuckets(ctx context.Context, ownerID string) ([]*domain.Bucket, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("%w: owner cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.RLock()
+	var buckets []*domain.Bucket
+	for _, bucket := range r.db.buckets {
+		if bucket.OwnerID == ownerID {
+			buckets = append(buckets, cloneBucket(bucket))
+		}
+	}
+	r.db.mu.RUnlock()
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets, nil
+}
+
+func (r *MemoryBucketRepository) UpdateBucketSettings(ctx context.Context, name string, settings domain.BucketSettings) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	bucket, exists := r.db.buckets[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", domain.ErrBucketNotFound, name)
+	}
+	updated := cloneBucket(bucket)
+	updated.Settings = settings
+	updated.Settings.AllowedTypes = slices.Clone(settings.AllowedTypes)
+	updated.UpdatedAt = time.Now().UTC()
+	r.db.buckets[name] = updated
+	return nil
+}
+
+// DeleteBucket removes the named bucket unless any file, also one in the
+// trash, is in it
+func (r *MemoryBucketRepository) DeleteBucket(ctx context.Context, name string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if _, exists := r.db.buckets[name]; !exists {
+		return fmt.Errorf("%w: %s", domain.ErrBucketNotFound, name)
+	}
+	for _, row := range r.db.files {
+		if row.record.Bucket == name {
+			return fmt.Errorf("%w: %s", domain.ErrBucketNotEmpty, name)
+		}
+	}
+	delete(r.db.buckets, name)
+	return nil
+}
+
+func (r *MemoryBucketRepository) IsBucketOwnedByUser(ctx context.Context, name, userID string) (bool, error) {
+	if name == "" || userID == "" {
+		return false, fmt.Errorf("%w: bucket name and user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	bucket, exists := r.db.buckets[name]
+	return exists && bucket.OwnerID == userID, nil
+}
+
+func cloneBucket(bucket *domain.Bucket) *domain.Bucket {
+	c := *bucket
+	c.Settings.AllowedTypes = slices.Clone(bucket.Settings.AllowedTypes)
+	return &c
+}
@@ -0,0 +1,404 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// Database holds file metadata in memory. The repositories made from the
+// same Database share its records, like SQLite repositories share a *sql.DB.
+type Database struct {
+	mu          sync.RWMutex
+	files       map[string]*row
+	quarantined map[string]*domain.QuarantinedFile
+	buckets     map[string]*domain.Bucket
+	retention   map[string]*domain.Retention
+	// retentionAudit holds each file's retention changes, oldest first
+	retentionAudit map[string][]*domain.RetentionChange
+	// seq numbers rows in insertion order, the order SQLite lists them in
+	seq int64
+}
+
+func NewDatabase() *Database {
+	return &Database{
+		files:          make(map[string]*row),
+		quarantined:    make(map[string]*domain.QuarantinedFile),
+		buckets:        make(map[string]*domain.Bucket),
+		retention:      make(map[string]*domain.Retention),
+		retentionAudit: make(map[string][]*domain.RetentionChange),
+	}
+}
+
+// row is a stored record. Like the SQLite table it keeps the proto metadata
+// as JSON and the owner apart from it, so callers never share memory with it.
+type row struct {
+	record       domain.FileMetadataRecord
+	metadataJSON []byte
+	userID       string
+	isDeleted    bool
+	seq          int64
+}
+
+func (r *row) clone() *row {
+	c := *r
+	c.record.WrappedDataKey = bytes.Clone(r.record.WrappedDataKey)
+	return &c
+}
+
+func (r *row) toRecord() (*domain.FileMetadataRecord, error) {
+	record := r.record
+	record.WrappedDataKey = bytes.Clone(r.record.WrappedDataKey)
+	if len(r.metadataJSON) > 0 {
+		record.Metadata = &sharedv1.FileMetadata{}
+		if err := json.Unmarshal(r.metadataJSON, record.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal file metadata: %w", err)
+		}
+		record.Metadata.UserId = r.userID
+	}
+	return &record, nil
+}
+
+// transaction records how to undo the changes made under it
+type transaction struct {
+	undo []undoEntry
+	done bool
+}
+
+// undoEntry restores a file's row; a nil row means it did not exist
+type undoEntry struct {
+	fileID string
+	row    *row
+}
+
+// txContext is implemented by the context metadata.MetadataService.BeginTx
+// returns
+type txContext interface {
+	Transaction() interface{}
+}
+
+// MemoryFileMetadataRepository implements FileMetadataRepository in memory.
+// Changes made with a context carrying one of its transactions are visible
+// at once and undone if the transaction is rolled back; transactions are not
+// isolated from each other.
+type MemoryFileMetadataRepository struct {
+	db     *Database
+	logger *logger.Logger
+}
+
+func NewMemoryFileMetadataRepository(db *Database, logger *logger.Logger) *MemoryFileMetadataRepository {
+	return &MemoryFileMetadataRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *MemoryFileMetadataRepository) BeginTx(ctx context.Context) (interface{}, error) {
+	return &transaction{}, nil
+}
+
+func (r *MemoryFileMetadataRepository) CommitTx(ctx context.Context, tx interface{}) error {
+	t, ok := tx.(*transaction)
+	if !ok {
+		return fmt.Errorf("invalid transaction type")
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.undo = nil
+	return nil
+}
+
+func (r *MemoryFileMetadataRepository) RollbackTx(ctx context.Context, tx interface{}) error {
+	t, ok := tx.(*transaction)
+	if !ok {
+		return fmt.Errorf("invalid transaction type")
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		entry := t.undo[i]
+		if entry.row == nil {
+			delete(r.db.files, entry.fileID)
+		} else {
+			r.db.files[entry.fileID] = entry.row
+		}
+	}
+	t.undo = nil
+	return nil
+}
+
+// put replaces the row of fileID, nil removing it, and remembers the old one
+// in the context's transaction. The caller holds the write lock.
+func (r *MemoryFileMetadataRepository) put(ctx context.Context, fileID string, updated *row) {
+	if txCtx, ok := ctx.(txContext); ok {
+		if t, ok := txCtx.Transaction().(*transaction); ok && !t.done {
+			var previous *row
+			if current, exists := r.db.files[fileID]; exists {
+				previous = current.clone()
+			}
+			t.undo = append(t.undo, undoEntry{fileID: fileID, row: previous})
+		}
+	}
+	if updated == nil {
+		delete(r.db.files, fileID)
+		return
+	}
+	r.db.files[fileID] = updated
+}
+
+// UpdateFileMetadata updates an existing file metadata record; there is
+// nothing to update for an unknown ID
+func (r *MemoryFileMetadataRepository) UpdateFileMetadata(ctx context.Context, metadata *domain.FileMetadataRecord) error {
+	if err := metadata.Validate(); err != nil {
+		return fmt.Errorf("invalid file metadata: %w", err)
+	}
+	fileMetadataJSON, err := json.Marshal(metadata.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal file metadata: %w", err)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	current, exists := r.db.files[metadata.ID]
+	if !exists {
+		return nil
+	}
+	updated := current.clone()
+	updated.metadataJSON = fileMetadataJSON
+	updated.record.StoragePath = metadata.StoragePath
+	updated.record.ProcessingStatus = metadata.ProcessingStatus
+	updated.record.UpdatedAt = metadata.UpdatedAt
+	updated.record.Compression = metadata.Compression
+	updated.record.OriginalSize = metadata.OriginalSize
+	updated.record.StoredSize = metadata.StoredSize
+	updated.record.EncryptionKeyID = metadata.EncryptionKeyID
+	updated.record.WrappedDataKey = bytes.Clone(metadata.WrappedDataKey)
+	updated.record.Tier = metadata.Tier
+	updated.record.Checksum = metadata.Checksum
+	r.put(ctx, metadata.ID, updated)
+	return nil
+}
+
+// CreateFileMetadata saves file metadata, updating the record if one with the
+// same ID exists
+func (r *MemoryFileMetadataRepository) CreateFileMetadata(ctx context.Context, metadata *domain.FileMetadataRecord) error {
+	if err := metadata.Validate(); err != nil {
+		return fmt.Errorf("invalid file metadata: %w", err)
+	}
+	fileMetadataJSON, err := json.Marshal(metadata.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal file metadata: %w", err)
+	}
+
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+	metadata.UpdatedAt = time.Now().UTC()
+	if metadata.ProcessingStatus == "" {
+		metadata.ProcessingStatus = "PENDING"
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var created *row
+	if current, exists := r.db.files[metadata.ID]; exists {
+		created = current.clone()
+	} else {
+		r.db.seq++
+		created = &row{
+			record: domain.FileMetadataRecord{
+				ID:        metadata.ID,
+				CreatedAt: metadata.CreatedAt,
+				VersionOf: metadata.VersionOf,
+				Bucket:    metadata.Bucket,
+			},
+			userID: metadata.Metadata.UserId,
+			seq:    r.db.seq,
+		}
+	}
+	created.metadataJSON = fileMetadataJSON
+	created.record.StoragePath = metadata.StoragePath
+	created.record.ProcessingStatus = metadata.ProcessingStatus
+	created.record.UpdatedAt = metadata.UpdatedAt
+	r.put(ctx, metadata.ID, created)
+	return nil
+}
+
+func (r *MemoryFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+	if fileID == "" {
+		return nil, fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	current, exists := r.db.files[fileID]
+	if !exists {
+		return nil, domain.ErrFileNotFound
+	}
+	return current.toRecord()
+}
+
+// ListFileMetadata lists a user's files, in opts.Bucket if set, leaving out
+// versions and files in the trash
+func (r *MemoryFileMetadataRepository) ListFileMetadata(ctx context.Context, opts *domain.FileMetadataListOptions) ([]*domain.FileMetadataRecord, error) {
+	if err := opts.ValidateEssential(); err != nil {
+		return nil, fmt.Errorf("invalid list options: %w", err)
+	}
+	return r.db.selectRecords(func(row *row) bool {
+		return row.userID == opts.UserID && row.record.VersionOf == "" && !row.isDeleted &&
+			(opts.Bucket == "" || row.record.Bucket == opts.Bucket)
+	}, func(a, b *row) int {
+		return cmp.Compare(a.seq, b.seq)
+	}, 0)
+}
+
+func (r *MemoryFileMetadataRepository) RemoveFileMetadata(ctx context.Context, fileID string) error {
+	if fileID == "" {
+		return fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if _, exists := r.db.files[fileID]; !exists {
+		return domain.ErrFileNotFound
+	}
+	r.put(ctx, fileID, nil)
+	return nil
+}
+
+func (r *MemoryFileMetadataRepository) IsFileOwnedByUser(ctx context.Context, opts *domain.FileMetadataListOptions) (bool, error) {
+	if err := opts.ValidateEssential(); err != nil {
+		return false, fmt.Errorf("invalid list options: %w", err)
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	current, exists := r.db.files[opts.FileID]
+	return exists && current.userID == opts.UserID && (opts.IncludeDeleted || !current.isDeleted), nil
+}
+
+func (r *MemoryFileMetadataRepository) SoftDeleteMetadata(ctx context.Context, fileID, userID string) error {
+	if fileID == "" || userID == "" {
+		return fmt.Errorf("%w: file and user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	current, exists := r.db.files[fileID]
+	if !exists || current.userID != userID || current.isDeleted {
+		return domain.ErrFileNotFound
+	}
+	updated := current.clone()
+	updated.isDeleted = true
+	updated.record.DeletedAt = time.Now().UTC()
+	r.put(ctx, fileID, updated)
+	return nil
+}
+
+// ListDeletedMetadata lists the files in a user's trash, most recently
+// deleted first
+func (r *MemoryFileMetadataRepository) ListDeletedMetadata(ctx context.Context, userID string) ([]*domain.FileMetadataRecord, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: user ID cannot be empty", domain.ErrInvalidInput)
+	}
+	return r.db.selectRecords(func(row *row) bool {
+		return row.userID == userID && row.record.VersionOf == "" && row.isDeleted
+	}, func(a, b *row) int {
+		if c := b.record.DeletedAt.Compare(a.record.DeletedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.record.ID, b.record.ID)
+	}, 0)
+}
+
+func (r *MemoryFileMetadataRepository) RestoreMetadata(ctx context.Context, fileID, userID string) error {
+	if fileID == "" || userID == "" {
+		return fmt.Errorf("%w: file and user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	current, exists := r.db.files[fileID]
+	if !exists || current.userID != userID || !current.isDeleted {
+		return domain.ErrFileNotFound
+	}
+	updated := current.clone()
+	updated.isDeleted = false
+	updated.record.DeletedAt = time.Time{}
+	r.put(ctx, fileID, updated)
+	return nil
+}
+
+// CleanupExpiredMetadata removes uploads still pending since before
+// expiredBefore, except those under retention or a legal hold
+func (r *MemoryFileMetadataRepository) CleanupExpiredMetadata(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	now := time.Now().UTC()
+	var removed int64
+	for fileID, current := range r.db.files {
+		if current.record.ProcessingStatus == string(file.StatusPending) &&
+			current.record.CreatedAt.Before(expiredBefore) &&
+			current.record.UpdatedAt.Before(expiredBefore) &&
+			!r.db.retention[fileID].Applies(now) {
+			r.put(ctx, fileID, nil)
+			removed++
+		}
+	}
+	if removed > 0 {
+		r.logger.Info().Int64("totalDeleted", removed).Msg("Expired file metadata removed")
+	}
+	return removed, nil
+}
+
+// selectRecords returns up to limit records matching match in the order of
+// compare; a limit of zero returns all of them
+func (db *Database) selectRecords(match func(*row) bool, compare func(a, b *row) int, limit int) ([]*domain.FileMetadataRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var rows []*row
+	for _, current := range db.files {
+		if match(current) {
+			rows = append(rows, current)
+		}
+	}
+	slices.SortFunc(rows, compare)
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	var records []*domain.FileMetadataRecord
+	for _, current := range rows {
+		record, err := current.toRecord()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// byID orders rows by file ID
+func byID(a, b *row) int {
+	return strings.Compare(a.record.ID, b.record.ID)
+}
@@ -0,0 +1,326 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// txCtx carries a transaction like metadata.TxContext does
+type txCtx struct {
+	context.Context
+	tx interface{}
+}
+
+func (c *txCtx) Transaction() interface{} {
+	return c.tx
+}
+
+func newTestRepository() *MemoryFileMetadataRepository {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	return NewMemoryFileMetadataRepository(NewDatabase(), &testLogger)
+}
+
+func newRecord(fileID, userID string) *domain.FileMetadataRecord {
+	return &domain.FileMetadataRecord{
+		ID:       fileID,
+		Metadata: &sharedv1.FileMetadata{FileId: fileID, UserId: userID, OriginalFilename: fileID + ".csv"},
+	}
+}
+
+func TestMemoryFileMetadataRepository_CreateAndRetrieve(t *testing.T) {
+	repo := newTestRepository()
+	ctx := context.Background()
+
+	record := newRecord("file-a", "user-1")
+	if err := repo.CreateFileMetadata(ctx, record); err != nil {
+		t.Fatalf("CreateFileMetadata() error = %v", err)
+	}
+	if record.ProcessingStatus != string(file.StatusPending) || record.CreatedAt.IsZero() {
+		t.Errorf("created record = %+v, want it PENDING with a creation time", record)
+	}
+
+	got, err := repo.RetrieveFileMetadataByID(ctx, "file-a")
+	if err != nil {
+		t.Fatalf("RetrieveFileMetadataByID() error = %v", err)
+	}
+	if got.Metadata.UserId != "user-1" || got.Metadata.OriginalFilename != "file-a.csv" {
+		t.Errorf("RetrieveFileMetadataByID() = %+v", got.Metadata)
+	}
+
+	// Records are copies, changing them changes nothing stored
+	got.Metadata.OriginalFilename = "changed.csv"
+	record.Metadata.OriginalFilename = "changed.csv"
+	if again, _ := repo.RetrieveFileMetadataByID(ctx, "file-a"); again.Metadata.OriginalFilename != "file-a.csv" {
+		t.Errorf("stored filename = %q after changing returned records", again.Metadata.OriginalFilename)
+	}
+
+	if _, err := repo.RetrieveFileMetadataByID(ctx, "file-missing"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("RetrieveFileMetadataByID() of a missing file error = %v, want domain.ErrFileNotFound", err)
+	}
+	if err := repo.CreateFileMetadata(ctx, &domain.FileMetadataRecord{ID: "file-b"}); !errors.Is(err, domain.ErrNilMetadata) {
+		t.Errorf("CreateFileMetadata() without metadata error = %v, want ErrNilMetadata", err)
+	}
+}
+
+func TestMemoryFileMetadataRepository_Transactions(t *testing.T) {
+	repo := newTestRepository()
+	ctx := context.Background()
+	if err := repo.CreateFileMetadata(ctx, newRecord("file-a", "user-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolling back undoes every change made under the transaction
+	tx, _ := repo.BeginTx(ctx)
+	txCtx := &txCtx{Context: ctx, tx: tx}
+	update := newRecord("file-a", "user-1")
+	update.ProcessingStatus = string(file.StatusComplete)
+	update.Checksum = "abc"
+	if err := repo.UpdateFileMetadata(txCtx, update); err != nil {
+		t.Fatalf("UpdateFileMetadata() error = %v", err)
+	}
+	if err := repo.CreateFileMetadata(txCtx, newRecord("file-b", "user-1")); err != nil {
+		t.Fatalf("CreateFileMetadata() error = %v", err)
+	}
+	if got, _ := repo.RetrieveFileMetadataByID(ctx, "file-a"); got.Checksum != "abc" {
+		t.Errorf("checksum = %q inside the transaction, want the update", got.Checksum)
+	}
+	if err := repo.RollbackTx(txCtx, tx); err != nil {
+		t.Fatalf("RollbackTx() error = %v", err)
+	}
+	if got, _ := repo.RetrieveFileMetadataByID(ctx, "file-a"); got.Checksum != "" || got.ProcessingStatus != string(file.StatusPending) {
+		t.Errorf("record after rollback = %+v, want the update undone", got)
+	}
+	if _, err := repo.RetrieveFileMetadataByID(ctx, "file-b"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("file created in a rolled back transaction: error = %v, want domain.ErrFileNotFound", err)
+	}
+	if err := repo.CommitTx(txCtx, tx); err == nil {
+		t.Error("CommitTx() of a finished transaction error = nil")
+	}
+
+	// Committed changes stay
+	tx, _ = repo.BeginTx(ctx)
+	txCtx.tx = tx
+	if err := repo.RemoveFileMetadata(txCtx, "file-a"); err != nil {
+		t.Fatalf("RemoveFileMetadata() error = %v", err)
+	}
+	if err := repo.CommitTx(txCtx, tx); err != nil {
+		t.Fatalf("CommitTx() error = %v", err)
+	}
+	if _, err := repo.RetrieveFileMetadataByID(ctx, "file-a"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("file removed in a committed transaction: error = %v, want domain.ErrFileNotFound", err)
+	}
+	if err := repo.RollbackTx(ctx, "not a transaction"); err == nil {
+		t.Error("RollbackTx() of a foreign transaction error = nil")
+	}
+}
+
+func TestMemoryFileMetadataRepository_OwnershipAndTrash(t *testing.T) {
+	repo := newTestRepository()
+	ctx := context.Background()
+	for _, record := range []*domain.FileMetadataRecord{
+		newRecord("file-a", "user-1"),
+		newRecord("file-b", "user-1"),
+		newRecord("file-c", "user-2"),
+	} {
+		if err := repo.CreateFileMetadata(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version := newRecord("file-a-v2", "user-1")
+	version.VersionOf = "file-a"
+	if err := repo.CreateFileMetadata(ctx, version); err != nil {
+		t.Fatal(err)
+	}
+
+	owned := func(fileID, userID string, includeDeleted bool) bool {
+		t.Helper()
+		ok, err := repo.IsFileOwnedByUser(ctx, &domain.FileMetadataListOptions{UserID: userID, FileID: fileID, IncludeDeleted: includeDeleted})
+		if err != nil {
+			t.Fatalf("IsFileOwnedByUser() error = %v", err)
+		}
+		return ok
+	}
+	if !owned("file-a", "user-1", false) || owned("file-a", "user-2", false) {
+		t.Error("IsFileOwnedByUser() does not tell owners apart")
+	}
+
+	// Other users cannot move a file to the trash
+	if err := repo.SoftDeleteMetadata(ctx, "file-a", "user-2"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("SoftDeleteMetadata() by another user error = %v, want domain.ErrFileNotFound", err)
+	}
+	if err := repo.SoftDeleteMetadata(ctx, "file-a", "user-1"); err != nil {
+		t.Fatalf("SoftDeleteMetadata() error = %v", err)
+	}
+	if owned("file-a", "user-1", false) || !owned("file-a", "user-1", true) {
+		t.Error("a file in the trash should only be owned including deleted files")
+	}
+
+	records, err := repo.ListFileMetadata(ctx, &domain.FileMetadataListOptions{UserID: "user-1"})
+	if err != nil || len(records) != 1 || records[0].ID != "file-b" {
+		t.Errorf("ListFileMetadata() = %v, %v; want only file-b", records, err)
+	}
+	deleted, err := repo.ListDeletedMetadata(ctx, "user-1")
+	if err != nil || len(deleted) != 1 || deleted[0].ID != "file-a" || deleted[0].DeletedAt.IsZero() {
+		t.Errorf("ListDeletedMetadata() = %v, %v; want file-a", deleted, err)
+	}
+	trash := NewMemoryTrashRepository(repo.db, repo.logger)
+	if expired, err := trash.ListExpiredTrash(ctx, time.Now().Add(time.Minute), "", 10); err != nil || len(expired) != 1 {
+		t.Errorf("ListExpiredTrash() = %v, %v; want file-a", expired, err)
+	}
+
+	if err := repo.RestoreMetadata(ctx, "file-a", "user-1"); err != nil {
+		t.Fatalf("RestoreMetadata() error = %v", err)
+	}
+	if err := repo.RestoreMetadata(ctx, "file-a", "user-1"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("RestoreMetadata() of a file not in the trash error = %v, want domain.ErrFileNotFound", err)
+	}
+	records, _ = repo.ListFileMetadata(ctx, &domain.FileMetadataListOptions{UserID: "user-1"})
+	if len(records) != 2 || records[0].ID != "file-a" || records[1].ID != "file-b" {
+		t.Errorf("ListFileMetadata() after restore = %v, want file-a and file-b in creation order", records)
+	}
+}
+
+func TestMemoryFileMetadataRepository_CleanupExpiredMetadata(t *testing.T) {
+	repo := newTestRepository()
+	ctx := context.Background()
+	for _, fileID := range []string{"file-pending", "file-complete"} {
+		if err := repo.CreateFileMetadata(ctx, newRecord(fileID, "user-1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	complete := newRecord("file-complete", "user-1")
+	complete.ProcessingStatus = string(file.StatusComplete)
+	if err := repo.UpdateFileMetadata(ctx, complete); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := repo.CleanupExpiredMetadata(ctx, time.Now().Add(-time.Minute)); err != nil || removed != 0 {
+		t.Errorf("CleanupExpiredMetadata() of recent uploads = %d, %v; want 0", removed, err)
+	}
+	removed, err := repo.CleanupExpiredMetadata(ctx, time.Now().Add(time.Minute))
+	if err != nil || removed != 1 {
+		t.Fatalf("CleanupExpiredMetadata() = %d, %v; want 1", removed, err)
+	}
+	if _, err := repo.RetrieveFileMetadataByID(ctx, "file-pending"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("expired upload error = %v, want domain.ErrFileNotFound", err)
+	}
+
+	reconcile := NewMemoryReconcileRepository(repo.db, repo.logger)
+	found, err := reconcile.FindFileIDs(ctx, []string{"file-pending", "file-complete"})
+	if err != nil || len(found) != 1 || !found["file-complete"] {
+		t.Errorf("FindFileIDs() = %v, %v; want only file-complete", found, err)
+	}
+	if files, err := reconcile.ListCompleteFiles(ctx, "", 10); err != nil || len(files) != 1 {
+		t.Errorf("ListCompleteFiles() = %v, %v; want file-complete", files, err)
+	}
+}
+
+func TestMemoryRetentionRepository(t *testing.T) {
+	repo := newTestRepository()
+	retention := NewMemoryRetentionRepository(repo.db, repo.logger)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, fileID := range []string{"file-retained", "file-held", "file-expired"} {
+		if err := repo.CreateFileMetadata(ctx, newRecord(fileID, "user-1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := retention.GetRetention(ctx, "file-retained"); err != nil || got != nil {
+		t.Errorf("GetRetention() before any is set = %v, %v; want nil", got, err)
+	}
+	for _, change := range []*domain.RetentionChange{
+		{FileID: "file-retained", RetainUntil: now.Add(time.Hour), Actor: "admin", ChangedAt: now},
+		{FileID: "file-held", LegalHold: true, Actor: "admin", ChangedAt: now},
+		{FileID: "file-expired", RetainUntil: now.Add(-time.Minute), Actor: "admin", ChangedAt: now.Add(-time.Hour)},
+	} {
+		if err := retention.SetRetention(ctx, change); err != nil {
+			t.Fatalf("SetRetention(%s) error = %v", change.FileID, err)
+		}
+	}
+	shortened := &domain.RetentionChange{FileID: "file-retained", RetainUntil: now, Actor: "admin", ChangedAt: now}
+	if err := retention.SetRetention(ctx, shortened); !errors.Is(err, domain.ErrRetentionShortened) {
+		t.Errorf("SetRetention() shortening = %v, want ErrRetentionShortened", err)
+	}
+	if changes, err := retention.ListRetentionChanges(ctx, "file-retained"); err != nil || len(changes) != 1 || changes[0].Actor != "admin" {
+		t.Errorf("ListRetentionChanges() = %v, %v; want the one accepted change", changes, err)
+	}
+
+	// Only the upload whose retention has ended is cleaned up
+	removed, err := repo.CleanupExpiredMetadata(ctx, now.Add(time.Minute))
+	if err != nil || removed != 1 {
+		t.Fatalf("CleanupExpiredMetadata() = %d, %v; want 1", removed, err)
+	}
+	if _, err := repo.RetrieveFileMetadataByID(ctx, "file-expired"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("upload with ended retention error = %v, want domain.ErrFileNotFound", err)
+	}
+	for _, fileID := range []string{"file-retained", "file-held"} {
+		if _, err := repo.RetrieveFileMetadataByID(ctx, fileID); err != nil {
+			t.Errorf("retained upload %s error = %v, want it kept", fileID, err)
+		}
+	}
+}
+
+func TestMemoryBucketRepository(t *testing.T) {
+	repo := newTestRepository()
+	buckets := NewMemoryBucketRepository(repo.db, repo.logger)
+	ctx := context.Background()
+
+	for _, bucket := range []*domain.Bucket{
+		{Name: "photos", OwnerID: "user-1", Settings: domain.BucketSettings{AllowedTypes: []string{"image/*"}}},
+		{Name: "archive", OwnerID: "user-1"},
+		{Name: "shared", OwnerID: "user-2"},
+	} {
+		if err := buckets.CreateBucket(ctx, bucket); err != nil {
+			t.Fatalf("CreateBucket(%s) error = %v", bucket.Name, err)
+		}
+	}
+	if err := buckets.CreateBucket(ctx, &domain.Bucket{Name: "photos", OwnerID: "user-2"}); !errors.Is(err, domain.ErrBucketExists) {
+		t.Errorf("CreateBucket() of a taken name error = %v, want ErrBucketExists", err)
+	}
+
+	listed, err := buckets.ListBuckets(ctx, "user-1")
+	if err != nil || len(listed) != 2 || listed[0].Name != "archive" || listed[1].Name != "photos" {
+		t.Errorf("ListBuckets() = %v, %v; want archive and photos", listed, err)
+	}
+	if owned, _ := buckets.IsBucketOwnedByUser(ctx, "photos", "user-2"); owned {
+		t.Error("IsBucketOwnedByUser() = true for another user's bucket")
+	}
+
+	if err := buckets.UpdateBucketSettings(ctx, "photos", domain.BucketSettings{MaxFileSize: 10}); err != nil {
+		t.Fatalf("UpdateBucketSettings() error = %v", err)
+	}
+	if got, _ := buckets.GetBucket(ctx, "photos"); got.Settings.MaxFileSize != 10 || len(got.Settings.AllowedTypes) != 0 {
+		t.Errorf("settings after update = %+v, want them replaced", got.Settings)
+	}
+
+	// Files in the trash still keep the bucket from being deleted
+	record := newRecord("file-a", "user-1")
+	record.Bucket = "photos"
+	if err := repo.CreateFileMetadata(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SoftDeleteMetadata(ctx, "file-a", "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := buckets.DeleteBucket(ctx, "photos"); !errors.Is(err, domain.ErrBucketNotEmpty) {
+		t.Errorf("DeleteBucket() of a bucket holding files error = %v, want ErrBucketNotEmpty", err)
+	}
+	if err := repo.RemoveFileMetadata(ctx, "file-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := buckets.DeleteBucket(ctx, "photos"); err != nil {
+		t.Errorf("DeleteBucket() error = %v", err)
+	}
+	if _, err := buckets.GetBucket(ctx, "photos"); !errors.Is(err, domain.ErrBucketNotFound) {
+		t.Errorf("GetBucket() of a deleted bucket error = %v, want ErrBucketNotFound", err)
+	}
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+type MemoryQuarantineRepository struct {
+	db     *Database
+	logger *logger.Logger
+}
+
+func NewMemoryQuarantineRepository(db *Database, logger *logger.Logger) *MemoryQuarantineRepository {
+	return &MemoryQuarantineRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *MemoryQuarantineRepository) QuarantineFile(ctx context.Context, finding *domain.QuarantinedFile) error {
+	if finding == nil || finding.FileID == "" {
+		return fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
+	}
+	if finding.DetectedAt.IsZero() {
+		finding.DetectedAt = time.Now().UTC()
+	}
+
+	r.db.mu.Lock()
+	recorded := *finding
+	r.db.quarantined[finding.FileID] = &recorded
+	if current, exists := r.db.files[finding.FileID]; exists {
+		updated := current.clone()
+		updated.record.ProcessingStatus = string(file.StatusCorrupted)
+		updated.record.UpdatedAt = finding.DetectedAt
+		r.db.files[finding.FileID] = updated
+	}
+	r.db.mu.Unlock()
+
+	r.logger.Warn().
+		Str("fileId", finding.FileID).
+		Str("expectedChecksum", finding.ExpectedChecksum).
+		Str("actualChecksum", finding.ActualChecksum).
+		Str("quarantinePath", finding.QuarantinePath).
+		Msg("File quarantined")
+	return nil
+}
+
+// ListQuarantinedFiles returns up to limit findings, most recent first
+func (r *MemoryQuarantineRepository) ListQuarantinedFiles(ctx context.Context, limit int) ([]*domain.QuarantinedFile, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.RLock()
+	findings := make([]*domain.QuarantinedFile, 0, len(r.db.quarantined))
+	for _, finding := range r.db.quarantined {
+		f := *finding
+		findings = append(findings, &f)
+	}
+	r.db.mu.RUnlock()
+
+	sort.Slice(findings, func(i, j int) bool {
+		if !findings[i].DetectedAt.Equal(findings[j].DetectedAt) {
+			return findings[i].DetectedAt.After(findings[j].DetectedAt)
+		}
+		return findings[i].FileID < findings[j].FileID
+	})
+	return findings[:min(limit, len(findings))], nil
+}
@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+type MemoryReconcileRepository struct {
+	db     *Database
+	logger *logger.Logger
+}
+
+func NewMemoryReconcileRepository(db *Database, logger *logger.Logger) *MemoryReconcileRepository {
+	return &MemoryReconcileRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *MemoryReconcileRepository) FindFileIDs(ctx context.Context, fileIDs []string) (map[string]bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	found := make(map[string]bool, len(fileIDs))
+	for _, fileID := range fileIDs {
+		if _, exists := r.db.files[fileID]; exists {
+			found[fileID] = true
+		}
+	}
+	return found, nil
+}
+
+func (r *MemoryReconcileRepository) ListCompleteFiles(ctx context.Context, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+	return r.db.selectRecords(func(row *row) bool {
+		return row.record.ProcessingStatus == string(file.StatusComplete) && row.record.ID > afterFileID
+	}, byID, limit)
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// MemoryRetentionRepository keeps file retention and its audit trail beside
+// the file metadata, so cleaning up expired uploads can honour it
+type MemoryRetentionRepository struct {
+	db     *Database
+	logger *logger.Logger
+}
+
+func NewMemoryRetentionRepository(db *Database, logger *logger.Logger) *MemoryRetentionRepository {
+	return &MemoryRetentionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// GetRetention returns the retention of fileID, or nil if none was ever set
+func (r *MemoryRetentionRepository) GetRetention(ctx context.Context, fileID string) (*domain.Retention, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	retention, exists := r.db.retention[fileID]
+	if !exists {
+		return nil, nil
+	}
+	c := *retention
+	return &c, nil
+}
+
+// SetRetention replaces the retention of a file and records the change. A
+// retention period that has not ended by change.ChangedAt cannot be moved
+// earlier.
+func (r *MemoryRetentionRepository) SetRetention(ctx context.Context, change *domain.RetentionChange) error {
+	if change == nil || change.FileID == "" || change.Actor == "" {
+		return fmt.Errorf("%w: file ID and actor cannot be empty", domain.ErrInvalidInput)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if current, exists := r.db.retention[change.FileID]; exists &&
+		change.ChangedAt.Before(current.RetainUntil) && change.RetainUntil.Before(current.RetainUntil) {
+		return fmt.Errorf("%w: %s is retained until %s", domain.ErrRetentionShortened, change.FileID, current.RetainUntil)
+	}
+	r.db.retention[change.FileID] = &domain.Retention{
+		FileID:      change.FileID,
+		RetainUntil: change.RetainUntil.UTC(),
+		LegalHold:   change.LegalHold,
+	}
+	recorded := *change
+	recorded.RetainUntil = change.RetainUntil.UTC()
+	recorded.ChangedAt = change.ChangedAt.UTC()
+	r.db.retentionAudit[change.FileID] = append(r.db.retentionAudit[change.FileID], &recorded)
+
+	r.logger.Info().
+		Str("fileId", change.FileID).
+		Time("retainUntil", change.RetainUntil).
+		Bool("legalHold", change.LegalHold).
+		Str("actor", change.Actor).
+		Msg("File retention changed")
+	return nil
+}
+
+// ListRetentionChanges returns the audit trail of fileID, oldest first
+func (r *MemoryRetentionRepository) ListRetentionChanges(ctx context.Context, fileID string) ([]*domain.RetentionChange, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	var changes []*domain.RetentionChange
+	for _, change := range r.db.retentionAudit[fileID] {
+		c := *change
+		changes = append(changes, &c)
+	}
+	return changes, nil
+}
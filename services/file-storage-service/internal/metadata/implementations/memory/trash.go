@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+type MemoryTrashRepository struct {
+	db     *Database
+	logger *logger.Logger
+}
+
+func NewMemoryTrashRepository(db *Database, logger *logger.Logger) *MemoryTrashRepository {
+	return &MemoryTrashRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *MemoryTrashRepository) ListExpiredTrash(ctx context.Context, deletedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+	return r.db.selectRecords(func(row *row) bool {
+		return row.isDeleted && row.record.DeletedAt.Before(deletedBefore) && row.record.ID > afterFileID
+	}, byID, limit)
+}
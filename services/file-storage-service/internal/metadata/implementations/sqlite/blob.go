@@ -0,0 +1,183 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// ErrFileExists represents an error when a file is already mapped to a blob
+var ErrFileExists = file.ErrFileAlreadyExists
+
+// SQLiteBlobRepository keeps content-addressed blob reference counts in SQLite
+type SQLiteBlobRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteBlobRepository creates a new SQLite-based blob reference repository
+func NewSQLiteBlobRepository(db *sql.DB, logger *logger.Logger) *SQLiteBlobRepository {
+	return &SQLiteBlobRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AddBlobReference maps fileID to the blob with the given hash and increments the
+// blob's reference count. created reports whether this is the first reference.
+func (r *SQLiteBlobRepository) AddBlobReference(ctx context.Context, fileID, hash string, size int64) (created bool, err error) {
+	if fileID == "" || hash == "" {
+		return false, fmt.Errorf("%w: file ID and hash cannot be empty", domain.ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var count int
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM file_blobs WHERE file_id = ?`, fileID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check file mapping: %w", err)
+	}
+	if count > 0 {
+		err = ErrFileExists
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	var refCount int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO blobs (hash, size_bytes, ref_count, created_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1
+		RETURNING ref_count
+	`, hash, size, now).Scan(&refCount)
+	if err != nil {
+		return false, fmt.Errorf("failed to reference blob: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO file_blobs (file_id, blob_hash, created_at) VALUES (?, ?, ?)
+	`, fileID, hash, now); err != nil {
+		return false, fmt.Errorf("failed to map file to blob: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	r.logger.Debug().
+		Str("fileId", fileID).
+		Str("blobHash", hash).
+		Int64("refCount", refCount).
+		Msg("Blob reference added")
+
+	return refCount == 1, nil
+}
+
+// RemoveBlobReference removes the mapping for fileID and decrements the blob's
+// reference count. The blob row is deleted once no references remain.
+func (r *SQLiteBlobRepository) RemoveBlobReference(ctx context.Context, fileID string) (hash string, remaining int64, err error) {
+	if fileID == "" {
+		return "", 0, fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, `SELECT blob_hash FROM file_blobs WHERE file_id = ?`, fileID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		err = domain.ErrFileNotFound
+		return "", 0, err
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to look up blob: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM file_blobs WHERE file_id = ?`, fileID); err != nil {
+		return "", 0, fmt.Errorf("failed to remove file mapping: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ? RETURNING ref_count
+	`, hash).Scan(&remaining)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to dereference blob: %w", err)
+	}
+
+	if remaining <= 0 {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM blobs WHERE hash = ?`, hash); err != nil {
+			return "", 0, fmt.Errorf("failed to remove blob: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	r.logger.Debug().
+		Str("fileId", fileID).
+		Str("blobHash", hash).
+		Int64("refCount", remaining).
+		Msg("Blob reference removed")
+
+	return hash, remaining, nil
+}
+
+// GetBlobHash returns the hash of the blob fileID is mapped to
+func (r *SQLiteBlobRepository) GetBlobHash(ctx context.Context, fileID string) (string, error) {
+	var hash string
+	err := r.db.QueryRowContext(ctx, `SELECT blob_hash FROM file_blobs WHERE file_id = ?`, fileID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", domain.ErrFileNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up blob: %w", err)
+	}
+	return hash, nil
+}
+
+// ListBlobFileIDs returns up to limit IDs of files mapped to a blob, in
+// order, that start with prefix and sort after afterFileID
+func (r *SQLiteBlobRepository) ListBlobFileIDs(ctx context.Context, prefix, afterFileID string, limit int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT file_id FROM file_blobs
+		WHERE file_id > @after AND substr(file_id, 1, length(@prefix)) = @prefix
+		ORDER BY file_id
+		LIMIT @limit
+	`, sql.Named("after", afterFileID), sql.Named("prefix", prefix), sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file mappings: %w", err)
+	}
+	defer rows.Close()
+
+	var fileIDs []string
+	for rows.Next() {
+		var fileID string
+		if err := rows.Scan(&fileID); err != nil {
+			return nil, fmt.Errorf("failed to scan file mapping: %w", err)
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error processing file mappings: %w", err)
+	}
+	return fileIDs, nil
+}
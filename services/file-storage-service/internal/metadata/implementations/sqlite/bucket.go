@@ -0,0 +1,201 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteBucketRepository keeps buckets and their settings in SQLite
+type SQLiteBucketRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteBucketRepository creates a new SQLite-based bucket repository
+func NewSQLiteBucketRepository(db *sql.DB, logger *logger.Logger) *SQLiteBucketRepository {
+	return &SQLiteBucketRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// CreateBucket adds a bucket, failing with domain.ErrBucketExists if its name
+// is taken
+func (r *SQLiteBucketRepository) CreateBucket(ctx context.Context, bucket *domain.Bucket) error {
+	if bucket == nil || bucket.Name == "" || bucket.OwnerID == "" {
+		return fmt.Errorf("%w: bucket name and owner cannot be empty", domain.ErrInvalidInput)
+	}
+	if bucket.CreatedAt.IsZero() {
+		bucket.CreatedAt = time.Now().UTC()
+	}
+	bucket.UpdatedAt = bucket.CreatedAt
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO buckets (name, owner_id, allowed_types, max_file_size_bytes, retention_seconds, versioning, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO NOTHING
+	`, bucket.Name, bucket.OwnerID,
+		strings.Join(bucket.Settings.AllowedTypes, ","),
+		bucket.Settings.MaxFileSize,
+		int64(bucket.Settings.Retention/time.Second),
+		bucket.Settings.Versioning,
+		bucket.CreatedAt, bucket.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w: %w", ErrDatabaseOperation, err)
+	}
+	if created, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error checking bucket creation: %w: %w", ErrDatabaseOperation, err)
+	} else if created == 0 {
+		return fmt.Errorf("%w: %s", domain.ErrBucketExists, bucket.Name)
+	}
+
+	r.logger.Info().
+		Str("bucket", bucket.Name).
+		Str("ownerId", bucket.OwnerID).
+		Msg("Bucket created")
+	return nil
+}
+
+// GetBucket returns the named bucket or domain.ErrBucketNotFound
+func (r *SQLiteBucketRepository) GetBucket(ctx context.Context, name string) (*domain.Bucket, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT name, owner_id, allowed_types, max_file_size_bytes, retention_seconds, versioning, created_at, updated_at
+		FROM buckets WHERE name = ?
+	`, name)
+	bucket, err := scanBucket(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", domain.ErrBucketNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve bucket: %w", err)
+	}
+	return bucket, nil
+}
+
+// ListBuckets returns the buckets owned by ownerID, by name
+func (r *SQLiteBucketRepository) ListBuckets(ctx context.Context, ownerID string) ([]*domain.Bucket, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("%w: owner cannot be empty", domain.ErrInvalidInput)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT name, owner_id, allowed_types, max_file_size_bytes, retention_seconds, versioning, created_at, updated_at
+		FROM buckets WHERE owner_id = ?
+		ORDER BY name
+	`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []*domain.Bucket
+	for rows.Next() {
+		bucket, err := scanBucket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan bucket: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	return buckets, nil
+}
+
+// UpdateBucketSettings replaces the settings of the named bucket. Files
+// already in the bucket are not checked against the new settings.
+func (r *SQLiteBucketRepository) UpdateBucketSettings(ctx context.Context, name string, settings domain.BucketSettings) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE buckets
+		SET allowed_types = ?, max_file_size_bytes = ?, retention_seconds = ?, versioning = ?, updated_at = ?
+		WHERE name = ?
+	`, strings.Join(settings.AllowedTypes, ","),
+		settings.MaxFileSize,
+		int64(settings.Retention/time.Second),
+		settings.Versioning,
+		time.Now().UTC(), name)
+	if err != nil {
+		return fmt.Errorf("failed to update bucket: %w: %w", ErrDatabaseOperation, err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error checking bucket update: %w: %w", ErrDatabaseOperation, err)
+	} else if updated == 0 {
+		return fmt.Errorf("%w: %s", domain.ErrBucketNotFound, name)
+	}
+	return nil
+}
+
+// DeleteBucket removes the named bucket, failing with domain.ErrBucketNotEmpty
+// while any file, also one in the trash, is in it
+func (r *SQLiteBucketRepository) DeleteBucket(ctx context.Context, name string) error {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM buckets
+		WHERE name = ? AND NOT EXISTS (SELECT 1 FROM file_metadata WHERE bucket = ?)
+	`, name, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete bucket: %w: %w", ErrDatabaseOperation, err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error checking bucket deletion: %w: %w", ErrDatabaseOperation, err)
+	} else if deleted == 0 {
+		if _, err := r.GetBucket(ctx, name); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", domain.ErrBucketNotEmpty, name)
+	}
+
+	r.logger.Info().
+		Str("bucket", name).
+		Msg("Bucket deleted")
+	return nil
+}
+
+// IsBucketOwnedByUser checks if a bucket is owned by a user
+func (r *SQLiteBucketRepository) IsBucketOwnedByUser(ctx context.Context, name, userID string) (bool, error) {
+	if name == "" || userID == "" {
+		return false, fmt.Errorf("%w: bucket name and user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM buckets WHERE name = ? AND owner_id = ?
+	`, name, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check bucket ownership: %w: %w", ErrDatabaseOperation, err)
+	}
+	return count > 0, nil
+}
+
+type bucketScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBucket(row bucketScanner) (*domain.Bucket, error) {
+	bucket := &domain.Bucket{}
+	var allowedTypes string
+	var retentionSeconds int64
+	if err := row.Scan(
+		&bucket.Name,
+		&bucket.OwnerID,
+		&allowedTypes,
+		&bucket.Settings.MaxFileSize,
+		&retentionSeconds,
+		&bucket.Settings.Versioning,
+		&bucket.CreatedAt,
+		&bucket.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if allowedTypes != "" {
+		bucket.Settings.AllowedTypes = strings.Split(allowedTypes, ",")
+	}
+	bucket.Settings.Retention = time.Duration(retentionSeconds) * time.Second
+	return bucket, nil
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// ErrDataKeyChanged represents an error when a data key was re-wrapped or
+// removed concurrently
+var ErrDataKeyChanged = errors.New("data key changed concurrently")
+
+// SQLiteDataKeyRepository reads and re-wraps the data keys stored on file
+// metadata records
+type SQLiteDataKeyRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteDataKeyRepository creates a new SQLite-based data key repository
+func NewSQLiteDataKeyRepository(db *sql.DB, logger *logger.Logger) *SQLiteDataKeyRepository {
+	return &SQLiteDataKeyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListDataKeys returns encrypted records whose data key is not wrapped with
+// excludeKeyID, ordered by ID and starting after afterFileID
+func (r *SQLiteDataKeyRepository) ListDataKeys(ctx context.Context, excludeKeyID, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, encryption_key_id, wrapped_data_key
+		FROM file_metadata
+		WHERE encryption_key_id != '' AND encryption_key_id != ? AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`, excludeKeyID, afterFileID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data keys: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*domain.FileMetadataRecord
+	for rows.Next() {
+		record := &domain.FileMetadataRecord{}
+		if err := rows.Scan(&record.ID, &record.EncryptionKeyID, &record.WrappedDataKey); err != nil {
+			return nil, fmt.Errorf("failed to scan data key: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list data keys: %w", err)
+	}
+	return records, nil
+}
+
+// RewrapDataKey stores wrappedKey under newKeyID, provided the record's key
+// is still wrapped with oldKeyID
+func (r *SQLiteDataKeyRepository) RewrapDataKey(ctx context.Context, fileID, oldKeyID, newKeyID string, wrappedKey []byte) error {
+	if fileID == "" || newKeyID == "" || len(wrappedKey) == 0 {
+		return fmt.Errorf("%w: file ID, key ID and wrapped key cannot be empty", domain.ErrInvalidInput)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE file_metadata
+		SET encryption_key_id = ?, wrapped_data_key = ?, updated_at = ?
+		WHERE id = ? AND encryption_key_id = ?
+	`, newKeyID, wrappedKey, time.Now().UTC(), fileID, oldKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to re-wrap data key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to re-wrap data key: %w", err)
+	}
+	if affected == 0 {
+		return ErrDataKeyChanged
+	}
+
+	r.logger.Debug().
+		Str("fileId", fileID).
+		Str("oldKeyId", oldKeyID).
+		Str("newKeyId", newKeyID).
+		Msg("Data key re-wrapped")
+	return nil
+}
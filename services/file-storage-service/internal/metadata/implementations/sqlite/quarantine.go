@@ -0,0 +1,105 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteQuarantineRepository keeps the files found corrupt by the integrity
+// scrubber in SQLite
+type SQLiteQuarantineRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteQuarantineRepository creates a new SQLite-based quarantine repository
+func NewSQLiteQuarantineRepository(db *sql.DB, logger *logger.Logger) *SQLiteQuarantineRepository {
+	return &SQLiteQuarantineRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// QuarantineFile records the finding and marks the file CORRUPTED in one
+// transaction. A file quarantined again keeps only its latest finding.
+func (r *SQLiteQuarantineRepository) QuarantineFile(ctx context.Context, finding *domain.QuarantinedFile) error {
+	if finding == nil || finding.FileID == "" {
+		return fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
+	}
+	if finding.DetectedAt.IsZero() {
+		finding.DetectedAt = time.Now().UTC()
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO quarantined_files (file_id, expected_checksum, actual_checksum, quarantine_path, detected_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(file_id) DO UPDATE SET
+			expected_checksum = excluded.expected_checksum,
+			actual_checksum = excluded.actual_checksum,
+			quarantine_path = excluded.quarantine_path,
+			detected_at = excluded.detected_at
+	`, finding.FileID, finding.ExpectedChecksum, finding.ActualChecksum, finding.QuarantinePath, finding.DetectedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record quarantined file: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE file_metadata SET processing_status = ?, updated_at = ? WHERE id = ?
+	`, string(file.StatusCorrupted), finding.DetectedAt, finding.FileID)
+	if err != nil {
+		return fmt.Errorf("failed to mark file corrupted: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit quarantined file: %w", err)
+	}
+
+	r.logger.Warn().
+		Str("fileId", finding.FileID).
+		Str("expectedChecksum", finding.ExpectedChecksum).
+		Str("actualChecksum", finding.ActualChecksum).
+		Str("quarantinePath", finding.QuarantinePath).
+		Msg("File quarantined")
+	return nil
+}
+
+// ListQuarantinedFiles returns up to limit findings, most recent first
+func (r *SQLiteQuarantineRepository) ListQuarantinedFiles(ctx context.Context, limit int) ([]*domain.QuarantinedFile, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT file_id, expected_checksum, actual_checksum, quarantine_path, detected_at
+		FROM quarantined_files
+		ORDER BY detected_at DESC, file_id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined files: %w", err)
+	}
+	defer rows.Close()
+
+	var findings []*domain.QuarantinedFile
+	for rows.Next() {
+		f := &domain.QuarantinedFile{}
+		if err := rows.Scan(&f.FileID, &f.ExpectedChecksum, &f.ActualChecksum, &f.QuarantinePath, &f.DetectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan quarantined file: %w", err)
+		}
+		findings = append(findings, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list quarantined files: %w", err)
+	}
+	return findings, nil
+}
@@ -0,0 +1,155 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteQuotaRepository keeps per-user usage and upload reservations in SQLite
+type SQLiteQuotaRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteQuotaRepository creates a new SQLite-based quota repository
+func NewSQLiteQuotaRepository(db *sql.DB, logger *logger.Logger) *SQLiteQuotaRepository {
+	return &SQLiteQuotaRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ReserveQuota reserves size bytes and one file for fileID, provided usage
+// and existing reservations leave room for it under limits. The check and
+// the insert are a single statement, so concurrent reservations cannot both
+// take the last of the quota.
+func (r *SQLiteQuotaRepository) ReserveQuota(ctx context.Context, userID, fileID string, size int64, limits domain.QuotaLimits) error {
+	if userID == "" || fileID == "" || size < 0 {
+		return fmt.Errorf("%w: user ID and file ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO quota_reservations (file_id, user_id, size_bytes, created_at)
+		SELECT @file_id, @user_id, @size, @now
+		WHERE (@max_bytes <= 0 OR @size
+				+ COALESCE((SELECT used_bytes FROM quota_usage WHERE user_id = @user_id), 0)
+				+ COALESCE((SELECT SUM(size_bytes) FROM quota_reservations WHERE user_id = @user_id), 0)
+				<= @max_bytes)
+			AND (@max_files <= 0 OR 1
+				+ COALESCE((SELECT used_files FROM quota_usage WHERE user_id = @user_id), 0)
+				+ (SELECT COUNT(*) FROM quota_reservations WHERE user_id = @user_id)
+				<= @max_files)
+	`,
+		sql.Named("file_id", fileID),
+		sql.Named("user_id", userID),
+		sql.Named("size", size),
+		sql.Named("now", time.Now().UTC()),
+		sql.Named("max_bytes", limits.MaxBytes),
+		sql.Named("max_files", limits.MaxFiles),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reserve quota: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to reserve quota: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// CommitQuota turns the reservation for fileID into usage of size bytes
+func (r *SQLiteQuotaRepository) CommitQuota(ctx context.Context, userID, fileID string, size int64) error {
+	if userID == "" || fileID == "" {
+		return fmt.Errorf("%w: user ID and file ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM quota_reservations WHERE file_id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to remove quota reservation: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO quota_usage (user_id, used_bytes, used_files, updated_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			used_bytes = used_bytes + excluded.used_bytes,
+			used_files = used_files + 1,
+			updated_at = excluded.updated_at
+	`, userID, size, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record quota usage: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit quota usage: %w", err)
+	}
+	return nil
+}
+
+// ReleaseQuota drops the reservation for fileID, if any
+func (r *SQLiteQuotaRepository) ReleaseQuota(ctx context.Context, fileID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM quota_reservations WHERE file_id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to release quota reservation: %w", err)
+	}
+	return nil
+}
+
+// FreeQuota returns size bytes and one file to the user's quota
+func (r *SQLiteQuotaRepository) FreeQuota(ctx context.Context, userID string, size int64) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE quota_usage
+		SET used_bytes = MAX(used_bytes - ?, 0), used_files = MAX(used_files - 1, 0), updated_at = ?
+		WHERE user_id = ?
+	`, size, time.Now().UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to free quota: %w", err)
+	}
+	return nil
+}
+
+// ReleaseExpiredReservations drops reservations made before expiredBefore
+func (r *SQLiteQuotaRepository) ReleaseExpiredReservations(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM quota_reservations WHERE created_at < ?`, expiredBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to release expired reservations: %w", err)
+	}
+	released, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to release expired reservations: %w", err)
+	}
+	return released, nil
+}
+
+// GetQuotaUsage returns the user's usage and reservations; limits are left
+// for the caller to fill in
+func (r *SQLiteQuotaRepository) GetQuotaUsage(ctx context.Context, userID string) (*domain.QuotaUsage, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	usage := &domain.QuotaUsage{UserID: userID}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE((SELECT used_bytes FROM quota_usage WHERE user_id = @user_id), 0),
+			COALESCE((SELECT used_files FROM quota_usage WHERE user_id = @user_id), 0),
+			COALESCE(SUM(size_bytes), 0),
+			COUNT(*)
+		FROM quota_reservations
+		WHERE user_id = @user_id
+	`, sql.Named("user_id", userID)).Scan(&usage.UsedBytes, &usage.UsedFiles, &usage.ReservedBytes, &usage.ReservedFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteReconcileRepository looks up file metadata in bulk for reconciling it
+// with the content in storage
+type SQLiteReconcileRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteReconcileRepository creates a new SQLite-based reconcile repository
+func NewSQLiteReconcileRepository(db *sql.DB, logger *logger.Logger) *SQLiteReconcileRepository {
+	return &SQLiteReconcileRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// FindFileIDs returns the subset of fileIDs that have a metadata record, in
+// any state
+func (r *SQLiteReconcileRepository) FindFileIDs(ctx context.Context, fileIDs []string) (map[string]bool, error) {
+	found := make(map[string]bool, len(fileIDs))
+	if len(fileIDs) == 0 {
+		return found, nil
+	}
+
+	args := make([]interface{}, len(fileIDs))
+	for i, fileID := range fileIDs {
+		args[i] = fileID
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id FROM file_metadata WHERE id IN (?`+strings.Repeat(", ?", len(fileIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up file metadata: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fileID string
+		if err := rows.Scan(&fileID); err != nil {
+			return nil, fmt.Errorf("failed to scan file ID: %w", err)
+		}
+		found[fileID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to look up file metadata: %w", err)
+	}
+	return found, nil
+}
+
+// ListCompleteFiles returns up to limit COMPLETE records ordered by ID,
+// starting after afterFileID. Only the ID, owner and timestamps are filled in.
+// Versioned files are left out, as their content is checked through the
+// records of their versions and the first one may have been pruned.
+func (r *SQLiteReconcileRepository) ListCompleteFiles(ctx context.Context, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, processing_status, created_at, updated_at
+		FROM file_metadata
+		WHERE processing_status = ? AND id > ?
+		AND NOT EXISTS (SELECT 1 FROM file_versions WHERE file_versions.file_id = file_metadata.id)
+		ORDER BY id
+		LIMIT ?
+	`, string(file.StatusComplete), afterFileID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list complete files: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*domain.FileMetadataRecord
+	for rows.Next() {
+		record := &domain.FileMetadataRecord{Metadata: &sharedv1.FileMetadata{}}
+		if err := rows.Scan(&record.ID, &record.Metadata.UserId, &record.ProcessingStatus, &record.CreatedAt, &record.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %w", err)
+		}
+		record.Metadata.FileId = record.ID
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list complete files: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteReplicaRepository keeps the mirror replicas awaiting repair in SQLite
+type SQLiteReplicaRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteReplicaRepository creates a new SQLite-based missing replica repository
+func NewSQLiteReplicaRepository(db *sql.DB, logger *logger.Logger) *SQLiteReplicaRepository {
+	return &SQLiteReplicaRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// MarkReplicaMissing records that replica lacks a valid copy of fileID. A
+// replica already recorded keeps its original timestamp.
+func (r *SQLiteReplicaRepository) MarkReplicaMissing(ctx context.Context, fileID, replica, reason string) error {
+	if fileID == "" || replica == "" {
+		return fmt.Errorf("%w: file ID and replica cannot be empty", domain.ErrInvalidInput)
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO missing_replicas (file_id, replica, reason, recorded_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(file_id, replica) DO UPDATE SET reason = excluded.reason
+	`, fileID, replica, reason, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record missing replica: %w", err)
+	}
+
+	r.logger.Warn().
+		Str("fileId", fileID).
+		Str("replica", replica).
+		Str("reason", reason).
+		Msg("Replica recorded as missing")
+	return nil
+}
+
+// ClearReplicaMissing removes the record for fileID on replica
+func (r *SQLiteReplicaRepository) ClearReplicaMissing(ctx context.Context, fileID, replica string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM missing_replicas WHERE file_id = ? AND replica = ?`, fileID, replica); err != nil {
+		return fmt.Errorf("failed to clear missing replica: %w", err)
+	}
+	return nil
+}
+
+// ClearFile removes every missing replica record for fileID
+func (r *SQLiteReplicaRepository) ClearFile(ctx context.Context, fileID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM missing_replicas WHERE file_id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to clear missing replicas: %w", err)
+	}
+	return nil
+}
+
+// ListMissingReplicas returns up to limit missing replicas, oldest first
+func (r *SQLiteReplicaRepository) ListMissingReplicas(ctx context.Context, limit int) ([]*domain.MissingReplica, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT file_id, replica, reason, recorded_at
+		FROM missing_replicas
+		ORDER BY recorded_at, file_id, replica
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list missing replicas: %w", err)
+	}
+	defer rows.Close()
+
+	var missing []*domain.MissingReplica
+	for rows.Next() {
+		m := &domain.MissingReplica{}
+		if err := rows.Scan(&m.FileID, &m.Replica, &m.Reason, &m.RecordedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan missing replica: %w", err)
+		}
+		missing = append(missing, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list missing replicas: %w", err)
+	}
+	return missing, nil
+}
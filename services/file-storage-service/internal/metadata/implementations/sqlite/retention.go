@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteRetentionRepository keeps file retention and its audit trail in SQLite
+type SQLiteRetentionRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteRetentionRepository creates a new SQLite-based retention repository
+func NewSQLiteRetentionRepository(db *sql.DB, logger *logger.Logger) *SQLiteRetentionRepository {
+	return &SQLiteRetentionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// GetRetention returns the retention of fileID, or nil if none was ever set
+func (r *SQLiteRetentionRepository) GetRetention(ctx context.Context, fileID string) (*domain.Retention, error) {
+	var retainUntil sql.NullTime
+	retention := &domain.Retention{FileID: fileID}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT retain_until, legal_hold FROM file_retention WHERE file_id = ?
+	`, fileID).Scan(&retainUntil, &retention.LegalHold)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve retention: %w", err)
+	}
+	retention.RetainUntil = retainUntil.Time
+	return retention, nil
+}
+
+// SetRetention replaces the retention of a file and records the change. A
+// retention period that has not ended by change.ChangedAt cannot be moved
+// earlier.
+func (r *SQLiteRetentionRepository) SetRetention(ctx context.Context, change *domain.RetentionChange) error {
+	if change == nil || change.FileID == "" || change.Actor == "" {
+		return fmt.Errorf("%w: file ID and actor cannot be empty", domain.ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+	}
+	defer tx.Rollback()
+
+	var current sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT retain_until FROM file_retention WHERE file_id = ?
+	`, change.FileID).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to retrieve retention: %w", err)
+	}
+	if current.Valid && change.ChangedAt.Before(current.Time) && change.RetainUntil.Before(current.Time) {
+		return fmt.Errorf("%w: %s is retained until %s", domain.ErrRetentionShortened, change.FileID, current.Time)
+	}
+
+	retainUntil := sql.NullTime{Time: change.RetainUntil.UTC(), Valid: !change.RetainUntil.IsZero()}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO file_retention (file_id, retain_until, legal_hold, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (file_id) DO UPDATE SET
+			retain_until = excluded.retain_until,
+			legal_hold = excluded.legal_hold,
+			updated_at = excluded.updated_at
+	`, change.FileID, retainUntil, change.LegalHold, change.ChangedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set retention: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO retention_audit (file_id, retain_until, legal_hold, actor, reason, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, change.FileID, retainUntil, change.LegalHold, change.Actor, change.Reason, change.ChangedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record retention change: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit retention: %w", err)
+	}
+
+	r.logger.Info().
+		Str("fileId", change.FileID).
+		Time("retainUntil", change.RetainUntil).
+		Bool("legalHold", change.LegalHold).
+		Str("actor", change.Actor).
+		Msg("File retention changed")
+	return nil
+}
+
+// ListRetentionChanges returns the audit trail of fileID, oldest first
+func (r *SQLiteRetentionRepository) ListRetentionChanges(ctx context.Context, fileID string) ([]*domain.RetentionChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT file_id, retain_until, legal_hold, actor, reason, changed_at
+		FROM retention_audit
+		WHERE file_id = ?
+		ORDER BY id
+	`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*domain.RetentionChange
+	for rows.Next() {
+		var retainUntil sql.NullTime
+		c := &domain.RetentionChange{}
+		if err := rows.Scan(&c.FileID, &retainUntil, &c.LegalHold, &c.Actor, &c.Reason, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention change: %w", err)
+		}
+		c.RetainUntil = retainUntil.Time
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list retention changes: %w", err)
+	}
+	return changes, nil
+}
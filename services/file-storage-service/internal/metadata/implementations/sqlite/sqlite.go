@@ -22,12 +22,6 @@ type CleanupResult struct {
 }
 
 var (
-	// ErrInvalidInput represents an error for invalid input
-	ErrInvalidInput = errors.New("invalid input")
-
-	// ErrFileNotFound represents an error when a file is not found
-	ErrFileNotFound = errors.New("file not found")
-
 	// ErrDatabaseOperation represents a generic database operation error
 	ErrDatabaseOperation = errors.New("database operation failed")
 )
@@ -105,7 +99,7 @@ func (r *SQLiteFileMetadataRepository) UpdateFileMetadata(ctx context.Context, m
 
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+		return fmt.Errorf("failed to begin transaction: %w: %w", ErrDatabaseOperation, err)
 	}
 	defer func() {
 		if p := recover(); p != nil {
@@ -134,7 +128,14 @@ func (r *SQLiteFileMetadataRepository) UpdateFileMetadata(ctx context.Context, m
 			metadata_json = ?, 
 			storage_path = ?, 
 			processing_status = ?, 
-			updated_at = ?
+			updated_at = ?,
+			compression = ?,
+			original_size_bytes = ?,
+			stored_size_bytes = ?,
+			encryption_key_id = ?,
+			wrapped_data_key = ?,
+			tier = ?,
+			checksum = ?
 		WHERE id = ?
 	`
 
@@ -143,6 +144,13 @@ func (r *SQLiteFileMetadataRepository) UpdateFileMetadata(ctx context.Context, m
 		metadata.StoragePath,
 		metadata.ProcessingStatus,
 		metadata.UpdatedAt,
+		metadata.Compression,
+		metadata.OriginalSize,
+		metadata.StoredSize,
+		metadata.EncryptionKeyID,
+		metadata.WrappedDataKey,
+		metadata.Tier,
+		metadata.Checksum,
 		metadata.ID,
 	)
 
@@ -182,7 +190,7 @@ func (r *SQLiteFileMetadataRepository) CreateFileMetadata(ctx context.Context, m
 
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+		return fmt.Errorf("failed to begin transaction: %w: %w", ErrDatabaseOperation, err)
 	}
 	defer func() {
 		if p := recover(); p != nil {
@@ -207,8 +215,10 @@ func (r *SQLiteFileMetadataRepository) CreateFileMetadata(ctx context.Context, m
 			processing_status, 
 			user_id,
 			created_at, 
-			updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?)
+			updated_at,
+			version_of,
+			bucket
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET 
 			metadata_json = ?,
 			storage_path = ?,
@@ -253,6 +263,8 @@ func (r *SQLiteFileMetadataRepository) CreateFileMetadata(ctx context.Context, m
 		userID,
 		metadata.CreatedAt,
 		metadata.UpdatedAt,
+		metadata.VersionOf,
+		metadata.Bucket,
 		// Update values
 		fileMetadataJSON,
 		metadata.StoragePath,
@@ -290,7 +302,7 @@ func (r *SQLiteFileMetadataRepository) CreateFileMetadata(ctx context.Context, m
 func (r *SQLiteFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Context, fileID string) (*domain.FileMetadataRecord, error) {
 	// Validate input
 	if fileID == "" {
-		return nil, fmt.Errorf("%w: file ID cannot be empty", ErrInvalidInput)
+		return nil, fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
 	}
 
 	if err := r.acquireLock(ctx); err != nil {
@@ -307,7 +319,17 @@ func (r *SQLiteFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Cont
 			processing_status, 
 			user_id,
 			created_at, 
-			updated_at
+			updated_at,
+			compression,
+			original_size_bytes,
+			stored_size_bytes,
+			encryption_key_id,
+			wrapped_data_key,
+			tier,
+			last_accessed_at,
+			checksum,
+			version_of,
+			bucket
 		FROM file_metadata 
 		WHERE id = ?
 	`
@@ -319,6 +341,7 @@ func (r *SQLiteFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Cont
 	metadata := &domain.FileMetadataRecord{}
 	var fileMetadataJSON []byte
 	var userID string
+	var lastAccessedAt sql.NullTime
 	err := row.Scan(
 		&metadata.ID,
 		&fileMetadataJSON,
@@ -327,13 +350,23 @@ func (r *SQLiteFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Cont
 		&userID,
 		&metadata.CreatedAt,
 		&metadata.UpdatedAt,
+		&metadata.Compression,
+		&metadata.OriginalSize,
+		&metadata.StoredSize,
+		&metadata.EncryptionKeyID,
+		&metadata.WrappedDataKey,
+		&metadata.Tier,
+		&lastAccessedAt,
+		&metadata.Checksum,
+		&metadata.VersionOf,
+		&metadata.Bucket,
 	)
 
 	if err == sql.ErrNoRows {
 		r.logger.Info().
 			Str("fileId", fileID).
 			Msg("File metadata not found")
-		return nil, ErrFileNotFound
+		return nil, domain.ErrFileNotFound
 	} else if err != nil {
 		r.logger.Error().
 			Err(err).
@@ -342,6 +375,8 @@ func (r *SQLiteFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Cont
 		return nil, fmt.Errorf("failed to retrieve file metadata: %w", err)
 	}
 
+	metadata.LastAccessedAt = lastAccessedAt.Time
+
 	// Unmarshal file metadata
 	if len(fileMetadataJSON) > 0 {
 		metadata.Metadata = &sharedv1.FileMetadata{}
@@ -374,9 +409,13 @@ func (r *SQLiteFileMetadataRepository) ListFileMetadata(ctx context.Context, opt
 	defer r.mu.Unlock()
 
 	// Count total files
-	countQuery := `SELECT COUNT(*) FROM file_metadata WHERE user_id = ?`
+	countQuery := `
+		SELECT COUNT(*) FROM file_metadata
+		WHERE user_id = ? AND version_of = '' AND (? = '' OR bucket = ?)
+		AND (is_deleted = 0 OR is_deleted IS NULL)
+	`
 	var totalFiles int
-	err := r.db.QueryRowContext(ctx, countQuery, opts.UserID).Scan(&totalFiles)
+	err := r.db.QueryRowContext(ctx, countQuery, opts.UserID, opts.Bucket, opts.Bucket).Scan(&totalFiles)
 	if err != nil {
 		r.logger.Error().
 			Err(err).
@@ -394,12 +433,14 @@ func (r *SQLiteFileMetadataRepository) ListFileMetadata(ctx context.Context, opt
 			processing_status, 
 			user_id,
 			created_at, 
-			updated_at
+			updated_at,
+			bucket
 		FROM file_metadata
-		WHERE user_id = ?
+		WHERE user_id = ? AND version_of = '' AND (? = '' OR bucket = ?)
+		AND (is_deleted = 0 OR is_deleted IS NULL)
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, opts.UserID)
+	rows, err := r.db.QueryContext(ctx, query, opts.UserID, opts.Bucket, opts.Bucket)
 	if err != nil {
 		r.logger.Error().
 			Err(err).
@@ -422,6 +463,7 @@ func (r *SQLiteFileMetadataRepository) ListFileMetadata(ctx context.Context, opt
 			&userID,
 			&metadata.CreatedAt,
 			&metadata.UpdatedAt,
+			&metadata.Bucket,
 		)
 		if err != nil {
 			r.logger.Error().
@@ -477,7 +519,7 @@ func (r *SQLiteFileMetadataRepository) ListFiles(ctx context.Context, opts *doma
 	defer tx.Rollback()
 
 	// Count total files
-	countQuery := `SELECT COUNT(*) FROM file_metadata WHERE user_id = ?`
+	countQuery := `SELECT COUNT(*) FROM file_metadata WHERE user_id = ? AND version_of = ''`
 	var totalFiles int
 
 	err = tx.QueryRowContext(ctx, countQuery, opts.UserID).Scan(&totalFiles)
@@ -500,7 +542,7 @@ func (r *SQLiteFileMetadataRepository) ListFiles(ctx context.Context, opts *doma
 			created_at, 
 			updated_at
 		FROM file_metadata
-		WHERE user_id = ?
+		WHERE user_id = ? AND version_of = ''
 		AND (? = '' OR processing_status = ?)
         AND (is_deleted = 0 OR is_deleted IS NULL)
         ORDER BY created_at DESC
@@ -569,7 +611,7 @@ func (r *SQLiteFileMetadataRepository) ListFiles(ctx context.Context, opts *doma
 func (r *SQLiteFileMetadataRepository) RemoveFileMetadata(ctx context.Context, fileID string) error {
 	// Validate input
 	if fileID == "" {
-		return fmt.Errorf("%w: file ID cannot be empty", ErrInvalidInput)
+		return fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
 	}
 
 	if err := r.acquireLock(ctx); err != nil {
@@ -585,7 +627,7 @@ func (r *SQLiteFileMetadataRepository) RemoveFileMetadata(ctx context.Context, f
 			Err(err).
 			Str("fileId", fileID).
 			Msg("Error removing file metadata")
-		return fmt.Errorf("failed to remove file metadata: %w", ErrDatabaseOperation)
+		return fmt.Errorf("failed to remove file metadata: %w: %w", ErrDatabaseOperation, err)
 	}
 
 	// Check if any rows were affected
@@ -595,14 +637,14 @@ func (r *SQLiteFileMetadataRepository) RemoveFileMetadata(ctx context.Context, f
 			Err(err).
 			Str("fileId", fileID).
 			Msg("Error checking rows affected")
-		return fmt.Errorf("error checking deletion: %w", ErrDatabaseOperation)
+		return fmt.Errorf("error checking deletion: %w: %w", ErrDatabaseOperation, err)
 	}
 
 	if rowsAffected == 0 {
 		r.logger.Info().
 			Str("fileId", fileID).
 			Msg("No file metadata found with ID")
-		return ErrFileNotFound
+		return domain.ErrFileNotFound
 	}
 
 	r.logger.Info().
@@ -619,18 +661,22 @@ func (r *SQLiteFileMetadataRepository) IsFileOwnedByUser(ctx context.Context, op
 		return false, fmt.Errorf("invalid list options: %w", err)
 	}
 
-	// Select query: count number of files with given ID and user ID
+	// Select query: count number of files with given ID and user ID, leaving
+	// out files in the trash unless asked for
 
-	query := `SELECT COUNT(*) FROM file_metadata WHERE id = ? AND user_id = ?`
+	query := `
+		SELECT COUNT(*) FROM file_metadata
+		WHERE id = ? AND user_id = ? AND (? OR is_deleted = 0 OR is_deleted IS NULL)
+	`
 	var count int
-	row := r.db.QueryRowContext(ctx, query, opts.FileID, opts.UserID)
+	row := r.db.QueryRowContext(ctx, query, opts.FileID, opts.UserID, opts.IncludeDeleted)
 	if row.Err() != nil {
 		r.logger.Error().
 			Err(row.Err()).
 			Str("fileId", opts.FileID).
 			Str("userId", opts.UserID).
 			Msg("Error checking file ownership")
-		return false, fmt.Errorf("failed to check file ownership: %w", ErrDatabaseOperation)
+		return false, fmt.Errorf("failed to check file ownership: %w: %w", ErrDatabaseOperation, row.Err())
 	}
 
 	if err := row.Scan(&count); err != nil {
@@ -639,13 +685,18 @@ func (r *SQLiteFileMetadataRepository) IsFileOwnedByUser(ctx context.Context, op
 			Str("fileId", opts.FileID).
 			Str("userId", opts.UserID).
 			Msg("Error scanning file ownership count")
-		return false, fmt.Errorf("failed to check file ownership: %w", ErrDatabaseOperation)
+		return false, fmt.Errorf("failed to check file ownership: %w: %w", ErrDatabaseOperation, err)
 	}
 	return count > 0, nil
 }
 
-// SoftDeleteMetadata marks a file metadata record as deleted
+// SoftDeleteMetadata moves a file to the trash. The record and content stay
+// until the file is restored or purged.
 func (r *SQLiteFileMetadataRepository) SoftDeleteMetadata(ctx context.Context, fileID, userID string) error {
+	if fileID == "" || userID == "" {
+		return fmt.Errorf("%w: file and user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
 	if err := r.acquireLock(ctx); err != nil {
 		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
@@ -654,12 +705,134 @@ func (r *SQLiteFileMetadataRepository) SoftDeleteMetadata(ctx context.Context, f
 	query := `
         UPDATE file_metadata 
         SET 
-            deleted_at = CURRENT_TIMESTAMP, 
+            deleted_at = ?, 
             is_deleted = 1 
-        WHERE id = ? AND user_id = ?
+        WHERE id = ? AND user_id = ? AND (is_deleted = 0 OR is_deleted IS NULL)
     `
-	_, err := r.db.ExecContext(ctx, query, fileID, userID)
-	return err
+	result, err := r.db.ExecContext(ctx, query, time.Now().UTC(), fileID, userID)
+	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Str("fileId", fileID).
+			Msg("Error moving file metadata to trash")
+		return fmt.Errorf("failed to soft delete file metadata: %w: %w", ErrDatabaseOperation, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error checking soft deletion: %w: %w", ErrDatabaseOperation, err)
+	} else if rowsAffected == 0 {
+		return domain.ErrFileNotFound
+	}
+
+	r.logger.Info().
+		Str("fileId", fileID).
+		Msg("File metadata moved to trash")
+	return nil
+}
+
+// ListDeletedMetadata lists the files in a user's trash, most recently
+// deleted first
+func (r *SQLiteFileMetadataRepository) ListDeletedMetadata(ctx context.Context, userID string) ([]*domain.FileMetadataRecord, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	if err := r.acquireLock(ctx); err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	defer r.mu.Unlock()
+
+	query := `
+		SELECT 
+			id, 
+			metadata_json, 
+			storage_path, 
+			processing_status, 
+			user_id,
+			created_at, 
+			updated_at,
+			deleted_at
+		FROM file_metadata
+		WHERE user_id = ? AND version_of = '' AND is_deleted = 1
+		ORDER BY deleted_at DESC, id
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Str("userId", userID).
+			Msg("Error listing deleted file metadata")
+		return nil, fmt.Errorf("failed to list deleted file metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*domain.FileMetadataRecord
+	for rows.Next() {
+		record := &domain.FileMetadataRecord{}
+		var fileMetadataJSON []byte
+		var owner string
+		var deletedAt sql.NullTime
+		if err := rows.Scan(
+			&record.ID,
+			&fileMetadataJSON,
+			&record.StoragePath,
+			&record.ProcessingStatus,
+			&owner,
+			&record.CreatedAt,
+			&record.UpdatedAt,
+			&deletedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan deleted file metadata: %w", err)
+		}
+		record.DeletedAt = deletedAt.Time
+		if len(fileMetadataJSON) > 0 {
+			record.Metadata = &sharedv1.FileMetadata{}
+			if err := json.Unmarshal(fileMetadataJSON, record.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal file metadata: %w", err)
+			}
+			record.Metadata.UserId = owner
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error processing deleted file metadata rows: %w", err)
+	}
+	return records, nil
+}
+
+// RestoreMetadata moves a file out of the user's trash
+func (r *SQLiteFileMetadataRepository) RestoreMetadata(ctx context.Context, fileID, userID string) error {
+	if fileID == "" || userID == "" {
+		return fmt.Errorf("%w: file and user ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	if err := r.acquireLock(ctx); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	defer r.mu.Unlock()
+
+	query := `
+		UPDATE file_metadata
+		SET deleted_at = NULL, is_deleted = 0
+		WHERE id = ? AND user_id = ? AND is_deleted = 1
+	`
+	result, err := r.db.ExecContext(ctx, query, fileID, userID)
+	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Str("fileId", fileID).
+			Msg("Error restoring file metadata")
+		return fmt.Errorf("failed to restore file metadata: %w: %w", ErrDatabaseOperation, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error checking restore: %w: %w", ErrDatabaseOperation, err)
+	} else if rowsAffected == 0 {
+		return domain.ErrFileNotFound
+	}
+
+	r.logger.Info().
+		Str("fileId", fileID).
+		Msg("File metadata restored from trash")
+	return nil
 }
 
 func (r *SQLiteFileMetadataRepository) CleanupExpiredMetadata(ctx context.Context, expiredBefore time.Time) (int64, error) {
@@ -697,6 +870,11 @@ func (r *SQLiteFileMetadataRepository) CleanupExpiredMetadata(ctx context.Contex
                 AND created_at < ? 
                 AND updated_at < ?
                 AND id > ?
+                AND NOT EXISTS (
+                    SELECT 1 FROM file_retention
+                    WHERE file_id = file_metadata.id
+                    AND (legal_hold = 1 OR retain_until > ?)
+                )
                 ORDER BY id
                 LIMIT ?
             )
@@ -704,7 +882,7 @@ func (r *SQLiteFileMetadataRepository) CleanupExpiredMetadata(ctx context.Contex
         `
 
 		// Collect deleted IDs to track progress
-		rows, err := tx.QueryContext(ctx, query, expiredBefore, expiredBefore, lastID, batchSize)
+		rows, err := tx.QueryContext(ctx, query, expiredBefore, expiredBefore, lastID, time.Now().UTC(), batchSize)
 		if err != nil {
 			tx.Rollback()
 			return 0, fmt.Errorf("failed to delete expired metadata: %w", err)
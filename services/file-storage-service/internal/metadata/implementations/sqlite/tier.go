@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// ErrTierChanged represents an error when a file changed tier concurrently
+var ErrTierChanged = errors.New("storage tier changed concurrently")
+
+// SQLiteTierRepository tracks the storage tier and last access of files
+type SQLiteTierRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteTierRepository creates a new SQLite-based storage tier repository
+func NewSQLiteTierRepository(db *sql.DB, logger *logger.Logger) *SQLiteTierRepository {
+	return &SQLiteTierRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListTierCandidates returns completed files in any of tiers that were
+// created before createdBefore and not read since accessedBefore, ordered by
+// ID and starting after afterFileID
+func (r *SQLiteTierRepository) ListTierCandidates(ctx context.Context, tiers []string, createdBefore, accessedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	if len(tiers) == 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: tiers and limit must be set", domain.ErrInvalidInput)
+	}
+
+	args := make([]interface{}, 0, len(tiers)+4)
+	for _, tier := range tiers {
+		args = append(args, tier)
+	}
+	args = append(args, createdBefore, accessedBefore, afterFileID, limit)
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, tier, created_at, last_accessed_at
+		FROM file_metadata
+		WHERE tier IN (?`+strings.Repeat(", ?", len(tiers)-1)+`)
+			AND processing_status = 'COMPLETE'
+			AND created_at <= ?
+			AND COALESCE(last_accessed_at, created_at) <= ?
+			AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tier candidates: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*domain.FileMetadataRecord
+	for rows.Next() {
+		record := &domain.FileMetadataRecord{}
+		var lastAccessedAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.Tier, &record.CreatedAt, &lastAccessedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tier candidate: %w", err)
+		}
+		record.LastAccessedAt = lastAccessedAt.Time
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tier candidates: %w", err)
+	}
+	return records, nil
+}
+
+// MoveTier records that fileID now lives in toTier, provided it is still
+// recorded in fromTier
+func (r *SQLiteTierRepository) MoveTier(ctx context.Context, fileID, fromTier, toTier string) error {
+	if fileID == "" || toTier == "" {
+		return fmt.Errorf("%w: file ID and tier cannot be empty", domain.ErrInvalidInput)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE file_metadata
+		SET tier = ?, updated_at = ?
+		WHERE id = ? AND tier = ?
+	`, toTier, time.Now().UTC(), fileID, fromTier)
+	if err != nil {
+		return fmt.Errorf("failed to move storage tier: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to move storage tier: %w", err)
+	}
+	if affected == 0 {
+		return ErrTierChanged
+	}
+
+	r.logger.Debug().
+		Str("fileId", fileID).
+		Str("fromTier", fromTier).
+		Str("toTier", toTier).
+		Msg("Storage tier moved")
+	return nil
+}
+
+// TouchFile records that fileID was read at accessedAt
+func (r *SQLiteTierRepository) TouchFile(ctx context.Context, fileID string, accessedAt time.Time) error {
+	if fileID == "" {
+		return fmt.Errorf("%w: file ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE file_metadata SET last_accessed_at = ? WHERE id = ?
+	`, accessedAt.UTC(), fileID)
+	if err != nil {
+		return fmt.Errorf("failed to record file access: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteTrashRepository finds the files whose time in the trash is up
+type SQLiteTrashRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteTrashRepository creates a new SQLite-based trash repository
+func NewSQLiteTrashRepository(db *sql.DB, logger *logger.Logger) *SQLiteTrashRepository {
+	return &SQLiteTrashRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListExpiredTrash returns up to limit files moved to the trash before
+// deletedBefore, ordered by ID and starting after afterFileID. Only the ID,
+// owner and timestamps are filled in.
+func (r *SQLiteTrashRepository) ListExpiredTrash(ctx context.Context, deletedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", domain.ErrInvalidInput)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, created_at, updated_at, deleted_at
+		FROM file_metadata
+		WHERE is_deleted = 1 AND deleted_at < ? AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`, deletedBefore.UTC(), afterFileID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired trash: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*domain.FileMetadataRecord
+	for rows.Next() {
+		record := &domain.FileMetadataRecord{Metadata: &sharedv1.FileMetadata{}}
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.Metadata.UserId, &record.CreatedAt, &record.UpdatedAt, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %w", err)
+		}
+		record.Metadata.FileId = record.ID
+		record.DeletedAt = deletedAt.Time
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expired trash: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,161 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// SQLiteVersionRepository keeps the versions of versioned files in SQLite
+type SQLiteVersionRepository struct {
+	db     *sql.DB
+	logger *logger.Logger
+}
+
+// NewSQLiteVersionRepository creates a new SQLite-based version repository
+func NewSQLiteVersionRepository(db *sql.DB, logger *logger.Logger) *SQLiteVersionRepository {
+	return &SQLiteVersionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AddVersion records versionID as the current version of fileID. The first
+// time a file gets a version, its own content is recorded as version one.
+func (r *SQLiteVersionRepository) AddVersion(ctx context.Context, fileID, versionID string, createdAt time.Time) error {
+	if fileID == "" || versionID == "" {
+		return fmt.Errorf("%w: file and version ID cannot be empty", domain.ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO file_versions (file_id, version_id, is_current, created_at)
+		SELECT id, id, 1, created_at FROM file_metadata
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM file_versions WHERE file_id = ?)
+	`, fileID, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to record first version: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE file_versions SET is_current = 0 WHERE file_id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to update current version: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO file_versions (file_id, version_id, is_current, created_at)
+		VALUES (?, ?, 1, ?)
+	`, fileID, versionID, createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to record version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit version: %w", err)
+	}
+
+	r.logger.Info().
+		Str("fileId", fileID).
+		Str("versionId", versionID).
+		Msg("File version added")
+	return nil
+}
+
+// CurrentVersion returns the ID of the current version of fileID, which is
+// fileID itself for files without versions
+func (r *SQLiteVersionRepository) CurrentVersion(ctx context.Context, fileID string) (string, error) {
+	var versionID string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT version_id FROM file_versions WHERE file_id = ? AND is_current = 1
+	`, fileID).Scan(&versionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fileID, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve current version: %w", err)
+	}
+	return versionID, nil
+}
+
+// ListVersions returns the versions of fileID, newest first
+func (r *SQLiteVersionRepository) ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT file_id, version_id, is_current, created_at
+		FROM file_versions
+		WHERE file_id = ?
+		ORDER BY created_at DESC, version_id
+	`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*domain.FileVersion
+	for rows.Next() {
+		v := &domain.FileVersion{}
+		if err := rows.Scan(&v.FileID, &v.VersionID, &v.Current, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	return versions, nil
+}
+
+// SetCurrentVersion makes versionID, which must be a version of fileID, the
+// current one
+func (r *SQLiteVersionRepository) SetCurrentVersion(ctx context.Context, fileID, versionID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", ErrDatabaseOperation)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM file_versions WHERE file_id = ? AND version_id = ?)
+	`, fileID, versionID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up version: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: version %s of %s", domain.ErrFileNotFound, versionID, fileID)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE file_versions SET is_current = (version_id = ?) WHERE file_id = ?
+	`, versionID, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to update current version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit current version: %w", err)
+	}
+	return nil
+}
+
+// RemoveVersion drops a version that is not the current one
+func (r *SQLiteVersionRepository) RemoveVersion(ctx context.Context, fileID, versionID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM file_versions WHERE file_id = ? AND version_id = ? AND is_current = 0
+	`, fileID, versionID)
+	if err != nil {
+		return fmt.Errorf("failed to remove version: %w", err)
+	}
+	return nil
+}
+
+// RemoveVersions drops every version of fileID
+func (r *SQLiteVersionRepository) RemoveVersions(ctx context.Context, fileID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM file_versions WHERE file_id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to remove versions: %w", err)
+	}
+	return nil
+}
@@ -115,6 +115,21 @@ func (mr *MockFileMetadataRepositoryMockRecorder) IsFileOwnedByUser(ctx, opts an
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsFileOwnedByUser", reflect.TypeOf((*MockFileMetadataRepository)(nil).IsFileOwnedByUser), ctx, opts)
 }
 
+// ListDeletedMetadata mocks base method.
+func (m *MockFileMetadataRepository) ListDeletedMetadata(ctx context.Context, userID string) ([]*metadata.FileMetadataRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDeletedMetadata", ctx, userID)
+	ret0, _ := ret[0].([]*metadata.FileMetadataRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDeletedMetadata indicates an expected call of ListDeletedMetadata.
+func (mr *MockFileMetadataRepositoryMockRecorder) ListDeletedMetadata(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDeletedMetadata", reflect.TypeOf((*MockFileMetadataRepository)(nil).ListDeletedMetadata), ctx, userID)
+}
+
 // ListFileMetadata mocks base method.
 func (m *MockFileMetadataRepository) ListFileMetadata(ctx context.Context, opts *metadata.FileMetadataListOptions) ([]*metadata.FileMetadataRecord, error) {
 	m.ctrl.T.Helper()
@@ -144,6 +159,20 @@ func (mr *MockFileMetadataRepositoryMockRecorder) RemoveFileMetadata(ctx, fileID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFileMetadata", reflect.TypeOf((*MockFileMetadataRepository)(nil).RemoveFileMetadata), ctx, fileID)
 }
 
+// RestoreMetadata mocks base method.
+func (m *MockFileMetadataRepository) RestoreMetadata(ctx context.Context, fileID, userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreMetadata", ctx, fileID, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreMetadata indicates an expected call of RestoreMetadata.
+func (mr *MockFileMetadataRepositoryMockRecorder) RestoreMetadata(ctx, fileID, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreMetadata", reflect.TypeOf((*MockFileMetadataRepository)(nil).RestoreMetadata), ctx, fileID, userID)
+}
+
 // RetrieveFileMetadataByID mocks base method.
 func (m *MockFileMetadataRepository) RetrieveFileMetadataByID(ctx context.Context, fileID string) (*metadata.FileMetadataRecord, error) {
 	m.ctrl.T.Helper()
@@ -85,6 +85,20 @@ func (mr *MockMetadataServiceMockRecorder) CommitTx(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitTx", reflect.TypeOf((*MockMetadataService)(nil).CommitTx), ctx)
 }
 
+// CreateBucket mocks base method.
+func (m *MockMetadataService) CreateBucket(ctx context.Context, bucket *metadata.Bucket) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBucket", ctx, bucket)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateBucket indicates an expected call of CreateBucket.
+func (mr *MockMetadataServiceMockRecorder) CreateBucket(ctx, bucket any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBucket", reflect.TypeOf((*MockMetadataService)(nil).CreateBucket), ctx, bucket)
+}
+
 // CreateFileMetadata mocks base method.
 func (m *MockMetadataService) CreateFileMetadata(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -99,6 +113,20 @@ func (mr *MockMetadataServiceMockRecorder) CreateFileMetadata(ctx any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateFileMetadata", reflect.TypeOf((*MockMetadataService)(nil).CreateFileMetadata), ctx)
 }
 
+// DeleteBucket mocks base method.
+func (m *MockMetadataService) DeleteBucket(ctx context.Context, userID, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBucket", ctx, userID, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteBucket indicates an expected call of DeleteBucket.
+func (mr *MockMetadataServiceMockRecorder) DeleteBucket(ctx, userID, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBucket", reflect.TypeOf((*MockMetadataService)(nil).DeleteBucket), ctx, userID, name)
+}
+
 // DeleteFileMetadata mocks base method.
 func (m *MockMetadataService) DeleteFileMetadata(ctx context.Context, userID, fileID string) error {
 	m.ctrl.T.Helper()
@@ -113,6 +141,21 @@ func (mr *MockMetadataServiceMockRecorder) DeleteFileMetadata(ctx, userID, fileI
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFileMetadata", reflect.TypeOf((*MockMetadataService)(nil).DeleteFileMetadata), ctx, userID, fileID)
 }
 
+// GetBucket mocks base method.
+func (m *MockMetadataService) GetBucket(ctx context.Context, userID, name string) (*metadata.Bucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBucket", ctx, userID, name)
+	ret0, _ := ret[0].(*metadata.Bucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBucket indicates an expected call of GetBucket.
+func (mr *MockMetadataServiceMockRecorder) GetBucket(ctx, userID, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBucket", reflect.TypeOf((*MockMetadataService)(nil).GetBucket), ctx, userID, name)
+}
+
 // GetFileMetadata mocks base method.
 func (m *MockMetadataService) GetFileMetadata(ctx context.Context, userID, fileID string) (*metadata.FileMetadataRecord, error) {
 	m.ctrl.T.Helper()
@@ -128,6 +171,52 @@ func (mr *MockMetadataServiceMockRecorder) GetFileMetadata(ctx, userID, fileID a
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFileMetadata", reflect.TypeOf((*MockMetadataService)(nil).GetFileMetadata), ctx, userID, fileID)
 }
 
+// GetFileRetention mocks base method.
+func (m *MockMetadataService) GetFileRetention(ctx context.Context, fileID string) (*metadata.Retention, []*metadata.RetentionChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFileRetention", ctx, fileID)
+	ret0, _ := ret[0].(*metadata.Retention)
+	ret1, _ := ret[1].([]*metadata.RetentionChange)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetFileRetention indicates an expected call of GetFileRetention.
+func (mr *MockMetadataServiceMockRecorder) GetFileRetention(ctx, fileID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFileRetention", reflect.TypeOf((*MockMetadataService)(nil).GetFileRetention), ctx, fileID)
+}
+
+// ListBuckets mocks base method.
+func (m *MockMetadataService) ListBuckets(ctx context.Context, userID string) ([]*metadata.Bucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBuckets", ctx, userID)
+	ret0, _ := ret[0].([]*metadata.Bucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBuckets indicates an expected call of ListBuckets.
+func (mr *MockMetadataServiceMockRecorder) ListBuckets(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBuckets", reflect.TypeOf((*MockMetadataService)(nil).ListBuckets), ctx, userID)
+}
+
+// ListDeletedFileMetadata mocks base method.
+func (m *MockMetadataService) ListDeletedFileMetadata(ctx context.Context, userID string) ([]*metadata.FileMetadataRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDeletedFileMetadata", ctx, userID)
+	ret0, _ := ret[0].([]*metadata.FileMetadataRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDeletedFileMetadata indicates an expected call of ListDeletedFileMetadata.
+func (mr *MockMetadataServiceMockRecorder) ListDeletedFileMetadata(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDeletedFileMetadata", reflect.TypeOf((*MockMetadataService)(nil).ListDeletedFileMetadata), ctx, userID)
+}
+
 // ListFileMetadata mocks base method.
 func (m *MockMetadataService) ListFileMetadata(ctx context.Context, opts *metadata.FileMetadataListOptions) ([]*metadata.FileMetadataRecord, error) {
 	m.ctrl.T.Helper()
@@ -158,6 +247,20 @@ func (mr *MockMetadataServiceMockRecorder) PrepareUpload(ctx, params any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrepareUpload", reflect.TypeOf((*MockMetadataService)(nil).PrepareUpload), ctx, params)
 }
 
+// RestoreFileMetadata mocks base method.
+func (m *MockMetadataService) RestoreFileMetadata(ctx context.Context, userID, fileID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreFileMetadata", ctx, userID, fileID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreFileMetadata indicates an expected call of RestoreFileMetadata.
+func (mr *MockMetadataServiceMockRecorder) RestoreFileMetadata(ctx, userID, fileID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreFileMetadata", reflect.TypeOf((*MockMetadataService)(nil).RestoreFileMetadata), ctx, userID, fileID)
+}
+
 // RetrieveFileMetadataByID mocks base method.
 func (m *MockMetadataService) RetrieveFileMetadataByID(ctx context.Context, fileID string) (*metadata.FileMetadataRecord, error) {
 	m.ctrl.T.Helper()
@@ -187,6 +290,49 @@ func (mr *MockMetadataServiceMockRecorder) RollbackTx(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackTx", reflect.TypeOf((*MockMetadataService)(nil).RollbackTx), ctx)
 }
 
+// SetFileRetention mocks base method.
+func (m *MockMetadataService) SetFileRetention(ctx context.Context, change *metadata.RetentionChange) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetFileRetention", ctx, change)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetFileRetention indicates an expected call of SetFileRetention.
+func (mr *MockMetadataServiceMockRecorder) SetFileRetention(ctx, change any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFileRetention", reflect.TypeOf((*MockMetadataService)(nil).SetFileRetention), ctx, change)
+}
+
+// TrashFileMetadata mocks base method.
+func (m *MockMetadataService) TrashFileMetadata(ctx context.Context, userID, fileID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TrashFileMetadata", ctx, userID, fileID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TrashFileMetadata indicates an expected call of TrashFileMetadata.
+func (mr *MockMetadataServiceMockRecorder) TrashFileMetadata(ctx, userID, fileID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TrashFileMetadata", reflect.TypeOf((*MockMetadataService)(nil).TrashFileMetadata), ctx, userID, fileID)
+}
+
+// UpdateBucket mocks base method.
+func (m *MockMetadataService) UpdateBucket(ctx context.Context, userID, name string, settings metadata.BucketSettings) (*metadata.Bucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBucket", ctx, userID, name, settings)
+	ret0, _ := ret[0].(*metadata.Bucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateBucket indicates an expected call of UpdateBucket.
+func (mr *MockMetadataServiceMockRecorder) UpdateBucket(ctx, userID, name, settings any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBucket", reflect.TypeOf((*MockMetadataService)(nil).UpdateBucket), ctx, userID, name, settings)
+}
+
 // UpdateFileMetadata mocks base method.
 func (m *MockMetadataService) UpdateFileMetadata(ctx context.Context, fileID string, record *metadata.FileMetadataRecord) error {
 	m.ctrl.T.Helper()
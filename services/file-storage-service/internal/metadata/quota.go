@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// QuotaPolicy resolves a user's limits: a per-user entry wins, otherwise the
+// most generous limits among the user's roles, otherwise Default
+type QuotaPolicy struct {
+	Default domain.QuotaLimits
+	Roles   map[string]domain.QuotaLimits
+	Users   map[string]domain.QuotaLimits
+}
+
+// LimitsFor returns the limits that apply to userID holding roles
+func (p QuotaPolicy) LimitsFor(userID string, roles []string) domain.QuotaLimits {
+	if limits, ok := p.Users[userID]; ok {
+		return limits
+	}
+
+	var limits domain.QuotaLimits
+	var matched bool
+	for _, role := range roles {
+		roleLimits, ok := p.Roles[role]
+		if !ok {
+			continue
+		}
+		if !matched {
+			limits, matched = roleLimits, true
+			continue
+		}
+		limits.MaxBytes = mostGenerous(limits.MaxBytes, roleLimits.MaxBytes)
+		limits.MaxFiles = mostGenerous(limits.MaxFiles, roleLimits.MaxFiles)
+	}
+	if !matched {
+		return p.Default
+	}
+	return limits
+}
+
+// mostGenerous picks the larger limit, zero being unlimited
+func mostGenerous(a, b int64) int64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+// QuotaService enforces per-user storage quotas. Space is reserved when an
+// upload is prepared, then committed once the content is stored or released
+// if the upload fails.
+type QuotaService interface {
+	Reserve(ctx context.Context, userID string, roles []string, fileID string, size int64) error
+	Commit(ctx context.Context, userID, fileID string, size int64) error
+	Release(ctx context.Context, fileID string) error
+	// Free returns the space of a deleted file
+	Free(ctx context.Context, userID string, size int64) error
+	ReleaseExpired(ctx context.Context, expiredBefore time.Time) (int64, error)
+	Usage(ctx context.Context, userID string, roles []string) (*domain.QuotaUsage, error)
+}
+
+type QuotaServiceImpl struct {
+	quotaRepo QuotaRepository
+	policy    QuotaPolicy
+	logger    *logger.Logger
+}
+
+// NewQuotaService creates a new quota service
+func NewQuotaService(quotaRepo QuotaRepository, policy QuotaPolicy, logger *logger.Logger) *QuotaServiceImpl {
+	return &QuotaServiceImpl{
+		quotaRepo: quotaRepo,
+		policy:    policy,
+		logger:    logger,
+	}
+}
+
+func (s *QuotaServiceImpl) Reserve(ctx context.Context, userID string, roles []string, fileID string, size int64) error {
+	limits := s.policy.LimitsFor(userID, roles)
+	if err := s.quotaRepo.ReserveQuota(ctx, userID, fileID, size, limits); err != nil {
+		s.logger.Warn().
+			Err(err).
+			Str("userId", userID).
+			Int64("size", size).
+			Int64("maxBytes", limits.MaxBytes).
+			Int64("maxFiles", limits.MaxFiles).
+			Msg("Failed to reserve quota")
+		return err
+	}
+	return nil
+}
+
+func (s *QuotaServiceImpl) Commit(ctx context.Context, userID, fileID string, size int64) error {
+	return s.quotaRepo.CommitQuota(ctx, userID, fileID, size)
+}
+
+func (s *QuotaServiceImpl) Release(ctx context.Context, fileID string) error {
+	return s.quotaRepo.ReleaseQuota(ctx, fileID)
+}
+
+func (s *QuotaServiceImpl) Free(ctx context.Context, userID string, size int64) error {
+	return s.quotaRepo.FreeQuota(ctx, userID, size)
+}
+
+func (s *QuotaServiceImpl) ReleaseExpired(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	return s.quotaRepo.ReleaseExpiredReservations(ctx, expiredBefore)
+}
+
+func (s *QuotaServiceImpl) Usage(ctx context.Context, userID string, roles []string) (*domain.QuotaUsage, error) {
+	usage, err := s.quotaRepo.GetQuotaUsage(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota usage: %w", err)
+	}
+	usage.Limits = s.policy.LimitsFor(userID, roles)
+	return usage, nil
+}
+
+var _ QuotaService = (*QuotaServiceImpl)(nil)
@@ -0,0 +1,84 @@
+package metadata
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotaPolicy_LimitsFor(t *testing.T) {
+	policy := QuotaPolicy{
+		Default: domain.QuotaLimits{MaxBytes: 100, MaxFiles: 10},
+		Roles: map[string]domain.QuotaLimits{
+			"editor":  {MaxBytes: 1000, MaxFiles: 5},
+			"archive": {MaxBytes: 500, MaxFiles: 50},
+			"admin":   {},
+		},
+		Users: map[string]domain.QuotaLimits{
+			"vip": {MaxBytes: 1},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		roles  []string
+		want   domain.QuotaLimits
+	}{
+		{"no roles", "u1", nil, domain.QuotaLimits{MaxBytes: 100, MaxFiles: 10}},
+		{"unknown role", "u1", []string{"viewer"}, domain.QuotaLimits{MaxBytes: 100, MaxFiles: 10}},
+		{"single role", "u1", []string{"editor"}, domain.QuotaLimits{MaxBytes: 1000, MaxFiles: 5}},
+		{"most generous of each", "u1", []string{"editor", "archive"}, domain.QuotaLimits{MaxBytes: 1000, MaxFiles: 50}},
+		{"unlimited role wins", "u1", []string{"editor", "admin"}, domain.QuotaLimits{}},
+		{"user override", "vip", []string{"admin"}, domain.QuotaLimits{MaxBytes: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.LimitsFor(tt.userID, tt.roles); got != tt.want {
+				t.Errorf("LimitsFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// exhaustedQuotas rejects every reservation
+type exhaustedQuotas struct{}
+
+func (exhaustedQuotas) Reserve(ctx context.Context, userID string, roles []string, fileID string, size int64) error {
+	return domain.ErrQuotaExceeded
+}
+func (exhaustedQuotas) Commit(ctx context.Context, userID, fileID string, size int64) error {
+	return nil
+}
+func (exhaustedQuotas) Release(ctx context.Context, fileID string) error          { return nil }
+func (exhaustedQuotas) Free(ctx context.Context, userID string, size int64) error { return nil }
+func (exhaustedQuotas) ReleaseExpired(context.Context, time.Time) (int64, error)  { return 0, nil }
+func (exhaustedQuotas) Usage(context.Context, string, []string) (*domain.QuotaUsage, error) {
+	return &domain.QuotaUsage{}, nil
+}
+
+func TestMetadataServiceImpl_PrepareUpload_QuotaExceeded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := NewMockFileMetadataRepository(ctrl)
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	service := NewMetadataService(mockRepo, exhaustedQuotas{}, nil, nil, &testLogger)
+
+	// No metadata is created for a rejected upload
+	mockRepo.EXPECT().CreateFileMetadata(gomock.Any(), gomock.Any()).Times(0)
+
+	_, err := service.PrepareUpload(context.Background(), &PrepareUploadParams{
+		FileName: "data.csv",
+		FileSize: 1024,
+		UserID:   "u1",
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("PrepareUpload() error = %v, want ResourceExhausted", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	memoryRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/memory"
 	sqliteRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/sqlite"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 )
@@ -19,7 +20,11 @@ type FileMetadataRepository interface {
 	RemoveFileMetadata(ctx context.Context, fileID string) error
 	UpdateFileMetadata(ctx context.Context, metadata *domain.FileMetadataRecord) error
 	IsFileOwnedByUser(ctx context.Context, opts *domain.FileMetadataListOptions) (bool, error)
+	// SoftDeleteMetadata moves a file to the trash
 	SoftDeleteMetadata(ctx context.Context, fileID, userID string) error
+	ListDeletedMetadata(ctx context.Context, userID string) ([]*domain.FileMetadataRecord, error)
+	// RestoreMetadata moves a file out of the trash
+	RestoreMetadata(ctx context.Context, fileID, userID string) error
 	CleanupExpiredMetadata(ctx context.Context, expirationTime time.Time) (int64, error)
 	// Transaction methods
 	BeginTx(ctx context.Context) (interface{}, error)
@@ -27,10 +32,133 @@ type FileMetadataRepository interface {
 	RollbackTx(ctx context.Context, tx interface{}) error
 }
 
+// BlobRepository tracks which content-addressed blob each file points to and
+// how many files reference each blob
+type BlobRepository interface {
+	AddBlobReference(ctx context.Context, fileID, hash string, size int64) (created bool, err error)
+	RemoveBlobReference(ctx context.Context, fileID string) (hash string, remaining int64, err error)
+	GetBlobHash(ctx context.Context, fileID string) (string, error)
+	ListBlobFileIDs(ctx context.Context, prefix, afterFileID string, limit int) ([]string, error)
+}
+
+// DataKeyRepository lists and re-wraps the per-file data keys of encrypted
+// files, for master key rotation
+type DataKeyRepository interface {
+	// ListDataKeys returns up to limit encrypted records ordered by ID, after
+	// afterFileID, whose data key is not wrapped with excludeKeyID
+	ListDataKeys(ctx context.Context, excludeKeyID, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error)
+	// RewrapDataKey replaces the wrapped key if it is still wrapped with oldKeyID
+	RewrapDataKey(ctx context.Context, fileID, oldKeyID, newKeyID string, wrappedKey []byte) error
+}
+
+// ReplicaRepository records which mirror replicas are missing a file, so they
+// can be repaired in the background
+type ReplicaRepository interface {
+	MarkReplicaMissing(ctx context.Context, fileID, replica, reason string) error
+	ClearReplicaMissing(ctx context.Context, fileID, replica string) error
+	// ClearFile drops every record for fileID, e.g. once it is deleted
+	ClearFile(ctx context.Context, fileID string) error
+	// ListMissingReplicas returns up to limit records, oldest first
+	ListMissingReplicas(ctx context.Context, limit int) ([]*domain.MissingReplica, error)
+}
+
+// TierRepository tracks which storage tier holds each file and when it was
+// last read, for lifecycle policies to move files between tiers
+type TierRepository interface {
+	// ListTierCandidates returns up to limit completed files in tiers created
+	// before createdBefore and not read since accessedBefore, by ID after afterFileID
+	ListTierCandidates(ctx context.Context, tiers []string, createdBefore, accessedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error)
+	// MoveTier records the file in toTier, provided it is still in fromTier
+	MoveTier(ctx context.Context, fileID, fromTier, toTier string) error
+	TouchFile(ctx context.Context, fileID string, accessedAt time.Time) error
+}
+
+// QuotaRepository tracks per-user storage usage and the quota reserved by
+// uploads in progress
+type QuotaRepository interface {
+	// ReserveQuota fails with domain.ErrQuotaExceeded if size does not fit in limits
+	ReserveQuota(ctx context.Context, userID, fileID string, size int64, limits domain.QuotaLimits) error
+	CommitQuota(ctx context.Context, userID, fileID string, size int64) error
+	ReleaseQuota(ctx context.Context, fileID string) error
+	FreeQuota(ctx context.Context, userID string, size int64) error
+	ReleaseExpiredReservations(ctx context.Context, expiredBefore time.Time) (int64, error)
+	GetQuotaUsage(ctx context.Context, userID string) (*domain.QuotaUsage, error)
+}
+
+// QuarantineRepository records files whose stored content no longer matches
+// their checksum
+type QuarantineRepository interface {
+	// QuarantineFile records the finding and marks the file CORRUPTED
+	QuarantineFile(ctx context.Context, finding *domain.QuarantinedFile) error
+	// ListQuarantinedFiles returns up to limit findings, most recent first
+	ListQuarantinedFiles(ctx context.Context, limit int) ([]*domain.QuarantinedFile, error)
+}
+
+// ReconcileRepository looks up metadata records in bulk, for reconciling them
+// with the content in storage
+type ReconcileRepository interface {
+	// FindFileIDs returns which of fileIDs have a metadata record
+	FindFileIDs(ctx context.Context, fileIDs []string) (map[string]bool, error)
+	// ListCompleteFiles returns up to limit COMPLETE records by ID after afterFileID
+	ListCompleteFiles(ctx context.Context, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error)
+}
+
+// VersionRepository tracks the versions of versioned files and which of them
+// is current
+type VersionRepository interface {
+	// AddVersion records versionID as the current version of fileID
+	AddVersion(ctx context.Context, fileID, versionID string, createdAt time.Time) error
+	// CurrentVersion returns fileID itself for files without versions
+	CurrentVersion(ctx context.Context, fileID string) (string, error)
+	// ListVersions returns the versions of fileID, newest first
+	ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error)
+	SetCurrentVersion(ctx context.Context, fileID, versionID string) error
+	// RemoveVersion drops a version other than the current one
+	RemoveVersion(ctx context.Context, fileID, versionID string) error
+	RemoveVersions(ctx context.Context, fileID string) error
+}
+
+// TrashRepository finds the files in the trash that are due to be purged
+type TrashRepository interface {
+	// ListExpiredTrash returns up to limit files moved to the trash before
+	// deletedBefore, by ID after afterFileID
+	ListExpiredTrash(ctx context.Context, deletedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error)
+}
+
+// RetentionRepository keeps the retention and legal holds that protect files
+// from deletion, and the audit trail of their changes
+type RetentionRepository interface {
+	// GetRetention returns nil if no retention was ever set for fileID
+	GetRetention(ctx context.Context, fileID string) (*domain.Retention, error)
+	// SetRetention fails with domain.ErrRetentionShortened if a retention
+	// period that has not ended would be moved earlier
+	SetRetention(ctx context.Context, change *domain.RetentionChange) error
+	// ListRetentionChanges returns the changes to fileID's retention, oldest first
+	ListRetentionChanges(ctx context.Context, fileID string) ([]*domain.RetentionChange, error)
+}
+
+// BucketRepository keeps buckets, their owners and upload settings
+type BucketRepository interface {
+	// CreateBucket fails with domain.ErrBucketExists if the name is taken
+	CreateBucket(ctx context.Context, bucket *domain.Bucket) error
+	// GetBucket fails with domain.ErrBucketNotFound if there is no such bucket
+	GetBucket(ctx context.Context, name string) (*domain.Bucket, error)
+	// ListBuckets returns the buckets owned by ownerID, by name
+	ListBuckets(ctx context.Context, ownerID string) ([]*domain.Bucket, error)
+	UpdateBucketSettings(ctx context.Context, name string, settings domain.BucketSettings) error
+	// DeleteBucket fails with domain.ErrBucketNotEmpty while any file, also
+	// one in the trash, is in the bucket
+	DeleteBucket(ctx context.Context, name string) error
+	IsBucketOwnedByUser(ctx context.Context, name, userID string) (bool, error)
+}
+
 type RepositoryType string
 
 const (
 	SQLite RepositoryType = "sqlite"
+	// Memory keeps metadata in a *memory.Database, for tests and for running
+	// without a disk; only some repositories have a memory implementation
+	Memory RepositoryType = "memory"
 )
 
 func NewRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (FileMetadataRepository, error) {
@@ -41,9 +169,207 @@ func NewRepository(repoType RepositoryType, db interface{}, logger *logger.Logge
 			return nil, errors.New("invalid database type")
 		}
 		return sqliteRepository.NewSQLiteFileMetadataRepository(sqlDb, logger), nil
+	case Memory:
+		memoryDb, ok := db.(*memoryRepository.Database)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return memoryRepository.NewMemoryFileMetadataRepository(memoryDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewBlobRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (BlobRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteBlobRepository(sqlDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewDataKeyRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (DataKeyRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteDataKeyRepository(sqlDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewReplicaRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (ReplicaRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteReplicaRepository(sqlDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewTierRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (TierRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteTierRepository(sqlDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewQuotaRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (QuotaRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteQuotaRepository(sqlDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewQuarantineRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (QuarantineRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteQuarantineRepository(sqlDb, logger), nil
+	case Memory:
+		memoryDb, ok := db.(*memoryRepository.Database)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return memoryRepository.NewMemoryQuarantineRepository(memoryDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewReconcileRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (ReconcileRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteReconcileRepository(sqlDb, logger), nil
+	case Memory:
+		memoryDb, ok := db.(*memoryRepository.Database)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return memoryRepository.NewMemoryReconcileRepository(memoryDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewVersionRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (VersionRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteVersionRepository(sqlDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewTrashRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (TrashRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteTrashRepository(sqlDb, logger), nil
+	case Memory:
+		memoryDb, ok := db.(*memoryRepository.Database)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return memoryRepository.NewMemoryTrashRepository(memoryDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewRetentionRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (RetentionRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteRetentionRepository(sqlDb, logger), nil
+	case Memory:
+		memoryDb, ok := db.(*memoryRepository.Database)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return memoryRepository.NewMemoryRetentionRepository(memoryDb, logger), nil
+	default:
+		return nil, errors.New("invalid repository type")
+	}
+}
+
+func NewBucketRepository(repoType RepositoryType, db interface{}, logger *logger.Logger) (BucketRepository, error) {
+	switch repoType {
+	case SQLite:
+		sqlDb, ok := db.(*sql.DB)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return sqliteRepository.NewSQLiteBucketRepository(sqlDb, logger), nil
+	case Memory:
+		memoryDb, ok := db.(*memoryRepository.Database)
+		if !ok {
+			return nil, errors.New("invalid database type")
+		}
+		return memoryRepository.NewMemoryBucketRepository(memoryDb, logger), nil
 	default:
 		return nil, errors.New("invalid repository type")
 	}
 }
 
 var _ FileMetadataRepository = (*sqliteRepository.SQLiteFileMetadataRepository)(nil)
+var _ BlobRepository = (*sqliteRepository.SQLiteBlobRepository)(nil)
+var _ DataKeyRepository = (*sqliteRepository.SQLiteDataKeyRepository)(nil)
+var _ ReplicaRepository = (*sqliteRepository.SQLiteReplicaRepository)(nil)
+var _ TierRepository = (*sqliteRepository.SQLiteTierRepository)(nil)
+var _ QuotaRepository = (*sqliteRepository.SQLiteQuotaRepository)(nil)
+var _ QuarantineRepository = (*sqliteRepository.SQLiteQuarantineRepository)(nil)
+var _ ReconcileRepository = (*sqliteRepository.SQLiteReconcileRepository)(nil)
+var _ VersionRepository = (*sqliteRepository.SQLiteVersionRepository)(nil)
+var _ TrashRepository = (*sqliteRepository.SQLiteTrashRepository)(nil)
+var _ RetentionRepository = (*sqliteRepository.SQLiteRetentionRepository)(nil)
+var _ BucketRepository = (*sqliteRepository.SQLiteBucketRepository)(nil)
+var _ FileMetadataRepository = (*RetryingRepository)(nil)
+var _ BlobRepository = (*RetryingBlobRepository)(nil)
+var _ FileMetadataRepository = (*memoryRepository.MemoryFileMetadataRepository)(nil)
+var _ QuarantineRepository = (*memoryRepository.MemoryQuarantineRepository)(nil)
+var _ ReconcileRepository = (*memoryRepository.MemoryReconcileRepository)(nil)
+var _ TrashRepository = (*memoryRepository.MemoryTrashRepository)(nil)
+var _ BucketRepository = (*memoryRepository.MemoryBucketRepository)(nil)
+var _ RetentionRepository = (*memoryRepository.MemoryRetentionRepository)(nil)
@@ -0,0 +1,224 @@
+package metadata
+
+import (
+	"context"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	sqliteRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/sqlite"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// RetryingRepository retries FileMetadataRepository calls that fail because
+// the database is busy. Operations are named create, retrieve, list, remove,
+// update, ownership, soft_delete, list_deleted, restore, cleanup and begin_tx
+// for per-operation policies. Commits and rollbacks are never retried, as a
+// transaction cannot be finished twice.
+type RetryingRepository struct {
+	inner  FileMetadataRepository
+	cfg    retry.Config
+	logger *logger.Logger
+}
+
+// NewRetryingRepository wraps inner. Errors are classified with
+// sqlite.IsTransient unless cfg says otherwise.
+func NewRetryingRepository(inner FileMetadataRepository, cfg retry.Config, logger *logger.Logger) *RetryingRepository {
+	if cfg.IsRetryable == nil {
+		cfg.IsRetryable = sqliteRepository.IsTransient
+	}
+	return &RetryingRepository{inner: inner, cfg: cfg, logger: logger}
+}
+
+func (r *RetryingRepository) CreateFileMetadata(ctx context.Context, metadata *domain.FileMetadataRecord) error {
+	return r.do(ctx, "create", func() error {
+		return r.inner.CreateFileMetadata(ctx, metadata)
+	})
+}
+
+func (r *RetryingRepository) RetrieveFileMetadataByID(ctx context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+	var record *domain.FileMetadataRecord
+	err := r.do(ctx, "retrieve", func() error {
+		var err error
+		record, err = r.inner.RetrieveFileMetadataByID(ctx, fileID)
+		return err
+	})
+	return record, err
+}
+
+func (r *RetryingRepository) ListFileMetadata(ctx context.Context, opts *domain.FileMetadataListOptions) ([]*domain.FileMetadataRecord, error) {
+	var records []*domain.FileMetadataRecord
+	err := r.do(ctx, "list", func() error {
+		var err error
+		records, err = r.inner.ListFileMetadata(ctx, opts)
+		return err
+	})
+	return records, err
+}
+
+func (r *RetryingRepository) RemoveFileMetadata(ctx context.Context, fileID string) error {
+	return r.do(ctx, "remove", func() error {
+		return r.inner.RemoveFileMetadata(ctx, fileID)
+	})
+}
+
+func (r *RetryingRepository) UpdateFileMetadata(ctx context.Context, metadata *domain.FileMetadataRecord) error {
+	return r.do(ctx, "update", func() error {
+		return r.inner.UpdateFileMetadata(ctx, metadata)
+	})
+}
+
+func (r *RetryingRepository) IsFileOwnedByUser(ctx context.Context, opts *domain.FileMetadataListOptions) (bool, error) {
+	var owned bool
+	err := r.do(ctx, "ownership", func() error {
+		var err error
+		owned, err = r.inner.IsFileOwnedByUser(ctx, opts)
+		return err
+	})
+	return owned, err
+}
+
+func (r *RetryingRepository) SoftDeleteMetadata(ctx context.Context, fileID, userID string) error {
+	return r.do(ctx, "soft_delete", func() error {
+		return r.inner.SoftDeleteMetadata(ctx, fileID, userID)
+	})
+}
+
+func (r *RetryingRepository) ListDeletedMetadata(ctx context.Context, userID string) ([]*domain.FileMetadataRecord, error) {
+	var records []*domain.FileMetadataRecord
+	err := r.do(ctx, "list_deleted", func() error {
+		var err error
+		records, err = r.inner.ListDeletedMetadata(ctx, userID)
+		return err
+	})
+	return records, err
+}
+
+func (r *RetryingRepository) RestoreMetadata(ctx context.Context, fileID, userID string) error {
+	return r.do(ctx, "restore", func() error {
+		return r.inner.RestoreMetadata(ctx, fileID, userID)
+	})
+}
+
+func (r *RetryingRepository) CleanupExpiredMetadata(ctx context.Context, expirationTime time.Time) (int64, error) {
+	var removed int64
+	err := r.do(ctx, "cleanup", func() error {
+		var err error
+		removed, err = r.inner.CleanupExpiredMetadata(ctx, expirationTime)
+		return err
+	})
+	return removed, err
+}
+
+func (r *RetryingRepository) BeginTx(ctx context.Context) (interface{}, error) {
+	var tx interface{}
+	err := r.do(ctx, "begin_tx", func() error {
+		var err error
+		tx, err = r.inner.BeginTx(ctx)
+		return err
+	})
+	return tx, err
+}
+
+func (r *RetryingRepository) CommitTx(ctx context.Context, tx interface{}) error {
+	return r.inner.CommitTx(ctx, tx)
+}
+
+func (r *RetryingRepository) RollbackTx(ctx context.Context, tx interface{}) error {
+	return r.inner.RollbackTx(ctx, tx)
+}
+
+func (r *RetryingRepository) do(ctx context.Context, operation string, fn func() error) error {
+	policy := r.cfg.For(operation)
+	onRetry := policy.OnRetry
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		r.logger.Warn().
+			Err(err).
+			Str("operation", operation).
+			Int("attempt", attempt).
+			Dur("wait", wait).
+			Msg("Retrying metadata operation")
+		if onRetry != nil {
+			onRetry(attempt, err, wait)
+		}
+	}
+	return policy.Do(ctx, fn)
+}
+
+// RetryingBlobRepository retries BlobRepository calls that fail because the
+// database is busy, like RetryingRepository. Every call runs in a transaction
+// of its own, so a failed attempt leaves nothing behind. Operations are named
+// add_blob_reference, remove_blob_reference, get_blob_hash and
+// list_blob_file_ids.
+type RetryingBlobRepository struct {
+	inner  BlobRepository
+	cfg    retry.Config
+	logger *logger.Logger
+}
+
+// NewRetryingBlobRepository wraps inner. Errors are classified with
+// sqlite.IsTransient unless cfg says otherwise.
+func NewRetryingBlobRepository(inner BlobRepository, cfg retry.Config, logger *logger.Logger) *RetryingBlobRepository {
+	if cfg.IsRetryable == nil {
+		cfg.IsRetryable = sqliteRepository.IsTransient
+	}
+	return &RetryingBlobRepository{inner: inner, cfg: cfg, logger: logger}
+}
+
+func (r *RetryingBlobRepository) AddBlobReference(ctx context.Context, fileID, hash string, size int64) (bool, error) {
+	var created bool
+	err := r.do(ctx, "add_blob_reference", func() error {
+		var err error
+		created, err = r.inner.AddBlobReference(ctx, fileID, hash, size)
+		return err
+	})
+	return created, err
+}
+
+func (r *RetryingBlobRepository) RemoveBlobReference(ctx context.Context, fileID string) (string, int64, error) {
+	var hash string
+	var remaining int64
+	err := r.do(ctx, "remove_blob_reference", func() error {
+		var err error
+		hash, remaining, err = r.inner.RemoveBlobReference(ctx, fileID)
+		return err
+	})
+	return hash, remaining, err
+}
+
+func (r *RetryingBlobRepository) GetBlobHash(ctx context.Context, fileID string) (string, error) {
+	var hash string
+	err := r.do(ctx, "get_blob_hash", func() error {
+		var err error
+		hash, err = r.inner.GetBlobHash(ctx, fileID)
+		return err
+	})
+	return hash, err
+}
+
+func (r *RetryingBlobRepository) ListBlobFileIDs(ctx context.Context, prefix, afterFileID string, limit int) ([]string, error) {
+	var fileIDs []string
+	err := r.do(ctx, "list_blob_file_ids", func() error {
+		var err error
+		fileIDs, err = r.inner.ListBlobFileIDs(ctx, prefix, afterFileID, limit)
+		return err
+	})
+	return fileIDs, err
+}
+
+func (r *RetryingBlobRepository) do(ctx context.Context, operation string, fn func() error) error {
+	policy := r.cfg.For(operation)
+	onRetry := policy.OnRetry
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		r.logger.Warn().
+			Err(err).
+			Str("operation", operation).
+			Int("attempt", attempt).
+			Dur("wait", wait).
+			Msg("Retrying blob reference operation")
+		if onRetry != nil {
+			onRetry(attempt, err, wait)
+		}
+	}
+	return policy.Do(ctx, fn)
+}
@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	sqliteRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/sqlite"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRetryingRepository_RetriesBusyDatabase(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	inner := NewMockFileMetadataRepository(ctrl)
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	repo := NewRetryingRepository(inner, retry.Config{
+		Policy: retry.Policy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+	}, &testLogger)
+	ctx := context.Background()
+
+	busy := fmt.Errorf("failed to update file metadata: %w: %w", sqliteRepository.ErrDatabaseOperation, sqlite3.Error{Code: sqlite3.ErrBusy})
+	record := &domain.FileMetadataRecord{ID: "file-a"}
+	gomock.InOrder(
+		inner.EXPECT().UpdateFileMetadata(ctx, record).Return(busy).Times(2),
+		inner.EXPECT().UpdateFileMetadata(ctx, record).Return(nil),
+	)
+	if err := repo.UpdateFileMetadata(ctx, record); err != nil {
+		t.Errorf("UpdateFileMetadata() error = %v, want success on the third attempt", err)
+	}
+
+	// Missing records and failed commits are final
+	inner.EXPECT().RetrieveFileMetadataByID(ctx, "file-b").Return(nil, domain.ErrFileNotFound)
+	if _, err := repo.RetrieveFileMetadataByID(ctx, "file-b"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("RetrieveFileMetadataByID() error = %v, want ErrFileNotFound", err)
+	}
+	inner.EXPECT().CommitTx(ctx, "tx").Return(busy)
+	if err := repo.CommitTx(ctx, "tx"); !errors.Is(err, sqliteRepository.ErrDatabaseOperation) {
+		t.Errorf("CommitTx() error = %v, want the busy error", err)
+	}
+}
+
+// lockedBlobRepository fails with a locked shared-cache table a number of
+// times before adding a reference
+type lockedBlobRepository struct {
+	BlobRepository
+	failures int
+	calls    int
+}
+
+func (r *lockedBlobRepository) AddBlobReference(ctx context.Context, fileID, hash string, size int64) (bool, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return false, fmt.Errorf("failed to map file to blob: %w", sqlite3.Error{Code: sqlite3.ErrLocked})
+	}
+	return true, nil
+}
+
+func TestRetryingBlobRepository_RetriesLockedTable(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	inner := &lockedBlobRepository{failures: 2}
+	repo := NewRetryingBlobRepository(inner, retry.Config{
+		Policy: retry.Policy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+	}, &testLogger)
+
+	created, err := repo.AddBlobReference(context.Background(), "file-a", "hash", 1)
+	if err != nil || !created || inner.calls != 3 {
+		t.Errorf("AddBlobReference() = %v, %v after %d calls; want success on the third attempt", created, err, inner.calls)
+	}
+}
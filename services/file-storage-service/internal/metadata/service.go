@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -19,6 +20,16 @@ type PrepareUploadParams struct {
 	FileName string
 	FileSize int64
 	UserID   string
+	// Roles select the user's quota limits
+	Roles []string
+	// VersionOf is set to upload a new version of an existing file
+	VersionOf string
+	// RetainUntil and LegalHold protect the file from deletion
+	RetainUntil time.Time
+	LegalHold   bool
+	// Bucket names the bucket the file goes into, whose settings the upload
+	// must meet; empty keeps the file outside any bucket
+	Bucket string
 }
 
 type PrepareUploadResult struct {
@@ -35,16 +46,42 @@ type TxContext struct {
 	Tx interface{}
 }
 
+// Transaction returns the repository transaction, for repositories that
+// find it in the context of the calls made under it
+func (c *TxContext) Transaction() interface{} {
+	return c.Tx
+}
+
 // MetadataService defines the interface for file metadata operations
 type MetadataService interface {
 	CreateFileMetadata(ctx context.Context) error
 	GetFileMetadata(ctx context.Context, userID string, fileID string) (*domain.FileMetadataRecord, error)
+	// DeleteFileMetadata removes a file's record for good, also from the trash
 	DeleteFileMetadata(ctx context.Context, userID string, fileID string) error
+	// TrashFileMetadata moves a file to the user's trash
+	TrashFileMetadata(ctx context.Context, userID string, fileID string) error
+	ListDeletedFileMetadata(ctx context.Context, userID string) ([]*domain.FileMetadataRecord, error)
+	// RestoreFileMetadata moves a file out of the user's trash
+	RestoreFileMetadata(ctx context.Context, userID string, fileID string) error
 	ListFileMetadata(ctx context.Context, opts *domain.FileMetadataListOptions) (records []*domain.FileMetadataRecord, err error)
 	PrepareUpload(ctx context.Context, params *PrepareUploadParams) (*PrepareUploadResult, error)
 	CleanupExpiredMetadata(ctx context.Context) (int64, error)
 	UpdateFileMetadata(ctx context.Context, fileID string, record *domain.FileMetadataRecord) error
 	RetrieveFileMetadataByID(ctx context.Context, fileID string) (*domain.FileMetadataRecord, error)
+	// SetFileRetention replaces a file's retention and legal hold on behalf of
+	// change.Actor
+	SetFileRetention(ctx context.Context, change *domain.RetentionChange) error
+	// GetFileRetention returns a file's retention along with its audit trail
+	GetFileRetention(ctx context.Context, fileID string) (*domain.Retention, []*domain.RetentionChange, error)
+	// CreateBucket creates a bucket owned by bucket.OwnerID
+	CreateBucket(ctx context.Context, bucket *domain.Bucket) error
+	GetBucket(ctx context.Context, userID string, name string) (*domain.Bucket, error)
+	ListBuckets(ctx context.Context, userID string) ([]*domain.Bucket, error)
+	// UpdateBucket replaces a bucket's settings; files already in the bucket
+	// are not checked against them
+	UpdateBucket(ctx context.Context, userID string, name string, settings domain.BucketSettings) (*domain.Bucket, error)
+	// DeleteBucket removes a bucket that holds no files, also none in the trash
+	DeleteBucket(ctx context.Context, userID string, name string) error
 	// Transaction methods
 	BeginTx(ctx context.Context) (context.Context, error)
 	CommitTx(ctx context.Context) error
@@ -53,13 +90,23 @@ type MetadataService interface {
 
 type MetadataServiceImpl struct {
 	metadataRepo FileMetadataRepository
-	logger       *logger.Logger
+	// quotas is nil when quotas are not enforced
+	quotas QuotaService
+	// retention is nil when files cannot be put under retention
+	retention RetentionRepository
+	// buckets is nil when files cannot be put into buckets
+	buckets BucketRepository
+	logger  *logger.Logger
 }
 
-// NewMetadataService creates a new metadata service
-func NewMetadataService(metadataRepo FileMetadataRepository, logger *logger.Logger) *MetadataServiceImpl {
+// NewMetadataService creates a new metadata service; quotas, retention and
+// buckets may be nil
+func NewMetadataService(metadataRepo FileMetadataRepository, quotas QuotaService, retention RetentionRepository, buckets BucketRepository, logger *logger.Logger) *MetadataServiceImpl {
 	return &MetadataServiceImpl{
 		metadataRepo: metadataRepo,
+		quotas:       quotas,
+		retention:    retention,
+		buckets:      buckets,
 		logger:       logger,
 	}
 }
@@ -139,10 +186,21 @@ func (s *MetadataServiceImpl) DeleteFileMetadata(ctx context.Context, userID str
 		}
 	}()
 
-	if err := s.validateFileOwnership(txCtx, userID, fileID); err != nil {
+	if err := s.validateFileOwnership(txCtx, userID, fileID, true); err != nil {
+		return err
+	}
+	if err := s.checkRetention(txCtx, fileID); err != nil {
 		return err
 	}
 
+	var record *domain.FileMetadataRecord
+	if s.quotas != nil {
+		record, err = s.metadataRepo.RetrieveFileMetadataByID(txCtx, fileID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve file metadata: %w", err)
+		}
+	}
+
 	if err := s.metadataRepo.RemoveFileMetadata(txCtx, fileID); err != nil {
 		return fmt.Errorf("failed to delete file metadata: %w", err)
 	}
@@ -152,15 +210,153 @@ func (s *MetadataServiceImpl) DeleteFileMetadata(ctx context.Context, userID str
 	}
 
 	success = true
+	if record != nil {
+		s.returnQuota(ctx, userID, record)
+	}
+	return nil
+}
+
+// TrashFileMetadata moves a file to the user's trash. Its content and quota
+// are kept until it is restored or purged.
+func (s *MetadataServiceImpl) TrashFileMetadata(ctx context.Context, userID string, fileID string) error {
+	if err := s.validateFileOwnership(ctx, userID, fileID, false); err != nil {
+		return err
+	}
+	if err := s.checkRetention(ctx, fileID); err != nil {
+		return err
+	}
+	if err := s.metadataRepo.SoftDeleteMetadata(ctx, fileID, userID); err != nil {
+		s.logger.Error().
+			Str("method", "TrashFileMetadata").
+			Err(err).
+			Str("fileId", fileID).
+			Msg("failed to move file to trash")
+		return status.Errorf(codes.Internal, "failed to move file to trash")
+	}
+	return nil
+}
+
+func (s *MetadataServiceImpl) ListDeletedFileMetadata(ctx context.Context, userID string) ([]*domain.FileMetadataRecord, error) {
+	records, err := s.metadataRepo.ListDeletedMetadata(ctx, userID)
+	if err != nil {
+		s.logger.Error().
+			Str("method", "ListDeletedFileMetadata").
+			Err(err).
+			Str("userId", userID).
+			Msg("failed to list deleted file metadata")
+		return nil, status.Errorf(codes.Internal, "failed to list deleted files")
+	}
+	return records, nil
+}
+
+// RestoreFileMetadata moves a file out of the user's trash
+func (s *MetadataServiceImpl) RestoreFileMetadata(ctx context.Context, userID string, fileID string) error {
+	err := s.metadataRepo.RestoreMetadata(ctx, fileID, userID)
+	if errors.Is(err, domain.ErrFileNotFound) {
+		return status.Errorf(codes.NotFound, "file not found in trash")
+	}
+	if err != nil {
+		s.logger.Error().
+			Str("method", "RestoreFileMetadata").
+			Err(err).
+			Str("fileId", fileID).
+			Msg("failed to restore file from trash")
+		return status.Errorf(codes.Internal, "failed to restore file")
+	}
+	return nil
+}
+
+// checkRetention fails with FailedPrecondition while fileID is under
+// retention or legal hold
+func (s *MetadataServiceImpl) checkRetention(ctx context.Context, fileID string) error {
+	if s.retention == nil {
+		return nil
+	}
+	retention, err := s.retention.GetRetention(ctx, fileID)
+	if err != nil {
+		s.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to check file retention")
+		return status.Errorf(codes.Internal, "failed to check file retention")
+	}
+	if retention.Applies(time.Now()) {
+		return status.Errorf(codes.FailedPrecondition, "%v", domain.ErrFileRetained)
+	}
 	return nil
 }
 
+// SetFileRetention replaces a file's retention and legal hold. A retention
+// period that has not ended cannot be shortened, and every change is audited.
+func (s *MetadataServiceImpl) SetFileRetention(ctx context.Context, change *domain.RetentionChange) error {
+	if s.retention == nil {
+		return status.Errorf(codes.Unimplemented, "file retention is not enabled")
+	}
+	if change.Actor == "" {
+		return status.Errorf(codes.InvalidArgument, "retention changes need an actor")
+	}
+	if _, err := s.metadataRepo.RetrieveFileMetadataByID(ctx, change.FileID); err != nil {
+		if errors.Is(err, domain.ErrFileNotFound) {
+			return status.Errorf(codes.NotFound, "file metadata not found")
+		}
+		return status.Errorf(codes.Internal, "failed to retrieve file metadata")
+	}
+
+	change.ChangedAt = time.Now().UTC()
+	err := s.retention.SetRetention(ctx, change)
+	if errors.Is(err, domain.ErrRetentionShortened) {
+		return status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	if err != nil {
+		s.logger.Error().
+			Str("method", "SetFileRetention").
+			Err(err).
+			Str("fileId", change.FileID).
+			Msg("failed to set file retention")
+		return status.Errorf(codes.Internal, "failed to set file retention")
+	}
+	return nil
+}
+
+// GetFileRetention returns a file's retention, empty if none was set, along
+// with the changes made to it
+func (s *MetadataServiceImpl) GetFileRetention(ctx context.Context, fileID string) (*domain.Retention, []*domain.RetentionChange, error) {
+	if s.retention == nil {
+		return nil, nil, status.Errorf(codes.Unimplemented, "file retention is not enabled")
+	}
+	retention, err := s.retention.GetRetention(ctx, fileID)
+	if err != nil {
+		s.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to retrieve file retention")
+		return nil, nil, status.Errorf(codes.Internal, "failed to retrieve file retention")
+	}
+	if retention == nil {
+		retention = &domain.Retention{FileID: fileID}
+	}
+	changes, err := s.retention.ListRetentionChanges(ctx, fileID)
+	if err != nil {
+		s.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to list file retention changes")
+		return nil, nil, status.Errorf(codes.Internal, "failed to list file retention changes")
+	}
+	return retention, changes, nil
+}
+
+// returnQuota gives back the quota held by a deleted file: the usage of a
+// stored file, or the reservation of an upload that never completed
+func (s *MetadataServiceImpl) returnQuota(ctx context.Context, userID string, record *domain.FileMetadataRecord) {
+	var err error
+	if record.ProcessingStatus == string(file.StatusComplete) && record.Metadata != nil {
+		err = s.quotas.Free(ctx, userID, record.Metadata.FileSizeBytes)
+	} else {
+		err = s.quotas.Release(ctx, record.ID)
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("fileId", record.ID).Msg("Failed to return quota of deleted file")
+	}
+}
+
 func (s *MetadataServiceImpl) GetFileMetadata(ctx context.Context, userID string, fileID string) (record *domain.FileMetadataRecord, err error) {
 	// cancels the context if the request is canceled
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	if err := s.validateFileOwnership(ctx, userID, fileID); err != nil {
+	if err := s.validateFileOwnership(ctx, userID, fileID, false); err != nil {
 		// log error
 		s.logger.Error().
 			Str("method", "DeleteFileMetadata").
@@ -193,6 +389,11 @@ func (s *MetadataServiceImpl) ListFileMetadata(ctx context.Context, opts *domain
 	defer cancel()
 
 	opts.Status = string(file.StatusComplete)
+	if opts.Bucket != "" {
+		if _, err := s.ownedBucket(ctx, opts.UserID, opts.Bucket); err != nil {
+			return nil, err
+		}
+	}
 
 	// call repository operation
 	records, err = s.metadataRepo.ListFileMetadata(ctx, opts)
@@ -222,6 +423,38 @@ func (s *MetadataServiceImpl) PrepareUpload(ctx context.Context, params *Prepare
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if params.FileSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "file size cannot be negative")
+	}
+	if !params.RetainUntil.IsZero() && params.RetainUntil.Before(time.Now()) {
+		return nil, status.Errorf(codes.InvalidArgument, "retention must end in the future")
+	}
+
+	if params.VersionOf != "" {
+		current, err := s.versionedFile(ctx, params.UserID, params.VersionOf)
+		if err != nil {
+			return nil, err
+		}
+		if params.FileName == "" && current.Metadata != nil {
+			params.FileName = current.Metadata.OriginalFilename
+		}
+		// Versions stay in the bucket of their file
+		if params.Bucket != "" && params.Bucket != current.Bucket {
+			return nil, status.Errorf(codes.InvalidArgument, "a new version must go into the bucket of its file")
+		}
+		params.Bucket = current.Bucket
+	}
+	if params.Bucket != "" {
+		if err := s.applyBucketSettings(ctx, params); err != nil {
+			return nil, err
+		}
+	}
+
+	retained := params.LegalHold || !params.RetainUntil.IsZero()
+	if retained && s.retention == nil {
+		return nil, status.Errorf(codes.Unimplemented, "file retention is not enabled")
+	}
+
 	// Generate secure file ID
 	fileID, err := token.GenerateSecureFileID()
 	if err != nil {
@@ -261,14 +494,55 @@ func (s *MetadataServiceImpl) PrepareUpload(ctx context.Context, params *Prepare
 		ProcessingStatus: string(file.StatusPending),
 		CreatedAt:        time.Now().UTC(),
 		UpdatedAt:        time.Now().UTC(),
+		VersionOf:        params.VersionOf,
+		Bucket:           params.Bucket,
+	}
+
+	// Reserve the declared size before anything is stored
+	if s.quotas != nil {
+		if err := s.quotas.Reserve(ctx, params.UserID, params.Roles, fileID, params.FileSize); err != nil {
+			if errors.Is(err, domain.ErrQuotaExceeded) {
+				return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+			}
+			s.logger.Error().Err(err).Msg("Failed to reserve quota")
+			return nil, status.Errorf(codes.Internal, "failed to reserve quota")
+		}
 	}
 
 	// Store initial metadata
 	if err := s.metadataRepo.CreateFileMetadata(ctx, metadataRecord); err != nil {
 		s.logger.Error().Err(err).Msg("Failed to create initial file metadata")
+		if s.quotas != nil {
+			if relErr := s.quotas.Release(ctx, fileID); relErr != nil {
+				s.logger.Error().Err(relErr).Str("fileId", fileID).Msg("Failed to release quota reservation")
+			}
+		}
 		return nil, status.Errorf(codes.Internal, "failed to create file metadata")
 	}
 
+	if retained {
+		err := s.retention.SetRetention(ctx, &domain.RetentionChange{
+			FileID:      fileID,
+			RetainUntil: params.RetainUntil,
+			LegalHold:   params.LegalHold,
+			Actor:       params.UserID,
+			Reason:      "set when the upload was prepared",
+			ChangedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			s.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to set file retention")
+			if rmErr := s.metadataRepo.RemoveFileMetadata(ctx, fileID); rmErr != nil {
+				s.logger.Error().Err(rmErr).Str("fileId", fileID).Msg("Failed to remove file metadata")
+			}
+			if s.quotas != nil {
+				if relErr := s.quotas.Release(ctx, fileID); relErr != nil {
+					s.logger.Error().Err(relErr).Str("fileId", fileID).Msg("Failed to release quota reservation")
+				}
+			}
+			return nil, status.Errorf(codes.Internal, "failed to set file retention")
+		}
+	}
+
 	return &PrepareUploadResult{
 		FileID:      fileID,
 		UploadToken: uploadToken,
@@ -278,6 +552,181 @@ func (s *MetadataServiceImpl) PrepareUpload(ctx context.Context, params *Prepare
 	}, nil
 }
 
+// versionedFile returns the record of the file a new version is uploaded
+// for, which the user must own and which must be a complete file rather than
+// a version itself. Retained files get no versions, as adding them prunes
+// earlier ones.
+func (s *MetadataServiceImpl) versionedFile(ctx context.Context, userID, fileID string) (*domain.FileMetadataRecord, error) {
+	if err := s.validateFileOwnership(ctx, userID, fileID, false); err != nil {
+		return nil, err
+	}
+	if err := s.checkRetention(ctx, fileID); err != nil {
+		return nil, err
+	}
+	record, err := s.metadataRepo.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "file metadata not found")
+	}
+	if record.ProcessingStatus != string(file.StatusComplete) || record.VersionOf != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "only complete files can get a new version")
+	}
+	return record, nil
+}
+
+// applyBucketSettings checks an upload against the settings of the bucket it
+// goes into, which the user must own, and extends its retention to the
+// bucket's
+func (s *MetadataServiceImpl) applyBucketSettings(ctx context.Context, params *PrepareUploadParams) error {
+	bucket, err := s.ownedBucket(ctx, params.UserID, params.Bucket)
+	if err != nil {
+		return err
+	}
+
+	settings := bucket.Settings
+	if params.VersionOf != "" && !settings.Versioning {
+		return status.Errorf(codes.FailedPrecondition, "versioning is not enabled for bucket %s", bucket.Name)
+	}
+	if contentType := file.DetermineFileType(params.FileName); !settings.Allows(contentType) {
+		return status.Errorf(codes.InvalidArgument, "files of type %s are not allowed in bucket %s", contentType, bucket.Name)
+	}
+	if settings.MaxFileSize > 0 && params.FileSize > settings.MaxFileSize {
+		return status.Errorf(codes.InvalidArgument, "file exceeds the maximum size of %d bytes of bucket %s", settings.MaxFileSize, bucket.Name)
+	}
+	if settings.Retention > 0 {
+		if retainUntil := time.Now().Add(settings.Retention); retainUntil.After(params.RetainUntil) {
+			params.RetainUntil = retainUntil
+		}
+	}
+	return nil
+}
+
+func (s *MetadataServiceImpl) CreateBucket(ctx context.Context, bucket *domain.Bucket) error {
+	if s.buckets == nil {
+		return status.Errorf(codes.Unimplemented, "buckets are not enabled")
+	}
+	if bucket.OwnerID == "" {
+		return status.Errorf(codes.InvalidArgument, "bucket owner cannot be empty")
+	}
+	if err := domain.ValidateBucketName(bucket.Name); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.validateBucketSettings(bucket.Settings); err != nil {
+		return err
+	}
+
+	if err := s.buckets.CreateBucket(ctx, bucket); err != nil {
+		if errors.Is(err, domain.ErrBucketExists) {
+			return status.Errorf(codes.AlreadyExists, "bucket %s already exists", bucket.Name)
+		}
+		s.logger.Error().
+			Str("method", "CreateBucket").
+			Err(err).
+			Str("bucket", bucket.Name).
+			Msg("failed to create bucket")
+		return status.Errorf(codes.Internal, "failed to create bucket")
+	}
+	return nil
+}
+
+func (s *MetadataServiceImpl) GetBucket(ctx context.Context, userID string, name string) (*domain.Bucket, error) {
+	return s.ownedBucket(ctx, userID, name)
+}
+
+func (s *MetadataServiceImpl) ListBuckets(ctx context.Context, userID string) ([]*domain.Bucket, error) {
+	if s.buckets == nil {
+		return nil, status.Errorf(codes.Unimplemented, "buckets are not enabled")
+	}
+	buckets, err := s.buckets.ListBuckets(ctx, userID)
+	if err != nil {
+		s.logger.Error().
+			Str("method", "ListBuckets").
+			Err(err).
+			Str("userId", userID).
+			Msg("failed to list buckets")
+		return nil, status.Errorf(codes.Internal, "failed to list buckets")
+	}
+	return buckets, nil
+}
+
+func (s *MetadataServiceImpl) UpdateBucket(ctx context.Context, userID string, name string, settings domain.BucketSettings) (*domain.Bucket, error) {
+	if _, err := s.ownedBucket(ctx, userID, name); err != nil {
+		return nil, err
+	}
+	if err := s.validateBucketSettings(settings); err != nil {
+		return nil, err
+	}
+
+	if err := s.buckets.UpdateBucketSettings(ctx, name, settings); err != nil {
+		s.logger.Error().
+			Str("method", "UpdateBucket").
+			Err(err).
+			Str("bucket", name).
+			Msg("failed to update bucket")
+		if errors.Is(err, domain.ErrBucketNotFound) {
+			return nil, status.Errorf(codes.NotFound, "bucket not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update bucket")
+	}
+	return s.ownedBucket(ctx, userID, name)
+}
+
+func (s *MetadataServiceImpl) DeleteBucket(ctx context.Context, userID string, name string) error {
+	if _, err := s.ownedBucket(ctx, userID, name); err != nil {
+		return err
+	}
+
+	if err := s.buckets.DeleteBucket(ctx, name); err != nil {
+		s.logger.Error().
+			Str("method", "DeleteBucket").
+			Err(err).
+			Str("bucket", name).
+			Msg("failed to delete bucket")
+		switch {
+		case errors.Is(err, domain.ErrBucketNotEmpty):
+			return status.Errorf(codes.FailedPrecondition, "bucket %s is not empty", name)
+		case errors.Is(err, domain.ErrBucketNotFound):
+			return status.Errorf(codes.NotFound, "bucket not found")
+		}
+		return status.Errorf(codes.Internal, "failed to delete bucket")
+	}
+	return nil
+}
+
+// ownedBucket returns the named bucket if the user owns it
+func (s *MetadataServiceImpl) ownedBucket(ctx context.Context, userID string, name string) (*domain.Bucket, error) {
+	if s.buckets == nil {
+		return nil, status.Errorf(codes.Unimplemented, "buckets are not enabled")
+	}
+	if err := s.validateBucketOwnership(ctx, userID, name); err != nil {
+		return nil, err
+	}
+	bucket, err := s.buckets.GetBucket(ctx, name)
+	if err != nil {
+		if errors.Is(err, domain.ErrBucketNotFound) {
+			return nil, status.Errorf(codes.NotFound, "bucket not found")
+		}
+		s.logger.Error().
+			Str("method", "GetBucket").
+			Err(err).
+			Str("bucket", name).
+			Msg("failed to retrieve bucket")
+		return nil, status.Errorf(codes.Internal, "failed to retrieve bucket")
+	}
+	return bucket, nil
+}
+
+// validateBucketSettings rejects settings that cannot apply, including a
+// retention while files cannot be put under retention
+func (s *MetadataServiceImpl) validateBucketSettings(settings domain.BucketSettings) error {
+	if err := settings.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid bucket settings: %v", err)
+	}
+	if settings.Retention > 0 && s.retention == nil {
+		return status.Errorf(codes.Unimplemented, "file retention is not enabled")
+	}
+	return nil
+}
+
 func (s *MetadataServiceImpl) CleanupExpiredMetadata(ctx context.Context) (int64, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -288,6 +737,15 @@ func (s *MetadataServiceImpl) CleanupExpiredMetadata(ctx context.Context) (int64
 		s.logger.Error().Err(err).Msg("Failed to delete expired file metadata")
 		return 0, status.Errorf(codes.Internal, "failed to delete expired file metadata")
 	}
+	// Uploads that expired hold their reservations until now
+	if s.quotas != nil {
+		released, err := s.quotas.ReleaseExpired(ctx, expirationThreshold)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Failed to release expired quota reservations")
+		} else if released > 0 {
+			s.logger.Info().Int64("released", released).Msg("Released expired quota reservations")
+		}
+	}
 	return result, nil
 }
 
@@ -312,12 +770,14 @@ func (s *MetadataServiceImpl) RetrieveFileMetadataByID(ctx context.Context, file
 
 var _ MetadataService = (*MetadataServiceImpl)(nil)
 
-// ValidateGetFileMetadataRequest validates the get file metadata request
-func (s *MetadataServiceImpl) validateFileOwnership(ctx context.Context, userID string, fileID string) error {
+// ValidateGetFileMetadataRequest validates the get file metadata request.
+// Files in the trash are only matched if includeDeleted is set.
+func (s *MetadataServiceImpl) validateFileOwnership(ctx context.Context, userID string, fileID string, includeDeleted bool) error {
 
 	opts := &domain.FileMetadataListOptions{
-		UserID: userID,
-		FileID: fileID,
+		UserID:         userID,
+		FileID:         fileID,
+		IncludeDeleted: includeDeleted,
 	}
 
 	isOwner, err := s.metadataRepo.IsFileOwnedByUser(ctx, opts)
@@ -342,6 +802,34 @@ func (s *MetadataServiceImpl) validateFileOwnership(ctx context.Context, userID
 	return nil
 }
 
+// validateBucketOwnership checks that the user owns the named bucket; a
+// bucket that does not exist is owned by no one
+func (s *MetadataServiceImpl) validateBucketOwnership(ctx context.Context, userID string, name string) error {
+	isOwner, err := s.buckets.IsBucketOwnedByUser(ctx, name, userID)
+	if errors.Is(err, domain.ErrInvalidInput) {
+		return status.Errorf(codes.InvalidArgument, "bucket name and user ID cannot be empty")
+	}
+	if err != nil {
+		s.logger.Error().
+			Str("method", "validateBucketOwnership").
+			Err(err).
+			Str("bucket", name).
+			Msg("failed to check bucket ownership")
+		return status.Errorf(codes.Internal, "failed to check bucket ownership: %v", err)
+	}
+
+	if !isOwner {
+		s.logger.Warn().
+			Str("method", "validateBucketOwnership").
+			Str("bucket", name).
+			Str("user_id", userID).
+			Msg("user does not own bucket")
+		return status.Errorf(codes.PermissionDenied, "user does not own bucket")
+	}
+
+	return nil
+}
+
 func (s *MetadataServiceImpl) generateStoragePath(fileID string) (string, error) {
 	timestamp := time.Now().UnixNano()
 	return fmt.Sprintf("%d-%s", timestamp, fileID), nil
@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
 	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	memoryRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/memory"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestMetadataServiceImpl_UpdateFileMetadata(t *testing.T) {
@@ -96,3 +101,225 @@ func TestMetadataServiceImpl_UpdateFileMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestMetadataServiceImpl_Trash(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := NewMockFileMetadataRepository(ctrl)
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	service := NewMetadataService(mockRepo, nil, nil, nil, &testLogger)
+	ctx := context.Background()
+
+	// Files already in the trash are not matched, so cannot be trashed again
+	mockRepo.EXPECT().IsFileOwnedByUser(gomock.Any(), &domain.FileMetadataListOptions{UserID: "u1", FileID: "file-a"}).Return(false, nil)
+	if err := service.TrashFileMetadata(ctx, "u1", "file-a"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("TrashFileMetadata() of a trashed file error = %v, want PermissionDenied", err)
+	}
+
+	mockRepo.EXPECT().IsFileOwnedByUser(gomock.Any(), &domain.FileMetadataListOptions{UserID: "u1", FileID: "file-b"}).Return(true, nil)
+	mockRepo.EXPECT().SoftDeleteMetadata(gomock.Any(), "file-b", "u1").Return(nil)
+	if err := service.TrashFileMetadata(ctx, "u1", "file-b"); err != nil {
+		t.Errorf("TrashFileMetadata() error = %v", err)
+	}
+
+	mockRepo.EXPECT().RestoreMetadata(gomock.Any(), "file-c", "u1").Return(domain.ErrFileNotFound)
+	if err := service.RestoreFileMetadata(ctx, "u1", "file-c"); status.Code(err) != codes.NotFound {
+		t.Errorf("RestoreFileMetadata() of a file not in the trash error = %v, want NotFound", err)
+	}
+
+	// Deleting for good also matches files in the trash
+	tx := struct{}{}
+	mockRepo.EXPECT().BeginTx(gomock.Any()).Return(tx, nil)
+	mockRepo.EXPECT().IsFileOwnedByUser(gomock.Any(), &domain.FileMetadataListOptions{UserID: "u1", FileID: "file-b", IncludeDeleted: true}).Return(true, nil)
+	mockRepo.EXPECT().RemoveFileMetadata(gomock.Any(), "file-b").Return(nil)
+	mockRepo.EXPECT().CommitTx(gomock.Any(), tx).Return(nil)
+	if err := service.DeleteFileMetadata(ctx, "u1", "file-b"); err != nil {
+		t.Errorf("DeleteFileMetadata() of a trashed file error = %v", err)
+	}
+}
+
+// heldFiles puts every file it knows under legal hold
+type heldFiles map[string]bool
+
+func (h heldFiles) GetRetention(ctx context.Context, fileID string) (*domain.Retention, error) {
+	if !h[fileID] {
+		return nil, nil
+	}
+	return &domain.Retention{FileID: fileID, LegalHold: true}, nil
+}
+
+func (h heldFiles) SetRetention(ctx context.Context, change *domain.RetentionChange) error {
+	return nil
+}
+
+func (h heldFiles) ListRetentionChanges(ctx context.Context, fileID string) ([]*domain.RetentionChange, error) {
+	return nil, nil
+}
+
+func TestMetadataServiceImpl_RetainedFilesCannotBeDeleted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := NewMockFileMetadataRepository(ctrl)
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	service := NewMetadataService(mockRepo, nil, heldFiles{"file-a": true}, nil, &testLogger)
+	ctx := context.Background()
+
+	mockRepo.EXPECT().IsFileOwnedByUser(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	if err := service.TrashFileMetadata(ctx, "u1", "file-a"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("TrashFileMetadata() of a held file error = %v, want FailedPrecondition", err)
+	}
+
+	tx := struct{}{}
+	mockRepo.EXPECT().BeginTx(gomock.Any()).Return(tx, nil)
+	mockRepo.EXPECT().RollbackTx(gomock.Any(), tx).Return(nil)
+	if err := service.DeleteFileMetadata(ctx, "u1", "file-a"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DeleteFileMetadata() of a held file error = %v, want FailedPrecondition", err)
+	}
+
+	mockRepo.EXPECT().SoftDeleteMetadata(gomock.Any(), "file-b", "u1").Return(nil)
+	if err := service.TrashFileMetadata(ctx, "u1", "file-b"); err != nil {
+		t.Errorf("TrashFileMetadata() error = %v", err)
+	}
+}
+
+// recordedRetention keeps the last retention change of each file
+type recordedRetention map[string]*domain.RetentionChange
+
+func (r recordedRetention) GetRetention(ctx context.Context, fileID string) (*domain.Retention, error) {
+	change, ok := r[fileID]
+	if !ok {
+		return nil, nil
+	}
+	return &domain.Retention{FileID: fileID, RetainUntil: change.RetainUntil, LegalHold: change.LegalHold}, nil
+}
+
+func (r recordedRetention) SetRetention(ctx context.Context, change *domain.RetentionChange) error {
+	r[change.FileID] = change
+	return nil
+}
+
+func (r recordedRetention) ListRetentionChanges(ctx context.Context, fileID string) ([]*domain.RetentionChange, error) {
+	return nil, nil
+}
+
+func TestMetadataServiceImpl_Buckets(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	db := memoryRepository.NewDatabase()
+	retention := recordedRetention{}
+	service := NewMetadataService(
+		memoryRepository.NewMemoryFileMetadataRepository(db, &testLogger),
+		nil,
+		retention,
+		memoryRepository.NewMemoryBucketRepository(db, &testLogger),
+		&testLogger,
+	)
+	ctx := context.Background()
+
+	bucket := &domain.Bucket{
+		Name:    "reports",
+		OwnerID: "u1",
+		Settings: domain.BucketSettings{
+			AllowedTypes: []string{"application/pdf", "image/*"},
+			MaxFileSize:  100,
+			Retention:    24 * time.Hour,
+		},
+	}
+	if err := service.CreateBucket(ctx, bucket); err != nil {
+		t.Fatalf("CreateBucket() error = %v", err)
+	}
+	if err := service.CreateBucket(ctx, &domain.Bucket{Name: "reports", OwnerID: "u2"}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateBucket() of a taken name error = %v, want AlreadyExists", err)
+	}
+	if err := service.CreateBucket(ctx, &domain.Bucket{Name: "../etc", OwnerID: "u1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateBucket() with an invalid name error = %v, want InvalidArgument", err)
+	}
+
+	// Only the owner sees or changes the bucket
+	if _, err := service.GetBucket(ctx, "u2", "reports"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetBucket() by another user error = %v, want PermissionDenied", err)
+	}
+	if _, err := service.UpdateBucket(ctx, "u2", "reports", domain.BucketSettings{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("UpdateBucket() by another user error = %v, want PermissionDenied", err)
+	}
+	if buckets, err := service.ListBuckets(ctx, "u1"); err != nil || len(buckets) != 1 || buckets[0].Name != "reports" {
+		t.Errorf("ListBuckets() = %v, %v; want reports", buckets, err)
+	}
+
+	// Uploads must meet the bucket's settings
+	upload := func(userID, fileName string, size int64) (*PrepareUploadResult, error) {
+		return service.PrepareUpload(ctx, &PrepareUploadParams{FileName: fileName, FileSize: size, UserID: userID, Bucket: "reports"})
+	}
+	if _, err := upload("u2", "a.pdf", 10); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("PrepareUpload() into another user's bucket error = %v, want PermissionDenied", err)
+	}
+	if _, err := upload("u1", "a.csv", 10); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PrepareUpload() of a type not allowed error = %v, want InvalidArgument", err)
+	}
+	if _, err := upload("u1", "a.pdf", 101); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PrepareUpload() of a file too large error = %v, want InvalidArgument", err)
+	}
+	prepared, err := upload("u1", "a.png", 100)
+	if err != nil {
+		t.Fatalf("PrepareUpload() error = %v", err)
+	}
+	if change := retention[prepared.FileID]; change == nil || time.Until(change.RetainUntil) < 23*time.Hour {
+		t.Errorf("retention = %+v, want the bucket's day of retention", change)
+	}
+	record, err := service.RetrieveFileMetadataByID(ctx, prepared.FileID)
+	if err != nil || record.Bucket != "reports" {
+		t.Errorf("RetrieveFileMetadataByID() = %+v, %v; want the file in reports", record, err)
+	}
+
+	// Versions need versioning enabled on the bucket, which excludes retention
+	if _, err := service.UpdateBucket(ctx, "u1", "reports", domain.BucketSettings{Retention: time.Hour, Versioning: true}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateBucket() with retention and versioning error = %v, want InvalidArgument", err)
+	}
+	if err := service.CreateBucket(ctx, &domain.Bucket{Name: "drafts", OwnerID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	draft, err := service.PrepareUpload(ctx, &PrepareUploadParams{FileName: "draft.txt", FileSize: 10, UserID: "u1", Bucket: "drafts"})
+	if err != nil {
+		t.Fatalf("PrepareUpload() error = %v", err)
+	}
+	draftRecord, _ := service.RetrieveFileMetadataByID(ctx, draft.FileID)
+	draftRecord.ProcessingStatus = string(file.StatusComplete)
+	if err := service.UpdateFileMetadata(ctx, draftRecord.ID, draftRecord); err != nil {
+		t.Fatal(err)
+	}
+	versionParams := &PrepareUploadParams{FileSize: 10, UserID: "u1", VersionOf: draft.FileID}
+	if _, err := service.PrepareUpload(ctx, versionParams); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PrepareUpload() of a version error = %v, want FailedPrecondition", err)
+	}
+	updated, err := service.UpdateBucket(ctx, "u1", "drafts", domain.BucketSettings{Versioning: true})
+	if err != nil || !updated.Settings.Versioning {
+		t.Fatalf("UpdateBucket() = %+v, %v; want versioning enabled", updated, err)
+	}
+	versionParams.Bucket = ""
+	if _, err := service.PrepareUpload(ctx, versionParams); err != nil {
+		t.Errorf("PrepareUpload() of a version error = %v", err)
+	}
+	versionParams.Bucket = "reports"
+	if _, err := service.PrepareUpload(ctx, versionParams); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PrepareUpload() of a version into another bucket error = %v, want InvalidArgument", err)
+	}
+
+	records, err := service.ListFileMetadata(ctx, &domain.FileMetadataListOptions{UserID: "u1", Bucket: "reports"})
+	if err != nil || len(records) != 1 || records[0].ID != prepared.FileID {
+		t.Errorf("ListFileMetadata() of the bucket = %v, %v; want the uploaded file", records, err)
+	}
+	if _, err := service.ListFileMetadata(ctx, &domain.FileMetadataListOptions{UserID: "u2", Bucket: "reports"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ListFileMetadata() of another user's bucket error = %v, want PermissionDenied", err)
+	}
+
+	// Buckets holding files cannot be deleted
+	if err := service.DeleteBucket(ctx, "u1", "reports"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DeleteBucket() of a bucket holding files error = %v, want FailedPrecondition", err)
+	}
+	if err := service.CreateBucket(ctx, &domain.Bucket{Name: "empty", OwnerID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteBucket(ctx, "u1", "empty"); err != nil {
+		t.Errorf("DeleteBucket() error = %v", err)
+	}
+	if _, err := service.GetBucket(ctx, "u1", "empty"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetBucket() of a deleted bucket error = %v, want PermissionDenied", err)
+	}
+}
@@ -0,0 +1,141 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultMaxAttempts     = 3
+	defaultInitialInterval = 50 * time.Millisecond
+	defaultMaxInterval     = 2 * time.Second
+	defaultMultiplier      = 2
+	defaultMaxElapsedTime  = 10 * time.Second
+)
+
+// Policy retries an operation with exponential backoff and jitter. Zero values
+// take the defaults.
+type Policy struct {
+	// MaxAttempts caps the attempts, the first one included, 3 by default
+	MaxAttempts int
+	// InitialInterval is the wait before the first retry, 50ms by default.
+	// Each retry waits Multiplier times longer, 2 by default, up to
+	// MaxInterval, 2s by default.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// MaxElapsedTime stops retrying once a retry would start this long after
+	// the first attempt, 10s by default
+	MaxElapsedTime time.Duration
+	// IsRetryable decides which errors are worth another attempt. Nil uses
+	// DefaultIsRetryable.
+	IsRetryable func(error) bool
+	// OnRetry is called before waiting for the next attempt
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// DefaultIsRetryable retries every error except the caller giving up
+func DefaultIsRetryable(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// merge returns p with its zero fields taken from base
+func (p Policy) merge(base Policy) Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = base.MaxAttempts
+	}
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = base.InitialInterval
+	}
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = base.MaxInterval
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = base.Multiplier
+	}
+	if p.MaxElapsedTime <= 0 {
+		p.MaxElapsedTime = base.MaxElapsedTime
+	}
+	if p.IsRetryable == nil {
+		p.IsRetryable = base.IsRetryable
+	}
+	if p.OnRetry == nil {
+		p.OnRetry = base.OnRetry
+	}
+	return p
+}
+
+var defaultPolicy = Policy{
+	MaxAttempts:     defaultMaxAttempts,
+	InitialInterval: defaultInitialInterval,
+	MaxInterval:     defaultMaxInterval,
+	Multiplier:      defaultMultiplier,
+	MaxElapsedTime:  defaultMaxElapsedTime,
+	IsRetryable:     DefaultIsRetryable,
+}
+
+// Do runs fn until it succeeds, fails with an error that is not retryable or
+// the policy gives up, and returns its last error. It never waits past the
+// context's deadline: a retry that could not start in time is not attempted.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	p = p.merge(defaultPolicy)
+	start := time.Now()
+	interval := p.InitialInterval
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.IsRetryable(err) {
+			return err
+		}
+
+		wait := jitter(interval)
+		retryAt := time.Now().Add(wait)
+		if retryAt.Sub(start) > p.MaxElapsedTime {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && !retryAt.Before(deadline) {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		interval = min(time.Duration(float64(interval)*p.Multiplier), p.MaxInterval)
+	}
+}
+
+// jitter picks a wait between half and all of interval, so callers that
+// failed together don't retry in lockstep
+func jitter(interval time.Duration) time.Duration {
+	half := interval / 2
+	if half <= 0 {
+		return interval
+	}
+	return half + rand.N(half+1)
+}
+
+// Config configures the retries of a group of operations
+type Config struct {
+	Policy
+	// Operations overrides Policy for individual operations
+	Operations map[string]Policy
+}
+
+// For returns the policy of operation, with the defaults filled in
+func (c Config) For(operation string) Policy {
+	return c.Operations[operation].merge(c.Policy).merge(defaultPolicy)
+}
+
+// Do runs fn with the policy of operation
+func (c Config) Do(ctx context.Context, operation string, fn func() error) error {
+	return c.For(operation).Do(ctx, fn)
+}
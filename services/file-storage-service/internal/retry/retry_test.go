@@ -0,0 +1,121 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errBusy    = errors.New("database is locked")
+	errMissing = errors.New("not found")
+)
+
+func TestPolicy_RetriesUntilSuccess(t *testing.T) {
+	var waits []time.Duration
+	policy := Policy{
+		MaxAttempts:     5,
+		InitialInterval: time.Millisecond,
+		Multiplier:      2,
+		OnRetry: func(attempt int, err error, wait time.Duration) {
+			waits = append(waits, wait)
+		},
+	}
+
+	attempts := 0
+	err := policy.Do(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return errBusy
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Do() = %v after %d attempts, want success after 3", err, attempts)
+	}
+	// Each wait is jittered to between half and all of its interval
+	if len(waits) != 2 || waits[0] > time.Millisecond || waits[1] < time.Millisecond || waits[1] > 2*time.Millisecond {
+		t.Errorf("waits = %v, want about 1ms then 2ms", waits)
+	}
+}
+
+func TestPolicy_StopsOnPermanentErrorsAndMaxAttempts(t *testing.T) {
+	policy := Policy{
+		MaxAttempts:     3,
+		InitialInterval: time.Millisecond,
+		IsRetryable: func(err error) bool {
+			return !errors.Is(err, errMissing)
+		},
+	}
+	ctx := context.Background()
+
+	attempts := 0
+	err := policy.Do(ctx, func() error { attempts++; return errMissing })
+	if !errors.Is(err, errMissing) || attempts != 1 {
+		t.Errorf("Do() = %v after %d attempts, want errMissing after 1", err, attempts)
+	}
+
+	attempts = 0
+	err = policy.Do(ctx, func() error { attempts++; return errBusy })
+	if !errors.Is(err, errBusy) || attempts != 3 {
+		t.Errorf("Do() = %v after %d attempts, want errBusy after 3", err, attempts)
+	}
+}
+
+func TestPolicy_RespectsDeadlines(t *testing.T) {
+	policy := Policy{MaxAttempts: 10, InitialInterval: time.Second}
+
+	// A retry that would start after the deadline is not waited for
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	attempts := 0
+	start := time.Now()
+	err := policy.Do(ctx, func() error { attempts++; return errBusy })
+	if !errors.Is(err, errBusy) || attempts != 1 {
+		t.Errorf("Do() = %v after %d attempts, want errBusy after 1", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Do() took %v, want it to give up without waiting", elapsed)
+	}
+
+	// Neither is one that would start after MaxElapsedTime
+	policy.MaxElapsedTime = 10 * time.Millisecond
+	attempts = 0
+	policy.Do(context.Background(), func() error { attempts++; return errBusy })
+	if attempts != 1 {
+		t.Errorf("attempts past MaxElapsedTime = %d, want 1", attempts)
+	}
+
+	// Canceling the context ends the wait for the next attempt
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	attempts = 0
+	start = time.Now()
+	err = Policy{MaxAttempts: 10, InitialInterval: time.Second}.Do(ctx, func() error { attempts++; return errBusy })
+	if !errors.Is(err, errBusy) || attempts != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Do() = %v after %d attempts in %v, want errBusy after 1, promptly", err, attempts, time.Since(start))
+	}
+}
+
+func TestConfig_OverridesPerOperation(t *testing.T) {
+	cfg := Config{
+		Policy:     Policy{MaxAttempts: 2, InitialInterval: time.Millisecond},
+		Operations: map[string]Policy{"store": {MaxAttempts: 1}},
+	}
+	ctx := context.Background()
+
+	for operation, want := range map[string]int{"store": 1, "retrieve": 2} {
+		attempts := 0
+		cfg.Do(ctx, operation, func() error { attempts++; return errBusy })
+		if attempts != want {
+			t.Errorf("%s attempts = %d, want %d", operation, attempts, want)
+		}
+	}
+	if policy := cfg.For("store"); policy.InitialInterval != time.Millisecond || policy.MaxInterval != defaultMaxInterval {
+		t.Errorf("For(store) = %+v, want the group's interval and the default maximum", policy)
+	}
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	// CodecNone marks files stored as uploaded
+	CodecNone = "none"
+	CodecGzip = "gzip"
+
+	DefaultCompressionSampleSize = 64 * 1024
+	DefaultCompressionMinSavings = 0.1
+)
+
+// Codec encodes stored content and decodes it on retrieval
+type Codec interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (c gzipCodec) Name() string { return CodecGzip }
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (c gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// NewCodec returns the codec registered under name. Level only affects
+// writing; zero selects the codec's default.
+func NewCodec(name string, level int) (Codec, error) {
+	switch name {
+	case CodecGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip compression level: %d", level)
+		}
+		return gzipCodec{level: level}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression codec: %s", name)
+	}
+}
+
+// CompressionConfig configures CompressingProvider
+type CompressionConfig struct {
+	Codec string
+	Level int
+	// MinSavings is the fraction of the sample a codec must save, otherwise
+	// the file is stored as-is. Zero compresses whenever the codec saves
+	// anything; nil uses DefaultCompressionMinSavings.
+	MinSavings *float64
+	// SampleSize is how much of each upload is test-compressed to decide
+	SampleSize int
+}
+
+// CompressingProvider compresses content on Store and decompresses it on
+// Retrieve. The codec and the original and stored sizes are recorded in the
+// file metadata, so files written before compression was enabled, or stored
+// as-is because they did not compress well, are returned unchanged.
+type CompressingProvider struct {
+	inner           Provider
+	codec           Codec
+	minSavings      float64
+	sampleSize      int
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+}
+
+func NewCompressingProvider(inner Provider, cfg CompressionConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*CompressingProvider, error) {
+	if cfg.Codec == "" {
+		cfg.Codec = CodecGzip
+	}
+	codec, err := NewCodec(cfg.Codec, cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	minSavings := DefaultCompressionMinSavings
+	if cfg.MinSavings != nil {
+		minSavings = *cfg.MinSavings
+	}
+	if minSavings < 0 || minSavings >= 1 {
+		return nil, fmt.Errorf("compression min savings must be at least 0 and below 1, got %v", minSavings)
+	}
+	if cfg.SampleSize <= 0 {
+		cfg.SampleSize = DefaultCompressionSampleSize
+	}
+	return &CompressingProvider{
+		inner:           inner,
+		codec:           codec,
+		minSavings:      minSavings,
+		sampleSize:      cfg.SampleSize,
+		metadataService: metadataService,
+		logger:          logger,
+	}, nil
+}
+
+func (p *CompressingProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	// Test-compress the head of the upload to decide without buffering it all
+	sample := make([]byte, p.sampleSize)
+	n, err := io.ReadFull(content, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read content: %w", err)
+	}
+	sample = sample[:n]
+	original := &countingReader{r: io.MultiReader(bytes.NewReader(sample), content)}
+
+	if !p.worthCompressing(sample) {
+		storagePath, err := p.inner.Store(ctx, fileID, original)
+		if err != nil {
+			return "", err
+		}
+		return storagePath, p.recordCompression(ctx, fileID, CodecNone, original.n, original.n)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w, err := p.codec.NewWriter(pw)
+		if err == nil {
+			_, err = io.Copy(w, original)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	stored := &countingReader{r: pr}
+	storagePath, err := p.inner.Store(ctx, fileID, stored)
+	// Unblock the encoder if the provider stopped reading early, and wait
+	// for it to stop reading the content, which belongs to the caller again
+	// once Store returns
+	pr.Close()
+	<-done
+	if err != nil {
+		return "", err
+	}
+
+	p.logger.Debug().
+		Str("fileId", fileID).
+		Int64("originalSize", original.n).
+		Int64("storedSize", stored.n).
+		Msg("Stored compressed file")
+	return storagePath, p.recordCompression(ctx, fileID, p.codec.Name(), original.n, stored.n)
+}
+
+// worthCompressing reports whether the codec saves at least minSavings on sample
+func (p *CompressingProvider) worthCompressing(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	var buf bytes.Buffer
+	w, err := p.codec.NewWriter(&buf)
+	if err != nil {
+		return false
+	}
+	if _, err := w.Write(sample); err != nil {
+		return false
+	}
+	if err := w.Close(); err != nil {
+		return false
+	}
+	savings := 1 - float64(buf.Len())/float64(len(sample))
+	return savings > 0 && savings >= p.minSavings
+}
+
+// recordCompression stores the codec and sizes on the metadata record. The
+// stored file is removed if that fails, as it could not be decoded later.
+func (p *CompressingProvider) recordCompression(ctx context.Context, fileID, codec string, originalSize, storedSize int64) error {
+	err := func() error {
+		metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve metadata: %w", err)
+		}
+		metadata.Compression = codec
+		metadata.OriginalSize = originalSize
+		metadata.StoredSize = storedSize
+		if err := p.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		if delErr := p.inner.Delete(ctx, fileID); delErr != nil {
+			p.logger.Error().Err(delErr).Str("fileId", fileID).Msg("Failed to remove file after metadata failure")
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *CompressingProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+
+	content, err := p.inner.Retrieve(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if !isCompressed(metadata.Compression) {
+		return content, nil
+	}
+	return p.decode(metadata.Compression, content)
+}
+
+// RetrieveRange passes ranges of uncompressed files through. Compressed files
+// are decoded from the start, as the stream cannot be entered mid-way.
+func (p *CompressingProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	if !isCompressed(metadata.Compression) {
+		return p.inner.RetrieveRange(ctx, fileID, offset, length)
+	}
+	if offset < 0 || offset > metadata.OriginalSize {
+		return nil, fmt.Errorf("%w: offset %d outside file size %d", ErrInvalidRange, offset, metadata.OriginalSize)
+	}
+
+	content, err := p.inner.Retrieve(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := p.decode(metadata.Compression, content)
+	if err != nil {
+		return nil, err
+	}
+	return sliceReadCloser(decoded, offset, length)
+}
+
+func isCompressed(codec string) bool {
+	return codec != "" && codec != CodecNone
+}
+
+// decode wraps stored content in a decoder for codecName
+func (p *CompressingProvider) decode(codecName string, content io.ReadCloser) (io.ReadCloser, error) {
+	codec, err := NewCodec(codecName, 0)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	decoded, err := codec.NewReader(content)
+	if err != nil {
+		content.Close()
+		return nil, fmt.Errorf("failed to decode file: %w", err)
+	}
+	return &decodingReader{ReadCloser: decoded, stored: content}, nil
+}
+
+// Stat reports the original size of compressed files
+func (p *CompressingProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	info, err := p.inner.Stat(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	if isCompressed(metadata.Compression) {
+		info.Size = metadata.OriginalSize
+	}
+	return info, nil
+}
+
+func (p *CompressingProvider) Delete(ctx context.Context, fileID string) error {
+	return p.inner.Delete(ctx, fileID)
+}
+
+func (p *CompressingProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	return p.inner.List(ctx, opts)
+}
+
+// SweepTempFiles forwards to the wrapped provider, if it stages writes
+func (p *CompressingProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	sweeper, ok := p.inner.(TempFileSweeper)
+	if !ok {
+		return nil, nil
+	}
+	return sweeper.SweepTempFiles(ctx)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodingReader closes both the decoder and the stored content beneath it
+type decodingReader struct {
+	io.ReadCloser
+	stored io.ReadCloser
+}
+
+func (r *decodingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if storedErr := r.stored.Close(); err == nil {
+		err = storedErr
+	}
+	return err
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// memoryProvider keeps stored files in a map
+type memoryProvider struct {
+	files map[string][]byte
+}
+
+func (m *memoryProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	m.files[fileID] = data
+	return "memory://" + fileID, nil
+}
+
+func (m *memoryProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	data, ok := m.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	data, ok := m.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return nil, fmt.Errorf("%w: offset %d", file.ErrInvalidRange, offset)
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	data, ok := m.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	return &file.ObjectInfo{FileID: fileID, Size: int64(len(data)), StorageClass: file.StorageClassStandard}, nil
+}
+
+func (m *memoryProvider) Delete(ctx context.Context, fileID string) error {
+	delete(m.files, fileID)
+	return nil
+}
+
+func (m *memoryProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	var fileIDs []string
+	for fileID := range m.files {
+		if fileID > opts.ContinuationToken && strings.HasPrefix(fileID, opts.Prefix) {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	sort.Strings(fileIDs)
+	if len(fileIDs) <= opts.Limit() {
+		return &file.ListPage{FileIDs: fileIDs}, nil
+	}
+	fileIDs = fileIDs[:opts.Limit()]
+	return &file.ListPage{FileIDs: fileIDs, NextContinuationToken: fileIDs[len(fileIDs)-1]}, nil
+}
+
+func newTestCompressingProvider(t *testing.T) (*CompressingProvider, *memoryProvider, map[string]*domain.FileMetadataRecord) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	records := map[string]*domain.FileMetadataRecord{}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			if record, ok := records[fileID]; ok {
+				copied := *record
+				return &copied, nil
+			}
+			return &domain.FileMetadataRecord{ID: fileID}, nil
+		}).AnyTimes()
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string, record *domain.FileMetadataRecord) error {
+			records[fileID] = record
+			return nil
+		}).AnyTimes()
+
+	inner := &memoryProvider{files: map[string][]byte{}}
+	p, err := NewCompressingProvider(inner, CompressionConfig{Codec: CodecGzip}, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewCompressingProvider() error = %v", err)
+	}
+	return p, inner, records
+}
+
+func retrieveAll(t *testing.T, p Provider, fileID string) []byte {
+	t.Helper()
+	reader, err := p.Retrieve(context.Background(), fileID)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	defer reader.Close()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading %s: %v", fileID, err)
+	}
+	return got
+}
+
+func TestCompressingProvider_CompressesText(t *testing.T) {
+	p, inner, records := newTestCompressingProvider(t)
+	content := []byte(strings.Repeat("id,name,email\n1,alice,alice@example.com\n", 10000))
+
+	if _, err := p.Store(context.Background(), "file-a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	record := records["file-a"]
+	if record.Compression != CodecGzip {
+		t.Errorf("Compression = %q, want %q", record.Compression, CodecGzip)
+	}
+	if record.OriginalSize != int64(len(content)) {
+		t.Errorf("OriginalSize = %d, want %d", record.OriginalSize, len(content))
+	}
+	if record.StoredSize != int64(len(inner.files["file-a"])) || record.StoredSize >= record.OriginalSize/5 {
+		t.Errorf("StoredSize = %d, stored %d bytes of %d", record.StoredSize, len(inner.files["file-a"]), len(content))
+	}
+	if got := retrieveAll(t, p, "file-a"); !bytes.Equal(got, content) {
+		t.Error("Retrieve() content differs from stored content")
+	}
+}
+
+func TestCompressingProvider_StoresIncompressibleAsIs(t *testing.T) {
+	p, inner, records := newTestCompressingProvider(t)
+	content := make([]byte, 256*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Store(context.Background(), "file-a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	if record := records["file-a"]; record.Compression != CodecNone || record.StoredSize != record.OriginalSize {
+		t.Errorf("record = %+v, want stored as-is", record)
+	}
+	if !bytes.Equal(inner.files["file-a"], content) {
+		t.Error("incompressible content was modified")
+	}
+	if got := retrieveAll(t, p, "file-a"); !bytes.Equal(got, content) {
+		t.Error("Retrieve() content differs from stored content")
+	}
+}
+
+// ownedReader fails the test if it is read once its owner got it back. Past
+// the first free bytes, reads signal blocked and wait for release.
+type ownedReader struct {
+	t        *testing.T
+	free     int
+	blocked  chan struct{}
+	release  chan struct{}
+	returned atomic.Bool
+}
+
+func (r *ownedReader) Read(p []byte) (int, error) {
+	if r.returned.Load() {
+		r.t.Error("content read after Store returned")
+	}
+	if r.free > 0 {
+		p = p[:min(len(p), r.free)]
+		r.free -= len(p)
+	} else {
+		select {
+		case <-r.blocked:
+		default:
+			close(r.blocked)
+		}
+		<-r.release
+	}
+	return copy(p, bytes.Repeat([]byte("a"), len(p))), nil
+}
+
+// partialStoreProvider reads the content until blocked is closed and fails
+type partialStoreProvider struct {
+	failingProvider
+	blocked chan struct{}
+}
+
+func (p *partialStoreProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	buf := make([]byte, 1024)
+	for {
+		select {
+		case <-p.blocked:
+			return "", errReplicaDown
+		default:
+		}
+		if _, err := content.Read(buf); err != nil {
+			return "", err
+		}
+	}
+}
+
+func TestCompressingProvider_StopsReadingWhenStoreFails(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	content := &ownedReader{
+		t:       t,
+		free:    DefaultCompressionSampleSize,
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	// The inner provider fails while the encoder is still reading
+	inner := &partialStoreProvider{blocked: content.blocked}
+	p, err := NewCompressingProvider(inner, CompressionConfig{Codec: CodecGzip}, nil, &testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { close(content.release) })
+
+	if _, err := p.Store(context.Background(), "file-a", content); !errors.Is(err, errReplicaDown) {
+		t.Fatalf("Store() error = %v, want %v", err, errReplicaDown)
+	}
+	content.returned.Store(true)
+	time.Sleep(30 * time.Millisecond)
+}
+
+func TestCompressingProvider_MinSavings(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	for _, minSavings := range []float64{-0.1, 1} {
+		if _, err := NewCompressingProvider(&memoryProvider{}, CompressionConfig{MinSavings: &minSavings}, nil, &testLogger); err == nil {
+			t.Errorf("NewCompressingProvider(MinSavings %v) error = nil, want out of range", minSavings)
+		}
+	}
+
+	// Random bytes with a compressible tail save a little, less than the
+	// default asks for
+	sample := make([]byte, 1000)
+	rand.Read(sample[:900])
+	for minSavings, want := range map[float64]bool{0: true, DefaultCompressionMinSavings: false} {
+		p, err := NewCompressingProvider(&memoryProvider{}, CompressionConfig{MinSavings: &minSavings}, nil, &testLogger)
+		if err != nil {
+			t.Fatalf("NewCompressingProvider(MinSavings %v) error = %v", minSavings, err)
+		}
+		if got := p.worthCompressing(sample); got != want {
+			t.Errorf("MinSavings %v: worthCompressing() = %v, want %v", minSavings, got, want)
+		}
+	}
+	p, err := NewCompressingProvider(&memoryProvider{}, CompressionConfig{MinSavings: new(float64)}, nil, &testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 1000)
+	rand.Read(random)
+	if p.worthCompressing(random) {
+		t.Error("MinSavings 0: worthCompressing(random bytes) = true, want false")
+	}
+}
+
+func TestCompressingProvider_ReadsFilesStoredBeforeCompression(t *testing.T) {
+	p, inner, _ := newTestCompressingProvider(t)
+	inner.files["legacy"] = []byte("id,name\n1,alice\n")
+
+	if got := retrieveAll(t, p, "legacy"); string(got) != "id,name\n1,alice\n" {
+		t.Errorf("Retrieve() = %q", got)
+	}
+}
+
+func retrieveRange(t *testing.T, p Provider, fileID string, offset, length int64) []byte {
+	t.Helper()
+	reader, err := p.RetrieveRange(context.Background(), fileID, offset, length)
+	if err != nil {
+		t.Fatalf("RetrieveRange(%d, %d) error = %v", offset, length, err)
+	}
+	defer reader.Close()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading range %d+%d of %s: %v", offset, length, fileID, err)
+	}
+	return got
+}
+
+func TestCompressingProvider_RetrieveRange(t *testing.T) {
+	p, inner, _ := newTestCompressingProvider(t)
+	content := []byte(strings.Repeat("id,name,email\n1,alice,alice@example.com\n", 1000))
+	if _, err := p.Store(context.Background(), "file-a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	inner.files["legacy"] = content
+
+	for _, fileID := range []string{"file-a", "legacy"} {
+		if got := retrieveRange(t, p, fileID, 14, 27); !bytes.Equal(got, content[14:41]) {
+			t.Errorf("RetrieveRange(%s, 14, 27) = %q", fileID, got)
+		}
+		if got := retrieveRange(t, p, fileID, int64(len(content))-5, -1); !bytes.Equal(got, content[len(content)-5:]) {
+			t.Errorf("RetrieveRange(%s) tail = %q", fileID, got)
+		}
+	}
+	if _, err := p.RetrieveRange(context.Background(), "file-a", int64(len(content))+1, 1); err == nil {
+		t.Error("RetrieveRange() past end error = nil, want invalid range")
+	}
+}
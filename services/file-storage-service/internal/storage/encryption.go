@@ -0,0 +1,548 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	// encryptionMagic starts every encrypted file and carries the format version
+	encryptionMagic = "UPE\x01"
+	// Each chunk is sealed separately, so files are encrypted and decrypted as
+	// a stream. The nonce is the per-file prefix, the chunk counter and a flag
+	// marking the final chunk, so chunks cannot be reordered or truncated.
+	encryptionChunkSize   = 64 * 1024
+	encryptionPrefixSize  = 7
+	encryptionHeaderSize  = len(encryptionMagic) + encryptionPrefixSize
+	dataKeySize           = 32
+	masterKeySize         = 32
+	encryptionFinalFlag   = 1
+	encryptionCounterSize = 4
+	gcmTagSize            = 16
+)
+
+var (
+	// ErrUnknownKeyID is returned when a data key is wrapped with a master key
+	// that is not configured
+	ErrUnknownKeyID = errors.New("unknown encryption key ID")
+
+	errDecryptionFailed = fmt.Errorf("%w: decryption failed", ErrIntegrityCheckFailed)
+)
+
+// Keyring holds the master keys data keys are wrapped with. New data keys are
+// wrapped with the current key; the others remain for unwrapping until every
+// data key has been rotated off them.
+type Keyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring from base64-encoded 256-bit master keys by ID
+func NewKeyring(currentID string, encodedKeys map[string]string) (*Keyring, error) {
+	if currentID == "" {
+		return nil, errors.New("current encryption key ID must be set")
+	}
+	keys := make(map[string]cipher.AEAD, len(encodedKeys))
+	for keyID, encoded := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %w", keyID, err)
+		}
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("invalid master key %s: must be %d bytes", keyID, masterKeySize)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[keyID] = aead
+	}
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, currentID)
+	}
+	return &Keyring{currentID: currentID, keys: keys}, nil
+}
+
+// CurrentKeyID returns the ID of the key new data keys are wrapped with
+func (k *Keyring) CurrentKeyID() string {
+	return k.currentID
+}
+
+// wrap encrypts dataKey with the current master key. The file ID is bound as
+// associated data, so a wrapped key is only valid for its own file.
+func (k *Keyring) wrap(fileID string, dataKey []byte) (string, []byte, error) {
+	aead := k.keys[k.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return k.currentID, aead.Seal(nonce, nonce, dataKey, []byte(fileID)), nil
+}
+
+func (k *Keyring) unwrap(fileID, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// EncryptingProvider encrypts each file with its own AES-256-GCM data key on
+// Store and decrypts it on Retrieve. The data key is wrapped with a master key
+// from the keyring and kept, with the key ID, on the file's metadata record.
+// Files stored before encryption was enabled are returned as stored.
+type EncryptingProvider struct {
+	inner           Provider
+	keyring         *Keyring
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+}
+
+func NewEncryptingProvider(inner Provider, keyring *Keyring, metadataService metadataService.MetadataService, logger *logger.Logger) *EncryptingProvider {
+	return &EncryptingProvider{
+		inner:           inner,
+		keyring:         keyring,
+		metadataService: metadataService,
+		logger:          logger,
+	}
+}
+
+func (p *EncryptingProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	keyID, wrappedKey, err := p.keyring.wrap(fileID, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := newEncryptingWriter(pw, dataKey, fileID)
+		if err == nil {
+			_, err = io.Copy(w, content)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	storagePath, err := p.inner.Store(ctx, fileID, pr)
+	// Unblock the encryptor if the provider stopped reading early
+	pr.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.recordDataKey(ctx, fileID, keyID, wrappedKey); err != nil {
+		if delErr := p.inner.Delete(ctx, fileID); delErr != nil {
+			p.logger.Error().Err(delErr).Str("fileId", fileID).Msg("Failed to remove file after metadata failure")
+		}
+		return "", err
+	}
+	return storagePath, nil
+}
+
+func (p *EncryptingProvider) recordDataKey(ctx context.Context, fileID, keyID string, wrappedKey []byte) error {
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.EncryptionKeyID = keyID
+	metadata.WrappedDataKey = wrappedKey
+	if err := p.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+func (p *EncryptingProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+
+	content, err := p.inner.Retrieve(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.EncryptionKeyID == "" {
+		return content, nil
+	}
+
+	dataKey, err := p.keyring.unwrap(fileID, metadata.EncryptionKeyID, metadata.WrappedDataKey)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	decrypted, err := newDecryptingReader(content, dataKey, fileID)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	return &decodingReader{ReadCloser: io.NopCloser(decrypted), stored: content}, nil
+}
+
+// RetrieveRange decrypts only the chunks covering the range. It reads the
+// header for the nonce prefix, then the stored content from the first chunk
+// needed to the end, so the final chunk is still recognised as final.
+func (p *EncryptingProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	if metadata.EncryptionKeyID == "" {
+		return p.inner.RetrieveRange(ctx, fileID, offset, length)
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidRange, offset)
+	}
+
+	dataKey, err := p.keyring.unwrap(fileID, metadata.EncryptionKeyID, metadata.WrappedDataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := p.inner.RetrieveRange(ctx, fileID, 0, int64(encryptionHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := readEncryptionHeader(header)
+	header.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Start one chunk early on a chunk boundary, so an offset at the very end
+	// of the file still lands inside the final chunk
+	chunk := offset / encryptionChunkSize
+	if chunk > 0 && offset%encryptionChunkSize == 0 {
+		chunk--
+	}
+	if chunk > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: offset %d beyond end of file", ErrInvalidRange, offset)
+	}
+	sealedChunkSize := int64(encryptionChunkSize + gcmTagSize)
+	content, err := p.inner.RetrieveRange(ctx, fileID, int64(encryptionHeaderSize)+chunk*sealedChunkSize, -1)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := newDecryptingReaderAt(content, dataKey, fileID, prefix, uint32(chunk))
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	return sliceReadCloser(&decodingReader{ReadCloser: io.NopCloser(decrypted), stored: content}, offset-chunk*encryptionChunkSize, length)
+}
+
+// Stat reports the plaintext size of encrypted files
+func (p *EncryptingProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	info, err := p.inner.Stat(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := p.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	if metadata.EncryptionKeyID != "" {
+		info.Size = plaintextSize(info.Size)
+	}
+	return info, nil
+}
+
+// plaintextSize is the size of the content encrypted into storedSize bytes:
+// the header followed by chunks that each carry a tag
+func plaintextSize(storedSize int64) int64 {
+	sealed := storedSize - int64(encryptionHeaderSize)
+	if sealed <= 0 {
+		return 0
+	}
+	sealedChunkSize := int64(encryptionChunkSize + gcmTagSize)
+	chunks := (sealed + sealedChunkSize - 1) / sealedChunkSize
+	return max(sealed-chunks*gcmTagSize, 0)
+}
+
+func (p *EncryptingProvider) Delete(ctx context.Context, fileID string) error {
+	return p.inner.Delete(ctx, fileID)
+}
+
+func (p *EncryptingProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	return p.inner.List(ctx, opts)
+}
+
+// SweepTempFiles forwards to the wrapped provider, if it stages writes
+func (p *EncryptingProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	sweeper, ok := p.inner.(TempFileSweeper)
+	if !ok {
+		return nil, nil
+	}
+	return sweeper.SweepTempFiles(ctx)
+}
+
+// chunkNonce builds the nonce for chunk counter of the stream with prefix
+func chunkNonce(nonce, prefix []byte, counter uint32, final bool) {
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixSize:], counter)
+	nonce[encryptionPrefixSize+encryptionCounterSize] = 0
+	if final {
+		nonce[encryptionPrefixSize+encryptionCounterSize] = encryptionFinalFlag
+	}
+}
+
+// encryptingWriter seals content in chunks. A full chunk is only sealed once
+// more content follows, so Close can always seal a final chunk.
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	aad     []byte
+	prefix  []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	sealed  []byte
+}
+
+func newEncryptingWriter(w io.Writer, dataKey []byte, fileID string) (*encryptingWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	if _, err := rand.Read(header[len(encryptionMagic):]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		w:      w,
+		aead:   aead,
+		aad:    []byte(fileID),
+		prefix: header[len(encryptionMagic):],
+		nonce:  make([]byte, aead.NonceSize()),
+		buf:    make([]byte, 0, encryptionChunkSize),
+		sealed: make([]byte, 0, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(encryptionChunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptingWriter) seal(final bool) error {
+	if e.counter == math.MaxUint32 {
+		return errors.New("file too large to encrypt")
+	}
+	chunkNonce(e.nonce, e.prefix, e.counter, final)
+	e.sealed = e.aead.Seal(e.sealed[:0], e.nonce, e.buf, e.aad)
+	if _, err := e.w.Write(e.sealed); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptingReader opens the chunks written by encryptingWriter, failing if
+// any chunk was modified, reordered or dropped
+type decryptingReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	aad     []byte
+	prefix  []byte
+	nonce   []byte
+	counter uint32
+	sealed  []byte
+	plain   []byte
+	pos     int
+	done    bool
+}
+
+func newDecryptingReader(r io.Reader, dataKey []byte, fileID string) (*decryptingReader, error) {
+	prefix, err := readEncryptionHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptingReaderAt(r, dataKey, fileID, prefix, 0)
+}
+
+// newDecryptingReaderAt decrypts a stream positioned at the start of chunk
+// counter, of the file whose header carried prefix
+func newDecryptingReaderAt(r io.Reader, dataKey []byte, fileID string, prefix []byte, counter uint32) (*decryptingReader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		r:       bufio.NewReaderSize(r, encryptionChunkSize+aead.Overhead()),
+		aead:    aead,
+		aad:     []byte(fileID),
+		prefix:  prefix,
+		nonce:   make([]byte, aead.NonceSize()),
+		counter: counter,
+		sealed:  make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// readEncryptionHeader checks the format marker and returns the nonce prefix
+func readEncryptionHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errDecryptionFailed
+	}
+	return header[len(encryptionMagic):], nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for d.pos == len(d.plain) {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain[d.pos:])
+	d.pos += n
+	return n, nil
+}
+
+func (d *decryptingReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	var final bool
+	switch {
+	case err == io.EOF:
+		// The final chunk is never empty on disk, so the file was truncated
+		return errDecryptionFailed
+	case err == io.ErrUnexpectedEOF:
+		final = true
+	case err != nil:
+		return err
+	default:
+		_, peekErr := d.r.Peek(1)
+		final = peekErr == io.EOF
+	}
+
+	chunkNonce(d.nonce, d.prefix, d.counter, final)
+	plain, err := d.aead.Open(d.plain[:0], d.nonce, d.sealed[:n], d.aad)
+	if err != nil {
+		return errDecryptionFailed
+	}
+	d.plain = plain
+	d.pos = 0
+	d.counter++
+	d.done = final
+	return nil
+}
+
+// RotationResult summarises a data key rotation
+type RotationResult struct {
+	Rotated int
+	Failed  []string
+}
+
+// RotateDataKeys re-wraps every data key not yet wrapped with the keyring's
+// current master key. Only the wrapped keys change; file bodies are left as
+// they are. With dryRun set, keys are only unwrapped to check they can be.
+func RotateDataKeys(ctx context.Context, repo metadataService.DataKeyRepository, keyring *Keyring, batchSize int, dryRun bool, logger *logger.Logger) (*RotationResult, error) {
+	result := &RotationResult{}
+	var after string
+	for {
+		records, err := repo.ListDataKeys(ctx, keyring.CurrentKeyID(), after, batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		for _, record := range records {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			after = record.ID
+			if err := rotateDataKey(ctx, repo, keyring, record, dryRun); err != nil {
+				logger.Error().Err(err).Str("fileId", record.ID).Str("keyId", record.EncryptionKeyID).Msg("Failed to rotate data key")
+				result.Failed = append(result.Failed, record.ID)
+				continue
+			}
+			result.Rotated++
+		}
+	}
+
+	logger.Info().
+		Str("keyId", keyring.CurrentKeyID()).
+		Int("rotated", result.Rotated).
+		Int("failed", len(result.Failed)).
+		Bool("dryRun", dryRun).
+		Msg("Data key rotation completed")
+	return result, nil
+}
+
+func rotateDataKey(ctx context.Context, repo metadataService.DataKeyRepository, keyring *Keyring, record *domain.FileMetadataRecord, dryRun bool) error {
+	dataKey, err := keyring.unwrap(record.ID, record.EncryptionKeyID, record.WrappedDataKey)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	keyID, wrappedKey, err := keyring.wrap(record.ID, dataKey)
+	if err != nil {
+		return err
+	}
+	return repo.RewrapDataKey(ctx, record.ID, record.EncryptionKeyID, keyID, wrappedKey)
+}
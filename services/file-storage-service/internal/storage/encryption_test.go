@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+func testMasterKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestEncryptingProvider(t *testing.T, keyring *Keyring) (*EncryptingProvider, *memoryProvider, map[string]*domain.FileMetadataRecord) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	records := map[string]*domain.FileMetadataRecord{}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			if record, ok := records[fileID]; ok {
+				copied := *record
+				return &copied, nil
+			}
+			return &domain.FileMetadataRecord{ID: fileID}, nil
+		}).AnyTimes()
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string, record *domain.FileMetadataRecord) error {
+			records[fileID] = record
+			return nil
+		}).AnyTimes()
+
+	inner := &memoryProvider{files: map[string][]byte{}}
+	return NewEncryptingProvider(inner, keyring, mockMetadata, &testLogger), inner, records
+}
+
+func TestEncryptingProvider_RoundTrip(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string]string{"k1": testMasterKey(t)})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	p, inner, records := newTestEncryptingProvider(t, keyring)
+
+	for _, size := range []int{0, 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize - 7} {
+		fileID := fmt.Sprintf("file-%d", size)
+		content := bytes.Repeat([]byte("id,name\n"), size/8+1)[:size]
+
+		if _, err := p.Store(context.Background(), fileID, bytes.NewReader(content)); err != nil {
+			t.Fatalf("Store(%s) error = %v", fileID, err)
+		}
+		if records[fileID].EncryptionKeyID != "k1" || len(records[fileID].WrappedDataKey) == 0 {
+			t.Errorf("record %s = %+v, want data key wrapped with k1", fileID, records[fileID])
+		}
+		if size >= 16 && bytes.Contains(inner.files[fileID], content[:16]) {
+			t.Errorf("%s stored in plaintext", fileID)
+		}
+		if got := retrieveAll(t, p, fileID); !bytes.Equal(got, content) {
+			t.Errorf("Retrieve(%s) returned %d bytes, want %d", fileID, len(got), len(content))
+		}
+		if info, err := p.Stat(context.Background(), fileID); err != nil || info.Size != int64(size) {
+			t.Errorf("Stat(%s) = %+v, %v; want size %d", fileID, info, err, size)
+		}
+	}
+}
+
+func TestEncryptingProvider_RetrieveRange(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string]string{"k1": testMasterKey(t)})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	p, _, _ := newTestEncryptingProvider(t, keyring)
+	content := make([]byte, 2*encryptionChunkSize)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Store(context.Background(), "file-a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	size := int64(len(content))
+	tests := []struct{ offset, length int64 }{
+		{0, 10},
+		{encryptionChunkSize - 5, 10},
+		{encryptionChunkSize, 1},
+		{encryptionChunkSize + 100, -1},
+		{size - 1, 1},
+		{size, -1},
+	}
+	for _, tt := range tests {
+		end := size
+		if tt.length >= 0 {
+			end = tt.offset + tt.length
+		}
+		if got := retrieveRange(t, p, "file-a", tt.offset, tt.length); !bytes.Equal(got, content[tt.offset:end]) {
+			t.Errorf("RetrieveRange(%d, %d) returned %d bytes that differ from the content", tt.offset, tt.length, len(got))
+		}
+	}
+	if _, err := p.RetrieveRange(context.Background(), "file-a", size+1, -1); err == nil {
+		t.Error("RetrieveRange() past end error = nil, want invalid range")
+	}
+}
+
+func TestEncryptingProvider_DetectsTampering(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string]string{"k1": testMasterKey(t)})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	p, inner, _ := newTestEncryptingProvider(t, keyring)
+	content := strings.Repeat("id,name\n1,alice\n", 10000)
+	if _, err := p.Store(context.Background(), "file-a", strings.NewReader(content)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	stored := inner.files["file-a"]
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"flipped bit", func(b []byte) []byte { b[len(b)/2] ^= 1; return b }},
+		{"truncated at chunk boundary", func(b []byte) []byte {
+			return b[:encryptionHeaderSize+encryptionChunkSize+16]
+		}},
+		{"header only", func(b []byte) []byte { return b[:encryptionHeaderSize] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner.files["file-a"] = tt.mutate(bytes.Clone(stored))
+			reader, err := p.Retrieve(context.Background(), "file-a")
+			if err == nil {
+				_, err = io.ReadAll(reader)
+				reader.Close()
+			}
+			if !errors.Is(err, errDecryptionFailed) {
+				t.Errorf("reading tampered file error = %v, want decryption failure", err)
+			}
+		})
+	}
+}
+
+// memoryDataKeys serves data keys from the records kept by the test metadata service
+type memoryDataKeys struct {
+	records map[string]*domain.FileMetadataRecord
+}
+
+func (m *memoryDataKeys) ListDataKeys(ctx context.Context, excludeKeyID, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	var found []*domain.FileMetadataRecord
+	for _, id := range []string{"file-a", "file-b", "file-c"} {
+		record := m.records[id]
+		if record != nil && id > afterFileID && record.EncryptionKeyID != excludeKeyID && len(found) < limit {
+			copied := *record
+			found = append(found, &copied)
+		}
+	}
+	return found, nil
+}
+
+func (m *memoryDataKeys) RewrapDataKey(ctx context.Context, fileID, oldKeyID, newKeyID string, wrappedKey []byte) error {
+	record := m.records[fileID]
+	if record.EncryptionKeyID != oldKeyID {
+		return errors.New("data key changed concurrently")
+	}
+	record.EncryptionKeyID = newKeyID
+	record.WrappedDataKey = wrappedKey
+	return nil
+}
+
+func TestRotateDataKeys(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	oldKey, newKey := testMasterKey(t), testMasterKey(t)
+	oldKeyring, err := NewKeyring("old", map[string]string{"old": oldKey})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	p, inner, records := newTestEncryptingProvider(t, oldKeyring)
+	for _, fileID := range []string{"file-a", "file-b", "file-c"} {
+		if _, err := p.Store(context.Background(), fileID, strings.NewReader("content of "+fileID)); err != nil {
+			t.Fatalf("Store(%s) error = %v", fileID, err)
+		}
+	}
+	bodies := map[string][]byte{}
+	for fileID, body := range inner.files {
+		bodies[fileID] = bytes.Clone(body)
+	}
+
+	newKeyring, err := NewKeyring("new", map[string]string{"old": oldKey, "new": newKey})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	result, err := RotateDataKeys(context.Background(), &memoryDataKeys{records: records}, newKeyring, 2, false, &testLogger)
+	if err != nil || result.Rotated != 3 || len(result.Failed) != 0 {
+		t.Fatalf("RotateDataKeys() = %+v, %v; want 3 rotated", result, err)
+	}
+
+	// Bodies are untouched and readable with only the new master key
+	p.keyring, err = NewKeyring("new", map[string]string{"new": newKey})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	for fileID, body := range bodies {
+		if !bytes.Equal(inner.files[fileID], body) {
+			t.Errorf("%s body rewritten by rotation", fileID)
+		}
+		if records[fileID].EncryptionKeyID != "new" {
+			t.Errorf("%s key ID = %q, want new", fileID, records[fileID].EncryptionKeyID)
+		}
+		if got := retrieveAll(t, p, fileID); string(got) != "content of "+fileID {
+			t.Errorf("Retrieve(%s) = %q after rotation", fileID, got)
+		}
+	}
+}
+
+func TestNewKeyring_RejectsInvalidKeys(t *testing.T) {
+	if _, err := NewKeyring("k1", map[string]string{"k2": testMasterKey(t)}); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("NewKeyring() missing current key error = %v, want ErrUnknownKeyID", err)
+	}
+	short := base64.StdEncoding.EncodeToString([]byte("too short"))
+	if _, err := NewKeyring("k1", map[string]string{"k1": short}); err == nil {
+		t.Error("NewKeyring() short key error = nil")
+	}
+}
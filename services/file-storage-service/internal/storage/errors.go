@@ -1,10 +1,44 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	"google.golang.org/grpc/codes"
+)
+
+// Every Provider reports missing files, duplicate stores, unusable file IDs,
+// content failing its checksum and ranges outside the file with errors
+// wrapping these, so callers can tell them apart with errors.Is whatever the
+// provider
+var (
+	ErrFileNotFound         = file.ErrFileNotFound
+	ErrFileAlreadyExists    = file.ErrFileAlreadyExists
+	ErrInvalidFileID        = file.ErrInvalidFileID
+	ErrIntegrityCheckFailed = file.ErrIntegrityCheckFailed
+	ErrInvalidRange         = file.ErrInvalidRange
+)
 
 var (
-	ErrFileNotFound  = errors.New("file not found in storage")
 	ErrStorageFull   = errors.New("storage capacity exceeded")
 	ErrInvalidPath   = errors.New("invalid storage path")
 	ErrStorageAccess = errors.New("storage access denied")
 )
+
+// ErrorCode is the status a provider error is reported with: NotFound,
+// AlreadyExists, InvalidArgument or OutOfRange for the errors above, Internal
+// otherwise
+func ErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrFileNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrFileAlreadyExists):
+		return codes.AlreadyExists
+	case errors.Is(err, ErrInvalidFileID):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrInvalidRange):
+		return codes.OutOfRange
+	default:
+		return codes.Internal
+	}
+}
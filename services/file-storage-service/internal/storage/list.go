@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"strings"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+)
+
+// AllFiles iterates over every file ID matching opts, listing a page at a
+// time, so any number of files can be walked without holding them in memory.
+// A failed List is yielded as the error of the last item.
+func AllFiles(ctx context.Context, p Provider, opts file.ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for {
+			page, err := p.List(ctx, opts)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, fileID := range page.FileIDs {
+				if !yield(fileID, nil) {
+					return
+				}
+			}
+			if page.NextContinuationToken == "" {
+				return
+			}
+			opts.ContinuationToken = page.NextContinuationToken
+		}
+	}
+}
+
+// ListKeyer is implemented by providers that do not list files in file ID
+// order. Their pages are in lexical order of ListKey instead.
+type ListKeyer interface {
+	ListKey(fileID string) string
+}
+
+// listKey returns the key p lists files in order of
+func listKey(p Provider) func(fileID string) string {
+	if keyer, ok := p.(ListKeyer); ok {
+		return keyer.ListKey
+	}
+	return func(fileID string) string { return fileID }
+}
+
+// mergePages combines pages listed with the same options from several
+// providers listing in order of key into a single page of at most limit file
+// IDs
+func mergePages(pages []*file.ListPage, limit int, key func(fileID string) string) *file.ListPage {
+	var fileIDs []string
+	// IDs past the end of a truncated page may still be missing from the
+	// other pages, so the merged page stops at the earliest end
+	var cutoff string
+	for _, page := range pages {
+		fileIDs = append(fileIDs, page.FileIDs...)
+		if token := page.NextContinuationToken; token != "" && (cutoff == "" || key(token) < cutoff) {
+			cutoff = key(token)
+		}
+	}
+	slices.SortFunc(fileIDs, func(a, b string) int { return strings.Compare(key(a), key(b)) })
+	fileIDs = slices.Compact(fileIDs)
+
+	truncated := cutoff != ""
+	if truncated {
+		end, found := slices.BinarySearchFunc(fileIDs, cutoff, func(fileID, cutoff string) int {
+			return strings.Compare(key(fileID), cutoff)
+		})
+		if found {
+			end++
+		}
+		fileIDs = fileIDs[:end]
+	}
+	if len(fileIDs) > limit {
+		fileIDs, truncated = fileIDs[:limit], true
+	}
+
+	merged := &file.ListPage{FileIDs: fileIDs}
+	if truncated && len(fileIDs) > 0 {
+		merged.NextContinuationToken = fileIDs[len(fileIDs)-1]
+	}
+	return merged
+}
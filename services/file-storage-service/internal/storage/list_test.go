@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+)
+
+func TestAllFiles_WalksTiersInOrder(t *testing.T) {
+	p, hot, cold, _ := newTestTieredProvider(t)
+	for _, fileID := range []string{"a", "c", "e", "g"} {
+		hot.files[fileID] = []byte(fileID)
+	}
+	// d is in both tiers mid-move
+	for _, fileID := range []string{"b", "d", "f"} {
+		cold.files[fileID] = []byte(fileID)
+	}
+	hot.files["d"] = []byte("d")
+
+	var got []string
+	for fileID, err := range AllFiles(context.Background(), p, file.ListOptions{PageSize: 2}) {
+		if err != nil {
+			t.Fatalf("AllFiles() error = %v", err)
+		}
+		got = append(got, fileID)
+	}
+	if want := []string{"a", "b", "c", "d", "e", "f", "g"}; !slices.Equal(got, want) {
+		t.Errorf("AllFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestAllFiles_StopsOnError(t *testing.T) {
+	var calls int
+	for _, err := range AllFiles(context.Background(), listErrorProvider{&failingProvider{}}, file.ListOptions{}) {
+		calls++
+		if !errors.Is(err, errReplicaDown) {
+			t.Errorf("AllFiles() error = %v, want %v", err, errReplicaDown)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("AllFiles() yielded %d times, want 1", calls)
+	}
+}
+
+// listErrorProvider fails every List
+type listErrorProvider struct {
+	*failingProvider
+}
+
+func (listErrorProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	return nil, errReplicaDown
+}
+
+func TestMergePages_StopsAtEarliestTruncation(t *testing.T) {
+	identity := func(fileID string) string { return fileID }
+	merged := mergePages([]*file.ListPage{
+		{FileIDs: []string{"a", "b"}, NextContinuationToken: "b"},
+		{FileIDs: []string{"a", "c", "d"}},
+	}, 3, identity)
+	if !slices.Equal(merged.FileIDs, []string{"a", "b"}) || merged.NextContinuationToken != "b" {
+		t.Errorf("mergePages() = %+v, want [a b] continuing after b", merged)
+	}
+
+	merged = mergePages([]*file.ListPage{{FileIDs: []string{"a", "c"}}, {FileIDs: []string{"b", "d"}}}, 3, identity)
+	if !slices.Equal(merged.FileIDs, []string{"a", "b", "c"}) || merged.NextContinuationToken != "c" {
+		t.Errorf("mergePages() = %+v, want [a b c] continuing after c", merged)
+	}
+
+	// Pages listed by another key are merged in its order
+	reversed := func(fileID string) string { return string(rune('z' - fileID[0])) }
+	merged = mergePages([]*file.ListPage{
+		{FileIDs: []string{"d", "c"}, NextContinuationToken: "c"},
+		{FileIDs: []string{"d", "b", "a"}},
+	}, 3, reversed)
+	if !slices.Equal(merged.FileIDs, []string{"d", "c"}) || merged.NextContinuationToken != "c" {
+		t.Errorf("mergePages() by key = %+v, want [d c] continuing after c", merged)
+	}
+}
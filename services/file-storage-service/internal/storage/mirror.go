@@ -0,0 +1,500 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	missingReasonWriteFailed = "write failed"
+	missingReasonNotFound    = "not found on read"
+	missingReasonCorrupt     = "checksum mismatch"
+
+	mirrorCopyBufferSize = 32 * 1024
+)
+
+// Replica is one named copy behind a MirroredProvider. The name identifies it
+// in missing replica records, so it must stay stable across restarts.
+type Replica struct {
+	Name     string
+	Provider Provider
+}
+
+// MirrorConfig configures MirroredProvider
+type MirrorConfig struct {
+	Replicas []Replica
+	// WriteQuorum is how many replicas must store a file for Store to succeed;
+	// zero requires all of them
+	WriteQuorum       int
+	ReplicaRepository metadataService.ReplicaRepository
+}
+
+// MirroredProvider writes every file to all replicas and reads from the first
+// healthy one, verifying content against the checksum on the metadata record.
+// Replicas that miss a write, or turn out to lack a valid copy on read, are
+// recorded so Repair can copy the file back onto them.
+type MirroredProvider struct {
+	replicas        []Replica
+	healthy         []atomic.Bool
+	writeQuorum     int
+	missing         metadataService.ReplicaRepository
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+}
+
+func NewMirroredProvider(cfg *MirrorConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*MirroredProvider, error) {
+	if len(cfg.Replicas) == 0 {
+		return nil, errors.New("mirror needs at least one replica")
+	}
+	names := make(map[string]bool, len(cfg.Replicas))
+	for _, replica := range cfg.Replicas {
+		if replica.Name == "" || names[replica.Name] {
+			return nil, fmt.Errorf("mirror replica names must be unique and non-empty: %q", replica.Name)
+		}
+		names[replica.Name] = true
+	}
+	quorum := cfg.WriteQuorum
+	if quorum == 0 {
+		quorum = len(cfg.Replicas)
+	}
+	if quorum < 1 || quorum > len(cfg.Replicas) {
+		return nil, fmt.Errorf("write quorum must be between 1 and %d", len(cfg.Replicas))
+	}
+
+	m := &MirroredProvider{
+		replicas:        cfg.Replicas,
+		healthy:         make([]atomic.Bool, len(cfg.Replicas)),
+		writeQuorum:     quorum,
+		missing:         cfg.ReplicaRepository,
+		metadataService: metadataService,
+		logger:          logger,
+	}
+	for i := range m.healthy {
+		m.healthy[i].Store(true)
+	}
+	return m, nil
+}
+
+func (m *MirroredProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	readers := make([]*io.PipeReader, len(m.replicas))
+	writers := make([]*io.PipeWriter, len(m.replicas))
+	for i := range m.replicas {
+		readers[i], writers[i] = io.Pipe()
+	}
+
+	paths := make([]string, len(m.replicas))
+	errs := make([]error, len(m.replicas))
+	var wg sync.WaitGroup
+	for i, replica := range m.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths[i], errs[i] = replica.Provider.Store(ctx, fileID, readers[i])
+			// Fail further writes to a replica that stopped reading
+			readers[i].CloseWithError(io.ErrClosedPipe)
+		}()
+	}
+
+	hasher := sha256.New()
+	copyErr := fanOut(content, hasher, writers)
+	wg.Wait()
+
+	var stored []int
+	var storagePath string
+	for i, err := range errs {
+		if err == nil && copyErr == nil {
+			if storagePath == "" {
+				storagePath = paths[i]
+			}
+			stored = append(stored, i)
+			m.healthy[i].Store(true)
+			continue
+		}
+		if err != nil {
+			m.logger.Error().Err(err).Str("fileId", fileID).Str("replica", m.replicas[i].Name).Msg("Replica write failed")
+		}
+	}
+
+	if copyErr != nil || len(stored) < m.writeQuorum {
+		m.removeFrom(ctx, fileID, stored)
+		if copyErr != nil {
+			return "", fmt.Errorf("failed to read content: %w", copyErr)
+		}
+		return "", fmt.Errorf("write quorum not met: %d of %d replicas stored %s, need %d: %w",
+			len(stored), len(m.replicas), fileID, m.writeQuorum, errors.Join(errs...))
+	}
+
+	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
+	if err := m.recordChecksum(ctx, fileID, checksum); err != nil {
+		m.removeFrom(ctx, fileID, stored)
+		return "", err
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			m.healthy[i].Store(false)
+			m.markMissing(ctx, fileID, i, missingReasonWriteFailed)
+		}
+	}
+	return storagePath, nil
+}
+
+// fanOut copies content to every writer and to hasher. Writers whose replica
+// failed are dropped, so one bad replica does not stop the others.
+func fanOut(content io.Reader, hasher hash.Hash, writers []*io.PipeWriter) error {
+	live := make([]bool, len(writers))
+	for i := range live {
+		live[i] = true
+	}
+	buf := make([]byte, mirrorCopyBufferSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			hasher.Write(buf[:n])
+			for i, w := range writers {
+				if live[i] {
+					if _, werr := w.Write(buf[:n]); werr != nil {
+						live[i] = false
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			for _, w := range writers {
+				w.Close()
+			}
+			return nil
+		}
+		if err != nil {
+			for _, w := range writers {
+				w.CloseWithError(err)
+			}
+			return err
+		}
+	}
+}
+
+func (m *MirroredProvider) recordChecksum(ctx context.Context, fileID, checksum string) error {
+	metadata, err := m.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.Checksum = checksum
+	if err := m.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+// removeFrom deletes fileID from the given replicas after a failed Store
+func (m *MirroredProvider) removeFrom(ctx context.Context, fileID string, replicas []int) {
+	for _, i := range replicas {
+		if err := m.replicas[i].Provider.Delete(ctx, fileID); err != nil {
+			m.logger.Error().Err(err).Str("fileId", fileID).Str("replica", m.replicas[i].Name).Msg("Failed to remove partial write")
+		}
+	}
+}
+
+func (m *MirroredProvider) markMissing(ctx context.Context, fileID string, replica int, reason string) {
+	if err := m.missing.MarkReplicaMissing(ctx, fileID, m.replicas[replica].Name, reason); err != nil {
+		m.logger.Error().Err(err).Str("fileId", fileID).Str("replica", m.replicas[replica].Name).Msg("Failed to record missing replica")
+	}
+}
+
+// readOrder lists healthy replicas first, keeping configuration order
+func (m *MirroredProvider) readOrder() []int {
+	order := make([]int, 0, len(m.replicas))
+	for i := range m.replicas {
+		if m.healthy[i].Load() {
+			order = append(order, i)
+		}
+	}
+	for i := range m.replicas {
+		if !m.healthy[i].Load() {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// Retrieve reads from the first replica that can open the file. The content
+// is verified against the recorded checksum as it is read; a replica whose
+// copy does not match is recorded for repair.
+func (m *MirroredProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	metadata, err := m.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+
+	var errs []error
+	for _, i := range m.readOrder() {
+		content, err := m.replicas[i].Provider.Retrieve(ctx, fileID)
+		if err != nil {
+			errs = append(errs, m.readFailed(ctx, fileID, i, err))
+			continue
+		}
+		m.healthy[i].Store(true)
+		if metadata.Checksum == "" {
+			return content, nil
+		}
+		return &mirrorVerifyingReader{
+			ReadCloser: content,
+			hash:       sha256.New(),
+			expected:   metadata.Checksum,
+			onMismatch: func() { m.markMissing(context.WithoutCancel(ctx), fileID, i, missingReasonCorrupt) },
+		}, nil
+	}
+	return nil, fmt.Errorf("no replica could serve %s: %w", fileID, errors.Join(errs...))
+}
+
+// RetrieveRange reads the range from the first replica that can serve it.
+// Ranges are not verified, as the checksum covers the whole file.
+func (m *MirroredProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	var errs []error
+	for _, i := range m.readOrder() {
+		content, err := m.replicas[i].Provider.RetrieveRange(ctx, fileID, offset, length)
+		if err != nil {
+			if errors.Is(err, ErrInvalidRange) {
+				return nil, err
+			}
+			errs = append(errs, m.readFailed(ctx, fileID, i, err))
+			continue
+		}
+		m.healthy[i].Store(true)
+		return content, nil
+	}
+	return nil, fmt.Errorf("no replica could serve %s: %w", fileID, errors.Join(errs...))
+}
+
+// Stat describes the file as held by the first replica that has it. Unlike
+// reads, a missing copy is not queued for repair, as Stat is also used to
+// probe for files that may not exist.
+func (m *MirroredProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	var errs []error
+	for _, i := range m.readOrder() {
+		info, err := m.replicas[i].Provider.Stat(ctx, fileID)
+		if err == nil {
+			return info, nil
+		}
+		if !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("%s: %w", m.replicas[i].Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no replica could stat %s: %w", fileID, errors.Join(errs...))
+	}
+	return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+}
+
+// readFailed records a failed read: a missing file is queued for repair, any
+// other error marks the replica unhealthy so reads try it last
+func (m *MirroredProvider) readFailed(ctx context.Context, fileID string, replica int, err error) error {
+	if isNotFound(err) {
+		m.markMissing(ctx, fileID, replica, missingReasonNotFound)
+	} else {
+		m.healthy[replica].Store(false)
+	}
+	m.logger.Warn().Err(err).Str("fileId", fileID).Str("replica", m.replicas[replica].Name).Msg("Replica read failed, trying next")
+	return fmt.Errorf("%s: %w", m.replicas[replica].Name, err)
+}
+
+// Delete removes the file from every replica. Replicas that never had it are
+// fine; the call fails if any copy could not be removed.
+func (m *MirroredProvider) Delete(ctx context.Context, fileID string) error {
+	var found bool
+	var errs []error
+	for i, replica := range m.replicas {
+		err := replica.Provider.Delete(ctx, fileID)
+		switch {
+		case err == nil:
+			found = true
+		case isNotFound(err):
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", m.replicas[i].Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to delete %s from all replicas: %w", fileID, errors.Join(errs...))
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if err := m.missing.ClearFile(ctx, fileID); err != nil {
+		m.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to clear missing replica records")
+	}
+	return nil
+}
+
+// List returns a page of the files stored on any replica. Replicas that
+// cannot be listed are skipped, as long as one can.
+func (m *MirroredProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	var pages []*file.ListPage
+	var errs []error
+	for _, replica := range m.replicas {
+		page, err := replica.Provider.List(ctx, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", replica.Name, err))
+			continue
+		}
+		pages = append(pages, page)
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("failed to list files: %w", errors.Join(errs...))
+	}
+	return mergePages(pages, opts.Limit(), m.ListKey), nil
+}
+
+// ListKey returns the list key of the first replica; replicas share a layout
+func (m *MirroredProvider) ListKey(fileID string) string {
+	return listKey(m.replicas[0].Provider)(fileID)
+}
+
+// RepairResult summarises a repair pass
+type RepairResult struct {
+	Repaired int
+	Failed   int
+}
+
+// Repair copies files back onto the replicas recorded as missing them, up to
+// batchSize records per call. Copies are verified against the recorded
+// checksum before a record is cleared.
+func (m *MirroredProvider) Repair(ctx context.Context, batchSize int) (*RepairResult, error) {
+	missing, err := m.missing.ListMissingReplicas(ctx, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RepairResult{}
+	for _, record := range missing {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		target := m.replicaIndex(record.Replica)
+		if target < 0 {
+			// The replica was removed from the configuration
+			if err := m.missing.ClearReplicaMissing(ctx, record.FileID, record.Replica); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if err := m.repair(ctx, record.FileID, target); err != nil {
+			m.logger.Error().Err(err).Str("fileId", record.FileID).Str("replica", record.Replica).Msg("Replica repair failed")
+			result.Failed++
+			continue
+		}
+		if err := m.missing.ClearReplicaMissing(ctx, record.FileID, record.Replica); err != nil {
+			return result, err
+		}
+		result.Repaired++
+	}
+	return result, nil
+}
+
+func (m *MirroredProvider) replicaIndex(name string) int {
+	for i, replica := range m.replicas {
+		if replica.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// repair copies fileID from a replica with a valid copy onto target
+func (m *MirroredProvider) repair(ctx context.Context, fileID string, target int) error {
+	metadata, err := m.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+
+	for _, i := range m.readOrder() {
+		if i == target {
+			continue
+		}
+		source, err := m.replicas[i].Provider.Retrieve(ctx, fileID)
+		if err != nil {
+			continue
+		}
+		err = m.copyVerified(ctx, fileID, source, target, metadata.Checksum)
+		source.Close()
+		if err == nil {
+			m.logger.Info().Str("fileId", fileID).Str("from", m.replicas[i].Name).Str("to", m.replicas[target].Name).Msg("Replica repaired")
+			return nil
+		}
+		m.logger.Warn().Err(err).Str("fileId", fileID).Str("from", m.replicas[i].Name).Msg("Repair source unusable, trying next")
+	}
+	return fmt.Errorf("no valid copy of %s to repair from", fileID)
+}
+
+func (m *MirroredProvider) copyVerified(ctx context.Context, fileID string, source io.Reader, target int, checksum string) error {
+	provider := m.replicas[target].Provider
+	// Drop a corrupt copy, if any, so the store does not collide with it
+	if err := provider.Delete(ctx, fileID); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to remove old copy: %w", err)
+	}
+
+	hasher := sha256.New()
+	if _, err := provider.Store(ctx, fileID, io.TeeReader(source, hasher)); err != nil {
+		return fmt.Errorf("failed to store copy: %w", err)
+	}
+	if checksum != "" && fmt.Sprintf("%x", hasher.Sum(nil)) != checksum {
+		if err := provider.Delete(ctx, fileID); err != nil {
+			m.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to remove corrupt repair copy")
+		}
+		return fmt.Errorf("%w: checksums do not match", ErrIntegrityCheckFailed)
+	}
+	// The replica's own Store recorded its checksum; restore the mirror's
+	return m.recordChecksum(ctx, fileID, checksum)
+}
+
+// SweepTempFiles sweeps every replica that stages writes in temp files
+func (m *MirroredProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	var removed []string
+	var errs []error
+	for _, replica := range m.replicas {
+		sweeper, ok := replica.Provider.(TempFileSweeper)
+		if !ok {
+			continue
+		}
+		paths, err := sweeper.SweepTempFiles(ctx)
+		removed = append(removed, paths...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", replica.Name, err))
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// isNotFound reports whether a provider error means the file does not exist.
+// Providers wrap ErrFileNotFound for that.
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrFileNotFound)
+}
+
+// mirrorVerifyingReader checks the content against the recorded checksum at
+// EOF and reports a mismatch so the replica can be repaired
+type mirrorVerifyingReader struct {
+	io.ReadCloser
+	hash       hash.Hash
+	expected   string
+	onMismatch func()
+}
+
+func (r *mirrorVerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && fmt.Sprintf("%x", r.hash.Sum(nil)) != r.expected {
+		r.onMismatch()
+		return n, fmt.Errorf("%w: checksums do not match", ErrIntegrityCheckFailed)
+	}
+	return n, err
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// failingProvider rejects every operation, like an unreachable disk
+type failingProvider struct {
+	memoryProvider
+}
+
+var errReplicaDown = errors.New("replica unavailable")
+
+func (f *failingProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	return "", errReplicaDown
+}
+
+func (f *failingProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	return nil, errReplicaDown
+}
+
+// memoryReplicas records missing replicas in a map keyed by fileID/replica
+type memoryReplicas struct {
+	missing map[string]string
+}
+
+func (m *memoryReplicas) MarkReplicaMissing(ctx context.Context, fileID, replica, reason string) error {
+	m.missing[fileID+"/"+replica] = reason
+	return nil
+}
+
+func (m *memoryReplicas) ClearReplicaMissing(ctx context.Context, fileID, replica string) error {
+	delete(m.missing, fileID+"/"+replica)
+	return nil
+}
+
+func (m *memoryReplicas) ClearFile(ctx context.Context, fileID string) error {
+	for key := range m.missing {
+		if strings.HasPrefix(key, fileID+"/") {
+			delete(m.missing, key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryReplicas) ListMissingReplicas(ctx context.Context, limit int) ([]*domain.MissingReplica, error) {
+	var found []*domain.MissingReplica
+	for key, reason := range m.missing {
+		fileID, replica, _ := strings.Cut(key, "/")
+		found = append(found, &domain.MissingReplica{FileID: fileID, Replica: replica, Reason: reason})
+	}
+	return found, nil
+}
+
+func newTestMirror(t *testing.T, quorum int, providers ...Provider) (*MirroredProvider, *memoryReplicas) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	records := map[string]*domain.FileMetadataRecord{}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			if record, ok := records[fileID]; ok {
+				copied := *record
+				return &copied, nil
+			}
+			return &domain.FileMetadataRecord{ID: fileID}, nil
+		}).AnyTimes()
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string, record *domain.FileMetadataRecord) error {
+			records[fileID] = record
+			return nil
+		}).AnyTimes()
+
+	missing := &memoryReplicas{missing: map[string]string{}}
+	cfg := &MirrorConfig{WriteQuorum: quorum, ReplicaRepository: missing}
+	for i, provider := range providers {
+		cfg.Replicas = append(cfg.Replicas, Replica{Name: string(rune('a' + i)), Provider: provider})
+	}
+	m, err := NewMirroredProvider(cfg, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewMirroredProvider() error = %v", err)
+	}
+	return m, missing
+}
+
+func TestMirroredProvider_StoreMeetsQuorumDespiteFailedReplica(t *testing.T) {
+	a := &memoryProvider{files: map[string][]byte{}}
+	b := &failingProvider{}
+	c := &memoryProvider{files: map[string][]byte{}}
+	m, missing := newTestMirror(t, 2, a, b, c)
+	content := []byte(strings.Repeat("id,name\n1,alice\n", 10000))
+
+	if _, err := m.Store(context.Background(), "file-a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if !bytes.Equal(a.files["file-a"], content) || !bytes.Equal(c.files["file-a"], content) {
+		t.Error("healthy replicas do not hold the content")
+	}
+	if _, ok := missing.missing["file-a/b"]; !ok || len(missing.missing) != 1 {
+		t.Errorf("missing replicas = %v, want only file-a/b", missing.missing)
+	}
+	if got := retrieveAll(t, m, "file-a"); !bytes.Equal(got, content) {
+		t.Error("Retrieve() content differs from stored content")
+	}
+}
+
+func TestMirroredProvider_StoreFailsBelowQuorum(t *testing.T) {
+	a := &memoryProvider{files: map[string][]byte{}}
+	m, _ := newTestMirror(t, 2, a, &failingProvider{})
+
+	if _, err := m.Store(context.Background(), "file-a", strings.NewReader("content")); err == nil {
+		t.Fatal("Store() error = nil, want quorum failure")
+	}
+	if _, ok := a.files["file-a"]; ok {
+		t.Error("partial write left on the replica that succeeded")
+	}
+}
+
+func TestMirroredProvider_RetrieveFallsBack(t *testing.T) {
+	a := &memoryProvider{files: map[string][]byte{}}
+	b := &memoryProvider{files: map[string][]byte{}}
+	m, missing := newTestMirror(t, 0, a, b)
+	if _, err := m.Store(context.Background(), "file-a", strings.NewReader("id,name\n1,alice\n")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	delete(a.files, "file-a")
+	if got := retrieveAll(t, m, "file-a"); string(got) != "id,name\n1,alice\n" {
+		t.Errorf("Retrieve() = %q", got)
+	}
+	if missing.missing["file-a/a"] != missingReasonNotFound {
+		t.Errorf("missing replicas = %v, want file-a/a not found", missing.missing)
+	}
+}
+
+func TestMirroredProvider_DetectsCorruptReplica(t *testing.T) {
+	a := &memoryProvider{files: map[string][]byte{}}
+	b := &memoryProvider{files: map[string][]byte{}}
+	m, missing := newTestMirror(t, 0, a, b)
+	if _, err := m.Store(context.Background(), "file-a", strings.NewReader("id,name\n1,alice\n")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	a.files["file-a"] = []byte("id,name\n1,mallory\n")
+
+	reader, err := m.Retrieve(context.Background(), "file-a")
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrIntegrityCheckFailed) {
+		t.Errorf("reading corrupt replica error = %v, want ErrIntegrityCheckFailed", err)
+	}
+	reader.Close()
+	if missing.missing["file-a/a"] != missingReasonCorrupt {
+		t.Fatalf("missing replicas = %v, want file-a/a corrupt", missing.missing)
+	}
+
+	result, err := m.Repair(context.Background(), 10)
+	if err != nil || result.Repaired != 1 {
+		t.Fatalf("Repair() = %+v, %v; want 1 repaired", result, err)
+	}
+	if string(a.files["file-a"]) != "id,name\n1,alice\n" || len(missing.missing) != 0 {
+		t.Errorf("after repair replica a = %q, missing = %v", a.files["file-a"], missing.missing)
+	}
+	if got := retrieveAll(t, m, "file-a"); string(got) != "id,name\n1,alice\n" {
+		t.Errorf("Retrieve() after repair = %q", got)
+	}
+}
+
+func TestMirroredProvider_RepairRestoresMissedWrite(t *testing.T) {
+	a := &memoryProvider{files: map[string][]byte{}}
+	b := &memoryProvider{files: map[string][]byte{}}
+	down := &failingProvider{memoryProvider: *b}
+	m, missing := newTestMirror(t, 1, a, down)
+	if _, err := m.Store(context.Background(), "file-a", strings.NewReader("content")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	// The replica comes back: repair copies the file onto it
+	m.replicas[1].Provider = b
+	result, err := m.Repair(context.Background(), 10)
+	if err != nil || result.Repaired != 1 || result.Failed != 0 {
+		t.Fatalf("Repair() = %+v, %v; want 1 repaired", result, err)
+	}
+	if string(b.files["file-a"]) != "content" || len(missing.missing) != 0 {
+		t.Errorf("after repair replica b = %q, missing = %v", b.files["file-a"], missing.missing)
+	}
+}
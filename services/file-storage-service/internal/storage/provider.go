@@ -5,8 +5,13 @@ import (
 	"errors"
 	"io"
 
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
 	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/cas"
 	filesystem "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/local"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/memory"
+	s3storage "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/providers/s3"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 )
 
@@ -18,11 +23,26 @@ type Provider interface {
 	// Retrieve gets a file by its ID
 	Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error)
 
+	// RetrieveRange gets length bytes of a file starting at offset, or the
+	// rest of the file when length is negative
+	RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat describes a stored file without reading it. Missing files are
+	// reported with an error wrapping file.ErrFileNotFound.
+	Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error)
+
 	// Delete removes a file from storage
 	Delete(ctx context.Context, fileID string) error
 
-	// List returns a list of files in the storage
-	List(ctx context.Context) ([]string, error)
+	// List returns a page of stored file IDs in lexical order. The page's
+	// continuation token, passed back in opts, fetches the next one.
+	List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error)
+}
+
+// TempFileSweeper is implemented by providers that stage writes in temp files.
+// SweepTempFiles removes leftovers from interrupted writes and reports them.
+type TempFileSweeper interface {
+	SweepTempFiles(ctx context.Context) ([]string, error)
 }
 
 type ProviderType string
@@ -30,10 +50,23 @@ type ProviderType string
 type Config struct {
 	BasePath        string `mapstructure:"base_path"`
 	MetadataService metadataService.MetadataService
+	// Layout fans files out into shard directories; the zero value is flat
+	Layout filesystem.Layout `mapstructure:",squash"`
+	// Breaker configures the circuit breakers, one per operation
+	Breaker circuit.Config `mapstructure:"-"`
 }
 
 const (
 	Local ProviderType = "local"
+	S3    ProviderType = "s3"
+	// ContentAddressed deduplicates identical uploads on the local filesystem
+	ContentAddressed ProviderType = "cas"
+	// Mirror writes every file to several replicas
+	Mirror ProviderType = "mirror"
+	// Tiered moves files between storage tiers by lifecycle policy
+	Tiered ProviderType = "tiered"
+	// Memory keeps files in memory until the process exits
+	Memory ProviderType = "memory"
 )
 
 func NewProvider(providerType ProviderType, cfg interface{}, metadataService metadataService.MetadataService, logger *logger.Logger) (Provider, error) {
@@ -43,7 +76,44 @@ func NewProvider(providerType ProviderType, cfg interface{}, metadataService met
 		if !ok {
 			return nil, errors.New("invalid configuration type")
 		}
-		return filesystem.NewLocalFileSystem(localCfg.BasePath, localCfg.MetadataService, logger), nil
+		var fs *filesystem.LocalFileSystem
+		var err error
+		if localCfg.Layout.IsFlat() {
+			fs, err = filesystem.NewLocalFileSystem(localCfg.BasePath, localCfg.MetadataService, logger)
+		} else {
+			fs, err = filesystem.NewShardedLocalFileSystem(localCfg.BasePath, localCfg.Layout, localCfg.MetadataService, logger)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fs.SetCircuitBreakers(localCfg.Breaker)
+		return fs, nil
+	case S3:
+		s3Cfg, ok := cfg.(*s3storage.Config)
+		if !ok {
+			return nil, errors.New("invalid configuration type")
+		}
+		return s3storage.NewS3Storage(s3Cfg, metadataService, logger)
+	case ContentAddressed:
+		casCfg, ok := cfg.(*cas.Config)
+		if !ok {
+			return nil, errors.New("invalid configuration type")
+		}
+		return cas.NewContentAddressedStorage(casCfg, logger), nil
+	case Mirror:
+		mirrorCfg, ok := cfg.(*MirrorConfig)
+		if !ok {
+			return nil, errors.New("invalid configuration type")
+		}
+		return NewMirroredProvider(mirrorCfg, metadataService, logger)
+	case Tiered:
+		tieringCfg, ok := cfg.(*TieringConfig)
+		if !ok {
+			return nil, errors.New("invalid configuration type")
+		}
+		return NewTieredProvider(tieringCfg, metadataService, logger)
+	case Memory:
+		return memory.NewMemoryStorage(metadataService, logger), nil
 	default:
 		return nil, errors.New("invalid provider type")
 	}
@@ -0,0 +1,432 @@
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	blobsDir = "blobs"
+	tmpDir   = "tmp"
+)
+
+// Config holds the settings for the content-addressed provider
+type Config struct {
+	BasePath        string
+	BlobRepository  metadataService.BlobRepository
+	MetadataService metadataService.MetadataService
+	// Breaker configures the circuit breakers, one per operation
+	Breaker circuit.Config
+}
+
+// ContentAddressedStorage stores each distinct content once, as a blob named by
+// its SHA-256. File IDs are mapped to blobs in the metadata DB, which also keeps
+// a reference count per blob so a blob is only removed with its last file.
+type ContentAddressedStorage struct {
+	basePath        string
+	blobs           metadataService.BlobRepository
+	breakers        *circuit.Group
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+	// mu serialises reference count changes with the matching blob file
+	// creation or removal, so a blob is never deleted under a new reference
+	mu sync.Mutex
+}
+
+func NewContentAddressedStorage(cfg *Config, logger *logger.Logger) *ContentAddressedStorage {
+	breakerCfg := cfg.Breaker
+	if breakerCfg.IsFailure == nil {
+		breakerCfg.IsFailure = isBreakerFailure
+	}
+	return &ContentAddressedStorage{
+		basePath:        cfg.BasePath,
+		blobs:           cfg.BlobRepository,
+		breakers:        circuit.NewGroup("cas:"+cfg.BasePath, breakerCfg),
+		metadataService: cfg.MetadataService,
+		logger:          logger,
+	}
+}
+
+// isBreakerFailure leaves out errors about the requested file itself, which
+// say nothing about the health of the storage
+func isBreakerFailure(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, file.ErrFileNotFound) || errors.Is(err, file.ErrFileAlreadyExists) {
+		return false
+	}
+	return circuit.DefaultIsFailure(err)
+}
+
+func (s *ContentAddressedStorage) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return "", err
+	}
+
+	_, err := s.blobHash(ctx, fileID)
+	if err == nil {
+		return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, fileID)
+	}
+	if !errors.Is(err, file.ErrFileNotFound) {
+		return "", err
+	}
+
+	// Stream into a temp file while hashing, so the blob name is known afterwards
+	var tmpPath, checksum string
+	var size int64
+	err = s.breakers.Execute(ctx, "store", func() error {
+		var err error
+		tmpPath, checksum, size, err = s.writeTemp(content)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+
+	blobPath := s.blobPath(checksum)
+	if err := s.commitBlob(ctx, fileID, checksum, size, tmpPath, blobPath); err != nil {
+		return "", err
+	}
+
+	if err := s.recordChecksum(ctx, fileID, blobPath, checksum); err != nil {
+		if rmErr := s.removeReference(ctx, fileID); rmErr != nil {
+			s.logger.Error().Err(rmErr).Str("fileId", fileID).Msg("Failed to release blob reference after metadata failure")
+		}
+		return "", err
+	}
+	return blobPath, nil
+}
+
+// commitBlob adds the reference and moves the temp file into place if this is
+// the first copy of the content on disk
+func (s *ContentAddressedStorage) commitBlob(ctx context.Context, fileID, checksum string, size int64, tmpPath, blobPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created, err := s.blobs.AddBlobReference(ctx, fileID, checksum, size)
+	if err != nil {
+		return fmt.Errorf("failed to add blob reference: %w", err)
+	}
+
+	if !created && s.fileExists(blobPath) {
+		s.logger.Debug().Str("fileId", fileID).Str("blobHash", checksum).Msg("Deduplicated upload")
+		return nil
+	}
+
+	if err := placeBlob(tmpPath, blobPath); err != nil {
+		if _, _, rmErr := s.blobs.RemoveBlobReference(ctx, fileID); rmErr != nil {
+			s.logger.Error().Err(rmErr).Str("fileId", fileID).Msg("Failed to release blob reference after write failure")
+		}
+		return err
+	}
+	return nil
+}
+
+// blobHash returns the hash of the blob fileID maps to. Only a missing mapping
+// is reported as a missing file; other lookup failures are passed on.
+func (s *ContentAddressedStorage) blobHash(ctx context.Context, fileID string) (string, error) {
+	checksum, err := s.blobs.GetBlobHash(ctx, fileID)
+	if errors.Is(err, domain.ErrFileNotFound) {
+		return "", fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up blob of %s: %w", fileID, err)
+	}
+	return checksum, nil
+}
+
+func placeBlob(tmpPath, blobPath string) error {
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(tmpPath, blobPath); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+func (s *ContentAddressedStorage) writeTemp(content io.Reader) (string, string, int64, error) {
+	dir := filepath.Join(s.basePath, tmpDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hasher), content)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		os.Remove(f.Name())
+		return "", "", 0, fmt.Errorf("failed to sync file: %w", err)
+	}
+	return f.Name(), fmt.Sprintf("%x", hasher.Sum(nil)), size, nil
+}
+
+// SweepTempFiles removes uploads left in the temp directory by writes that
+// never completed. It must only run while no Store is in progress.
+func (s *ContentAddressedStorage) SweepTempFiles(ctx context.Context) ([]string, error) {
+	dir := filepath.Join(s.basePath, tmpDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sweep temp files: %w", err)
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove temp file %s: %w", path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func (s *ContentAddressedStorage) recordChecksum(ctx context.Context, fileID, storagePath, checksum string) error {
+	metadata, err := s.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.Checksum = checksum
+	metadata.StoragePath = storagePath
+	if err := s.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *ContentAddressedStorage) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+
+	checksum, err := s.blobHash(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var file *os.File
+	err = s.breakers.Execute(ctx, "retrieve", func() error {
+		var err error
+		file, err = os.Open(s.blobPath(checksum))
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The blob name is its checksum, so integrity is verified while reading
+	return &verifyingReader{file: file, hash: sha256.New(), expected: checksum}, nil
+}
+
+// RetrieveRange reads part of a file's blob. Integrity is verified against
+// the blob name only when a file is read whole, through Retrieve.
+func (s *ContentAddressedStorage) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", file.ErrInvalidRange, offset)
+	}
+
+	checksum, err := s.blobHash(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	err = s.breakers.Execute(ctx, "retrieve", func() error {
+		var err error
+		f, err = os.Open(s.blobPath(checksum))
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if offset > info.Size() {
+		f.Close()
+		return nil, fmt.Errorf("%w: offset %d beyond file size %d", file.ErrInvalidRange, offset, info.Size())
+	}
+	if length < 0 {
+		length = info.Size() - offset
+	}
+	return &sectionReader{SectionReader: io.NewSectionReader(f, offset, length), file: f}, nil
+}
+
+// sectionReader reads a section of a blob and closes the blob file
+type sectionReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *sectionReader) Close() error {
+	return r.file.Close()
+}
+
+// Stat describes the blob a file maps to. Deduplicated files share the blob,
+// and with it the modification time of the first upload.
+func (s *ContentAddressedStorage) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+
+	checksum, err := s.blobHash(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var info os.FileInfo
+	err = s.breakers.Execute(ctx, "stat", func() error {
+		var err error
+		info, err = os.Stat(s.blobPath(checksum))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s: blob missing", file.ErrFileNotFound, fileID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat blob: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file.ObjectInfo{
+		FileID:       fileID,
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		Checksum:     checksum,
+		StorageClass: file.StorageClassStandard,
+	}, nil
+}
+
+func (s *ContentAddressedStorage) Delete(ctx context.Context, fileID string) error {
+	if err := s.validateFileID(fileID); err != nil {
+		return err
+	}
+
+	return s.breakers.Execute(ctx, "delete", func() error {
+		return s.removeReference(ctx, fileID)
+	})
+}
+
+// removeReference drops fileID's reference and removes the blob once unreferenced
+func (s *ContentAddressedStorage) removeReference(ctx context.Context, fileID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checksum, remaining, err := s.blobs.RemoveBlobReference(ctx, fileID)
+	if errors.Is(err, domain.ErrFileNotFound) {
+		return fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release blob of %s: %w", fileID, err)
+	}
+	if remaining > 0 {
+		return nil
+	}
+
+	if err := os.Remove(s.blobPath(checksum)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *ContentAddressedStorage) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	limit := opts.Limit()
+	var fileIDs []string
+	err := s.breakers.Execute(ctx, "list", func() error {
+		var err error
+		// One more than the page holds tells whether another page follows
+		fileIDs, err = s.blobs.ListBlobFileIDs(ctx, opts.Prefix, opts.ContinuationToken, limit+1)
+		if err != nil {
+			return fmt.Errorf("failed to list files: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(fileIDs) <= limit {
+		return &file.ListPage{FileIDs: fileIDs}, nil
+	}
+	return &file.ListPage{FileIDs: fileIDs[:limit], NextContinuationToken: fileIDs[limit-1]}, nil
+}
+
+// blobPath fans blobs out over two directory levels taken from the hash
+func (s *ContentAddressedStorage) blobPath(checksum string) string {
+	return filepath.Join(s.basePath, blobsDir, checksum[:2], checksum[2:4], checksum)
+}
+
+func (s *ContentAddressedStorage) validateFileID(fileID string) error {
+	if fileID == "" {
+		return fmt.Errorf("%w: empty", file.ErrInvalidFileID)
+	}
+	if filepath.Clean(fileID) != fileID {
+		return fmt.Errorf("%w: not a clean path", file.ErrInvalidFileID)
+	}
+	return nil
+}
+
+func (s *ContentAddressedStorage) fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// verifyingReader hashes the blob as it is read and fails at EOF when the
+// content no longer matches the hash it is stored under
+type verifyingReader struct {
+	file     *os.File
+	hash     hash.Hash
+	expected string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && fmt.Sprintf("%x", r.hash.Sum(nil)) != r.expected {
+		return n, fmt.Errorf("%w: checksums do not match", file.ErrIntegrityCheckFailed)
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.file.Close()
+}
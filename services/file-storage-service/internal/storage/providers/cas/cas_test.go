@@ -0,0 +1,215 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	database "github.com/yaanno/upload-store-process/services/file-storage-service/internal/database/sqlite"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+func newTestStorage(t *testing.T) *ContentAddressedStorage {
+	t.Helper()
+	ctx := context.Background()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+
+	db, err := database.NewDatabase(ctx, fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Connections to a shared-cache database fail with "table is locked"
+	// rather than wait for each other, so blobs are retried as in production
+	blobs, err := metadataService.NewBlobRepository(metadataService.SQLite, db, &testLogger)
+	if err != nil {
+		t.Fatalf("NewBlobRepository() error = %v", err)
+	}
+	blobs = metadataService.NewRetryingBlobRepository(blobs, retry.Config{}, &testLogger)
+
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			return &domain.FileMetadataRecord{ID: fileID}, nil
+		}).AnyTimes()
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	return NewContentAddressedStorage(&Config{
+		BasePath:        t.TempDir(),
+		BlobRepository:  blobs,
+		MetadataService: mockMetadata,
+	}, &testLogger)
+}
+
+func countBlobs(t *testing.T, s *ContentAddressedStorage) int {
+	t.Helper()
+	var count int
+	err := filepath.Walk(filepath.Join(s.basePath, blobsDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking blobs: %v", err)
+	}
+	return count
+}
+
+func TestContentAddressedStorage_Deduplicates(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	content := []byte("id,name\n1,alice\n")
+
+	firstPath, err := s.Store(ctx, "file-a", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Store(file-a) error = %v", err)
+	}
+	secondPath, err := s.Store(ctx, "file-b", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Store(file-b) error = %v", err)
+	}
+	if firstPath != secondPath {
+		t.Errorf("identical content stored at %q and %q", firstPath, secondPath)
+	}
+	if _, err := s.Store(ctx, "file-c", strings.NewReader("other content")); err != nil {
+		t.Fatalf("Store(file-c) error = %v", err)
+	}
+	if got := countBlobs(t, s); got != 2 {
+		t.Errorf("blobs on disk = %d, want 2", got)
+	}
+
+	page, err := s.List(ctx, file.ListOptions{PageSize: 2})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := strings.Join(page.FileIDs, ","); got != "file-a,file-b" || page.NextContinuationToken != "file-b" {
+		t.Errorf("List() = %q, next %q", got, page.NextContinuationToken)
+	}
+	page, err = s.List(ctx, file.ListOptions{PageSize: 2, ContinuationToken: page.NextContinuationToken})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := strings.Join(page.FileIDs, ","); got != "file-c" || page.NextContinuationToken != "" {
+		t.Errorf("List() second page = %q, next %q", got, page.NextContinuationToken)
+	}
+	page, err = s.List(ctx, file.ListOptions{Prefix: "file-b"})
+	if err != nil || strings.Join(page.FileIDs, ",") != "file-b" {
+		t.Errorf("List() with prefix = %v, %v; want [file-b]", page, err)
+	}
+
+	// The shared blob survives until its last reference is deleted
+	if err := s.Delete(ctx, "file-a"); err != nil {
+		t.Fatalf("Delete(file-a) error = %v", err)
+	}
+	reader, err := s.Retrieve(ctx, "file-b")
+	if err != nil {
+		t.Fatalf("Retrieve(file-b) error = %v", err)
+	}
+	got, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Retrieve(file-b) = %q, %v; want %q", got, err, content)
+	}
+
+	if err := s.Delete(ctx, "file-b"); err != nil {
+		t.Fatalf("Delete(file-b) error = %v", err)
+	}
+	if got := countBlobs(t, s); got != 1 {
+		t.Errorf("blobs on disk after deleting last reference = %d, want 1", got)
+	}
+	if err := s.Delete(ctx, "file-b"); err == nil {
+		t.Error("Delete(file-b) twice error = nil, want not found")
+	}
+}
+
+func TestContentAddressedStorage_DuplicateFileID(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	if _, err := s.Store(ctx, "file-a", strings.NewReader("one")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, err := s.Store(ctx, "file-a", strings.NewReader("two")); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Store() duplicate error = %v, want already exists", err)
+	}
+}
+
+func TestContentAddressedStorage_RetrieveDetectsCorruption(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	blobPath, err := s.Store(ctx, "file-a", strings.NewReader("original"))
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if err := os.WriteFile(blobPath, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := s.Retrieve(ctx, "file-a")
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	defer reader.Close()
+	if _, err := io.ReadAll(reader); !errors.Is(err, file.ErrIntegrityCheckFailed) {
+		t.Errorf("reading tampered blob error = %v, want ErrIntegrityCheckFailed", err)
+	}
+}
+
+// unavailableBlobs fails every lookup, as when the database is down
+type unavailableBlobs struct {
+	metadataService.BlobRepository
+}
+
+var errDatabaseDown = errors.New("database is locked")
+
+func (unavailableBlobs) GetBlobHash(ctx context.Context, fileID string) (string, error) {
+	return "", errDatabaseDown
+}
+
+func (unavailableBlobs) RemoveBlobReference(ctx context.Context, fileID string) (string, int64, error) {
+	return "", 0, errDatabaseDown
+}
+
+func TestContentAddressedStorage_LookupFailureIsNotMissingFile(t *testing.T) {
+	s := newTestStorage(t)
+	s.blobs = unavailableBlobs{s.blobs}
+	ctx := context.Background()
+
+	_, err := s.Store(ctx, "file-a", strings.NewReader("content"))
+	checkLookupFailure(t, "Store", err)
+	_, err = s.Retrieve(ctx, "file-a")
+	checkLookupFailure(t, "Retrieve", err)
+	_, err = s.RetrieveRange(ctx, "file-a", 0, 1)
+	checkLookupFailure(t, "RetrieveRange", err)
+	_, err = s.Stat(ctx, "file-a")
+	checkLookupFailure(t, "Stat", err)
+	checkLookupFailure(t, "Delete", s.Delete(ctx, "file-a"))
+}
+
+func checkLookupFailure(t *testing.T, op string, err error) {
+	t.Helper()
+	if !errors.Is(err, errDatabaseDown) || errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("%s() error = %v, want the lookup failure", op, err)
+	}
+}
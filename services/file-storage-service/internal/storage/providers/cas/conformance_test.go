@@ -0,0 +1,13 @@
+package cas
+
+import (
+	"testing"
+
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/storagetest"
+)
+
+func TestContentAddressedStorage_Conformance(t *testing.T) {
+	storagetest.TestProvider(t, func(t *testing.T) storagetest.Provider {
+		return newTestStorage(t)
+	})
+}
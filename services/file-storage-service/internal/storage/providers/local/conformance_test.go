@@ -0,0 +1,20 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/storagetest"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+func TestLocalFileSystem_Conformance(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	storagetest.TestProvider(t, func(t *testing.T) storagetest.Provider {
+		fs, err := NewLocalFileSystem(t.TempDir(), storagetest.NewMetadataService(t), &testLogger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
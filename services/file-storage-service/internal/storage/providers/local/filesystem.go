@@ -1,56 +1,271 @@
 package local
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
-	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
 	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/token"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 )
 
 type LocalFileSystem struct {
-	basePath        string
-	breaker         *circuit.CircuitBreaker
+	basePath string
+	// root confines all file access to basePath: paths are resolved relative
+	// to it, and symlinks leading out of it are refused
+	root            *os.Root
+	layout          Layout
+	breakers        *circuit.Group
 	metadataService metadataService.MetadataService
 	logger          *logger.Logger
+	// verified remembers files whose checksum was checked, keyed by file ID,
+	// so range reads don't hash the whole file every time
+	verified sync.Map
 }
 
-func NewLocalFileSystem(basePath string, metadataService metadataService.MetadataService, logger *logger.Logger) *LocalFileSystem {
+// verifiedFile identifies the on-disk state a checksum was verified against
+type verifiedFile struct {
+	checksum string
+	size     int64
+	modTime  time.Time
+}
+
+// NewLocalFileSystem creates a local provider storing files under basePath,
+// which is created if missing
+func NewLocalFileSystem(basePath string, metadataService metadataService.MetadataService, logger *logger.Logger) (*LocalFileSystem, error) {
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base path: %w", err)
+	}
+	root, err := os.OpenRoot(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open base path: %w", err)
+	}
 	return &LocalFileSystem{
 		basePath:        basePath,
-		breaker:         circuit.NewCircuitBreaker(3, 10*time.Second),
+		root:            root,
+		breakers:        circuit.NewGroup("local:"+basePath, circuit.Config{Settings: circuit.Settings{IsFailure: isBreakerFailure}}),
 		metadataService: metadataService,
 		logger:          logger,
+	}, nil
+}
+
+// SetCircuitBreakers replaces the default circuit breakers, which keep one
+// breaker per operation. Missing or existing files are not counted as
+// failures unless cfg classifies errors itself.
+func (fs *LocalFileSystem) SetCircuitBreakers(cfg circuit.Config) {
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = isBreakerFailure
 	}
+	fs.breakers = circuit.NewGroup("local:"+fs.basePath, cfg)
 }
 
-func (fs *LocalFileSystem) storeFile(storagePath string, content io.Reader) error {
-	var err error
+// isBreakerFailure leaves out errors about the requested file itself, which
+// say nothing about the health of the filesystem
+func isBreakerFailure(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrExist) || errors.Is(err, file.ErrFileNotFound) ||
+		errors.Is(err, file.ErrFileAlreadyExists) {
+		return false
+	}
+	return circuit.DefaultIsFailure(err)
+}
 
-	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+// NewShardedLocalFileSystem creates a local provider that fans files out into
+// subdirectories according to layout. Files still stored flat, e.g. before
+// MigrateLayout has run, remain readable.
+func NewShardedLocalFileSystem(basePath string, layout Layout, metadataService metadataService.MetadataService, logger *logger.Logger) (*LocalFileSystem, error) {
+	if err := layout.Validate(); err != nil {
+		return nil, err
 	}
+	fs, err := NewLocalFileSystem(basePath, metadataService, logger)
+	if err != nil {
+		return nil, err
+	}
+	fs.layout = layout
+	return fs, nil
+}
+
+// layoutPath returns where fileID is written under the configured layout,
+// relative to the root
+func (fs *LocalFileSystem) layoutPath(fileID string) string {
+	return fs.layout.relPath(fileID)
+}
 
-	f, err := os.Create(storagePath)
+// absPath turns a path relative to the root into the path reported to callers
+func (fs *LocalFileSystem) absPath(name string) string {
+	return filepath.Join(fs.basePath, name)
+}
+
+// resolvePath returns the path fileID is currently stored at, relative to the
+// root, and whether it exists
+func (fs *LocalFileSystem) resolvePath(fileID string) (string, bool) {
+	storagePath, info := fs.locate(fileID)
+	return storagePath, info != nil
+}
+
+// locate returns the path fileID is currently stored at and its file info,
+// which is nil if the file does not exist. Sharded providers fall back to the
+// flat path for files not yet migrated, and check the layout path once more
+// in case a migration moved the file meanwhile.
+func (fs *LocalFileSystem) locate(fileID string) (string, os.FileInfo) {
+	storagePath := fs.layoutPath(fileID)
+	if info := fs.statFile(storagePath); info != nil {
+		return storagePath, info
+	}
+	if fs.layout.IsFlat() {
+		return storagePath, nil
+	}
+	if info := fs.statFile(fileID); info != nil {
+		return fileID, info
+	}
+	return storagePath, fs.statFile(storagePath)
+}
+
+// statFile returns the info of the regular file at name, or nil. A symlink is
+// not a stored file, even one pointing into the root.
+func (fs *LocalFileSystem) statFile(name string) os.FileInfo {
+	info, err := fs.root.Lstat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return info
+}
+
+// tempFilePrefix marks in-progress writes. Files carrying it are never
+// reported as stored and are removed by SweepTempFiles.
+const tempFilePrefix = ".tmp-"
+
+// quarantineDir holds the files moved aside by Quarantine. It is never listed,
+// and no file ID may start with it.
+const quarantineDir = ".quarantine"
+
+// storeFile streams content to storagePath and returns its SHA-256 checksum.
+// The checksum is computed as the bytes are written, so memory use does not
+// depend on the file size. Content goes to a temp file in the same directory,
+// which is synced and then linked into place, so storagePath either holds the
+// complete file or does not exist, even after a crash. Unlike a rename, the
+// link never replaces a file stored meanwhile by a concurrent Store.
+func (fs *LocalFileSystem) storeFile(storagePath string, content io.Reader) (string, error) {
+	dir := filepath.Dir(storagePath)
+	if err := fs.root.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, tmpPath, err := createTemp(fs.root, dir, tempFilePrefix+filepath.Base(storagePath)+"-")
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	var committed bool
+	defer func() {
+		if !committed {
+			f.Close()
+			if err := fs.root.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+				fs.logger.Error().Err(err).Str("path", tmpPath).Msg("Failed to remove temp file")
+			}
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), content); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := fs.root.Link(tmpPath, storagePath); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, filepath.Base(storagePath))
+		}
+		return "", fmt.Errorf("failed to link file: %w", err)
+	}
+	committed = true
+	if err := fs.root.Remove(tmpPath); err != nil {
+		fs.logger.Error().Err(err).Str("path", tmpPath).Msg("Failed to remove temp file")
 	}
-	defer f.Close()
 
-	if _, err := io.Copy(f, content); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	// Persist the rename itself
+	if err := syncDir(fs.root, dir); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// createTemp creates a new file in dir, named prefix followed by a random
+// suffix, and returns it with its path. os.Root has no CreateTemp.
+func createTemp(root *os.Root, dir, prefix string) (*os.File, string, error) {
+	for range 100 {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return f, name, nil
+	}
+	return nil, "", fmt.Errorf("no unused temp file name in %s: %w", dir, os.ErrExist)
+}
+
+func syncDir(root *os.Root, dir string) error {
+	d, err := root.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
 	}
 	return nil
 }
 
+// SweepTempFiles removes temp files left behind by writes that never
+// completed, e.g. because the process crashed. It must only run while no
+// Store is in progress, typically at startup, and returns the removed paths.
+func (fs *LocalFileSystem) SweepTempFiles(ctx context.Context) ([]string, error) {
+	var removed []string
+	err := iofs.WalkDir(fs.root.FS(), ".", func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to access path %s: %w", path, err)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() || !isTempFile(d.Name()) {
+			return nil
+		}
+		name := filepath.FromSlash(path)
+		if err := fs.root.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove temp file %s: %w", path, err)
+		}
+		removed = append(removed, fs.absPath(name))
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to sweep temp files: %w", err)
+	}
+	return removed, nil
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
+
 func (fs *LocalFileSystem) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
 	if err := fs.validateFileID(fileID); err != nil {
 		return "", err
@@ -60,50 +275,39 @@ func (fs *LocalFileSystem) Store(ctx context.Context, fileID string, content io.
 	if err != nil {
 		return "", err
 	}
-	storagePath := filepath.Join(fs.basePath, fileID)
-	var success bool
+	storagePath := fs.layoutPath(fileID)
+	var success, written bool
 	defer func() {
 		if !success {
 			if rbErr := fs.metadataService.RollbackTx(txCtx); rbErr != nil {
 				fs.logger.Error().Err(rbErr).Msg("Failed to rollback transaction")
 			}
-			// Clean up the stored file if it exists
-			if fs.fileExists(storagePath) {
-				if cleanupErr := os.Remove(storagePath); cleanupErr != nil {
+			// Clean up the file written by this call, never a pre-existing one
+			if written {
+				if cleanupErr := fs.root.Remove(storagePath); cleanupErr != nil {
 					fs.logger.Error().Err(cleanupErr).Msg("Failed to cleanup file after transaction failure")
 				}
 			}
 		}
 	}()
 
-	// Create a TeeReader to calculate checksum while writing
-	var checksumBuf bytes.Buffer
-	teeReader := io.TeeReader(content, &checksumBuf)
-
-	if fs.fileExists(storagePath) {
-		return "", fmt.Errorf("file already exists: %s", fileID)
+	if _, exists := fs.resolvePath(fileID); exists {
+		return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, fileID)
 	}
 
-	err = fs.breaker.Execute(txCtx, func() error {
-		return fs.storeFile(storagePath, teeReader)
+	var checksum string
+	err = fs.breakers.Execute(txCtx, "store", func() error {
+		var err error
+		checksum, err = fs.storeFile(storagePath, content)
+		return err
 	})
 	if err != nil {
 		return "", err
 	}
-	// Calculate and store checksum
-	checksum, err := calculateChecksum(&checksumBuf)
-	if err != nil {
-		return "", err
-	}
+	written = true
 
-	metadata := &domain.FileMetadataRecord{
-		ID:          fileID,
-		Checksum:    checksum,
-		StoragePath: storagePath,
-	}
-
-	if err := fs.metadataService.UpdateFileMetadata(txCtx, fileID, metadata); err != nil {
-		return "", fmt.Errorf("failed to update metadata: %w", err)
+	if err := fs.recordChecksum(txCtx, fileID, fs.absPath(storagePath), checksum); err != nil {
+		return "", err
 	}
 
 	if err := fs.metadataService.CommitTx(txCtx); err != nil {
@@ -111,11 +315,26 @@ func (fs *LocalFileSystem) Store(ctx context.Context, fileID string, content io.
 		return "", fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	success = true
-	return storagePath, nil
+	return fs.absPath(storagePath), nil
+}
+
+// recordChecksum stores the checksum and path of a stored file on its
+// metadata record, leaving the record's other fields as they are
+func (fs *LocalFileSystem) recordChecksum(ctx context.Context, fileID, storagePath, checksum string) error {
+	metadata, err := fs.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.Checksum = checksum
+	metadata.StoragePath = storagePath
+	if err := fs.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
 }
 
 func (fs *LocalFileSystem) retrieveFile(storagePath string) (*os.File, error) {
-	file, err := os.Open(storagePath)
+	file, err := fs.root.Open(storagePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
@@ -127,19 +346,18 @@ func (fs *LocalFileSystem) Retrieve(ctx context.Context, fileID string) (io.Read
 		return nil, err
 	}
 
-	storagePath := filepath.Join(fs.basePath, fileID)
-
-	if !fs.fileExists(storagePath) {
-		return nil, fmt.Errorf("file not found: %s", fileID)
+	storagePath, exists := fs.resolvePath(fileID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
 	}
 
 	// Verify file integrity before returning
 	if err := fs.verifyFileIntegrity(ctx, fileID, storagePath); err != nil {
-		return nil, fmt.Errorf("file integrity check failed: %w", err)
+		return nil, err
 	}
 
 	var file *os.File
-	err := fs.breaker.Execute(ctx, func() error {
+	err := fs.breakers.Execute(ctx, "retrieve", func() error {
 		var err error
 		file, err = fs.retrieveFile(storagePath)
 		return err
@@ -150,10 +368,121 @@ func (fs *LocalFileSystem) Retrieve(ctx context.Context, fileID string) (io.Read
 	return file, nil
 }
 
+// RetrieveRange returns length bytes of the file starting at offset, or the
+// rest of the file when length is negative. The file's checksum is verified
+// on the first read and again only once the file changes on disk.
+func (fs *LocalFileSystem) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	if err := fs.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", file.ErrInvalidRange, offset)
+	}
+
+	storagePath, exists := fs.resolvePath(fileID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+
+	var f *os.File
+	err := fs.breakers.Execute(ctx, "retrieve", func() error {
+		var err error
+		f, err = fs.retrieveFile(storagePath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if offset > info.Size() {
+		f.Close()
+		return nil, fmt.Errorf("%w: offset %d beyond file size %d", file.ErrInvalidRange, offset, info.Size())
+	}
+
+	if err := fs.verifyOnce(ctx, fileID, f, info); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek file: %w", err)
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &rangeReader{Reader: io.LimitReader(f, length), file: f}, nil
+}
+
+// verifyOnce checks the file against its recorded checksum unless it was
+// already verified in its current state
+func (fs *LocalFileSystem) verifyOnce(ctx context.Context, fileID string, f *os.File, info os.FileInfo) error {
+	metadata, err := fs.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+
+	current := verifiedFile{checksum: metadata.Checksum, size: info.Size(), modTime: info.ModTime()}
+	if cached, ok := fs.verified.Load(fileID); ok && cached.(verifiedFile) == current {
+		return nil
+	}
+
+	checksum, err := calculateChecksum(f)
+	if err != nil {
+		return err
+	}
+	if checksum != metadata.Checksum {
+		fs.verified.Delete(fileID)
+		return fmt.Errorf("%w: checksums do not match", file.ErrIntegrityCheckFailed)
+	}
+	fs.verified.Store(fileID, current)
+	return nil
+}
+
+// rangeReader limits reads to a range while closing the underlying file
+type rangeReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *rangeReader) Close() error {
+	return r.file.Close()
+}
+
+// Stat reports the file's size and modification time on disk along with the
+// checksum recorded when it was stored
+func (fs *LocalFileSystem) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	if err := fs.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+
+	_, info := fs.locate(fileID)
+	if info == nil {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+
+	metadata, err := fs.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+	return &file.ObjectInfo{
+		FileID:       fileID,
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		Checksum:     metadata.Checksum,
+		StorageClass: file.StorageClassStandard,
+	}, nil
+}
+
 func (fs *LocalFileSystem) deleteFile(storagePath string) error {
-	if err := os.Remove(storagePath); err != nil {
+	if err := fs.root.Remove(storagePath); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("file not found: %w", err)
+			return fmt.Errorf("%w: %w", file.ErrFileNotFound, err)
 		}
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
@@ -165,67 +494,77 @@ func (fs *LocalFileSystem) Delete(ctx context.Context, fileID string) error {
 		return err
 	}
 
-	storagePath := filepath.Join(fs.basePath, fileID)
-	if !fs.fileExists(storagePath) {
-		return fmt.Errorf("file not found: %s", fileID)
+	storagePath, exists := fs.resolvePath(fileID)
+	if !exists {
+		return fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
 	}
 
-	return fs.breaker.Execute(ctx, func() error {
+	fs.verified.Delete(fileID)
+	return fs.breakers.Execute(ctx, "delete", func() error {
 		return fs.deleteFile(storagePath)
 	})
 }
 
-func (fs *LocalFileSystem) listFiles(basePath string) ([]string, error) {
-	var files []string
-	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("failed to access path %s: %w", path, err)
+// Quarantine moves a corrupt file under the quarantine directory, out of
+// reach of Retrieve and List, and returns its new path
+func (fs *LocalFileSystem) Quarantine(ctx context.Context, fileID string) (string, error) {
+	if err := fs.validateFileID(fileID); err != nil {
+		return "", err
+	}
+
+	storagePath, exists := fs.resolvePath(fileID)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+
+	target := filepath.Join(quarantineDir, fileID)
+	fs.verified.Delete(fileID)
+	err := fs.breakers.Execute(ctx, "quarantine", func() error {
+		if err := fs.root.MkdirAll(quarantineDir, 0755); err != nil {
+			return fmt.Errorf("failed to create quarantine directory: %w", err)
 		}
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(basePath, path)
-			if err != nil {
-				return fmt.Errorf("failed to get relative path: %w", err)
-			}
-			files = append(files, relPath)
+		if err := fs.root.Rename(storagePath, target); err != nil {
+			return fmt.Errorf("failed to quarantine file: %w", err)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list files: %w", err)
+		return "", err
 	}
-	return files, nil
+	return fs.absPath(target), nil
 }
 
-func (fs *LocalFileSystem) List(ctx context.Context) ([]string, error) {
-	var files []string
-	err := fs.breaker.Execute(ctx, func() error {
-		var err error
-		files, err = fs.listFiles(fs.basePath)
-		return err
+// List returns a page of stored file IDs in order of ListKey. A sharded
+// layout reads only the shard directories a page spans; a flat one reads the
+// whole base path for every page, holding on to only the IDs that belong on
+// the page.
+func (fs *LocalFileSystem) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	collector := newPageCollector(opts, fs.ListKey)
+	err := fs.breakers.Execute(ctx, "list", func() error {
+		if err := fs.walk(ctx, ".", collector); err != nil && !errors.Is(err, errPageFull) {
+			return err
+		}
+		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to list files: %w", err)
 	}
-	return files, nil
+	return collector.page(), nil
 }
 
-// Add validation helper methods
+// validateFileID accepts only IDs shaped like the generated ones. Their
+// alphabet has no separators or dots, so an ID can neither leave its
+// directory nor collide with temp files or the quarantine directory.
 func (fs *LocalFileSystem) validateFileID(fileID string) error {
 	if fileID == "" {
-		return fmt.Errorf("file ID cannot be empty")
+		return fmt.Errorf("%w: empty", file.ErrInvalidFileID)
 	}
-	if filepath.Clean(fileID) != fileID {
-		return fmt.Errorf("invalid file ID path")
+	if !token.IsSecureFileID(fileID) {
+		return fmt.Errorf("%w: not a generated file ID", file.ErrInvalidFileID)
 	}
 	return nil
 }
 
-// Add file existence helper
-func (fs *LocalFileSystem) fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return !os.IsNotExist(err)
-}
-
 // Add checksum calculation helper
 func calculateChecksum(file io.Reader) (string, error) {
 	hash := sha256.New()
@@ -242,19 +581,19 @@ func (fs *LocalFileSystem) verifyFileIntegrity(ctx context.Context, fileID, stor
 		return fmt.Errorf("failed to retrieve checksum: %w", err)
 	}
 
-	file, err := os.Open(storagePath)
+	f, err := fs.root.Open(storagePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file for integrity check: %w", err)
 	}
-	defer file.Close()
+	defer f.Close()
 
-	currentChecksum, err := calculateChecksum(file)
+	currentChecksum, err := calculateChecksum(f)
 	if err != nil {
 		return err
 	}
 
 	if currentChecksum != metadata.Checksum {
-		return fmt.Errorf("file integrity check failed: checksums do not match")
+		return fmt.Errorf("%w: checksums do not match", file.ErrIntegrityCheckFailed)
 	}
 
 	return nil
@@ -0,0 +1,185 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/storagetest"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/token"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// victimContent is the content of the file outside the base paths
+const victimContent = "secret"
+
+// Valid file IDs whose paths are planted with symlinks leading out of the base
+// path by plantEscapes
+var (
+	symlinkFileID  = storagetest.FileID("symlink")
+	symlinkShardID = storagetest.FileID("shard")
+)
+
+// plantEscapes makes symlinks under the provider's base path point at
+// outside: a directory, the file of symlinkFileID, the quarantine directory
+// and, in sharded layouts, the shard directory of symlinkShardID
+func plantEscapes(t testing.TB, lfs *LocalFileSystem, outside string) {
+	t.Helper()
+	links := map[string]string{
+		"escape":                      outside,
+		quarantineDir:                 outside,
+		lfs.layoutPath(symlinkFileID): filepath.Join(outside, "victim"),
+	}
+	if !lfs.layout.IsFlat() {
+		links[lfs.layout.shardDir(symlinkShardID)] = outside
+	}
+	for name, target := range links {
+		path := filepath.Join(lfs.basePath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// snapshot returns the content of every file under dir, skipping the
+// directories in skip
+func snapshot(t testing.TB, dir string, skip ...string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, s := range skip {
+			if path == s {
+				return filepath.SkipDir
+			}
+		}
+		if d.IsDir() {
+			files[path] = "dir"
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[path] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// exercise runs every operation taking a file ID and returns their errors.
+// Reads must never return the content of the file outside the base path.
+func exercise(t *testing.T, lfs *LocalFileSystem, fileID string) map[string]error {
+	ctx := context.Background()
+	errs := make(map[string]error)
+	_, errs["Store"] = lfs.Store(ctx, fileID, strings.NewReader("fuzz"))
+	for op, retrieve := range map[string]func() (io.ReadCloser, error){
+		"Retrieve":      func() (io.ReadCloser, error) { return lfs.Retrieve(ctx, fileID) },
+		"RetrieveRange": func() (io.ReadCloser, error) { return lfs.RetrieveRange(ctx, fileID, 0, -1) },
+	} {
+		reader, err := retrieve()
+		errs[op] = err
+		if err != nil {
+			continue
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(content) == victimContent {
+			t.Errorf("%s(%q) read the file outside the base path", op, fileID)
+		}
+	}
+	_, errs["Stat"] = lfs.Stat(ctx, fileID)
+	_, errs["Quarantine"] = lfs.Quarantine(ctx, fileID)
+	errs["Delete"] = lfs.Delete(ctx, fileID)
+	return errs
+}
+
+// FuzzLocalFileSystem_StaysInRoot feeds arbitrary file IDs to every operation
+// of providers whose base paths hold symlinks leading out of them, and checks
+// that IDs not shaped like generated ones are rejected and that nothing
+// outside the base paths is created, changed or removed
+func FuzzLocalFileSystem_StaysInRoot(f *testing.F) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	dir := f.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		f.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "victim"), []byte(victimContent), 0644); err != nil {
+		f.Fatal(err)
+	}
+
+	var providers []*LocalFileSystem
+	var bases []string
+	for i, layout := range []Layout{{}, {Depth: 1, Width: 2}} {
+		base := filepath.Join(dir, fmt.Sprintf("base-%d", i))
+		lfs, err := NewShardedLocalFileSystem(base, layout, nil, &testLogger)
+		if err != nil {
+			f.Fatal(err)
+		}
+		plantEscapes(f, lfs, outside)
+		providers = append(providers, lfs)
+		bases = append(bases, base)
+	}
+	want := snapshot(f, dir, bases...)
+
+	generated, err := token.GenerateSecureFileID()
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range []string{
+		generated, symlinkFileID, symlinkShardID, storagetest.FileID("file-a"),
+		"", ".", "..", "../outside/victim", "escape/victim", "/etc/passwd", "..\\outside\\victim",
+		".quarantine", tempFilePrefix + generated, "file-a\x00", strings.Repeat(".", token.FileIDLength),
+		"../" + generated[3:], "escape/" + generated[7:], strings.Repeat("/", token.FileIDLength),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, fileID string) {
+		valid := token.IsSecureFileID(fileID)
+		for _, lfs := range providers {
+			// Mocks report to the test they were made for, so every input
+			// gets its own
+			lfs.metadataService = storagetest.NewMetadataService(t)
+			for op, err := range exercise(t, lfs, fileID) {
+				if !valid && !errors.Is(err, file.ErrInvalidFileID) {
+					t.Errorf("%s(%q) error = %v, want ErrInvalidFileID", op, fileID, err)
+				}
+			}
+		}
+		if got := snapshot(t, dir, bases...); !maps.Equal(got, want) {
+			t.Fatalf("files outside the base paths changed for %q: got %v, want %v", fileID, got, want)
+		}
+	})
+}
+
+func TestLocalFileSystem_AcceptsGeneratedFileIDs(t *testing.T) {
+	fs, _ := newTestFileSystem(t)
+	for range 100 {
+		fileID, err := token.GenerateSecureFileID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.validateFileID(fileID); err != nil {
+			t.Errorf("validateFileID(%q) error = %v", fileID, err)
+		}
+	}
+}
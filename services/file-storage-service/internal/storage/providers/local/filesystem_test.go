@@ -0,0 +1,432 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/storagetest"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// Test file IDs have the shape of generated ones, as the provider accepts
+// nothing else
+var (
+	fileA = storagetest.FileID("file-a")
+	fileB = storagetest.FileID("file-b")
+	fileC = storagetest.FileID("file-c")
+)
+
+// failingReader returns limit bytes of content and then fails, like a client
+// connection dropping partway through an upload
+type failingReader struct {
+	limit int
+	read  int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read >= r.limit {
+		return 0, errors.New("connection reset")
+	}
+	n := min(len(p), r.limit-r.read)
+	for i := range p[:n] {
+		p[i] = 'x'
+	}
+	r.read += n
+	return n, nil
+}
+
+func newTestFileSystem(t *testing.T) (*LocalFileSystem, *metadataService.MockMetadataService) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().BeginTx(gomock.Any()).DoAndReturn(func(ctx context.Context) (context.Context, error) {
+		return ctx, nil
+	}).AnyTimes()
+	fs, err := NewLocalFileSystem(t.TempDir(), mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, mockMetadata
+}
+
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestLocalFileSystem_StoreFailurePartwayLeavesNothing(t *testing.T) {
+	fs, mockMetadata := newTestFileSystem(t)
+	mockMetadata.EXPECT().RollbackTx(gomock.Any()).Return(nil)
+
+	_, err := fs.Store(context.Background(), fileA, &failingReader{limit: 100 * 1024})
+	if err == nil {
+		t.Fatal("Store() error = nil, want write failure")
+	}
+	if names := dirEntries(t, fs.basePath); len(names) != 0 {
+		t.Errorf("files left after failed write: %v", names)
+	}
+}
+
+func TestLocalFileSystem_StoreIsAtomic(t *testing.T) {
+	fs, mockMetadata := newTestFileSystem(t)
+	record := &domain.FileMetadataRecord{ID: fileA, ProcessingStatus: "PENDING"}
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), fileA).Return(record, nil)
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), fileA, record).Return(nil)
+	mockMetadata.EXPECT().CommitTx(gomock.Any()).Return(nil)
+
+	storagePath, err := fs.Store(context.Background(), fileA, strings.NewReader("id,name\n1,alice\n"))
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if record.StoragePath != storagePath || record.Checksum == "" || record.ProcessingStatus != "PENDING" {
+		t.Errorf("recorded metadata = %+v, want the path and checksum added to the existing record", record)
+	}
+	if got, err := os.ReadFile(storagePath); err != nil || string(got) != "id,name\n1,alice\n" {
+		t.Errorf("stored content = %q, %v", got, err)
+	}
+	if names := dirEntries(t, fs.basePath); len(names) != 1 || names[0] != fileA {
+		t.Errorf("directory entries = %v, want only file-a", names)
+	}
+}
+
+func TestLocalFileSystem_StoreDuplicateKeepsExistingFile(t *testing.T) {
+	fs, mockMetadata := newTestFileSystem(t)
+	mockMetadata.EXPECT().RollbackTx(gomock.Any()).Return(nil)
+
+	existing := filepath.Join(fs.basePath, fileA)
+	if err := os.WriteFile(existing, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Store(context.Background(), fileA, strings.NewReader("replacement")); err == nil {
+		t.Fatal("Store() error = nil, want already exists")
+	}
+	if got, err := os.ReadFile(existing); err != nil || string(got) != "original" {
+		t.Errorf("existing file = %q, %v; want it untouched", got, err)
+	}
+}
+
+func TestLocalFileSystem_SweepTempFiles(t *testing.T) {
+	fs, _ := newTestFileSystem(t)
+
+	// Simulate a crash after the temp file was written but before the rename
+	nested := filepath.Join(fs.basePath, "nested")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	leftovers := []string{
+		filepath.Join(fs.basePath, tempFilePrefix+"file-a-123"),
+		filepath.Join(nested, tempFilePrefix+"file-b-456"),
+	}
+	for _, path := range leftovers {
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	complete := filepath.Join(fs.basePath, fileC)
+	if err := os.WriteFile(complete, []byte("complete"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := fs.List(context.Background(), file.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page.FileIDs) != 1 || page.FileIDs[0] != fileC {
+		t.Errorf("List() = %v, want temp files hidden", page.FileIDs)
+	}
+
+	removed, err := fs.SweepTempFiles(context.Background())
+	if err != nil {
+		t.Fatalf("SweepTempFiles() error = %v", err)
+	}
+	if len(removed) != len(leftovers) {
+		t.Errorf("SweepTempFiles() removed %v, want %v", removed, leftovers)
+	}
+	for _, path := range leftovers {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("temp file %s still exists", path)
+		}
+	}
+	if _, err := os.Stat(complete); err != nil {
+		t.Errorf("complete file removed by sweep: %v", err)
+	}
+}
+
+func TestLocalFileSystem_RejectsReservedFileID(t *testing.T) {
+	fs, _ := newTestFileSystem(t)
+	if _, err := fs.Store(context.Background(), tempFilePrefix+"x", strings.NewReader("x")); err == nil {
+		t.Error("Store() with temp prefix error = nil, want invalid file ID")
+	}
+}
+
+func TestLocalFileSystem_Quarantine(t *testing.T) {
+	fs, _ := newTestFileSystem(t)
+	ctx := context.Background()
+	for _, fileID := range []string{fileA, fileB} {
+		if err := os.WriteFile(filepath.Join(fs.basePath, fileID), []byte(fileID), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := fs.Quarantine(ctx, fileA)
+	if err != nil {
+		t.Fatalf("Quarantine() error = %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != fileA {
+		t.Errorf("quarantined file = %q, %v", data, err)
+	}
+	if _, err := fs.Stat(ctx, fileA); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Stat() after quarantine error = %v, want ErrFileNotFound", err)
+	}
+	page, err := fs.List(ctx, file.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if !slices.Equal(page.FileIDs, []string{fileB}) {
+		t.Errorf("List() = %v, want only file-b", page.FileIDs)
+	}
+
+	if _, err := fs.Quarantine(ctx, fileA); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Quarantine() missing error = %v, want ErrFileNotFound", err)
+	}
+	if _, err := fs.Store(ctx, filepath.Join(quarantineDir, fileC), strings.NewReader("x")); err == nil {
+		t.Error("Store() into quarantine error = nil, want invalid file ID")
+	}
+}
+
+func TestLayout_FileIDRoundTrip(t *testing.T) {
+	layout := Layout{Depth: 2, Width: 2}
+	relPath := layout.relPath("file-a")
+	if dir := filepath.Dir(relPath); len(dir) != len("ab/cd") {
+		t.Errorf("relPath() = %q, want two shard levels of width 2", relPath)
+	}
+	if got := layout.fileIDFromRelPath(relPath); got != "file-a" {
+		t.Errorf("fileIDFromRelPath(%q) = %q, want file-a", relPath, got)
+	}
+	if got := layout.fileIDFromRelPath("file-b"); got != "file-b" {
+		t.Errorf("fileIDFromRelPath(flat) = %q, want file-b", got)
+	}
+	if err := (Layout{Depth: 5, Width: 2}).Validate(); err == nil {
+		t.Error("Validate() error = nil, want depth out of range")
+	}
+}
+
+func TestLocalFileSystem_MigrateLayout(t *testing.T) {
+	flat, mockMetadata := newTestFileSystem(t)
+	ctx := context.Background()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	layout := Layout{Depth: 2, Width: 2}
+	sharded, err := NewShardedLocalFileSystem(flat.basePath, layout, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewShardedLocalFileSystem() error = %v", err)
+	}
+
+	for _, fileID := range []string{fileA, fileB} {
+		if err := os.WriteFile(filepath.Join(flat.basePath, fileID), []byte(fileID), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flat files stay reachable through the sharded provider before migration
+	if err := sharded.Delete(ctx, fileB); err != nil {
+		t.Fatalf("Delete() before migration error = %v", err)
+	}
+
+	// file-a has a record pointing at its flat path
+	record := &domain.FileMetadataRecord{
+		ID:          fileA,
+		Metadata:    &sharedv1.FileMetadata{FileId: fileA},
+		StoragePath: filepath.Join(flat.basePath, fileA),
+	}
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), fileA).Return(record, nil)
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), fileA, record).Return(nil)
+
+	dry, err := MigrateLayout(ctx, flat.basePath, layout, mockMetadata, true, &testLogger)
+	if err != nil || dry.Moved != 1 {
+		t.Fatalf("MigrateLayout(dryRun) = %+v, %v; want 1 file to move", dry, err)
+	}
+	if _, err := os.Stat(filepath.Join(flat.basePath, fileA)); err != nil {
+		t.Errorf("dry run moved file-a: %v", err)
+	}
+
+	result, err := MigrateLayout(ctx, flat.basePath, layout, mockMetadata, false, &testLogger)
+	if err != nil || result.Moved != 1 || len(result.Failed) != 0 {
+		t.Fatalf("MigrateLayout() = %+v, %v; want 1 file moved", result, err)
+	}
+	if want := filepath.Join(flat.basePath, layout.relPath(fileA)); record.StoragePath != want {
+		t.Errorf("recorded storage path = %q, want %q", record.StoragePath, want)
+	}
+	storagePath, exists := sharded.resolvePath(fileA)
+	if !exists || storagePath != layout.relPath(fileA) {
+		t.Errorf("resolvePath(file-a) = %q, %v; want sharded path", storagePath, exists)
+	}
+
+	page, err := sharded.List(ctx, file.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page.FileIDs) != 1 || page.FileIDs[0] != fileA {
+		t.Errorf("List() = %v, want [file-a]", page.FileIDs)
+	}
+
+	// Rerunning finds nothing left to move
+	if again, err := MigrateLayout(ctx, flat.basePath, layout, mockMetadata, false, &testLogger); err != nil || again.Moved != 0 {
+		t.Errorf("second MigrateLayout() = %+v, %v; want no-op", again, err)
+	}
+}
+
+func TestLocalFileSystem_RetrieveRange(t *testing.T) {
+	fs, mockMetadata := newTestFileSystem(t)
+	ctx := context.Background()
+	content := "id,name\n1,alice\n2,bob\n"
+	storagePath := filepath.Join(fs.basePath, fileA)
+	if err := os.WriteFile(storagePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := calculateChecksum(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), fileA).
+		Return(&domain.FileMetadataRecord{ID: fileA, Checksum: checksum}, nil).AnyTimes()
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 8, "id,name\n"},
+		{8, 8, "1,alice\n"},
+		{16, -1, "2,bob\n"},
+		{16, 100, "2,bob\n"},
+		{int64(len(content)), -1, ""},
+	}
+	for _, tt := range tests {
+		reader, err := fs.RetrieveRange(ctx, fileA, tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("RetrieveRange(%d, %d) error = %v", tt.offset, tt.length, err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(got) != tt.want {
+			t.Errorf("RetrieveRange(%d, %d) = %q, %v; want %q", tt.offset, tt.length, got, err, tt.want)
+		}
+	}
+	if _, ok := fs.verified.Load(fileA); !ok {
+		t.Error("verified checksum not cached after range reads")
+	}
+
+	if _, err := fs.RetrieveRange(ctx, fileA, int64(len(content))+1, 1); err == nil {
+		t.Error("RetrieveRange() past end error = nil, want invalid range")
+	}
+
+	// Changing the file on disk invalidates the cached verification
+	if err := os.WriteFile(storagePath, []byte("id,name\n1,mallory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.RetrieveRange(ctx, fileA, 0, 8); !errors.Is(err, file.ErrIntegrityCheckFailed) {
+		t.Errorf("RetrieveRange() after tampering error = %v, want ErrIntegrityCheckFailed", err)
+	}
+}
+
+func TestLocalFileSystem_Stat(t *testing.T) {
+	fs, mockMetadata := newTestFileSystem(t)
+	ctx := context.Background()
+	content := "id,name\n1,alice\n"
+	if err := os.WriteFile(filepath.Join(fs.basePath, fileA), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), fileA).
+		Return(&domain.FileMetadataRecord{ID: fileA, Checksum: "abc"}, nil)
+
+	info, err := fs.Stat(ctx, fileA)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size != int64(len(content)) || info.Checksum != "abc" || info.ModTime.IsZero() || info.StorageClass != file.StorageClassStandard {
+		t.Errorf("Stat() = %+v", info)
+	}
+
+	if _, err := fs.Stat(ctx, fileB); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Stat() missing error = %v, want ErrFileNotFound", err)
+	}
+	// A directory is not a stored file
+	if err := os.Mkdir(filepath.Join(fs.basePath, fileC), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(ctx, fileC); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Stat() directory error = %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestLocalFileSystem_ListPages(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	for _, layout := range []Layout{{}, {Depth: 2, Width: 1}} {
+		fs, err := NewShardedLocalFileSystem(t.TempDir(), layout, nil, &testLogger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for i := 9; i >= 0; i-- {
+			for _, prefix := range []string{"a-", "b-"} {
+				fileID := storagetest.FileID(fmt.Sprintf("%s%d", prefix, i))
+				path := filepath.Join(fs.basePath, layout.relPath(fileID))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(fileID), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if prefix == "b-" {
+					want = append(want, fileID)
+				}
+			}
+		}
+		sort.Slice(want, func(i, j int) bool { return fs.ListKey(want[i]) < fs.ListKey(want[j]) })
+
+		var got []string
+		opts := file.ListOptions{Prefix: "b-", PageSize: 3}
+		for pages := 1; ; pages++ {
+			page, err := fs.List(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(page.FileIDs) > 3 {
+				t.Errorf("List() returned %d IDs, want at most 3", len(page.FileIDs))
+			}
+			got = append(got, page.FileIDs...)
+			if page.NextContinuationToken == "" {
+				if pages != 4 {
+					t.Errorf("listing took %d pages, want 4", pages)
+				}
+				break
+			}
+			opts.ContinuationToken = page.NextContinuationToken
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("layout %+v: List() pages = %v, want %v", layout, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package local
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/token"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	maxShardDepth = 4
+	maxShardWidth = 4
+)
+
+// Layout describes how files are fanned out into subdirectories. Shard
+// directory names are taken from the hex SHA-256 of the file ID, so a layout
+// with Depth 2 and Width 2 stores a file at "ab/cd/<fileID>". The zero value
+// is the flat layout, with every file directly under the base path.
+type Layout struct {
+	Depth int `mapstructure:"shard_depth"`
+	Width int `mapstructure:"shard_width"`
+}
+
+// Validate checks that the layout is within the supported fan-out
+func (l Layout) Validate() error {
+	if l.Depth < 0 || l.Depth > maxShardDepth {
+		return fmt.Errorf("shard depth must be between 0 and %d", maxShardDepth)
+	}
+	if l.Depth > 0 && (l.Width < 1 || l.Width > maxShardWidth) {
+		return fmt.Errorf("shard width must be between 1 and %d", maxShardWidth)
+	}
+	return nil
+}
+
+// IsFlat reports whether files are stored directly under the base path
+func (l Layout) IsFlat() bool {
+	return l.Depth == 0
+}
+
+// shardDir returns the relative shard directory for fileID
+func (l Layout) shardDir(fileID string) string {
+	if l.IsFlat() {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fileID))
+	digest := hex.EncodeToString(sum[:])
+	parts := make([]string, l.Depth)
+	for i := range parts {
+		parts[i] = digest[i*l.Width : (i+1)*l.Width]
+	}
+	return filepath.Join(parts...)
+}
+
+// relPath returns the path of fileID relative to the base path
+func (l Layout) relPath(fileID string) string {
+	return filepath.Join(l.shardDir(fileID), fileID)
+}
+
+// fileIDFromRelPath recovers the file ID from a path relative to the base
+// path. Paths that are not in this layout are treated as flat file IDs.
+func (l Layout) fileIDFromRelPath(relPath string) string {
+	if l.IsFlat() {
+		return relPath
+	}
+	parts := strings.Split(relPath, string(filepath.Separator))
+	if len(parts) <= l.Depth {
+		return relPath
+	}
+	fileID := filepath.Join(parts[l.Depth:]...)
+	if filepath.Join(parts[:l.Depth]...) != l.shardDir(fileID) {
+		return relPath
+	}
+	return fileID
+}
+
+// MigrationResult summarises a layout migration
+type MigrationResult struct {
+	Moved   int
+	Skipped int
+	Failed  []string
+}
+
+// MigrateLayout moves every file stored directly under basePath into its
+// location in layout. Each file is hard-linked into place before the flat
+// copy is removed, so it stays readable throughout: LocalFileSystem looks at
+// the layout path first and falls back to the flat path. The storage path of
+// each moved file's metadata record is updated before its flat copy is
+// removed, so a failed update is retried by the next run. With dryRun set,
+// files are only counted.
+func MigrateLayout(ctx context.Context, basePath string, layout Layout, metadataService metadataService.MetadataService, dryRun bool, logger *logger.Logger) (*MigrationResult, error) {
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+	result := &MigrationResult{}
+	if layout.IsFlat() {
+		return result, nil
+	}
+
+	root, err := os.OpenRoot(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open base path: %w", err)
+	}
+	defer root.Close()
+	entries, err := iofs.ReadDir(root.FS(), ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base path: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if !entry.Type().IsRegular() || !token.IsSecureFileID(entry.Name()) {
+			result.Skipped++
+			continue
+		}
+
+		fileID := entry.Name()
+		if dryRun {
+			result.Moved++
+			continue
+		}
+
+		recordPath := func() error {
+			return recordStoragePath(ctx, metadataService, fileID, filepath.Join(basePath, layout.relPath(fileID)))
+		}
+		if err := moveIntoLayout(root, fileID, layout, recordPath); err != nil {
+			logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to migrate file")
+			result.Failed = append(result.Failed, fileID)
+			continue
+		}
+		result.Moved++
+	}
+
+	logger.Info().
+		Int("moved", result.Moved).
+		Int("skipped", result.Skipped).
+		Int("failed", len(result.Failed)).
+		Bool("dryRun", dryRun).
+		Msg("Layout migration completed")
+	return result, nil
+}
+
+// moveIntoLayout calls recordPath once the file is in place, before the flat
+// copy is removed
+func moveIntoLayout(root *os.Root, fileID string, layout Layout, recordPath func() error) error {
+	newPath := layout.relPath(fileID)
+	dir := filepath.Dir(newPath)
+
+	if err := root.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := root.Link(fileID, newPath); err != nil {
+		if !os.IsExist(err) {
+			// Fall back to an atomic rename where hard links are unsupported
+			if err := root.Rename(fileID, newPath); err != nil {
+				return fmt.Errorf("failed to move file: %w", err)
+			}
+			if err := syncDir(root, dir); err != nil {
+				return err
+			}
+			return recordPath()
+		}
+		// A previous run linked the file but did not remove the flat copy
+	}
+	if err := syncDir(root, dir); err != nil {
+		return err
+	}
+	if err := recordPath(); err != nil {
+		return err
+	}
+	if err := root.Remove(fileID); err != nil {
+		return fmt.Errorf("failed to remove flat copy: %w", err)
+	}
+	return syncDir(root, ".")
+}
+
+// recordStoragePath points the metadata record of fileID at storagePath.
+// Files without a record are left to the reconciler.
+func recordStoragePath(ctx context.Context, metadataService metadataService.MetadataService, fileID, storagePath string) error {
+	metadata, err := metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if errors.Is(err, domain.ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.StoragePath = storagePath
+	if err := metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package local
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/token"
+)
+
+// listBatchSize is how many directory entries are read at a time
+const listBatchSize = 1024
+
+// errPageFull ends a walk once nothing left to visit can make it onto the page
+var errPageFull = errors.New("page full")
+
+// ListKey returns the key files are listed in lexical order of: the path of
+// fileID in the layout. Shard directories are named after a hash of the file
+// ID, so a sharded provider does not list in file ID order, but a page only
+// reads the shard directories it spans.
+func (fs *LocalFileSystem) ListKey(fileID string) string {
+	return fs.layout.relPath(fileID)
+}
+
+// walk adds the files stored under dir, relative to the root, to collector.
+// Subdirectories are visited in sorted order, skipping those entirely before
+// the continuation token and stopping at the first one entirely after a full
+// page. Files not named like a file ID, such as temp files, or not in their
+// place in the layout are skipped. Directories are read in batches, so memory
+// use does not grow with the number of files in them.
+func (fs *LocalFileSystem) walk(ctx context.Context, dir string, collector *pageCollector) error {
+	d, err := fs.root.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	var subdirs []string
+	for {
+		entries, err := d.ReadDir(listBatchSize)
+		for _, entry := range entries {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				if !(dir == "." && entry.Name() == quarantineDir) {
+					subdirs = append(subdirs, path)
+				}
+				continue
+			}
+			if !entry.Type().IsRegular() || !token.IsSecureFileID(entry.Name()) {
+				continue
+			}
+			// Files directly under the root are still stored flat
+			if dir == "." || fs.layout.fileIDFromRelPath(path) == entry.Name() {
+				collector.add(entry.Name())
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", dir, err)
+		}
+	}
+
+	slices.Sort(subdirs)
+	for _, subdir := range subdirs {
+		if collector.skips(subdir) {
+			continue
+		}
+		if collector.full(subdir) {
+			return errPageFull
+		}
+		if err := fs.walk(ctx, subdir, collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageCollector keeps the files with the smallest list keys that belong on a
+// page, plus one more to tell whether another page follows
+type pageCollector struct {
+	key    func(fileID string) string
+	prefix string
+	token  string
+	limit  int
+	files  listHeap
+	seen   map[string]bool
+}
+
+func newPageCollector(opts file.ListOptions, key func(fileID string) string) *pageCollector {
+	c := &pageCollector{
+		key:    key,
+		prefix: opts.Prefix,
+		limit:  opts.Limit(),
+		seen:   make(map[string]bool),
+	}
+	if opts.ContinuationToken != "" {
+		c.token = key(opts.ContinuationToken)
+	}
+	return c
+}
+
+func (c *pageCollector) add(fileID string) {
+	// A file being migrated between layouts is seen twice
+	if !strings.HasPrefix(fileID, c.prefix) || c.seen[fileID] {
+		return
+	}
+	f := listedFile{id: fileID, key: c.key(fileID)}
+	if f.key <= c.token {
+		return
+	}
+	if len(c.files) <= c.limit {
+		heap.Push(&c.files, f)
+		c.seen[fileID] = true
+		return
+	}
+	if f.key < c.files[0].key {
+		delete(c.seen, c.files[0].id)
+		c.files[0] = f
+		c.seen[fileID] = true
+		heap.Fix(&c.files, 0)
+	}
+}
+
+// skips reports whether every key under dir sorts before the continuation
+// token
+func (c *pageCollector) skips(dir string) bool {
+	return c.token > dir && !strings.HasPrefix(c.token, dir+string(filepath.Separator))
+}
+
+// full reports whether the page is full and every key under dir sorts after
+// it
+func (c *pageCollector) full(dir string) bool {
+	return len(c.files) > c.limit && c.files[0].key < dir
+}
+
+func (c *pageCollector) page() *file.ListPage {
+	files := []listedFile(c.files)
+	sort.Slice(files, func(i, j int) bool { return files[i].key < files[j].key })
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.id
+	}
+	if len(ids) <= c.limit {
+		return &file.ListPage{FileIDs: ids}
+	}
+	return &file.ListPage{
+		FileIDs:               ids[:c.limit],
+		NextContinuationToken: ids[c.limit-1],
+	}
+}
+
+type listedFile struct {
+	id  string
+	key string
+}
+
+// listHeap is a max-heap of files by list key
+type listHeap []listedFile
+
+func (h listHeap) Len() int           { return len(h) }
+func (h listHeap) Less(i, j int) bool { return h[i].key > h[j].key }
+func (h listHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *listHeap) Push(x any)        { *h = append(*h, x.(listedFile)) }
+func (h *listHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
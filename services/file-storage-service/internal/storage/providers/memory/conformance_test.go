@@ -0,0 +1,16 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/storagetest"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+func TestMemoryStorage_Conformance(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	storagetest.TestProvider(t, func(t *testing.T) storagetest.Provider {
+		return NewMemoryStorage(storagetest.NewMetadataService(t), &testLogger)
+	})
+}
@@ -0,0 +1,200 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/token"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// storagePrefix marks the storage paths of files kept in memory
+const storagePrefix = "memory://"
+
+// object is a stored file. Its data is never modified once stored, so readers
+// share it without copying.
+type object struct {
+	data     []byte
+	checksum string
+	modTime  time.Time
+}
+
+// MemoryStorage keeps files in memory, for tests and for running the service
+// without a disk. Everything stored is lost when the process exits.
+type MemoryStorage struct {
+	mu              sync.RWMutex
+	objects         map[string]*object
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+}
+
+func NewMemoryStorage(metadataService metadataService.MetadataService, logger *logger.Logger) *MemoryStorage {
+	return &MemoryStorage{
+		objects:         make(map[string]*object),
+		metadataService: metadataService,
+		logger:          logger,
+	}
+}
+
+// Store reads the whole content before adding the file, so a failed read
+// leaves nothing behind. The checksum is recorded on the file's metadata.
+func (s *MemoryStorage) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return "", err
+	}
+	if _, err := s.object(fileID); err == nil {
+		return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, fileID)
+	}
+
+	hash := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(content, hash))
+	if err != nil {
+		return "", fmt.Errorf("failed to read content: %w", err)
+	}
+	stored := &object{
+		data:     data,
+		checksum: fmt.Sprintf("%x", hash.Sum(nil)),
+		modTime:  time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	if _, exists := s.objects[fileID]; exists {
+		s.mu.Unlock()
+		return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, fileID)
+	}
+	s.objects[fileID] = stored
+	s.mu.Unlock()
+
+	storagePath := storagePrefix + fileID
+	if err := s.recordChecksum(ctx, fileID, storagePath, stored.checksum); err != nil {
+		s.mu.Lock()
+		if s.objects[fileID] == stored {
+			delete(s.objects, fileID)
+		}
+		s.mu.Unlock()
+		return "", err
+	}
+	return storagePath, nil
+}
+
+func (s *MemoryStorage) recordChecksum(ctx context.Context, fileID, storagePath, checksum string) error {
+	metadata, err := s.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.Checksum = checksum
+	metadata.StoragePath = storagePath
+	if err := s.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+	stored, err := s.object(fileID)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(stored.data)), nil
+}
+
+func (s *MemoryStorage) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+	stored, err := s.object(fileID)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(stored.data))
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("%w: offset %d of %d bytes", file.ErrInvalidRange, offset, size)
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(stored.data[offset:end])), nil
+}
+
+func (s *MemoryStorage) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+	stored, err := s.object(fileID)
+	if err != nil {
+		return nil, err
+	}
+	return &file.ObjectInfo{
+		FileID:       fileID,
+		Size:         int64(len(stored.data)),
+		ModTime:      stored.modTime,
+		Checksum:     stored.checksum,
+		StorageClass: file.StorageClassStandard,
+	}, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, fileID string) error {
+	if err := s.validateFileID(fileID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.objects[fileID]; !exists {
+		return fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	delete(s.objects, fileID)
+	return nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	s.mu.RLock()
+	var fileIDs []string
+	for fileID := range s.objects {
+		if fileID > opts.ContinuationToken && strings.HasPrefix(fileID, opts.Prefix) {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(fileIDs)
+	limit := opts.Limit()
+	if len(fileIDs) <= limit {
+		return &file.ListPage{FileIDs: fileIDs}, nil
+	}
+	return &file.ListPage{FileIDs: fileIDs[:limit], NextContinuationToken: fileIDs[limit-1]}, nil
+}
+
+func (s *MemoryStorage) object(fileID string) (*object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, exists := s.objects[fileID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	return stored, nil
+}
+
+// validateFileID accepts the same IDs as the local provider, so files can be
+// moved between the two
+func (s *MemoryStorage) validateFileID(fileID string) error {
+	if fileID == "" {
+		return fmt.Errorf("%w: empty", file.ErrInvalidFileID)
+	}
+	if !token.IsSecureFileID(fileID) {
+		return fmt.Errorf("%w: not a generated file ID", file.ErrInvalidFileID)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// errObjectNotFound is returned when the endpoint answers 404 for an object
+	errObjectNotFound = errors.New("object not found")
+	// errInvalidRange is returned when the endpoint answers 416 for a range
+	errInvalidRange = errors.New("invalid range")
+	// errObjectExists is returned when the endpoint answers 412 for a write
+	// that must not replace an existing object
+	errObjectExists = errors.New("object already exists")
+)
+
+// apiError is the error document returned by S3-compatible endpoints
+type apiError struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("s3 request failed: status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated bool `xml:"IsTruncated"`
+}
+
+// client is a minimal path-style S3 REST client
+type client struct {
+	endpoint   *url.URL
+	bucket     string
+	signer     *signer
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// objectURL builds the path-style URL for key (or the bucket itself when key is empty)
+func (c *client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	basePath := strings.TrimSuffix(c.endpoint.Path, "/")
+	u.Path = basePath + "/" + c.bucket
+	u.RawPath = uriEncode(basePath, false) + "/" + uriEncode(c.bucket, true)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + uriEncode(key, false)
+	}
+	u.RawQuery = ""
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	return &u
+}
+
+// do signs and sends a request. body may be nil; payloadHash must match it.
+func (c *client) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		reader = bytes.NewReader(body)
+		payloadHash = hashHex(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	for name, values := range header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if body != nil {
+		req.ContentLength = int64(len(body))
+	}
+	c.signer.sign(req, payloadHash, c.now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, parseError(resp)
+	}
+	return resp, nil
+}
+
+func parseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return errObjectNotFound
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errObjectExists
+	}
+	apiErr := &apiError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if len(data) > 0 {
+		_ = xml.Unmarshal(data, apiErr)
+	}
+	if apiErr.Code == "" {
+		apiErr.Code = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// objectAttributes are what a HEAD request reports about an object
+type objectAttributes struct {
+	size         int64
+	lastModified time.Time
+	// storageClass is empty for STANDARD, which S3 does not report
+	storageClass string
+}
+
+func (c *client) statObject(ctx context.Context, key string) (*objectAttributes, error) {
+	resp, err := c.do(ctx, http.MethodHead, c.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	attrs := &objectAttributes{storageClass: resp.Header.Get("x-amz-storage-class")}
+	if attrs.size, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid object size: %w", err)
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		if attrs.lastModified, err = http.ParseTime(lastModified); err != nil {
+			return nil, fmt.Errorf("invalid object modification time: %w", err)
+		}
+	}
+	return attrs, nil
+}
+
+func (c *client) headObject(ctx context.Context, key string) (bool, error) {
+	_, err := c.statObject(ctx, key)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *client) putObject(ctx context.Context, key string, data []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	// Refuse to replace an object written meanwhile by a concurrent Store
+	header.Set("If-None-Match", "*")
+	resp, err := c.do(ctx, http.MethodPut, c.objectURL(key, nil), data, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *client) getObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// getObjectRange fetches length bytes from offset, or the rest of the object
+// when length is negative
+func (c *client) getObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if length < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(key, nil), nil, header)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return nil, errInvalidRange
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *client) deleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *client) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(ctx, http.MethodPost, c.objectURL(key, url.Values{"uploads": {""}}), nil, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result initiateMultipartUploadResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode multipart upload response: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("multipart upload response is missing the upload ID")
+	}
+	return result.UploadID, nil
+}
+
+func (c *client) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {uploadID},
+	}
+	resp, err := c.do(ctx, http.MethodPut, c.objectURL(key, query), data, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *client) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode multipart completion: %w", err)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("If-None-Match", "*")
+	resp, err := c.do(ctx, http.MethodPost, c.objectURL(key, url.Values{"uploadId": {uploadID}}), body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 may report a failure with a 200 status and an error document
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read multipart completion response: %w", err)
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, apiErr)
+		return apiErr
+	}
+	return nil
+}
+
+func (c *client) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listObjects lists up to maxKeys keys with prefix that sort after startAfter
+func (c *client) listObjects(ctx context.Context, prefix, startAfter string, maxKeys int) (*listBucketResult, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"max-keys":  {strconv.Itoa(maxKeys)},
+	}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if startAfter != "" {
+		query.Set("start-after", startAfter)
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL("", query), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode list response: %w", err)
+	}
+	return &result, nil
+}
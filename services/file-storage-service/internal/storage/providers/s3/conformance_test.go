@@ -0,0 +1,13 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage/storagetest"
+)
+
+func TestS3Storage_Conformance(t *testing.T) {
+	storagetest.TestProvider(t, func(t *testing.T) storagetest.Provider {
+		return newTestStorage(t, newFakeS3(), storagetest.NewMetadataService(t))
+	})
+}
@@ -0,0 +1,438 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	// DefaultPartSize is the multipart chunk size used when none is configured
+	DefaultPartSize int64 = 8 * 1024 * 1024
+	// MinPartSize is the smallest part size accepted by S3 for all but the last part
+	MinPartSize int64 = 5 * 1024 * 1024
+)
+
+// Config holds the connection settings for an S3-compatible endpoint
+type Config struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	Prefix          string `mapstructure:"prefix"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	// PartSize is the chunk size for multipart uploads. Objects smaller than
+	// PartSize are sent with a single PUT.
+	PartSize int64 `mapstructure:"part_size"`
+	// HTTPClient overrides the default HTTP client
+	HTTPClient *http.Client `mapstructure:"-"`
+	// Breaker configures the circuit breakers, one per operation
+	Breaker circuit.Config `mapstructure:"-"`
+}
+
+// Validate checks that the mandatory connection settings are present
+func (c *Config) Validate() error {
+	if c.Endpoint == "" {
+		return errors.New("s3 endpoint must be configured")
+	}
+	if c.Bucket == "" {
+		return errors.New("s3 bucket must be configured")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("s3 credentials must be configured")
+	}
+	if c.PartSize != 0 && c.PartSize < MinPartSize {
+		return fmt.Errorf("s3 part size must be at least %d bytes", MinPartSize)
+	}
+	return nil
+}
+
+type S3Storage struct {
+	client          *client
+	prefix          string
+	partSize        int64
+	breakers        *circuit.Group
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+}
+
+func NewS3Storage(cfg *Config, metadataService metadataService.MetadataService, logger *logger.Logger) (*S3Storage, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", cfg.Endpoint)
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+	breakerCfg := cfg.Breaker
+	if breakerCfg.IsFailure == nil {
+		breakerCfg.IsFailure = isBreakerFailure
+	}
+
+	return &S3Storage{
+		client: &client{
+			endpoint: endpoint,
+			bucket:   cfg.Bucket,
+			signer: &signer{
+				accessKeyID:     cfg.AccessKeyID,
+				secretAccessKey: cfg.SecretAccessKey,
+				region:          region,
+			},
+			httpClient: httpClient,
+			now:        time.Now,
+		},
+		prefix:          strings.Trim(cfg.Prefix, "/"),
+		partSize:        partSize,
+		breakers:        circuit.NewGroup("s3:"+cfg.Bucket, breakerCfg),
+		metadataService: metadataService,
+		logger:          logger,
+	}, nil
+}
+
+// isBreakerFailure counts server errors and throttling, but not missing
+// objects or requests the endpoint rejected
+func isBreakerFailure(err error) bool {
+	if errors.Is(err, errObjectNotFound) || errors.Is(err, errInvalidRange) || errors.Is(err, errObjectExists) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return circuit.DefaultIsFailure(err)
+}
+
+func (s *S3Storage) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return "", err
+	}
+	key := s.objectKey(fileID)
+
+	var exists bool
+	err := s.breakers.Execute(ctx, "head", func() error {
+		var err error
+		exists, err = s.client.headObject(ctx, key)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, fileID)
+	}
+
+	// Hash the content while it is streamed to the endpoint
+	hasher := sha256.New()
+	teeReader := io.TeeReader(content, hasher)
+
+	err = s.breakers.Execute(ctx, "store", func() error {
+		return s.upload(ctx, key, teeReader)
+	})
+	if errors.Is(err, errObjectExists) {
+		return "", fmt.Errorf("%w: %s", file.ErrFileAlreadyExists, fileID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	storagePath := s.storagePath(key)
+	if err := s.recordChecksum(ctx, fileID, storagePath, fmt.Sprintf("%x", hasher.Sum(nil))); err != nil {
+		if cleanupErr := s.client.deleteObject(ctx, key); cleanupErr != nil {
+			s.logger.Error().Err(cleanupErr).Str("fileId", fileID).Msg("Failed to cleanup object after metadata failure")
+		}
+		return "", err
+	}
+	return storagePath, nil
+}
+
+// upload sends content with a single PUT when it fits into one part, and as a
+// multipart upload otherwise. At most one part is held in memory at a time.
+func (s *S3Storage) upload(ctx context.Context, key string, content io.Reader) error {
+	buf := make([]byte, s.partSize)
+	n, err := io.ReadFull(content, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.client.putObject(ctx, key, buf[:n])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read content: %w", err)
+	}
+
+	uploadID, err := s.client.createMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var parts []completedPart
+	var success bool
+	defer func() {
+		if !success {
+			if abortErr := s.client.abortMultipartUpload(context.WithoutCancel(ctx), key, uploadID); abortErr != nil {
+				s.logger.Error().Err(abortErr).Str("key", key).Msg("Failed to abort multipart upload")
+			}
+		}
+	}()
+
+	for partNumber := 1; n > 0; partNumber++ {
+		etag, err := s.client.uploadPart(ctx, key, uploadID, partNumber, buf[:n])
+		if err != nil {
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: etag})
+
+		n, err = io.ReadFull(content, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read content: %w", err)
+		}
+	}
+
+	if err := s.client.completeMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		return err
+	}
+	success = true
+	return nil
+}
+
+func (s *S3Storage) recordChecksum(ctx context.Context, fileID, storagePath, checksum string) error {
+	metadata, err := s.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	metadata.Checksum = checksum
+	metadata.StoragePath = storagePath
+	if err := s.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+
+	var body io.ReadCloser
+	err = s.breakers.Execute(ctx, "retrieve", func() error {
+		var err error
+		body, err = s.client.getObject(ctx, s.objectKey(fileID))
+		return err
+	})
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The integrity check runs as the body is consumed instead of downloading twice
+	return &verifyingReader{
+		body:     body,
+		hash:     sha256.New(),
+		expected: metadata.Checksum,
+	}, nil
+}
+
+// RetrieveRange fetches part of an object with a ranged GET. The checksum
+// covers the whole object, so ranges are returned unverified.
+func (s *S3Storage) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", file.ErrInvalidRange, offset)
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	var body io.ReadCloser
+	err := s.breakers.Execute(ctx, "retrieve", func() error {
+		var err error
+		body, err = s.client.getObjectRange(ctx, s.objectKey(fileID), offset, length)
+		return err
+	})
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if errors.Is(err, errInvalidRange) {
+		return nil, fmt.Errorf("%w: offset %d beyond end of %s", file.ErrInvalidRange, offset, fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Stat describes an object with a HEAD request. The checksum is the one
+// recorded on Store, as ETags are not content hashes for multipart uploads.
+func (s *S3Storage) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	if err := s.validateFileID(fileID); err != nil {
+		return nil, err
+	}
+
+	var attrs *objectAttributes
+	err := s.breakers.Execute(ctx, "stat", func() error {
+		var err error
+		attrs, err = s.client.statObject(ctx, s.objectKey(fileID))
+		return err
+	})
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+	storageClass := attrs.storageClass
+	if storageClass == "" {
+		storageClass = file.StorageClassStandard
+	}
+	return &file.ObjectInfo{
+		FileID:       fileID,
+		Size:         attrs.size,
+		ModTime:      attrs.lastModified,
+		Checksum:     metadata.Checksum,
+		StorageClass: storageClass,
+	}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, fileID string) error {
+	if err := s.validateFileID(fileID); err != nil {
+		return err
+	}
+	key := s.objectKey(fileID)
+
+	return s.breakers.Execute(ctx, "delete", func() error {
+		// S3 deletes are idempotent, so check existence to report missing files
+		exists, err := s.client.headObject(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s: %w", file.ErrFileNotFound, fileID, errObjectNotFound)
+		}
+		return s.client.deleteObject(ctx, key)
+	})
+}
+
+// List returns a page of file IDs. S3 lists keys in order, so the page
+// resumes directly after the key of the continuation token.
+func (s *S3Storage) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	limit := opts.Limit()
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+
+	var page file.ListPage
+	err := s.breakers.Execute(ctx, "list", func() error {
+		page = file.ListPage{}
+		var startAfter string
+		if opts.ContinuationToken != "" {
+			startAfter = prefix + opts.ContinuationToken
+		}
+		// The endpoint may return fewer keys than asked for
+		for {
+			result, err := s.client.listObjects(ctx, prefix+opts.Prefix, startAfter, limit-len(page.FileIDs))
+			if err != nil {
+				return fmt.Errorf("failed to list files: %w", err)
+			}
+			for _, object := range result.Contents {
+				page.FileIDs = append(page.FileIDs, strings.TrimPrefix(object.Key, prefix))
+			}
+			if !result.IsTruncated || len(result.Contents) == 0 {
+				return nil
+			}
+			if len(page.FileIDs) >= limit {
+				page.NextContinuationToken = page.FileIDs[len(page.FileIDs)-1]
+				return nil
+			}
+			startAfter = result.Contents[len(result.Contents)-1].Key
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (s *S3Storage) validateFileID(fileID string) error {
+	if fileID == "" {
+		return fmt.Errorf("%w: empty", file.ErrInvalidFileID)
+	}
+	if path.Clean(fileID) != fileID || path.IsAbs(fileID) || fileID == ".." || strings.HasPrefix(fileID, "../") {
+		return fmt.Errorf("%w: not a clean relative path", file.ErrInvalidFileID)
+	}
+	return nil
+}
+
+func (s *S3Storage) objectKey(fileID string) string {
+	if s.prefix == "" {
+		return fileID
+	}
+	return s.prefix + "/" + fileID
+}
+
+func (s *S3Storage) storagePath(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.client.bucket, key)
+}
+
+// verifyingReader hashes the object as it is read and fails at EOF when the
+// content does not match the checksum recorded in the metadata
+type verifyingReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && r.expected != "" {
+		if fmt.Sprintf("%x", r.hash.Sum(nil)) != r.expected {
+			return n, fmt.Errorf("%w: checksums do not match", file.ErrIntegrityCheckFailed)
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+)
+
+// sliceReadCloser discards the first skip bytes of rc and limits the rest to
+// length bytes, or leaves it unlimited when length is negative. Decorators use
+// it where stored content can only be decoded from an earlier position.
+func sliceReadCloser(rc io.ReadCloser, skip, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, rc, skip); err != nil {
+		rc.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: offset beyond end of file", ErrInvalidRange)
+		}
+		return nil, err
+	}
+	if length < 0 {
+		return rc, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(rc, length), closer: rc}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *limitedReadCloser) Close() error {
+	return r.closer.Close()
+}
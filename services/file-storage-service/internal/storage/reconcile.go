@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	// maxReconcileReported caps the file IDs listed in a ReconcileResult
+	maxReconcileReported = 1000
+
+	defaultReconcileBatchSize = 500
+)
+
+// ErrReconcileInProgress is returned when a reconciliation is started while
+// one is running
+var ErrReconcileInProgress = errors.New("reconciliation already in progress")
+
+// ReconcileConfig configures a Reconciler
+type ReconcileConfig struct {
+	// MinAge keeps files written more recently from being reported as
+	// orphans, as their metadata may still be on its way
+	MinAge time.Duration
+	// BatchSize is how many files are listed and looked up at a time
+	BatchSize           int
+	ReconcileRepository metadataService.ReconcileRepository
+}
+
+// ReconcileResult reports what a reconciliation found and, unless it was a
+// dry run, fixed. The ID lists stop at maxReconcileReported entries; the
+// counts do not.
+type ReconcileResult struct {
+	DryRun bool `json:"dry_run"`
+	// Orphans are stored files without a metadata record
+	Orphans     []string `json:"orphans"`
+	OrphanCount int      `json:"orphan_count"`
+	// Dangling are COMPLETE records whose content is missing from storage
+	Dangling      []string `json:"dangling"`
+	DanglingCount int      `json:"dangling_count"`
+	Fixed         int      `json:"fixed"`
+	Failed        []string `json:"failed"`
+}
+
+func (r *ReconcileResult) addOrphan(fileID string) {
+	r.OrphanCount++
+	if len(r.Orphans) < maxReconcileReported {
+		r.Orphans = append(r.Orphans, fileID)
+	}
+}
+
+func (r *ReconcileResult) addDangling(fileID string) {
+	r.DanglingCount++
+	if len(r.Dangling) < maxReconcileReported {
+		r.Dangling = append(r.Dangling, fileID)
+	}
+}
+
+func (r *ReconcileResult) addFailed(fileID string) {
+	if len(r.Failed) < maxReconcileReported {
+		r.Failed = append(r.Failed, fileID)
+	}
+}
+
+// Reconciler brings storage and metadata back in line. Stored files without a
+// metadata record, e.g. left by an expired upload or a failed delete, are
+// removed from storage. COMPLETE records whose content is gone are removed,
+// returning their quota.
+type Reconciler struct {
+	provider        Provider
+	records         metadataService.ReconcileRepository
+	metadataService metadataService.MetadataService
+	minAge          time.Duration
+	batchSize       int
+	logger          *logger.Logger
+	now             func() time.Time
+	running         sync.Mutex
+}
+
+func NewReconciler(provider Provider, cfg *ReconcileConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*Reconciler, error) {
+	if cfg.ReconcileRepository == nil {
+		return nil, errors.New("reconciler needs a reconcile repository")
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultReconcileBatchSize
+	}
+	return &Reconciler{
+		provider:        provider,
+		records:         cfg.ReconcileRepository,
+		metadataService: metadataService,
+		minAge:          cfg.MinAge,
+		batchSize:       batchSize,
+		logger:          logger,
+		now:             time.Now,
+	}, nil
+}
+
+// Run compares storage with the metadata records and, unless dryRun is set,
+// fixes what it finds. Only one run may be in progress at a time.
+func (r *Reconciler) Run(ctx context.Context, dryRun bool) (*ReconcileResult, error) {
+	if !r.running.TryLock() {
+		return nil, ErrReconcileInProgress
+	}
+	defer r.running.Unlock()
+
+	result := &ReconcileResult{DryRun: dryRun, Orphans: []string{}, Dangling: []string{}, Failed: []string{}}
+	if err := r.reconcileOrphans(ctx, dryRun, result); err != nil {
+		return result, err
+	}
+	if err := r.reconcileDangling(ctx, dryRun, result); err != nil {
+		return result, err
+	}
+
+	r.logger.Info().
+		Int("orphans", result.OrphanCount).
+		Int("dangling", result.DanglingCount).
+		Int("fixed", result.Fixed).
+		Int("failed", len(result.Failed)).
+		Bool("dryRun", dryRun).
+		Msg("Reconciliation completed")
+	return result, nil
+}
+
+// reconcileOrphans deletes stored files that have no metadata record
+func (r *Reconciler) reconcileOrphans(ctx context.Context, dryRun bool, result *ReconcileResult) error {
+	opts := file.ListOptions{PageSize: r.batchSize}
+	for {
+		page, err := r.provider.List(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list stored files: %w", err)
+		}
+		known, err := r.records.FindFileIDs(ctx, page.FileIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, fileID := range page.FileIDs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if known[fileID] || !r.settled(ctx, fileID) {
+				continue
+			}
+			result.addOrphan(fileID)
+			if dryRun {
+				continue
+			}
+			if err := r.provider.Delete(ctx, fileID); err != nil && !isNotFound(err) {
+				r.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to delete orphaned file")
+				result.addFailed(fileID)
+				continue
+			}
+			r.logger.Info().Str("fileId", fileID).Msg("Deleted orphaned file")
+			result.Fixed++
+		}
+
+		if page.NextContinuationToken == "" {
+			return nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// settled reports whether fileID was written at least minAge ago
+func (r *Reconciler) settled(ctx context.Context, fileID string) bool {
+	if r.minAge <= 0 {
+		return true
+	}
+	info, err := r.provider.Stat(ctx, fileID)
+	if err != nil {
+		return false
+	}
+	return r.now().Sub(info.ModTime) >= r.minAge
+}
+
+// reconcileDangling removes COMPLETE records whose content is missing
+func (r *Reconciler) reconcileDangling(ctx context.Context, dryRun bool, result *ReconcileResult) error {
+	var after string
+	for {
+		records, err := r.records.ListCompleteFiles(ctx, after, r.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		for _, record := range records {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			after = record.ID
+			_, err := r.provider.Stat(ctx, record.ID)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, file.ErrFileNotFound) {
+				r.logger.Warn().Err(err).Str("fileId", record.ID).Msg("Failed to check file in storage")
+				continue
+			}
+			result.addDangling(record.ID)
+			if dryRun {
+				continue
+			}
+			if err := r.metadataService.DeleteFileMetadata(ctx, record.Metadata.UserId, record.ID); err != nil {
+				r.logger.Error().Err(err).Str("fileId", record.ID).Msg("Failed to remove dangling metadata")
+				result.addFailed(record.ID)
+				continue
+			}
+			r.logger.Info().Str("fileId", record.ID).Msg("Removed metadata of missing file")
+			result.Fixed++
+		}
+	}
+}
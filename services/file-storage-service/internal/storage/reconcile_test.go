@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// memoryRecords keeps the processing status of metadata records by file ID
+type memoryRecords struct {
+	statuses map[string]file.FileStatus
+}
+
+func (m *memoryRecords) FindFileIDs(ctx context.Context, fileIDs []string) (map[string]bool, error) {
+	found := map[string]bool{}
+	for _, fileID := range fileIDs {
+		if _, ok := m.statuses[fileID]; ok {
+			found[fileID] = true
+		}
+	}
+	return found, nil
+}
+
+func (m *memoryRecords) ListCompleteFiles(ctx context.Context, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	var fileIDs []string
+	for fileID, status := range m.statuses {
+		if status == file.StatusComplete && fileID > afterFileID {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	slices.Sort(fileIDs)
+	var records []*domain.FileMetadataRecord
+	for _, fileID := range fileIDs[:min(limit, len(fileIDs))] {
+		records = append(records, &domain.FileMetadataRecord{
+			ID:               fileID,
+			ProcessingStatus: string(file.StatusComplete),
+			Metadata:         &sharedv1.FileMetadata{FileId: fileID, UserId: "u1"},
+		})
+	}
+	return records, nil
+}
+
+func newTestReconciler(t *testing.T, provider Provider, records *memoryRecords, minAge time.Duration) (*Reconciler, *metadataService.MockMetadataService) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	reconciler, err := NewReconciler(provider, &ReconcileConfig{
+		MinAge:              minAge,
+		BatchSize:           2,
+		ReconcileRepository: records,
+	}, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewReconciler() error = %v", err)
+	}
+	return reconciler, mockMetadata
+}
+
+func TestReconciler_DryRunThenFix(t *testing.T) {
+	provider := &memoryProvider{files: map[string][]byte{
+		"file-a": []byte("a"),
+		"file-b": []byte("orphan"),
+		"file-e": []byte("e"),
+	}}
+	records := &memoryRecords{statuses: map[string]file.FileStatus{
+		"file-a": file.StatusComplete,
+		"file-c": file.StatusComplete,
+		"file-d": file.StatusPending,
+		"file-e": file.StatusComplete,
+	}}
+	reconciler, mockMetadata := newTestReconciler(t, provider, records, 0)
+	ctx := context.Background()
+
+	result, err := reconciler.Run(ctx, true)
+	if err != nil {
+		t.Fatalf("Run() dry run error = %v", err)
+	}
+	if !slices.Equal(result.Orphans, []string{"file-b"}) || !slices.Equal(result.Dangling, []string{"file-c"}) {
+		t.Errorf("Run() dry run orphans = %v, dangling = %v", result.Orphans, result.Dangling)
+	}
+	if result.Fixed != 0 || len(provider.files) != 3 {
+		t.Errorf("dry run changed storage: fixed = %d, files = %d", result.Fixed, len(provider.files))
+	}
+
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "file-c").
+		DoAndReturn(func(_ context.Context, _, fileID string) error {
+			delete(records.statuses, fileID)
+			return nil
+		})
+	result, err = reconciler.Run(ctx, false)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.OrphanCount != 1 || result.DanglingCount != 1 || result.Fixed != 2 || len(result.Failed) != 0 {
+		t.Errorf("Run() = %+v", result)
+	}
+	if _, ok := provider.files["file-b"]; ok {
+		t.Error("orphaned file was not deleted")
+	}
+
+	result, err = reconciler.Run(ctx, false)
+	if err != nil || result.OrphanCount != 0 || result.DanglingCount != 0 {
+		t.Errorf("Run() after fixing = %+v, %v, want nothing found", result, err)
+	}
+}
+
+func TestReconciler_SkipsRecentFiles(t *testing.T) {
+	provider := &memoryProvider{files: map[string][]byte{"file-a": []byte("new")}}
+	reconciler, _ := newTestReconciler(t, provider, &memoryRecords{statuses: map[string]file.FileStatus{}}, time.Hour)
+	// memoryProvider reports a zero ModTime, which is a minute old by this clock
+	reconciler.now = func() time.Time { return time.Time{}.Add(time.Minute) }
+
+	result, err := reconciler.Run(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.OrphanCount != 0 || len(provider.files) != 1 {
+		t.Errorf("Run() = %+v, want the recent file left alone", result)
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// RetainingProvider refuses to delete files under retention or legal hold,
+// whatever path the delete comes from
+type RetainingProvider struct {
+	inner     Provider
+	retention metadataService.RetentionRepository
+	logger    *logger.Logger
+	now       func() time.Time
+}
+
+// retainingVersioner also refuses new versions of retained files, as adding
+// a version may prune earlier ones
+type retainingVersioner struct {
+	*RetainingProvider
+	versioner Versioner
+}
+
+// NewRetainingProvider wraps inner, keeping it a Versioner if it is one
+func NewRetainingProvider(inner Provider, retention metadataService.RetentionRepository, logger *logger.Logger) Provider {
+	provider := &RetainingProvider{
+		inner:     inner,
+		retention: retention,
+		logger:    logger,
+		now:       time.Now,
+	}
+	if versioner, ok := inner.(Versioner); ok {
+		return &retainingVersioner{RetainingProvider: provider, versioner: versioner}
+	}
+	return provider
+}
+
+func (r *RetainingProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	return r.inner.Store(ctx, fileID, content)
+}
+
+func (r *RetainingProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	return r.inner.Retrieve(ctx, fileID)
+}
+
+func (r *RetainingProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	return r.inner.RetrieveRange(ctx, fileID, offset, length)
+}
+
+func (r *RetainingProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	return r.inner.Stat(ctx, fileID)
+}
+
+// Delete fails with domain.ErrFileRetained while the file is retained
+func (r *RetainingProvider) Delete(ctx context.Context, fileID string) error {
+	if err := r.checkRetention(ctx, fileID); err != nil {
+		return err
+	}
+	return r.inner.Delete(ctx, fileID)
+}
+
+func (r *RetainingProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	return r.inner.List(ctx, opts)
+}
+
+// SweepTempFiles forwards to the wrapped provider, if it stages writes
+func (r *RetainingProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	sweeper, ok := r.inner.(TempFileSweeper)
+	if !ok {
+		return nil, nil
+	}
+	return sweeper.SweepTempFiles(ctx)
+}
+
+func (r *RetainingProvider) checkRetention(ctx context.Context, fileID string) error {
+	retention, err := r.retention.GetRetention(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to check retention of %s: %w", fileID, err)
+	}
+	if retention.Applies(r.now()) {
+		r.logger.Warn().Str("fileId", fileID).Msg("Refused to delete retained file")
+		return fmt.Errorf("%w: %s", domain.ErrFileRetained, fileID)
+	}
+	return nil
+}
+
+// AddVersion fails with domain.ErrFileRetained while fileID is retained
+func (r *retainingVersioner) AddVersion(ctx context.Context, fileID, versionID string) error {
+	if err := r.checkRetention(ctx, fileID); err != nil {
+		return err
+	}
+	return r.versioner.AddVersion(ctx, fileID, versionID)
+}
+
+func (r *retainingVersioner) RestoreVersion(ctx context.Context, fileID, versionID string) error {
+	return r.versioner.RestoreVersion(ctx, fileID, versionID)
+}
+
+func (r *retainingVersioner) ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error) {
+	return r.versioner.ListVersions(ctx, fileID)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// memoryRetention keeps file retention by file ID
+type memoryRetention struct {
+	retention map[string]*domain.Retention
+}
+
+func (m *memoryRetention) GetRetention(ctx context.Context, fileID string) (*domain.Retention, error) {
+	return m.retention[fileID], nil
+}
+
+func (m *memoryRetention) SetRetention(ctx context.Context, change *domain.RetentionChange) error {
+	m.retention[change.FileID] = &domain.Retention{FileID: change.FileID, RetainUntil: change.RetainUntil, LegalHold: change.LegalHold}
+	return nil
+}
+
+func (m *memoryRetention) ListRetentionChanges(ctx context.Context, fileID string) ([]*domain.RetentionChange, error) {
+	return nil, nil
+}
+
+func TestRetainingProvider_RefusesDeletesOfRetainedFiles(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	inner := &memoryProvider{files: map[string][]byte{
+		"held": []byte("a"), "retained": []byte("b"), "expired": []byte("c"), "free": []byte("d"),
+	}}
+	retention := &memoryRetention{retention: map[string]*domain.Retention{
+		"held":     {FileID: "held", LegalHold: true},
+		"retained": {FileID: "retained", RetainUntil: now.Add(time.Hour)},
+		"expired":  {FileID: "expired", RetainUntil: now.Add(-time.Hour)},
+	}}
+	provider := NewRetainingProvider(inner, retention, &testLogger)
+	provider.(*RetainingProvider).now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for fileID, wantRetained := range map[string]bool{"held": true, "retained": true, "expired": false, "free": false} {
+		err := provider.Delete(ctx, fileID)
+		if retained := errors.Is(err, domain.ErrFileRetained); retained != wantRetained {
+			t.Errorf("Delete(%s) error = %v, want retained %v", fileID, err, wantRetained)
+		}
+		if _, kept := inner.files[fileID]; kept != wantRetained {
+			t.Errorf("%s kept = %v, want %v", fileID, kept, wantRetained)
+		}
+	}
+	if _, ok := provider.(Versioner); ok {
+		t.Error("provider is a Versioner although the wrapped provider is not")
+	}
+}
+
+// memoryVersioner records the versions added to files
+type memoryVersioner struct {
+	*memoryProvider
+	added map[string]string
+}
+
+func (m *memoryVersioner) AddVersion(ctx context.Context, fileID, versionID string) error {
+	m.added[fileID] = versionID
+	return nil
+}
+
+func (m *memoryVersioner) RestoreVersion(ctx context.Context, fileID, versionID string) error {
+	return nil
+}
+
+func (m *memoryVersioner) ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error) {
+	return nil, nil
+}
+
+func TestRetainingProvider_RefusesVersionsOfRetainedFiles(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	inner := &memoryVersioner{memoryProvider: &memoryProvider{files: map[string][]byte{}}, added: map[string]string{}}
+	retention := &memoryRetention{retention: map[string]*domain.Retention{
+		"held": {FileID: "held", LegalHold: true},
+	}}
+	versioner, ok := NewRetainingProvider(inner, retention, &testLogger).(Versioner)
+	if !ok {
+		t.Fatal("provider is not a Versioner although the wrapped provider is")
+	}
+	ctx := context.Background()
+
+	if err := versioner.AddVersion(ctx, "held", "ver-a"); !errors.Is(err, domain.ErrFileRetained) {
+		t.Errorf("AddVersion() of a held file error = %v, want retained", err)
+	}
+	if err := versioner.AddVersion(ctx, "free", "ver-b"); err != nil {
+		t.Errorf("AddVersion() error = %v", err)
+	}
+	if len(inner.added) != 1 || inner.added["free"] != "ver-b" {
+		t.Errorf("versions added = %v, want only ver-b of free", inner.added)
+	}
+}
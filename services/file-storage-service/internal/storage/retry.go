@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// RetryingProvider retries storage operations that fail with transient
+// errors, e.g. flaky disk I/O. Operations are named store, retrieve,
+// retrieve_range, stat, delete and list for per-operation policies.
+type RetryingProvider struct {
+	inner  Provider
+	cfg    retry.Config
+	logger *logger.Logger
+}
+
+// NewRetryingProvider wraps inner. Errors are classified with IsRetryable
+// unless cfg says otherwise.
+func NewRetryingProvider(inner Provider, cfg retry.Config, logger *logger.Logger) *RetryingProvider {
+	if cfg.IsRetryable == nil {
+		cfg.IsRetryable = IsRetryable
+	}
+	return &RetryingProvider{inner: inner, cfg: cfg, logger: logger}
+}
+
+// IsRetryable reports whether another attempt may succeed where err failed.
+// Missing or existing files, invalid input, integrity failures, retained
+// files and an open circuit breaker are final.
+func IsRetryable(err error) bool {
+	if !retry.DefaultIsRetryable(err) {
+		return false
+	}
+	for _, final := range []error{
+		circuit.ErrCircuitOpen,
+		ErrFileNotFound,
+		ErrFileAlreadyExists,
+		ErrInvalidFileID,
+		ErrIntegrityCheckFailed,
+		ErrInvalidRange,
+		domain.ErrFileRetained,
+		os.ErrNotExist,
+		os.ErrExist,
+		os.ErrPermission,
+		ErrInvalidPath,
+		ErrStorageAccess,
+		ErrStorageFull,
+	} {
+		if errors.Is(err, final) {
+			return false
+		}
+	}
+	return true
+}
+
+// Store rewinds seekable content to where the first attempt started reading
+// before each retry. Other content, such as an upload streamed from a client,
+// is first spooled to a temporary file so it can be replayed, unless the
+// policy allows a single attempt only.
+func (r *RetryingProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	seeker, ok := content.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		ok = err == nil
+	}
+	if !ok {
+		if r.cfg.For("store").MaxAttempts <= 1 {
+			return r.inner.Store(ctx, fileID, content)
+		}
+		spooled, err := spool(content)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			spooled.Close()
+			if err := os.Remove(spooled.Name()); err != nil {
+				r.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to remove spool file")
+			}
+		}()
+		content, seeker, start = spooled, spooled, 0
+	}
+
+	var storagePath string
+	attempted := false
+	err := r.do(ctx, "store", fileID, func() error {
+		if attempted {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind content: %w", err)
+			}
+		}
+		attempted = true
+		var err error
+		storagePath, err = r.inner.Store(ctx, fileID, content)
+		return err
+	})
+	return storagePath, err
+}
+
+// spool copies content to a temporary file and rewinds it. Errors reading
+// content, e.g. an upload exceeding its quota, are returned wrapped.
+func spool(content io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "upload-spool-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	_, err = io.Copy(f, content)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to spool content: %w", err)
+	}
+	return f, nil
+}
+
+func (r *RetryingProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := r.do(ctx, "retrieve", fileID, func() error {
+		var err error
+		reader, err = r.inner.Retrieve(ctx, fileID)
+		return err
+	})
+	return reader, err
+}
+
+func (r *RetryingProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := r.do(ctx, "retrieve_range", fileID, func() error {
+		var err error
+		reader, err = r.inner.RetrieveRange(ctx, fileID, offset, length)
+		return err
+	})
+	return reader, err
+}
+
+func (r *RetryingProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	var info *file.ObjectInfo
+	err := r.do(ctx, "stat", fileID, func() error {
+		var err error
+		info, err = r.inner.Stat(ctx, fileID)
+		return err
+	})
+	return info, err
+}
+
+func (r *RetryingProvider) Delete(ctx context.Context, fileID string) error {
+	return r.do(ctx, "delete", fileID, func() error {
+		return r.inner.Delete(ctx, fileID)
+	})
+}
+
+func (r *RetryingProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	var page *file.ListPage
+	err := r.do(ctx, "list", "", func() error {
+		var err error
+		page, err = r.inner.List(ctx, opts)
+		return err
+	})
+	return page, err
+}
+
+// SweepTempFiles forwards to the wrapped provider, if it stages writes
+func (r *RetryingProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	sweeper, ok := r.inner.(TempFileSweeper)
+	if !ok {
+		return nil, nil
+	}
+	return sweeper.SweepTempFiles(ctx)
+}
+
+func (r *RetryingProvider) do(ctx context.Context, operation, fileID string, fn func() error) error {
+	policy := r.cfg.For(operation)
+	onRetry := policy.OnRetry
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		r.logger.Warn().
+			Err(err).
+			Str("operation", operation).
+			Str("fileId", fileID).
+			Int("attempt", attempt).
+			Dur("wait", wait).
+			Msg("Retrying storage operation")
+		if onRetry != nil {
+			onRetry(attempt, err, wait)
+		}
+	}
+	return policy.Do(ctx, fn)
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/rs/zerolog"
+	circuit "github.com/yaanno/upload-store-process/services/file-storage-service/internal/breaker"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// flakyProvider fails the first failures calls of every operation with an
+// I/O error, reading part of the content before a failed Store
+type flakyProvider struct {
+	memoryProvider
+	failures int
+	calls    map[string]int
+}
+
+func (f *flakyProvider) fail(operation string) error {
+	f.calls[operation]++
+	if f.calls[operation] <= f.failures {
+		return fmt.Errorf("failed to write file: %w", syscall.EIO)
+	}
+	return nil
+}
+
+func (f *flakyProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	if err := f.fail("store"); err != nil {
+		io.CopyN(io.Discard, content, 3)
+		return "", err
+	}
+	return f.memoryProvider.Store(ctx, fileID, content)
+}
+
+func (f *flakyProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if err := f.fail("retrieve"); err != nil {
+		return nil, err
+	}
+	return f.memoryProvider.Retrieve(ctx, fileID)
+}
+
+func newTestRetryingProvider(failures int, cfg retry.Config) (*RetryingProvider, *flakyProvider) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	inner := &flakyProvider{
+		memoryProvider: memoryProvider{files: make(map[string][]byte)},
+		failures:       failures,
+		calls:          make(map[string]int),
+	}
+	return NewRetryingProvider(inner, cfg, &testLogger), inner
+}
+
+func TestRetryingProvider_RetriesTransientErrors(t *testing.T) {
+	provider, inner := newTestRetryingProvider(2, retry.Config{
+		Policy: retry.Policy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+	})
+	ctx := context.Background()
+
+	// Seekable content is rewound before each attempt
+	if _, err := provider.Store(ctx, "file-a", bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if got := string(inner.files["file-a"]); got != "content" {
+		t.Errorf("stored %q, want %q", got, "content")
+	}
+
+	reader, err := provider.Retrieve(ctx, "file-a")
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	reader.Close()
+	if inner.calls["store"] != 3 || inner.calls["retrieve"] != 3 {
+		t.Errorf("calls = %v, want 3 of each", inner.calls)
+	}
+
+	// A stream is spooled, so it can be replayed too
+	inner.calls = make(map[string]int)
+	stream := io.MultiReader(strings.NewReader("content"))
+	if _, err := provider.Store(ctx, "file-b", stream); err != nil || inner.calls["store"] != 3 {
+		t.Fatalf("Store() of a stream = %v after %d calls, want success after 3", err, inner.calls["store"])
+	}
+	if got := string(inner.files["file-b"]); got != "content" {
+		t.Errorf("stored %q, want %q", got, "content")
+	}
+}
+
+func TestRetryingProvider_StoresStreamOnceWithoutRetries(t *testing.T) {
+	provider, inner := newTestRetryingProvider(1, retry.Config{
+		Operations: map[string]retry.Policy{"store": {MaxAttempts: 1}},
+	})
+
+	stream := io.MultiReader(strings.NewReader("content"))
+	if _, err := provider.Store(context.Background(), "file-a", stream); !errors.Is(err, syscall.EIO) || inner.calls["store"] != 1 {
+		t.Errorf("Store() of a stream = %v after %d calls, want EIO after 1", err, inner.calls["store"])
+	}
+}
+
+func TestRetryingProvider_ReturnsStreamErrors(t *testing.T) {
+	provider, inner := newTestRetryingProvider(0, retry.Config{})
+
+	stream := io.MultiReader(strings.NewReader("cont"), iotest.ErrReader(syscall.ECONNRESET))
+	if _, err := provider.Store(context.Background(), "file-a", stream); !errors.Is(err, syscall.ECONNRESET) || inner.calls["store"] != 0 {
+		t.Errorf("Store() of a broken stream = %v after %d calls, want ECONNRESET before storing", err, inner.calls["store"])
+	}
+}
+
+func TestRetryingProvider_PerOperationPolicies(t *testing.T) {
+	provider, inner := newTestRetryingProvider(5, retry.Config{
+		Policy:     retry.Policy{MaxAttempts: 2, InitialInterval: time.Millisecond},
+		Operations: map[string]retry.Policy{"retrieve": {MaxAttempts: 4}},
+	})
+	ctx := context.Background()
+
+	provider.Store(ctx, "file-a", bytes.NewReader([]byte("content")))
+	provider.Retrieve(ctx, "file-a")
+	if inner.calls["store"] != 2 || inner.calls["retrieve"] != 4 {
+		t.Errorf("calls = %v, want 2 stores and 4 retrieves", inner.calls)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("failed to write file: %w", syscall.EIO), true},
+		{errors.New("connection reset by peer"), true},
+		{fmt.Errorf("%w: file-a", file.ErrFileNotFound), false},
+		{fmt.Errorf("%w: file-a", file.ErrFileAlreadyExists), false},
+		{fmt.Errorf("%w: path", file.ErrInvalidFileID), false},
+		{fmt.Errorf("%w: offset 10 of 5 bytes", file.ErrInvalidRange), false},
+		// Only the sentinels are final, not messages that look like them
+		{errors.New("file not found: file-a"), true},
+		{fmt.Errorf("%w: checksums do not match", ErrIntegrityCheckFailed), false},
+		{circuit.ErrCircuitOpen, false},
+		{context.DeadlineExceeded, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
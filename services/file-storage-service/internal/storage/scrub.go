@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const scrubBufferSize = 32 * 1024
+
+// ErrScrubInProgress is returned when a scrub is started while one is running
+var ErrScrubInProgress = errors.New("integrity scrub already in progress")
+
+// Quarantiner is implemented by providers that can move a corrupt file out of
+// the way of reads and listings. Quarantine returns where the file now lives.
+type Quarantiner interface {
+	Quarantine(ctx context.Context, fileID string) (string, error)
+}
+
+// ScrubConfig configures a Scrubber
+type ScrubConfig struct {
+	// BytesPerSecond caps how fast content is read; zero is unlimited
+	BytesPerSecond int64
+	// PageSize is how many file IDs are listed at a time
+	PageSize             int
+	QuarantineRepository metadataService.QuarantineRepository
+}
+
+// ScrubStatus reports the progress of the running or last finished scrub
+type ScrubStatus struct {
+	Running    bool      `json:"running"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// LastFileID is the last file checked; files are checked in ID order
+	LastFileID string `json:"last_file_id"`
+	Scanned    int64  `json:"scanned"`
+	Skipped    int64  `json:"skipped"`
+	Corrupted  int64  `json:"corrupted"`
+	Failed     int64  `json:"failed"`
+	BytesRead  int64  `json:"bytes_read"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+type scrubOutcome int
+
+const (
+	scrubClean scrubOutcome = iota
+	scrubSkipped
+	scrubCorrupted
+	scrubFailed
+)
+
+// Scrubber walks every stored file and recomputes its SHA-256 against the
+// checksum on its metadata record, so corruption is found before a user reads
+// the file. Corrupt files are recorded, marked CORRUPTED and, if the provider
+// supports it, moved into quarantine. It works on the undecorated provider,
+// whose stored bytes are what the checksum covers. Files are read with
+// Retrieve, so a mirror serves a valid replica and queues a corrupt one for
+// repair instead of the file being quarantined.
+type Scrubber struct {
+	provider        Provider
+	quarantine      metadataService.QuarantineRepository
+	metadataService metadataService.MetadataService
+	bytesPerSecond  int64
+	pageSize        int
+	logger          *logger.Logger
+
+	mu     sync.Mutex
+	status ScrubStatus
+}
+
+func NewScrubber(provider Provider, cfg *ScrubConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*Scrubber, error) {
+	if cfg.QuarantineRepository == nil {
+		return nil, errors.New("scrubber needs a quarantine repository")
+	}
+	if cfg.BytesPerSecond < 0 {
+		return nil, fmt.Errorf("scrub rate must not be negative: %d", cfg.BytesPerSecond)
+	}
+	return &Scrubber{
+		provider:        provider,
+		quarantine:      cfg.QuarantineRepository,
+		metadataService: metadataService,
+		bytesPerSecond:  cfg.BytesPerSecond,
+		pageSize:        cfg.PageSize,
+		logger:          logger,
+	}, nil
+}
+
+// Status returns the progress of the running or last finished scrub
+func (s *Scrubber) Status() ScrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Findings returns up to limit quarantined files, most recent first
+func (s *Scrubber) Findings(ctx context.Context, limit int) ([]*domain.QuarantinedFile, error) {
+	return s.quarantine.ListQuarantinedFiles(ctx, limit)
+}
+
+// Run scrubs every stored file once and returns the final status
+func (s *Scrubber) Run(ctx context.Context) (ScrubStatus, error) {
+	if err := s.begin(); err != nil {
+		return ScrubStatus{}, err
+	}
+	return s.run(ctx)
+}
+
+// Start runs a scrub in the background
+func (s *Scrubber) Start(ctx context.Context) error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	go func() {
+		if _, err := s.run(ctx); err != nil {
+			s.logger.Error().Err(err).Msg("Integrity scrub failed")
+		}
+	}()
+	return nil
+}
+
+func (s *Scrubber) begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Running {
+		return ErrScrubInProgress
+	}
+	s.status = ScrubStatus{Running: true, StartedAt: time.Now().UTC()}
+	return nil
+}
+
+func (s *Scrubber) run(ctx context.Context) (ScrubStatus, error) {
+	ctx = context.WithValue(ctx, scrubKey{}, true)
+	limiter := newByteLimiter(s.bytesPerSecond)
+
+	var runErr error
+	for fileID, err := range AllFiles(ctx, s.provider, file.ListOptions{PageSize: s.pageSize}) {
+		if err != nil {
+			runErr = fmt.Errorf("failed to list files: %w", err)
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			runErr = err
+			break
+		}
+		outcome, n, err := s.scrubFile(ctx, fileID, limiter)
+		if err != nil {
+			s.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to scrub file")
+		}
+		s.record(fileID, outcome, n)
+	}
+
+	s.mu.Lock()
+	s.status.Running = false
+	s.status.FinishedAt = time.Now().UTC()
+	if runErr != nil {
+		s.status.LastError = runErr.Error()
+	}
+	status := s.status
+	s.mu.Unlock()
+
+	s.logger.Info().
+		Int64("scanned", status.Scanned).
+		Int64("corrupted", status.Corrupted).
+		Int64("failed", status.Failed).
+		Int64("bytesRead", status.BytesRead).
+		Msg("Integrity scrub completed")
+	return status, runErr
+}
+
+func (s *Scrubber) record(fileID string, outcome scrubOutcome, bytesRead int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastFileID = fileID
+	s.status.Scanned++
+	s.status.BytesRead += bytesRead
+	switch outcome {
+	case scrubSkipped:
+		s.status.Skipped++
+	case scrubCorrupted:
+		s.status.Corrupted++
+	case scrubFailed:
+		s.status.Failed++
+	}
+}
+
+// scrubFile verifies one file and returns how many bytes were read. Files
+// without a completed metadata record are skipped.
+func (s *Scrubber) scrubFile(ctx context.Context, fileID string, limiter *byteLimiter) (scrubOutcome, int64, error) {
+	metadata, err := s.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		if errors.Is(err, domain.ErrFileNotFound) {
+			return scrubSkipped, 0, nil
+		}
+		return scrubFailed, 0, fmt.Errorf("failed to retrieve checksum: %w", err)
+	}
+	if metadata.ProcessingStatus != string(file.StatusComplete) || metadata.Checksum == "" {
+		return scrubSkipped, 0, nil
+	}
+
+	reader, err := s.provider.Retrieve(ctx, fileID)
+	if err != nil {
+		switch {
+		case isIntegrityError(err):
+			// The provider verified the checksum before returning content
+			return s.quarantineFile(ctx, metadata, "", 0)
+		case isNotFound(err):
+			// Deleted since it was listed
+			return scrubSkipped, 0, nil
+		}
+		return scrubFailed, 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	n, err := limiter.copy(ctx, hasher, reader)
+	if err != nil {
+		if isIntegrityError(err) {
+			return s.quarantineFile(ctx, metadata, "", n)
+		}
+		return scrubFailed, n, fmt.Errorf("failed to read file: %w", err)
+	}
+	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
+	if checksum == metadata.Checksum {
+		return scrubClean, n, nil
+	}
+	return s.quarantineFile(ctx, metadata, checksum, n)
+}
+
+// quarantineFile records the corrupt file before moving it, so a failed move
+// still leaves the file marked CORRUPTED
+func (s *Scrubber) quarantineFile(ctx context.Context, metadata *domain.FileMetadataRecord, actualChecksum string, bytesRead int64) (scrubOutcome, int64, error) {
+	s.logger.Warn().
+		Str("fileId", metadata.ID).
+		Str("expectedChecksum", metadata.Checksum).
+		Str("actualChecksum", actualChecksum).
+		Msg("Checksum mismatch found by scrub")
+
+	finding := &domain.QuarantinedFile{
+		FileID:           metadata.ID,
+		ExpectedChecksum: metadata.Checksum,
+		ActualChecksum:   actualChecksum,
+		DetectedAt:       time.Now().UTC(),
+	}
+	if err := s.quarantine.QuarantineFile(ctx, finding); err != nil {
+		return scrubFailed, bytesRead, fmt.Errorf("failed to record corrupt file: %w", err)
+	}
+
+	quarantiner, ok := s.provider.(Quarantiner)
+	if !ok {
+		return scrubCorrupted, bytesRead, nil
+	}
+	path, err := quarantiner.Quarantine(ctx, metadata.ID)
+	if err != nil {
+		return scrubCorrupted, bytesRead, fmt.Errorf("failed to move file to quarantine: %w", err)
+	}
+	finding.QuarantinePath = path
+	if err := s.quarantine.QuarantineFile(ctx, finding); err != nil {
+		return scrubCorrupted, bytesRead, fmt.Errorf("failed to record quarantine path: %w", err)
+	}
+	return scrubCorrupted, bytesRead, nil
+}
+
+type scrubKey struct{}
+
+// isScrubRead reports whether ctx belongs to a scrub, whose reads are not
+// accesses by users
+func isScrubRead(ctx context.Context) bool {
+	_, ok := ctx.Value(scrubKey{}).(bool)
+	return ok
+}
+
+func isIntegrityError(err error) bool {
+	return errors.Is(err, ErrIntegrityCheckFailed)
+}
+
+// byteLimiter paces reads to an average rate over the whole scrub
+type byteLimiter struct {
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+}
+
+func newByteLimiter(bytesPerSecond int64) *byteLimiter {
+	return &byteLimiter{bytesPerSecond: bytesPerSecond, start: time.Now()}
+}
+
+// copy hashes src into dst, sleeping between reads to stay under the rate
+func (l *byteLimiter) copy(ctx context.Context, dst hash.Hash, src io.Reader) (int64, error) {
+	buf := make([]byte, scrubBufferSize)
+	var total int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			dst.Write(buf[:n])
+			total += int64(n)
+			if waitErr := l.wait(ctx, n); waitErr != nil {
+				return total, waitErr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func (l *byteLimiter) wait(ctx context.Context, n int) error {
+	if l.bytesPerSecond <= 0 {
+		return nil
+	}
+	l.read += int64(n)
+	due := l.start.Add(time.Duration(float64(l.read) / float64(l.bytesPerSecond) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
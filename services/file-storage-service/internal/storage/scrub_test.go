@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// quarantiningProvider moves quarantined files into a separate map
+type quarantiningProvider struct {
+	memoryProvider
+	quarantined map[string][]byte
+}
+
+func (q *quarantiningProvider) Quarantine(ctx context.Context, fileID string) (string, error) {
+	data, ok := q.files[fileID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	q.quarantined[fileID] = data
+	delete(q.files, fileID)
+	return "quarantine://" + fileID, nil
+}
+
+// memoryQuarantine keeps the latest finding per file
+type memoryQuarantine struct {
+	findings map[string]domain.QuarantinedFile
+}
+
+func (m *memoryQuarantine) QuarantineFile(ctx context.Context, finding *domain.QuarantinedFile) error {
+	m.findings[finding.FileID] = *finding
+	return nil
+}
+
+func (m *memoryQuarantine) ListQuarantinedFiles(ctx context.Context, limit int) ([]*domain.QuarantinedFile, error) {
+	var findings []*domain.QuarantinedFile
+	for _, finding := range m.findings {
+		findings = append(findings, &finding)
+	}
+	return findings, nil
+}
+
+func checksumOf(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func newTestScrubber(t *testing.T, provider Provider, records map[string]*domain.FileMetadataRecord) (*Scrubber, *memoryQuarantine) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			if record, ok := records[fileID]; ok {
+				return record, nil
+			}
+			return nil, domain.ErrFileNotFound
+		}).AnyTimes()
+
+	quarantine := &memoryQuarantine{findings: map[string]domain.QuarantinedFile{}}
+	scrubber, err := NewScrubber(provider, &ScrubConfig{PageSize: 2, QuarantineRepository: quarantine}, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewScrubber() error = %v", err)
+	}
+	return scrubber, quarantine
+}
+
+func TestScrubber_QuarantinesCorruptFiles(t *testing.T) {
+	provider := &quarantiningProvider{
+		memoryProvider: memoryProvider{files: map[string][]byte{
+			"file-a": []byte("intact"),
+			"file-b": []byte("bit rot"),
+			"file-c": []byte("orphan"),
+			"file-d": []byte("pending"),
+		}},
+		quarantined: map[string][]byte{},
+	}
+	complete := string(file.StatusComplete)
+	scrubber, quarantine := newTestScrubber(t, provider, map[string]*domain.FileMetadataRecord{
+		"file-a": {ID: "file-a", ProcessingStatus: complete, Checksum: checksumOf([]byte("intact"))},
+		"file-b": {ID: "file-b", ProcessingStatus: complete, Checksum: checksumOf([]byte("bit rat"))},
+		"file-d": {ID: "file-d", ProcessingStatus: string(file.StatusPending)},
+	})
+
+	status, err := scrubber.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if status.Running || status.Scanned != 4 || status.Corrupted != 1 || status.Skipped != 2 || status.Failed != 0 {
+		t.Errorf("Run() status = %+v", status)
+	}
+	if status.BytesRead != int64(len("intact")+len("bit rot")) || status.LastFileID != "file-d" {
+		t.Errorf("Run() status = %+v", status)
+	}
+	if got := scrubber.Status(); got != status {
+		t.Errorf("Status() = %+v, want %+v", got, status)
+	}
+
+	if _, ok := provider.files["file-a"]; !ok {
+		t.Error("intact file was quarantined")
+	}
+	if !bytes.Equal(provider.quarantined["file-b"], []byte("bit rot")) {
+		t.Error("corrupt file was not moved to quarantine")
+	}
+	finding, ok := quarantine.findings["file-b"]
+	if !ok || len(quarantine.findings) != 1 {
+		t.Fatalf("findings = %v, want only file-b", quarantine.findings)
+	}
+	if finding.ActualChecksum != checksumOf([]byte("bit rot")) || finding.QuarantinePath != "quarantine://file-b" {
+		t.Errorf("finding = %+v", finding)
+	}
+}
+
+// failingReadProvider fails to read the files in errs with their error
+type failingReadProvider struct {
+	quarantiningProvider
+	errs map[string]error
+}
+
+func (f *failingReadProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if err, ok := f.errs[fileID]; ok {
+		return nil, err
+	}
+	return f.quarantiningProvider.Retrieve(ctx, fileID)
+}
+
+func TestScrubber_QuarantinesOnIntegrityErrors(t *testing.T) {
+	provider := &failingReadProvider{
+		quarantiningProvider: quarantiningProvider{
+			memoryProvider: memoryProvider{files: map[string][]byte{
+				"file-a": []byte("verified by the provider"),
+				"file-b": []byte("unreadable"),
+			}},
+			quarantined: map[string][]byte{},
+		},
+		errs: map[string]error{
+			// As wrapped by a layer such as tiering or mirroring
+			"file-a": fmt.Errorf("hot: %w: checksums do not match", ErrIntegrityCheckFailed),
+			// Only the sentinel counts, not an error's text
+			"file-b": errors.New("failed to open file: file integrity check failed"),
+		},
+	}
+	complete := string(file.StatusComplete)
+	scrubber, quarantine := newTestScrubber(t, provider, map[string]*domain.FileMetadataRecord{
+		"file-a": {ID: "file-a", ProcessingStatus: complete, Checksum: checksumOf([]byte("intact"))},
+		"file-b": {ID: "file-b", ProcessingStatus: complete, Checksum: checksumOf([]byte("unreadable"))},
+	})
+
+	status, err := scrubber.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if status.Corrupted != 1 || status.Failed != 1 {
+		t.Errorf("Run() status = %+v, want 1 corrupted and 1 failed", status)
+	}
+	if _, ok := quarantine.findings["file-a"]; !ok || len(quarantine.findings) != 1 {
+		t.Errorf("findings = %v, want only file-a", quarantine.findings)
+	}
+	if _, ok := provider.files["file-b"]; !ok {
+		t.Error("file that failed to read was quarantined")
+	}
+}
+
+func TestScrubber_OneRunAtATime(t *testing.T) {
+	scrubber, _ := newTestScrubber(t, &memoryProvider{files: map[string][]byte{}}, nil)
+	if err := scrubber.begin(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scrubber.Run(context.Background()); !errors.Is(err, ErrScrubInProgress) {
+		t.Errorf("Run() during a scrub error = %v, want ErrScrubInProgress", err)
+	}
+	if err := scrubber.Start(context.Background()); !errors.Is(err, ErrScrubInProgress) {
+		t.Errorf("Start() during a scrub error = %v, want ErrScrubInProgress", err)
+	}
+}
+
+func TestByteLimiter_PacesReads(t *testing.T) {
+	limiter := newByteLimiter(1 << 20)
+	content := make([]byte, 100*1024)
+
+	start := time.Now()
+	n, err := limiter.copy(context.Background(), sha256.New(), bytes.NewReader(content))
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("copy() = %d, %v", n, err)
+	}
+	// 100KiB at 1MiB/s takes about 98ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("copy() took %v, want at least 90ms", elapsed)
+	}
+}
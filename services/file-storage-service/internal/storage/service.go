@@ -4,14 +4,17 @@ import (
 	"context"
 	"io"
 
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 )
 
 type StorageService interface {
 	Store(ctx context.Context, fileID string, content io.Reader) (string, error)
 	Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error)
+	RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error)
 	Delete(ctx context.Context, fileID string) error
-	List(ctx context.Context) ([]string, error)
+	List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error)
 }
 
 type StorageServiceImpl struct {
@@ -33,8 +36,8 @@ func (s *StorageServiceImpl) Store(ctx context.Context, fileID string, content i
 	return s.provider.Store(ctx, fileID, content)
 }
 
-func (s *StorageServiceImpl) List(ctx context.Context) ([]string, error) {
-	return s.provider.List(ctx)
+func (s *StorageServiceImpl) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	return s.provider.List(ctx, opts)
 }
 
 func (s *StorageServiceImpl) Delete(ctx context.Context, fileID string) error {
@@ -45,4 +48,12 @@ func (s *StorageServiceImpl) Retrieve(ctx context.Context, fileID string) (io.Re
 	return s.provider.Retrieve(ctx, fileID)
 }
 
+func (s *StorageServiceImpl) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	return s.provider.RetrieveRange(ctx, fileID, offset, length)
+}
+
+func (s *StorageServiceImpl) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	return s.provider.Stat(ctx, fileID)
+}
+
 var _ StorageService = &StorageServiceImpl{}
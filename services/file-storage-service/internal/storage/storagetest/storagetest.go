@@ -0,0 +1,403 @@
+// Package storagetest checks that a storage provider behaves like the others:
+// how it reports missing files, duplicate stores and unusable file IDs, how it
+// reads ranges and pages listings, and that it is safe for concurrent use.
+// Providers run the suite from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.TestProvider(t, func(t *testing.T) storagetest.Provider {
+//			return newTestProvider(t)
+//		})
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/token"
+	"go.uber.org/mock/gomock"
+)
+
+// Provider restates storage.Provider, which the suite cannot import: the
+// storage package imports the providers whose tests run it
+type Provider interface {
+	Store(ctx context.Context, fileID string, content io.Reader) (string, error)
+	Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error)
+	RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error)
+	Delete(ctx context.Context, fileID string) error
+	List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error)
+}
+
+// invalidFileIDs are rejected by every provider
+var invalidFileIDs = []string{"", "./file-a", "file-a/", "dir/../file-a"}
+
+// FileID pads name, which must only use the characters of generated file IDs,
+// to the shape of one, as some providers accept nothing else. The name stays
+// the ID's prefix, so IDs sort and list by prefix like their names.
+func FileID(name string) string {
+	return name + strings.Repeat("A", token.FileIDLength-len(name)-1) + "="
+}
+
+// concurrency is the number of goroutines in the concurrent tests
+const concurrency = 8
+
+// TestProvider runs the suite against providers made by newProvider, which
+// must return a new, empty provider on every call
+func TestProvider(t *testing.T, newProvider func(t *testing.T) Provider) {
+	t.Run("StoreAndRetrieve", func(t *testing.T) { testStoreAndRetrieve(t, newProvider(t)) })
+	t.Run("DuplicateStore", func(t *testing.T) { testDuplicateStore(t, newProvider(t)) })
+	t.Run("MissingFile", func(t *testing.T) { testMissingFile(t, newProvider(t)) })
+	t.Run("InvalidFileID", func(t *testing.T) { testInvalidFileID(t, newProvider(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newProvider(t)) })
+	t.Run("RetrieveRange", func(t *testing.T) { testRetrieveRange(t, newProvider(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newProvider(t)) })
+	t.Run("ConcurrentAccess", func(t *testing.T) { testConcurrentAccess(t, newProvider(t)) })
+	t.Run("ConcurrentDuplicateStore", func(t *testing.T) { testConcurrentDuplicateStore(t, newProvider(t)) })
+}
+
+// NewMetadataService returns a metadata service that keeps the records
+// providers update, validating them as the repositories do, and has a record
+// ready for any other file ID, as the upload service creates one before
+// storing a file
+func NewMetadataService(t *testing.T) *metadataService.MockMetadataService {
+	var mu sync.Mutex
+	records := make(map[string]domain.FileMetadataRecord)
+
+	mock := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mock.EXPECT().BeginTx(gomock.Any()).DoAndReturn(func(ctx context.Context) (context.Context, error) {
+		return ctx, nil
+	}).AnyTimes()
+	mock.EXPECT().CommitTx(gomock.Any()).Return(nil).AnyTimes()
+	mock.EXPECT().RollbackTx(gomock.Any()).Return(nil).AnyTimes()
+	mock.EXPECT().UpdateFileMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string, record *domain.FileMetadataRecord) error {
+			// Rejects what the repositories reject
+			if err := record.Validate(); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			records[fileID] = *record
+			return nil
+		}).AnyTimes()
+	mock.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			record, ok := records[fileID]
+			if !ok {
+				record = domain.FileMetadataRecord{
+					ID:               fileID,
+					Metadata:         &sharedv1.FileMetadata{FileId: fileID},
+					ProcessingStatus: "PENDING",
+				}
+			}
+			return &record, nil
+		}).AnyTimes()
+	return mock
+}
+
+func store(t *testing.T, p Provider, fileID, content string) {
+	t.Helper()
+	if _, err := p.Store(context.Background(), fileID, strings.NewReader(content)); err != nil {
+		t.Fatalf("Store(%s) error = %v", fileID, err)
+	}
+}
+
+// read returns the whole content of fileID
+func read(t *testing.T, p Provider, fileID string) string {
+	t.Helper()
+	reader, err := p.Retrieve(context.Background(), fileID)
+	if err != nil {
+		t.Fatalf("Retrieve(%s) error = %v", fileID, err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading %s: %v", fileID, err)
+	}
+	return string(content)
+}
+
+func testStoreAndRetrieve(t *testing.T, p Provider) {
+	ctx := context.Background()
+	content := "id,name\n1,alice\n2,bob\n"
+
+	storagePath, err := p.Store(ctx, FileID("file-a"), strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if storagePath == "" {
+		t.Error("Store() returned an empty storage path")
+	}
+	if got := read(t, p, FileID("file-a")); got != content {
+		t.Errorf("Retrieve() = %q, want %q", got, content)
+	}
+
+	info, err := p.Stat(ctx, FileID("file-a"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.FileID != FileID("file-a") || info.Size != int64(len(content)) {
+		t.Errorf("Stat() = %+v, want file-a of %d bytes", info, len(content))
+	}
+	if checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(content))); info.Checksum != "" && info.Checksum != checksum {
+		t.Errorf("Stat() checksum = %s, want the SHA-256 %s", info.Checksum, checksum)
+	}
+
+	// Files of any size, empty ones included, round-trip
+	large := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	if _, err := p.Store(ctx, FileID("file-large"), bytes.NewReader(large)); err != nil {
+		t.Fatalf("Store() of a large file error = %v", err)
+	}
+	if got := read(t, p, FileID("file-large")); got != string(large) {
+		t.Errorf("Retrieve() of a large file returned %d bytes, want %d", len(got), len(large))
+	}
+	store(t, p, FileID("file-empty"), "")
+	if got := read(t, p, FileID("file-empty")); got != "" {
+		t.Errorf("Retrieve() of an empty file = %q", got)
+	}
+}
+
+func testDuplicateStore(t *testing.T, p Provider) {
+	store(t, p, FileID("file-a"), "original")
+
+	_, err := p.Store(context.Background(), FileID("file-a"), strings.NewReader("replacement"))
+	if !errors.Is(err, file.ErrFileAlreadyExists) {
+		t.Errorf("Store() of an existing file error = %v, want ErrFileAlreadyExists", err)
+	}
+	if got := read(t, p, FileID("file-a")); got != "original" {
+		t.Errorf("existing file = %q after a duplicate Store, want it untouched", got)
+	}
+}
+
+func testMissingFile(t *testing.T, p Provider) {
+	ctx := context.Background()
+	store(t, p, FileID("file-a"), "content")
+
+	for name, call := range map[string]func(fileID string) error{
+		"Retrieve": func(fileID string) error {
+			_, err := p.Retrieve(ctx, fileID)
+			return err
+		},
+		"RetrieveRange": func(fileID string) error {
+			_, err := p.RetrieveRange(ctx, fileID, 0, -1)
+			return err
+		},
+		"Stat": func(fileID string) error {
+			_, err := p.Stat(ctx, fileID)
+			return err
+		},
+		"Delete": func(fileID string) error {
+			return p.Delete(ctx, fileID)
+		},
+	} {
+		if err := call(FileID("file-missing")); !errors.Is(err, file.ErrFileNotFound) {
+			t.Errorf("%s() of a missing file error = %v, want ErrFileNotFound", name, err)
+		}
+	}
+}
+
+func testInvalidFileID(t *testing.T, p Provider) {
+	ctx := context.Background()
+	for _, fileID := range invalidFileIDs {
+		if _, err := p.Store(ctx, fileID, strings.NewReader("content")); !errors.Is(err, file.ErrInvalidFileID) {
+			t.Errorf("Store(%q) error = %v, want ErrInvalidFileID", fileID, err)
+		}
+		if _, err := p.Retrieve(ctx, fileID); !errors.Is(err, file.ErrInvalidFileID) {
+			t.Errorf("Retrieve(%q) error = %v, want ErrInvalidFileID", fileID, err)
+		}
+		if _, err := p.RetrieveRange(ctx, fileID, 0, -1); !errors.Is(err, file.ErrInvalidFileID) {
+			t.Errorf("RetrieveRange(%q) error = %v, want ErrInvalidFileID", fileID, err)
+		}
+		if _, err := p.Stat(ctx, fileID); !errors.Is(err, file.ErrInvalidFileID) {
+			t.Errorf("Stat(%q) error = %v, want ErrInvalidFileID", fileID, err)
+		}
+		if err := p.Delete(ctx, fileID); !errors.Is(err, file.ErrInvalidFileID) {
+			t.Errorf("Delete(%q) error = %v, want ErrInvalidFileID", fileID, err)
+		}
+	}
+}
+
+func testDelete(t *testing.T, p Provider) {
+	ctx := context.Background()
+	store(t, p, FileID("file-a"), "content")
+	store(t, p, FileID("file-b"), "content")
+
+	if err := p.Delete(ctx, FileID("file-a")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := p.Retrieve(ctx, FileID("file-a")); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Retrieve() after Delete() error = %v, want ErrFileNotFound", err)
+	}
+	if _, err := p.Stat(ctx, FileID("file-a")); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Stat() after Delete() error = %v, want ErrFileNotFound", err)
+	}
+	if err := p.Delete(ctx, FileID("file-a")); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrFileNotFound", err)
+	}
+	if got := read(t, p, FileID("file-b")); got != "content" {
+		t.Errorf("other file = %q after Delete(), want it untouched", got)
+	}
+
+	// A deleted file ID can be stored again
+	store(t, p, FileID("file-a"), "new content")
+	if got := read(t, p, FileID("file-a")); got != "new content" {
+		t.Errorf("Retrieve() after storing again = %q, want %q", got, "new content")
+	}
+}
+
+func testRetrieveRange(t *testing.T, p Provider) {
+	ctx := context.Background()
+	store(t, p, FileID("file-a"), "0123456789")
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 4, "0123"},
+		{2, 3, "234"},
+		{7, -1, "789"},
+		{8, 100, "89"},
+	}
+	for _, tt := range tests {
+		reader, err := p.RetrieveRange(ctx, FileID("file-a"), tt.offset, tt.length)
+		if err != nil {
+			t.Errorf("RetrieveRange(%d, %d) error = %v", tt.offset, tt.length, err)
+			continue
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(got) != tt.want {
+			t.Errorf("RetrieveRange(%d, %d) = %q, %v; want %q", tt.offset, tt.length, got, err, tt.want)
+		}
+	}
+
+	for _, offset := range []int64{-1, 11} {
+		reader, err := p.RetrieveRange(ctx, FileID("file-a"), offset, 1)
+		if err == nil {
+			reader.Close()
+		}
+		if !errors.Is(err, file.ErrInvalidRange) {
+			t.Errorf("RetrieveRange(%d, 1) error = %v, want ErrInvalidRange", offset, err)
+		}
+	}
+}
+
+func testList(t *testing.T, p Provider) {
+	ctx := context.Background()
+	page, err := p.List(ctx, file.ListOptions{})
+	if err != nil || len(page.FileIDs) != 0 || page.NextContinuationToken != "" {
+		t.Fatalf("List() of an empty provider = %+v, %v; want an empty last page", page, err)
+	}
+
+	for _, fileID := range []string{FileID("list-c"), FileID("other-a"), FileID("list-a"), FileID("list-b")} {
+		store(t, p, fileID, fileID)
+	}
+
+	var got []string
+	opts := file.ListOptions{Prefix: "list-", PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("List() did not finish after %d pages: %v", pages, got)
+		}
+		page, err := p.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(page.FileIDs) > opts.PageSize {
+			t.Errorf("List() returned %d file IDs, want at most %d", len(page.FileIDs), opts.PageSize)
+		}
+		got = append(got, page.FileIDs...)
+		if page.NextContinuationToken == "" {
+			break
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+	if want := []string{FileID("list-a"), FileID("list-b"), FileID("list-c")}; !slices.Equal(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, p Provider) {
+	ctx := context.Background()
+	errs := make(chan error, 2*concurrency)
+	var wg sync.WaitGroup
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fileID := FileID(fmt.Sprintf("file-%d", i))
+			content := strings.Repeat(fileID, 1024)
+			if _, err := p.Store(ctx, fileID, strings.NewReader(content)); err != nil {
+				errs <- fmt.Errorf("Store(%s): %w", fileID, err)
+				return
+			}
+			reader, err := p.Retrieve(ctx, fileID)
+			if err != nil {
+				errs <- fmt.Errorf("Retrieve(%s): %w", fileID, err)
+				return
+			}
+			defer reader.Close()
+			got, err := io.ReadAll(reader)
+			if err != nil || string(got) != content {
+				errs <- fmt.Errorf("Retrieve(%s) returned %d bytes, %v; want %d bytes", fileID, len(got), err, len(content))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	page, err := p.List(ctx, file.ListOptions{Prefix: "file-"})
+	if err != nil || len(page.FileIDs) != concurrency {
+		t.Errorf("List() after concurrent stores = %v, %v; want %d files", page, err, concurrency)
+	}
+}
+
+func testConcurrentDuplicateStore(t *testing.T, p Provider) {
+	ctx := context.Background()
+	results := make([]error, concurrency)
+	var wg sync.WaitGroup
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, results[i] = p.Store(ctx, FileID("file-a"), strings.NewReader(fmt.Sprintf("writer %d", i)))
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range results {
+		switch {
+		case err == nil && winner >= 0:
+			t.Errorf("writers %d and %d both stored file-a", winner, i)
+		case err == nil:
+			winner = i
+		case !errors.Is(err, file.ErrFileAlreadyExists):
+			t.Errorf("writer %d error = %v, want ErrFileAlreadyExists", i, err)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("no writer stored file-a")
+	}
+	if got, want := read(t, p, FileID("file-a")), fmt.Sprintf("writer %d", winner); got != want {
+		t.Errorf("file-a = %q, want the content of the writer that succeeded, %q", got, want)
+	}
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const DefaultAccessResolution = time.Hour
+
+// Tier is one storage class behind a TieredProvider, e.g. a fast local disk
+type Tier struct {
+	Name     string
+	Provider Provider
+}
+
+// TierPolicy moves files into Tier once they are older than MinAge and have
+// not been read for MinIdle. A zero duration does not restrict the move.
+type TierPolicy struct {
+	Tier    string
+	MinAge  time.Duration
+	MinIdle time.Duration
+}
+
+// TieringConfig configures TieredProvider. Tiers are ordered hottest first;
+// new files are stored in the first one.
+type TieringConfig struct {
+	Tiers          []Tier
+	Policies       []TierPolicy
+	TierRepository metadataService.TierRepository
+	// AccessResolution is how stale the recorded last access may get before a
+	// read updates it, bounding the writes reads cause
+	AccessResolution time.Duration
+}
+
+// TieredProvider stores files in the hottest tier and lets lifecycle
+// policies move them to colder ones. The tier holding a file is recorded on
+// its metadata, so reads are routed there regardless of where it lives.
+type TieredProvider struct {
+	tiers            []Tier
+	policies         []tierPolicy
+	tierRepository   metadataService.TierRepository
+	accessResolution time.Duration
+	metadataService  metadataService.MetadataService
+	logger           *logger.Logger
+	now              func() time.Time
+}
+
+// tierPolicy is a TierPolicy resolved to the index of its tier
+type tierPolicy struct {
+	TierPolicy
+	target int
+}
+
+func NewTieredProvider(cfg *TieringConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*TieredProvider, error) {
+	if len(cfg.Tiers) < 2 {
+		return nil, errors.New("tiering needs at least two tiers")
+	}
+	index := make(map[string]int, len(cfg.Tiers))
+	for i, tier := range cfg.Tiers {
+		if _, ok := index[tier.Name]; ok || tier.Name == "" {
+			return nil, fmt.Errorf("tier names must be unique and non-empty: %q", tier.Name)
+		}
+		index[tier.Name] = i
+	}
+
+	policies := make([]tierPolicy, 0, len(cfg.Policies))
+	for _, policy := range cfg.Policies {
+		target, ok := index[policy.Tier]
+		if !ok || target == 0 {
+			return nil, fmt.Errorf("policy tier %q must be a configured tier other than the first", policy.Tier)
+		}
+		if policy.MinAge < 0 || policy.MinIdle < 0 {
+			return nil, fmt.Errorf("policy for tier %q has a negative duration", policy.Tier)
+		}
+		policies = append(policies, tierPolicy{TierPolicy: policy, target: target})
+	}
+	// Apply the coldest policy first, so files due for it skip the tiers between
+	sort.SliceStable(policies, func(i, j int) bool { return policies[i].target > policies[j].target })
+
+	accessResolution := cfg.AccessResolution
+	if accessResolution <= 0 {
+		accessResolution = DefaultAccessResolution
+	}
+	return &TieredProvider{
+		tiers:            cfg.Tiers,
+		policies:         policies,
+		tierRepository:   cfg.TierRepository,
+		accessResolution: accessResolution,
+		metadataService:  metadataService,
+		logger:           logger,
+		now:              time.Now,
+	}, nil
+}
+
+// Store writes new files to the hottest tier
+func (t *TieredProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	hot := t.tiers[0]
+	storagePath, err := hot.Provider.Store(ctx, fileID, content)
+	if err != nil {
+		return "", err
+	}
+
+	err = func() error {
+		metadata, err := t.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve metadata: %w", err)
+		}
+		metadata.Tier = hot.Name
+		if err := t.metadataService.UpdateFileMetadata(ctx, fileID, metadata); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		if delErr := hot.Provider.Delete(ctx, fileID); delErr != nil {
+			t.logger.Error().Err(delErr).Str("fileId", fileID).Msg("Failed to remove file after metadata failure")
+		}
+		return "", err
+	}
+	return storagePath, nil
+}
+
+func (t *TieredProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	return t.read(ctx, fileID, func(p Provider) (io.ReadCloser, error) {
+		return p.Retrieve(ctx, fileID)
+	})
+}
+
+func (t *TieredProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	return t.read(ctx, fileID, func(p Provider) (io.ReadCloser, error) {
+		return p.RetrieveRange(ctx, fileID, offset, length)
+	})
+}
+
+// read opens the file in the tier its metadata names. A file not found there
+// may have just been moved, so the other tiers are tried before giving up.
+func (t *TieredProvider) read(ctx context.Context, fileID string, open func(Provider) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	metadata, err := t.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+	current := t.tierIndex(metadata.Tier)
+	if current < 0 {
+		return nil, fmt.Errorf("file %s is in unknown storage tier %q", fileID, metadata.Tier)
+	}
+
+	content, err := open(t.tiers[current].Provider)
+	for i := 0; err != nil && isNotFound(err) && i < len(t.tiers); i++ {
+		if i != current {
+			content, err = open(t.tiers[i].Provider)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.touch(ctx, metadata)
+	return content, nil
+}
+
+// Stat describes the file in the tier holding it and reports the tier as its
+// storage class. Stat does not count as an access.
+func (t *TieredProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	var current int
+	metadata, err := t.metadataService.RetrieveFileMetadataByID(ctx, fileID)
+	switch {
+	case err == nil:
+		if current = t.tierIndex(metadata.Tier); current < 0 {
+			return nil, fmt.Errorf("file %s is in unknown storage tier %q", fileID, metadata.Tier)
+		}
+	case !errors.Is(err, domain.ErrFileNotFound):
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+
+	tier := current
+	info, err := t.tiers[current].Provider.Stat(ctx, fileID)
+	for i := 0; err != nil && isNotFound(err) && i < len(t.tiers); i++ {
+		if i != current {
+			tier = i
+			info, err = t.tiers[i].Provider.Stat(ctx, fileID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.StorageClass = t.tiers[tier].Name
+	return info, nil
+}
+
+// touch records the read if the recorded last access is older than the
+// access resolution. Scrub reads are not recorded.
+func (t *TieredProvider) touch(ctx context.Context, metadata *domain.FileMetadataRecord) {
+	if isScrubRead(ctx) {
+		return
+	}
+	now := t.now()
+	if now.Sub(metadata.LastAccessedAt) < t.accessResolution {
+		return
+	}
+	if err := t.tierRepository.TouchFile(ctx, metadata.ID, now); err != nil {
+		t.logger.Warn().Err(err).Str("fileId", metadata.ID).Msg("Failed to record file access")
+	}
+}
+
+// tierIndex resolves a recorded tier name; empty names the hottest tier
+func (t *TieredProvider) tierIndex(name string) int {
+	if name == "" {
+		return 0
+	}
+	for i, tier := range t.tiers {
+		if tier.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Delete removes the file from every tier holding it
+func (t *TieredProvider) Delete(ctx context.Context, fileID string) error {
+	var found bool
+	var errs []error
+	for _, tier := range t.tiers {
+		err := tier.Provider.Delete(ctx, fileID)
+		switch {
+		case err == nil:
+			found = true
+		case isNotFound(err):
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", tier.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to delete %s: %w", fileID, errors.Join(errs...))
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	return nil
+}
+
+// List returns a page of the files stored in any tier
+func (t *TieredProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	pages := make([]*file.ListPage, 0, len(t.tiers))
+	for _, tier := range t.tiers {
+		page, err := tier.Provider.List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tier %s: %w", tier.Name, err)
+		}
+		pages = append(pages, page)
+	}
+	return mergePages(pages, opts.Limit(), t.ListKey), nil
+}
+
+// ListKey returns the list key of the first tier; tiers share a layout
+func (t *TieredProvider) ListKey(fileID string) string {
+	return listKey(t.tiers[0].Provider)(fileID)
+}
+
+// SweepTempFiles sweeps every tier that stages writes in temp files
+func (t *TieredProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	var removed []string
+	var errs []error
+	for _, tier := range t.tiers {
+		sweeper, ok := tier.Provider.(TempFileSweeper)
+		if !ok {
+			continue
+		}
+		paths, err := sweeper.SweepTempFiles(ctx)
+		removed = append(removed, paths...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tier.Name, err))
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Quarantine moves the file aside in every tier holding it and returns where
+// it now lives
+func (t *TieredProvider) Quarantine(ctx context.Context, fileID string) (string, error) {
+	var path string
+	var errs []error
+	for _, tier := range t.tiers {
+		quarantiner, ok := tier.Provider.(Quarantiner)
+		if !ok {
+			continue
+		}
+		tierPath, err := quarantiner.Quarantine(ctx, fileID)
+		switch {
+		case err == nil:
+			path = tierPath
+		case isNotFound(err):
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", tier.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("failed to quarantine %s: %w", fileID, errors.Join(errs...))
+	}
+	if path == "" {
+		return "", fmt.Errorf("%w: %s", file.ErrFileNotFound, fileID)
+	}
+	return path, nil
+}
+
+// TieringResult summarises a lifecycle pass
+type TieringResult struct {
+	Moved  int
+	Failed int
+}
+
+// ApplyPolicies moves every file due under the lifecycle policies to its
+// tier, listing candidates batchSize at a time
+func (t *TieredProvider) ApplyPolicies(ctx context.Context, batchSize int) (*TieringResult, error) {
+	result := &TieringResult{}
+	now := t.now()
+	for _, policy := range t.policies {
+		// Files move towards colder tiers only
+		sources := []string{""}
+		for _, tier := range t.tiers[:policy.target] {
+			sources = append(sources, tier.Name)
+		}
+
+		var after string
+		for {
+			candidates, err := t.tierRepository.ListTierCandidates(ctx, sources, now.Add(-policy.MinAge), now.Add(-policy.MinIdle), after, batchSize)
+			if err != nil {
+				return result, err
+			}
+			for _, record := range candidates {
+				if err := ctx.Err(); err != nil {
+					return result, err
+				}
+				if err := t.move(ctx, record, policy.target); err != nil {
+					t.logger.Error().Err(err).Str("fileId", record.ID).Str("tier", policy.Tier).Msg("Failed to move file between tiers")
+					result.Failed++
+					continue
+				}
+				result.Moved++
+			}
+			if len(candidates) < batchSize {
+				break
+			}
+			after = candidates[len(candidates)-1].ID
+		}
+	}
+	return result, nil
+}
+
+// move copies the file into the target tier, switches its recorded tier and
+// only then removes the old copy, so it stays readable throughout
+func (t *TieredProvider) move(ctx context.Context, record *domain.FileMetadataRecord, target int) error {
+	source := t.tierIndex(record.Tier)
+	if source < 0 {
+		return fmt.Errorf("unknown storage tier %q", record.Tier)
+	}
+	from, to := t.tiers[source], t.tiers[target]
+
+	// Drop a copy left by an interrupted move, so the store does not collide
+	if err := to.Provider.Delete(ctx, record.ID); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to clear target tier: %w", err)
+	}
+
+	content, err := from.Provider.Retrieve(ctx, record.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read from tier %s: %w", from.Name, err)
+	}
+	_, err = to.Provider.Store(ctx, record.ID, content)
+	content.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write to tier %s: %w", to.Name, err)
+	}
+
+	if err := t.tierRepository.MoveTier(ctx, record.ID, record.Tier, to.Name); err != nil {
+		if delErr := to.Provider.Delete(ctx, record.ID); delErr != nil {
+			t.logger.Error().Err(delErr).Str("fileId", record.ID).Msg("Failed to remove copy after tier update failure")
+		}
+		return err
+	}
+	if err := from.Provider.Delete(ctx, record.ID); err != nil {
+		t.logger.Warn().Err(err).Str("fileId", record.ID).Str("tier", from.Name).Msg("Failed to remove file from previous tier")
+	}
+
+	t.logger.Info().Str("fileId", record.ID).Str("from", from.Name).Str("to", to.Name).Msg("File moved between tiers")
+	return nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// memoryTiers applies tier queries to the records kept by the test metadata service
+type memoryTiers struct {
+	records map[string]*domain.FileMetadataRecord
+}
+
+func (m *memoryTiers) ListTierCandidates(ctx context.Context, tiers []string, createdBefore, accessedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	var found []*domain.FileMetadataRecord
+	for id, record := range m.records {
+		lastAccess := record.LastAccessedAt
+		if lastAccess.IsZero() {
+			lastAccess = record.CreatedAt
+		}
+		if slices.Contains(tiers, record.Tier) && !record.CreatedAt.After(createdBefore) && !lastAccess.After(accessedBefore) && id > afterFileID {
+			copied := *record
+			found = append(found, &copied)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (m *memoryTiers) MoveTier(ctx context.Context, fileID, fromTier, toTier string) error {
+	m.records[fileID].Tier = toTier
+	return nil
+}
+
+func (m *memoryTiers) TouchFile(ctx context.Context, fileID string, accessedAt time.Time) error {
+	m.records[fileID].LastAccessedAt = accessedAt
+	return nil
+}
+
+func newTestTieredProvider(t *testing.T, policies ...TierPolicy) (*TieredProvider, *memoryProvider, *memoryProvider, map[string]*domain.FileMetadataRecord) {
+	t.Helper()
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	records := map[string]*domain.FileMetadataRecord{}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string) (*domain.FileMetadataRecord, error) {
+			if record, ok := records[fileID]; ok {
+				copied := *record
+				return &copied, nil
+			}
+			return &domain.FileMetadataRecord{ID: fileID}, nil
+		}).AnyTimes()
+	mockMetadata.EXPECT().UpdateFileMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileID string, record *domain.FileMetadataRecord) error {
+			records[fileID] = record
+			return nil
+		}).AnyTimes()
+
+	hot := &memoryProvider{files: map[string][]byte{}}
+	cold := &memoryProvider{files: map[string][]byte{}}
+	p, err := NewTieredProvider(&TieringConfig{
+		Tiers:          []Tier{{Name: "hot", Provider: hot}, {Name: "cold", Provider: cold}},
+		Policies:       policies,
+		TierRepository: &memoryTiers{records: records},
+	}, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewTieredProvider() error = %v", err)
+	}
+	return p, hot, cold, records
+}
+
+func TestTieredProvider_MovesIdleFilesToColdTier(t *testing.T) {
+	p, hot, cold, records := newTestTieredProvider(t, TierPolicy{Tier: "cold", MinAge: 7 * 24 * time.Hour, MinIdle: 72 * time.Hour})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return start }
+
+	for _, fileID := range []string{"file-a", "file-b", "file-c"} {
+		if _, err := p.Store(context.Background(), fileID, strings.NewReader("content of "+fileID)); err != nil {
+			t.Fatalf("Store(%s) error = %v", fileID, err)
+		}
+		records[fileID].CreatedAt = start
+		records[fileID].ProcessingStatus = "COMPLETE"
+		if records[fileID].Tier != "hot" {
+			t.Errorf("%s tier = %q, want hot", fileID, records[fileID].Tier)
+		}
+	}
+	// file-c predates tiering, so it has no tier recorded
+	records["file-c"].Tier = ""
+
+	// file-b is read a week in, keeping it hot; the others have gone idle
+	p.now = func() time.Time { return start.Add(7 * 24 * time.Hour) }
+	retrieveAll(t, p, "file-b")
+	p.now = func() time.Time { return start.Add(8 * 24 * time.Hour) }
+
+	result, err := p.ApplyPolicies(context.Background(), 1)
+	if err != nil || result.Moved != 2 || result.Failed != 0 {
+		t.Fatalf("ApplyPolicies() = %+v, %v; want 2 moved", result, err)
+	}
+	for fileID, tier := range map[string]string{"file-a": "cold", "file-b": "hot", "file-c": "cold"} {
+		if records[fileID].Tier != tier {
+			t.Errorf("%s tier = %q, want %s", fileID, records[fileID].Tier, tier)
+		}
+		_, inHot := hot.files[fileID]
+		_, inCold := cold.files[fileID]
+		if inHot != (tier == "hot") || inCold != (tier == "cold") {
+			t.Errorf("%s in hot = %v, in cold = %v, want only in %s", fileID, inHot, inCold, tier)
+		}
+		if got := retrieveAll(t, p, fileID); string(got) != "content of "+fileID {
+			t.Errorf("Retrieve(%s) = %q", fileID, got)
+		}
+	}
+}
+
+func TestTieredProvider_RetrieveFallsBackDuringMove(t *testing.T) {
+	p, hot, cold, records := newTestTieredProvider(t)
+	if _, err := p.Store(context.Background(), "file-a", strings.NewReader("content")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	// The record still names the hot tier while the file has already moved
+	cold.files["file-a"] = hot.files["file-a"]
+	delete(hot.files, "file-a")
+
+	if got := retrieveRange(t, p, "file-a", 3, -1); string(got) != "tent" {
+		t.Errorf("RetrieveRange() = %q", got)
+	}
+	if records["file-a"].LastAccessedAt.IsZero() {
+		t.Error("read did not record the access")
+	}
+	if info, err := p.Stat(context.Background(), "file-a"); err != nil || info.StorageClass != "cold" || info.Size != 7 {
+		t.Errorf("Stat() = %+v, %v; want 7 bytes in cold", info, err)
+	}
+}
+
+func TestNewTieredProvider_RejectsPolicyForHotTier(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	tiers := []Tier{{Name: "hot", Provider: &memoryProvider{}}, {Name: "cold", Provider: &memoryProvider{}}}
+	for _, policy := range []TierPolicy{{Tier: "hot"}, {Tier: "glacier"}} {
+		if _, err := NewTieredProvider(&TieringConfig{Tiers: tiers, Policies: []TierPolicy{policy}}, nil, &testLogger); err == nil {
+			t.Errorf("NewTieredProvider() with policy for %q error = nil", policy.Tier)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultTrashGracePeriod = 30 * 24 * time.Hour
+	defaultTrashBatchSize   = 100
+)
+
+// ErrPurgeInProgress is returned when a trash purge is started while one is
+// running
+var ErrPurgeInProgress = errors.New("trash purge already in progress")
+
+// TrashConfig configures a TrashPurger
+type TrashConfig struct {
+	// GracePeriod is how long deleted files stay in the trash
+	GracePeriod time.Duration
+	// BatchSize is how many expired files are looked up at a time
+	BatchSize       int
+	TrashRepository metadataService.TrashRepository
+}
+
+// PurgeResult reports the outcome of a trash purge. Files under retention
+// or legal hold stay in the trash.
+type PurgeResult struct {
+	Purged   int      `json:"purged"`
+	Retained []string `json:"retained"`
+	Failed   []string `json:"failed"`
+}
+
+// TrashPurger permanently deletes the files that have been in the trash for
+// longer than the grace period, their content along with their metadata
+type TrashPurger struct {
+	provider        Provider
+	trash           metadataService.TrashRepository
+	metadataService metadataService.MetadataService
+	gracePeriod     time.Duration
+	batchSize       int
+	logger          *logger.Logger
+	now             func() time.Time
+	running         sync.Mutex
+}
+
+func NewTrashPurger(provider Provider, cfg *TrashConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*TrashPurger, error) {
+	if cfg.TrashRepository == nil {
+		return nil, errors.New("trash purger needs a trash repository")
+	}
+	if cfg.GracePeriod < 0 {
+		return nil, fmt.Errorf("trash grace period cannot be negative: %s", cfg.GracePeriod)
+	}
+	gracePeriod := cfg.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultTrashGracePeriod
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultTrashBatchSize
+	}
+	return &TrashPurger{
+		provider:        provider,
+		trash:           cfg.TrashRepository,
+		metadataService: metadataService,
+		gracePeriod:     gracePeriod,
+		batchSize:       batchSize,
+		logger:          logger,
+		now:             time.Now,
+	}, nil
+}
+
+// Purge deletes the files whose grace period is over. Only one purge may be
+// in progress at a time.
+func (p *TrashPurger) Purge(ctx context.Context) (*PurgeResult, error) {
+	if !p.running.TryLock() {
+		return nil, ErrPurgeInProgress
+	}
+	defer p.running.Unlock()
+
+	result := &PurgeResult{Retained: []string{}, Failed: []string{}}
+	deletedBefore := p.now().Add(-p.gracePeriod)
+	var after string
+	for {
+		records, err := p.trash.ListExpiredTrash(ctx, deletedBefore, after, p.batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		for _, record := range records {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			after = record.ID
+			if record.Metadata == nil {
+				p.logger.Error().Str("fileId", record.ID).Msg("Trashed file has no metadata to purge it by")
+				result.Failed = append(result.Failed, record.ID)
+				continue
+			}
+			// As with deletes through the API, the metadata goes first so a
+			// failure leaves an orphaned file for the reconciler
+			err := p.metadataService.DeleteFileMetadata(ctx, record.Metadata.UserId, record.ID)
+			if status.Code(err) == codes.FailedPrecondition {
+				result.Retained = append(result.Retained, record.ID)
+				continue
+			}
+			if err != nil {
+				p.logger.Error().Err(err).Str("fileId", record.ID).Msg("Failed to purge file metadata from trash")
+				result.Failed = append(result.Failed, record.ID)
+				continue
+			}
+			if err := p.provider.Delete(ctx, record.ID); err != nil && !isNotFound(err) {
+				p.logger.Warn().Err(err).Str("fileId", record.ID).Msg("Failed to purge file from storage, leaving it to reconciliation")
+			}
+			result.Purged++
+		}
+	}
+
+	p.logger.Info().
+		Int("purged", result.Purged).
+		Int("retained", len(result.Retained)).
+		Int("failed", len(result.Failed)).
+		Msg("Trash purge completed")
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memoryTrash keeps the time files were moved to the trash by file ID. Files
+// in noMetadata are listed without their proto metadata.
+type memoryTrash struct {
+	deletedAt  map[string]time.Time
+	noMetadata map[string]bool
+}
+
+func (m *memoryTrash) ListExpiredTrash(ctx context.Context, deletedBefore time.Time, afterFileID string, limit int) ([]*domain.FileMetadataRecord, error) {
+	var fileIDs []string
+	for fileID, deletedAt := range m.deletedAt {
+		if deletedAt.Before(deletedBefore) && fileID > afterFileID {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	slices.Sort(fileIDs)
+	var records []*domain.FileMetadataRecord
+	for _, fileID := range fileIDs[:min(limit, len(fileIDs))] {
+		record := &domain.FileMetadataRecord{ID: fileID, DeletedAt: m.deletedAt[fileID]}
+		if !m.noMetadata[fileID] {
+			record.Metadata = &sharedv1.FileMetadata{FileId: fileID, UserId: "u1"}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func TestTrashPurger_PurgesExpiredFiles(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+
+	now := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	provider := &memoryProvider{files: map[string][]byte{
+		"file-a": []byte("a"), "file-b": []byte("b"), "file-c": []byte("c"), "file-d": []byte("d"), "file-e": []byte("e"),
+		"file-f": []byte("f"),
+	}}
+	trash := &memoryTrash{deletedAt: map[string]time.Time{
+		"file-a": now.Add(-48 * time.Hour),
+		"file-b": now.Add(-25 * time.Hour),
+		"file-c": now.Add(-72 * time.Hour),
+		"file-d": now.Add(-time.Hour),
+		"file-e": now.Add(-96 * time.Hour),
+		"file-f": now.Add(-48 * time.Hour),
+	}, noMetadata: map[string]bool{"file-f": true}}
+	purger, err := NewTrashPurger(provider, &TrashConfig{
+		GracePeriod:     24 * time.Hour,
+		BatchSize:       2,
+		TrashRepository: trash,
+	}, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewTrashPurger() error = %v", err)
+	}
+	purger.now = func() time.Time { return now }
+
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "file-a").Return(nil)
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "file-b").Return(errors.New("database is locked"))
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "file-c").Return(nil)
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "file-e").
+		Return(status.Error(codes.FailedPrecondition, domain.ErrFileRetained.Error()))
+
+	result, err := purger.Purge(context.Background())
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	// A record without metadata names no owner to delete it for
+	if result.Purged != 2 || !slices.Equal(result.Failed, []string{"file-b", "file-f"}) || !slices.Equal(result.Retained, []string{"file-e"}) {
+		t.Errorf("Purge() = %+v, want 2 purged, file-b and file-f failed and file-e retained", result)
+	}
+	// Content is only removed once the metadata is gone
+	for fileID, wantKept := range map[string]bool{"file-a": false, "file-b": true, "file-c": false, "file-d": true, "file-e": true, "file-f": true} {
+		if _, kept := provider.files[fileID]; kept != wantKept {
+			t.Errorf("%s kept = %v, want %v", fileID, kept, wantKept)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// Versioner is implemented by providers that keep earlier uploads of a file
+type Versioner interface {
+	// AddVersion makes the stored file versionID the current version of fileID
+	AddVersion(ctx context.Context, fileID, versionID string) error
+	// RestoreVersion makes an earlier version of fileID current again
+	RestoreVersion(ctx context.Context, fileID, versionID string) error
+	// ListVersions returns the versions of fileID, newest first
+	ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error)
+}
+
+// VersioningConfig configures VersionedProvider
+type VersioningConfig struct {
+	// Retain is how many versions of a file are kept, the current one
+	// included. Older ones are pruned as versions are added; zero keeps all.
+	Retain            int
+	VersionRepository metadataService.VersionRepository
+}
+
+// VersionedProvider keeps earlier uploads of a file as versions. Every version
+// is stored, and has a metadata record, under its own ID, so the layers below
+// treat it like any other file. Reads of a file ID are routed to its current
+// version, while version IDs remain readable directly.
+type VersionedProvider struct {
+	inner           Provider
+	versions        metadataService.VersionRepository
+	retain          int
+	metadataService metadataService.MetadataService
+	logger          *logger.Logger
+	now             func() time.Time
+}
+
+func NewVersionedProvider(inner Provider, cfg *VersioningConfig, metadataService metadataService.MetadataService, logger *logger.Logger) (*VersionedProvider, error) {
+	if cfg.VersionRepository == nil {
+		return nil, errors.New("versioning needs a version repository")
+	}
+	if cfg.Retain < 0 {
+		return nil, fmt.Errorf("version retention cannot be negative: %d", cfg.Retain)
+	}
+	return &VersionedProvider{
+		inner:           inner,
+		versions:        cfg.VersionRepository,
+		retain:          cfg.Retain,
+		metadataService: metadataService,
+		logger:          logger,
+		now:             time.Now,
+	}, nil
+}
+
+func (v *VersionedProvider) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	return v.inner.Store(ctx, fileID, content)
+}
+
+func (v *VersionedProvider) Retrieve(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	versionID, err := v.versions.CurrentVersion(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return v.inner.Retrieve(ctx, versionID)
+}
+
+func (v *VersionedProvider) RetrieveRange(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, error) {
+	versionID, err := v.versions.CurrentVersion(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return v.inner.RetrieveRange(ctx, versionID, offset, length)
+}
+
+// Stat describes the current version, reported under the requested file ID
+func (v *VersionedProvider) Stat(ctx context.Context, fileID string) (*file.ObjectInfo, error) {
+	versionID, err := v.versions.CurrentVersion(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	info, err := v.inner.Stat(ctx, versionID)
+	if err != nil {
+		return nil, err
+	}
+	info.FileID = fileID
+	return info, nil
+}
+
+// Delete removes the file with all its versions
+func (v *VersionedProvider) Delete(ctx context.Context, fileID string) error {
+	versions, err := v.versions.ListVersions(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return v.inner.Delete(ctx, fileID)
+	}
+
+	var errs []error
+	for _, version := range versions {
+		if err := v.removeVersionContent(ctx, fileID, version.VersionID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to delete versions of %s: %w", fileID, err)
+	}
+	return v.versions.RemoveVersions(ctx, fileID)
+}
+
+func (v *VersionedProvider) List(ctx context.Context, opts file.ListOptions) (*file.ListPage, error) {
+	return v.inner.List(ctx, opts)
+}
+
+// SweepTempFiles forwards to the wrapped provider, if it stages writes
+func (v *VersionedProvider) SweepTempFiles(ctx context.Context) ([]string, error) {
+	sweeper, ok := v.inner.(TempFileSweeper)
+	if !ok {
+		return nil, nil
+	}
+	return sweeper.SweepTempFiles(ctx)
+}
+
+// AddVersion makes the stored file versionID the current version of fileID
+// and prunes the versions beyond the retention count
+func (v *VersionedProvider) AddVersion(ctx context.Context, fileID, versionID string) error {
+	if err := v.versions.AddVersion(ctx, fileID, versionID, v.now().UTC()); err != nil {
+		return err
+	}
+	v.prune(ctx, fileID)
+	return nil
+}
+
+// RestoreVersion makes an earlier version of fileID current again. The
+// version keeps its place in the history, so restoring prunes nothing.
+func (v *VersionedProvider) RestoreVersion(ctx context.Context, fileID, versionID string) error {
+	if err := v.versions.SetCurrentVersion(ctx, fileID, versionID); err != nil {
+		return err
+	}
+	v.logger.Info().Str("fileId", fileID).Str("versionId", versionID).Msg("File version restored")
+	return nil
+}
+
+func (v *VersionedProvider) ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error) {
+	return v.versions.ListVersions(ctx, fileID)
+}
+
+// prune removes the oldest versions other than the current one until at most
+// retain are left. Failures are logged; the next version added retries them.
+func (v *VersionedProvider) prune(ctx context.Context, fileID string) {
+	if v.retain == 0 {
+		return
+	}
+	versions, err := v.versions.ListVersions(ctx, fileID)
+	if err != nil {
+		v.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to list versions to prune")
+		return
+	}
+
+	excess := len(versions) - v.retain
+	for i := len(versions) - 1; i >= 0 && excess > 0; i-- {
+		version := versions[i]
+		if version.Current {
+			continue
+		}
+		if err := v.removeVersionContent(ctx, fileID, version.VersionID); err != nil {
+			v.logger.Error().Err(err).Str("fileId", fileID).Str("versionId", version.VersionID).Msg("Failed to prune file version")
+			continue
+		}
+		if err := v.versions.RemoveVersion(ctx, fileID, version.VersionID); err != nil {
+			v.logger.Error().Err(err).Str("fileId", fileID).Str("versionId", version.VersionID).Msg("Failed to remove pruned file version")
+			continue
+		}
+		v.logger.Info().Str("fileId", fileID).Str("versionId", version.VersionID).Msg("Pruned file version")
+		excess--
+	}
+}
+
+// removeVersionContent deletes a version from storage along with its metadata
+// record, which returns its quota. The first version's record is the file's
+// own and is kept, so its quota is only returned once the file is deleted.
+func (v *VersionedProvider) removeVersionContent(ctx context.Context, fileID, versionID string) error {
+	if err := v.inner.Delete(ctx, versionID); err != nil && !isNotFound(err) {
+		return err
+	}
+	if versionID == fileID {
+		return nil
+	}
+	record, err := v.metadataService.RetrieveFileMetadataByID(ctx, versionID)
+	if errors.Is(err, domain.ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve version metadata: %w", err)
+	}
+	var userID string
+	if record.Metadata != nil {
+		userID = record.Metadata.UserId
+	}
+	if err := v.metadataService.DeleteFileMetadata(ctx, userID, versionID); err != nil {
+		return fmt.Errorf("failed to delete version metadata: %w", err)
+	}
+	return nil
+}
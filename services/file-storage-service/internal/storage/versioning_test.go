@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	metadataService "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// memoryVersions keeps file versions by file ID
+type memoryVersions struct {
+	versions map[string][]*domain.FileVersion
+}
+
+func (m *memoryVersions) AddVersion(ctx context.Context, fileID, versionID string, createdAt time.Time) error {
+	if len(m.versions[fileID]) == 0 {
+		m.versions[fileID] = []*domain.FileVersion{{FileID: fileID, VersionID: fileID}}
+	}
+	for _, v := range m.versions[fileID] {
+		v.Current = false
+	}
+	m.versions[fileID] = append(m.versions[fileID], &domain.FileVersion{FileID: fileID, VersionID: versionID, Current: true, CreatedAt: createdAt})
+	return nil
+}
+
+func (m *memoryVersions) CurrentVersion(ctx context.Context, fileID string) (string, error) {
+	for _, v := range m.versions[fileID] {
+		if v.Current {
+			return v.VersionID, nil
+		}
+	}
+	return fileID, nil
+}
+
+func (m *memoryVersions) ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error) {
+	versions := append([]*domain.FileVersion(nil), m.versions[fileID]...)
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].CreatedAt.After(versions[j].CreatedAt) })
+	return versions, nil
+}
+
+func (m *memoryVersions) SetCurrentVersion(ctx context.Context, fileID, versionID string) error {
+	found := false
+	for _, v := range m.versions[fileID] {
+		found = found || v.VersionID == versionID
+	}
+	if !found {
+		return fmt.Errorf("%w: version %s of %s", domain.ErrFileNotFound, versionID, fileID)
+	}
+	for _, v := range m.versions[fileID] {
+		v.Current = v.VersionID == versionID
+	}
+	return nil
+}
+
+func (m *memoryVersions) RemoveVersion(ctx context.Context, fileID, versionID string) error {
+	kept := m.versions[fileID][:0]
+	for _, v := range m.versions[fileID] {
+		if v.VersionID != versionID || v.Current {
+			kept = append(kept, v)
+		}
+	}
+	m.versions[fileID] = kept
+	return nil
+}
+
+func (m *memoryVersions) RemoveVersions(ctx context.Context, fileID string) error {
+	delete(m.versions, fileID)
+	return nil
+}
+
+func readCurrent(t *testing.T, provider Provider, fileID string) string {
+	t.Helper()
+	content, err := provider.Retrieve(context.Background(), fileID)
+	if err != nil {
+		t.Fatalf("Retrieve(%s) error = %v", fileID, err)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	return string(data)
+}
+
+func TestVersionedProvider_VersionsRestoreAndPrune(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	mockMetadata := metadataService.NewMockMetadataService(gomock.NewController(t))
+	mockMetadata.EXPECT().RetrieveFileMetadataByID(gomock.Any(), gomock.Any()).
+		Return(&domain.FileMetadataRecord{Metadata: &sharedv1.FileMetadata{UserId: "u1"}}, nil).AnyTimes()
+
+	inner := &memoryProvider{files: map[string][]byte{}}
+	versions := &memoryVersions{versions: map[string][]*domain.FileVersion{}}
+	provider, err := NewVersionedProvider(inner, &VersioningConfig{Retain: 2, VersionRepository: versions}, mockMetadata, &testLogger)
+	if err != nil {
+		t.Fatalf("NewVersionedProvider() error = %v", err)
+	}
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	ctx := context.Background()
+
+	// Files without versions are read as stored
+	inner.files["file-a"] = []byte("one")
+	if got := readCurrent(t, provider, "file-a"); got != "one" {
+		t.Errorf("unversioned file = %q, want %q", got, "one")
+	}
+
+	for fileID, content := range map[string]string{"ver-b": "two", "ver-c": "three"} {
+		if _, err := provider.Store(ctx, fileID, strings.NewReader(content)); err != nil {
+			t.Fatalf("Store(%s) error = %v", fileID, err)
+		}
+	}
+	if err := provider.AddVersion(ctx, "file-a", "ver-b"); err != nil {
+		t.Fatalf("AddVersion() error = %v", err)
+	}
+	if got := readCurrent(t, provider, "file-a"); got != "two" {
+		t.Errorf("current version = %q, want %q", got, "two")
+	}
+	if got := readCurrent(t, provider, "ver-b"); got != "two" {
+		t.Errorf("version read by ID = %q, want %q", got, "two")
+	}
+
+	// A third version prunes the first, whose record is the file's own
+	if err := provider.AddVersion(ctx, "file-a", "ver-c"); err != nil {
+		t.Fatalf("AddVersion() error = %v", err)
+	}
+	if _, ok := inner.files["file-a"]; ok {
+		t.Error("first version was not pruned")
+	}
+	if info, err := provider.Stat(ctx, "file-a"); err != nil || info.FileID != "file-a" || info.Size != int64(len("three")) {
+		t.Errorf("Stat() = %+v, %v, want the current version under file-a", info, err)
+	}
+
+	if err := provider.RestoreVersion(ctx, "file-a", "ver-b"); err != nil {
+		t.Fatalf("RestoreVersion() error = %v", err)
+	}
+	if got := readCurrent(t, provider, "file-a"); got != "two" {
+		t.Errorf("restored version = %q, want %q", got, "two")
+	}
+	if err := provider.RestoreVersion(ctx, "file-a", "file-a"); !errors.Is(err, domain.ErrFileNotFound) {
+		t.Errorf("RestoreVersion() of a pruned version error = %v, want not found", err)
+	}
+
+	// Restoring keeps a version's place in the history, so it is still the
+	// oldest once another version is added
+	inner.files["ver-d"] = []byte("four")
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "ver-b").Return(nil)
+	if err := provider.AddVersion(ctx, "file-a", "ver-d"); err != nil {
+		t.Fatalf("AddVersion() error = %v", err)
+	}
+	listed, _ := provider.ListVersions(ctx, "file-a")
+	if len(listed) != 2 || listed[0].VersionID != "ver-d" || !listed[0].Current || listed[1].VersionID != "ver-c" {
+		t.Errorf("ListVersions() = %v, want ver-d and ver-c", listed)
+	}
+
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "ver-d").Return(nil)
+	mockMetadata.EXPECT().DeleteFileMetadata(gomock.Any(), "u1", "ver-c").Return(nil)
+	if err := provider.Delete(ctx, "file-a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if len(inner.files) != 0 || len(versions.versions) != 0 {
+		t.Errorf("Delete() left files %v and versions %v", inner.files, versions.versions)
+	}
+}
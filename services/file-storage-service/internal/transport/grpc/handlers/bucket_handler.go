@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	storagev1 "github.com/yaanno/upload-store-process/gen/go/filestorage/v1"
+	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CreateBucket creates a bucket owned by the requesting user
+func (h *FileStorageHandlerImpl) CreateBucket(ctx context.Context, req *storagev1.CreateBucketRequest) (*storagev1.CreateBucketResponse, error) {
+	bucket := &domain.Bucket{
+		Name:     req.Name,
+		OwnerID:  req.UserId,
+		Settings: bucketSettingsFromProto(req.Settings),
+	}
+	if err := h.metadataService.CreateBucket(ctx, bucket); err != nil {
+		h.logger.Error().
+			Str("method", "CreateBucket").
+			Err(err).
+			Str("bucket", req.Name).
+			Msg("failed to create bucket")
+		return nil, bucketError(err)
+	}
+
+	return &storagev1.CreateBucketResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Bucket created successfully",
+		},
+		Bucket: bucketToProto(bucket),
+	}, nil
+}
+
+// GetBucket retrieves one of the user's buckets
+func (h *FileStorageHandlerImpl) GetBucket(ctx context.Context, req *storagev1.GetBucketRequest) (*storagev1.GetBucketResponse, error) {
+	bucket, err := h.metadataService.GetBucket(ctx, req.UserId, req.Name)
+	if err != nil {
+		h.logger.Error().
+			Str("method", "GetBucket").
+			Err(err).
+			Str("bucket", req.Name).
+			Msg("failed to retrieve bucket")
+		return nil, bucketError(err)
+	}
+
+	return &storagev1.GetBucketResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Bucket retrieved successfully",
+		},
+		Bucket: bucketToProto(bucket),
+	}, nil
+}
+
+// ListBuckets lists the user's buckets by name
+func (h *FileStorageHandlerImpl) ListBuckets(ctx context.Context, req *storagev1.ListBucketsRequest) (*storagev1.ListBucketsResponse, error) {
+	buckets, err := h.metadataService.ListBuckets(ctx, req.UserId)
+	if err != nil {
+		h.logger.Error().
+			Str("method", "ListBuckets").
+			Err(err).
+			Str("userId", req.UserId).
+			Msg("failed to list buckets")
+		return nil, bucketError(err)
+	}
+
+	response := &storagev1.ListBucketsResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Buckets listed successfully",
+		},
+	}
+	for _, bucket := range buckets {
+		response.Buckets = append(response.Buckets, bucketToProto(bucket))
+	}
+	return response, nil
+}
+
+// UpdateBucket replaces the settings of one of the user's buckets
+func (h *FileStorageHandlerImpl) UpdateBucket(ctx context.Context, req *storagev1.UpdateBucketRequest) (*storagev1.UpdateBucketResponse, error) {
+	bucket, err := h.metadataService.UpdateBucket(ctx, req.UserId, req.Name, bucketSettingsFromProto(req.Settings))
+	if err != nil {
+		h.logger.Error().
+			Str("method", "UpdateBucket").
+			Err(err).
+			Str("bucket", req.Name).
+			Msg("failed to update bucket")
+		return nil, bucketError(err)
+	}
+
+	return &storagev1.UpdateBucketResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Bucket updated successfully",
+		},
+		Bucket: bucketToProto(bucket),
+	}, nil
+}
+
+// DeleteBucket deletes one of the user's buckets once it holds no files
+func (h *FileStorageHandlerImpl) DeleteBucket(ctx context.Context, req *storagev1.DeleteBucketRequest) (*storagev1.DeleteBucketResponse, error) {
+	if err := h.metadataService.DeleteBucket(ctx, req.UserId, req.Name); err != nil {
+		h.logger.Error().
+			Str("method", "DeleteBucket").
+			Err(err).
+			Str("bucket", req.Name).
+			Msg("failed to delete bucket")
+		return nil, bucketError(err)
+	}
+
+	return &storagev1.DeleteBucketResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Bucket deleted successfully",
+		},
+		BucketDeleted: true,
+	}, nil
+}
+
+// bucketError passes on the status errors of the metadata service's bucket
+// operations that tell the caller what went wrong
+func bucketError(err error) error {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied, codes.FailedPrecondition, codes.Unimplemented:
+		return err
+	}
+	return status.Errorf(codes.Internal, "bucket operation failed")
+}
+
+func bucketSettingsFromProto(settings *storagev1.BucketSettings) domain.BucketSettings {
+	if settings == nil {
+		return domain.BucketSettings{}
+	}
+	return domain.BucketSettings{
+		AllowedTypes: settings.AllowedTypes,
+		MaxFileSize:  settings.MaxFileSizeBytes,
+		Retention:    time.Duration(settings.RetentionSeconds) * time.Second,
+		Versioning:   settings.Versioning,
+	}
+}
+
+func bucketToProto(bucket *domain.Bucket) *storagev1.Bucket {
+	return &storagev1.Bucket{
+		Name:    bucket.Name,
+		OwnerId: bucket.OwnerID,
+		Settings: &storagev1.BucketSettings{
+			AllowedTypes:     bucket.Settings.AllowedTypes,
+			MaxFileSizeBytes: bucket.Settings.MaxFileSize,
+			RetentionSeconds: int64(bucket.Settings.Retention / time.Second),
+			Versioning:       bucket.Settings.Versioning,
+		},
+		CreatedAt: timestamppb.New(bucket.CreatedAt),
+		UpdatedAt: timestamppb.New(bucket.UpdatedAt),
+	}
+}
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"context"
+	"errors"
 
 	storagev1 "github.com/yaanno/upload-store-process/gen/go/filestorage/v1"
 	sharedv1 "github.com/yaanno/upload-store-process/gen/go/shared/v1"
 	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
 	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/auth"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,17 +20,33 @@ type FileStorageHandler interface {
 	ListFiles(ctx context.Context, req *storagev1.ListFilesRequest) (*storagev1.ListFilesResponse, error)
 	DeleteFile(ctx context.Context, req *storagev1.DeleteFileRequest) (*storagev1.DeleteFileResponse, error)
 	GetFileMetadata(ctx context.Context, req *storagev1.GetFileMetadataRequest) (*storagev1.GetFileMetadataResponse, error)
+	GetUsage(ctx context.Context, req *storagev1.GetUsageRequest) (*storagev1.GetUsageResponse, error)
+	StatFile(ctx context.Context, req *storagev1.StatFileRequest) (*storagev1.StatFileResponse, error)
+	ListFileVersions(ctx context.Context, req *storagev1.ListFileVersionsRequest) (*storagev1.ListFileVersionsResponse, error)
+	RestoreFileVersion(ctx context.Context, req *storagev1.RestoreFileVersionRequest) (*storagev1.RestoreFileVersionResponse, error)
+	ListDeleted(ctx context.Context, req *storagev1.ListDeletedRequest) (*storagev1.ListDeletedResponse, error)
+	RestoreFile(ctx context.Context, req *storagev1.RestoreFileRequest) (*storagev1.RestoreFileResponse, error)
+	CreateBucket(ctx context.Context, req *storagev1.CreateBucketRequest) (*storagev1.CreateBucketResponse, error)
+	GetBucket(ctx context.Context, req *storagev1.GetBucketRequest) (*storagev1.GetBucketResponse, error)
+	ListBuckets(ctx context.Context, req *storagev1.ListBucketsRequest) (*storagev1.ListBucketsResponse, error)
+	UpdateBucket(ctx context.Context, req *storagev1.UpdateBucketRequest) (*storagev1.UpdateBucketResponse, error)
+	DeleteBucket(ctx context.Context, req *storagev1.DeleteBucketRequest) (*storagev1.DeleteBucketResponse, error)
 }
 
 type FileStorageHandlerImpl struct {
 	storagev1.UnimplementedFileStorageServiceServer
 	metadataService metadata.MetadataService
-	logger          *logger.Logger
+	storage         storage.Provider
+	// quotas is nil when quotas are not enforced
+	quotas metadata.QuotaService
+	logger *logger.Logger
 }
 
-func NewFileOperationdHandler(metadataService metadata.MetadataService, logger *logger.Logger) *FileStorageHandlerImpl {
+func NewFileOperationdHandler(metadataService metadata.MetadataService, storage storage.Provider, quotas metadata.QuotaService, logger *logger.Logger) *FileStorageHandlerImpl {
 	return &FileStorageHandlerImpl{
 		metadataService: metadataService,
+		storage:         storage,
+		quotas:          quotas,
 		logger:          logger,
 	}
 }
@@ -38,12 +57,17 @@ func (h *FileStorageHandlerImpl) ListFiles(ctx context.Context, req *storagev1.L
 	// Prepare list options
 	listOpts := domain.FileMetadataListOptions{
 		UserID: req.UserId,
+		Bucket: req.Bucket,
 	}
 
 	// Retrieve file metadata
 	fileMetadataList, err := h.metadataService.ListFileMetadata(ctx, &listOpts)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to list file metadata")
+		switch status.Code(err) {
+		case codes.PermissionDenied, codes.InvalidArgument, codes.Unimplemented:
+			return nil, err
+		}
 		return nil, status.Errorf(codes.NotFound, "failed to list files")
 	}
 
@@ -65,6 +89,8 @@ func (h *FileStorageHandlerImpl) ListFiles(ctx context.Context, req *storagev1.L
 			OriginalFilename: metadata.Metadata.OriginalFilename,
 			FileSizeBytes:    metadata.Metadata.FileSizeBytes,
 			CreatedAt:        metadata.Metadata.CreatedAt,
+			Digests:          metadata.Metadata.Digests,
+			Bucket:           metadata.Bucket,
 		})
 	}
 
@@ -77,8 +103,32 @@ func (h *FileStorageHandlerImpl) ListFiles(ctx context.Context, req *storagev1.L
 	}, nil
 }
 
-// DeleteFile implements v1.FileStorageServiceServer.
+// DeleteFile moves a file to the user's trash, or deletes it for good if
+// ForceDelete is set
 func (h *FileStorageHandlerImpl) DeleteFile(ctx context.Context, req *storagev1.DeleteFileRequest) (*storagev1.DeleteFileResponse, error) {
+	if !req.ForceDelete {
+		if err := h.metadataService.TrashFileMetadata(ctx, req.UserId, req.FileId); err != nil {
+			h.logger.Error().
+				Str("method", "DeleteFile").
+				Err(err).
+				Str("fileId", req.FileId).
+				Msg("failed to move file to trash")
+			switch status.Code(err) {
+			case codes.PermissionDenied:
+				return nil, status.Errorf(codes.NotFound, "file not found")
+			case codes.FailedPrecondition:
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Internal, "failed to delete file: %v", err)
+		}
+		return &storagev1.DeleteFileResponse{
+			FileDeleted: true,
+			DeletedAt:   timestamppb.Now(),
+			BaseResponse: &sharedv1.Response{
+				Message: "File moved to trash",
+			},
+		}, nil
+	}
 
 	//  delete file from database
 	if err := h.metadataService.DeleteFileMetadata(ctx, req.UserId, req.FileId); err != nil {
@@ -87,9 +137,20 @@ func (h *FileStorageHandlerImpl) DeleteFile(ctx context.Context, req *storagev1.
 			Err(err).
 			Str("fileId", req.FileId).
 			Msg("failed to soft delete file metadata")
+		if status.Code(err) == codes.FailedPrecondition {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Internal, "failed to delete file metadata: %v", err)
 	}
-	// TODO: delete file from storage provider
+	// The metadata goes first, so a failure here leaves an orphaned file for
+	// the reconciler rather than a record pointing at nothing
+	if err := h.storage.Delete(ctx, req.FileId); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+		h.logger.Warn().
+			Str("method", "DeleteFile").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to delete file from storage, leaving it to reconciliation")
+	}
 
 	return &storagev1.DeleteFileResponse{
 		FileDeleted: true,
@@ -122,6 +183,8 @@ func (h *FileStorageHandlerImpl) GetFileMetadata(ctx context.Context, req *stora
 		CreatedAt:        metadata.Metadata.CreatedAt,
 		UserId:           metadata.Metadata.UserId,
 		StoragePath:      metadata.StoragePath,
+		Digests:          metadata.Metadata.Digests,
+		Bucket:           metadata.Bucket,
 	}
 
 	// Return response
@@ -140,11 +203,29 @@ func (h *FileStorageHandlerImpl) PrepareUpload(ctx context.Context, req *storage
 		FileName: req.Filename,
 		FileSize: req.FileSizeBytes,
 		UserID:   req.UserId,
+		Roles:    rolesFromContext(ctx),
+		// The uploader may protect the file; lifting that is up to an admin
+		LegalHold: req.LegalHold,
+		Bucket:    req.Bucket,
+	}
+	if req.RetainUntil != nil {
+		uploadParams.RetainUntil = req.RetainUntil.AsTime()
+	}
+	// Uploading to an existing file adds a version of it
+	if req.FileId != "" {
+		if _, ok := h.storage.(storage.Versioner); !ok {
+			return nil, status.Errorf(codes.FailedPrecondition, "file already exists and versioning is not enabled")
+		}
+		uploadParams.VersionOf = req.FileId
 	}
 
 	result, err := h.metadataService.PrepareUpload(ctx, uploadParams)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to prepare upload")
+		switch status.Code(err) {
+		case codes.ResourceExhausted, codes.InvalidArgument, codes.FailedPrecondition, codes.PermissionDenied, codes.NotFound, codes.Unimplemented:
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Internal, "failed to prepare upload: %v", err)
 	}
 
@@ -159,4 +240,225 @@ func (h *FileStorageHandlerImpl) PrepareUpload(ctx context.Context, req *storage
 	}, nil
 }
 
+// GetUsage reports the user's storage usage and quota limits
+func (h *FileStorageHandlerImpl) GetUsage(ctx context.Context, req *storagev1.GetUsageRequest) (*storagev1.GetUsageResponse, error) {
+	if h.quotas == nil {
+		return nil, status.Errorf(codes.Unimplemented, "storage quotas are not enabled")
+	}
+
+	usage, err := h.quotas.Usage(ctx, req.UserId, rolesFromContext(ctx))
+	if err != nil {
+		h.logger.Error().
+			Str("method", "GetUsage").
+			Err(err).
+			Str("userId", req.UserId).
+			Msg("failed to retrieve quota usage")
+		return nil, status.Errorf(codes.Internal, "failed to retrieve usage")
+	}
+
+	return &storagev1.GetUsageResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Usage retrieved successfully",
+		},
+		UsedBytes:     usage.UsedBytes,
+		UsedFiles:     usage.UsedFiles,
+		ReservedBytes: usage.ReservedBytes,
+		ReservedFiles: usage.ReservedFiles,
+		MaxBytes:      usage.Limits.MaxBytes,
+		MaxFiles:      usage.Limits.MaxFiles,
+	}, nil
+}
+
+// StatFile describes a stored file as reported by the storage provider
+func (h *FileStorageHandlerImpl) StatFile(ctx context.Context, req *storagev1.StatFileRequest) (*storagev1.StatFileResponse, error) {
+	// Only the owner may inspect the file
+	if _, err := h.metadataService.GetFileMetadata(ctx, req.UserId, req.FileId); err != nil {
+		h.logger.Error().
+			Str("method", "StatFile").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to retrieve file metadata")
+		return nil, status.Errorf(codes.NotFound, "file metadata not found")
+	}
+
+	info, err := h.storage.Stat(ctx, req.FileId)
+	if err != nil {
+		h.logger.Error().
+			Str("method", "StatFile").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to stat file")
+		switch storage.ErrorCode(err) {
+		case codes.NotFound:
+			return nil, status.Errorf(codes.NotFound, "file not found in storage")
+		case codes.InvalidArgument:
+			return nil, status.Errorf(codes.InvalidArgument, "invalid file ID")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat file")
+	}
+
+	return &storagev1.StatFileResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "File described successfully",
+		},
+		FileId:       info.FileID,
+		SizeBytes:    info.Size,
+		ModifiedAt:   timestamppb.New(info.ModTime),
+		Checksum:     info.Checksum,
+		StorageClass: info.StorageClass,
+	}, nil
+}
+
+// ListFileVersions lists the versions of a file, newest first. A file that
+// never got a new version is its only version.
+func (h *FileStorageHandlerImpl) ListFileVersions(ctx context.Context, req *storagev1.ListFileVersionsRequest) (*storagev1.ListFileVersionsResponse, error) {
+	versioner, ok := h.storage.(storage.Versioner)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "file versioning is not enabled")
+	}
+	record, err := h.metadataService.GetFileMetadata(ctx, req.UserId, req.FileId)
+	if err != nil {
+		h.logger.Error().
+			Str("method", "ListFileVersions").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to retrieve file metadata")
+		return nil, status.Errorf(codes.NotFound, "file metadata not found")
+	}
+
+	versions, err := versioner.ListVersions(ctx, req.FileId)
+	if err != nil {
+		h.logger.Error().
+			Str("method", "ListFileVersions").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to list file versions")
+		return nil, status.Errorf(codes.Internal, "failed to list file versions")
+	}
+	if len(versions) == 0 {
+		versions = []*domain.FileVersion{{FileID: record.ID, VersionID: record.ID, Current: true, CreatedAt: record.CreatedAt}}
+	}
+
+	response := &storagev1.ListFileVersionsResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "File versions listed successfully",
+		},
+	}
+	for _, version := range versions {
+		fileVersion := &storagev1.FileVersion{
+			VersionId: version.VersionID,
+			IsCurrent: version.Current,
+			CreatedAt: timestamppb.New(version.CreatedAt),
+		}
+		if versionRecord, err := h.metadataService.RetrieveFileMetadataByID(ctx, version.VersionID); err == nil && versionRecord.Metadata != nil {
+			fileVersion.SizeBytes = versionRecord.Metadata.FileSizeBytes
+		}
+		response.Versions = append(response.Versions, fileVersion)
+	}
+	return response, nil
+}
+
+// RestoreFileVersion makes an earlier version of a file its current version
+func (h *FileStorageHandlerImpl) RestoreFileVersion(ctx context.Context, req *storagev1.RestoreFileVersionRequest) (*storagev1.RestoreFileVersionResponse, error) {
+	versioner, ok := h.storage.(storage.Versioner)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "file versioning is not enabled")
+	}
+	if _, err := h.metadataService.GetFileMetadata(ctx, req.UserId, req.FileId); err != nil {
+		h.logger.Error().
+			Str("method", "RestoreFileVersion").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to retrieve file metadata")
+		return nil, status.Errorf(codes.NotFound, "file metadata not found")
+	}
+
+	if err := versioner.RestoreVersion(ctx, req.FileId, req.VersionId); err != nil {
+		h.logger.Error().
+			Str("method", "RestoreFileVersion").
+			Err(err).
+			Str("fileId", req.FileId).
+			Str("versionId", req.VersionId).
+			Msg("failed to restore file version")
+		// Versions missing from the history or from storage are both not found
+		if errors.Is(err, domain.ErrFileNotFound) || storage.ErrorCode(err) == codes.NotFound {
+			return nil, status.Errorf(codes.NotFound, "file version not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to restore file version")
+	}
+
+	return &storagev1.RestoreFileVersionResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "File version restored successfully",
+		},
+		VersionId: req.VersionId,
+	}, nil
+}
+
+// ListDeleted lists the files in the user's trash, most recently deleted first
+func (h *FileStorageHandlerImpl) ListDeleted(ctx context.Context, req *storagev1.ListDeletedRequest) (*storagev1.ListDeletedResponse, error) {
+	records, err := h.metadataService.ListDeletedFileMetadata(ctx, req.UserId)
+	if err != nil {
+		h.logger.Error().
+			Str("method", "ListDeleted").
+			Err(err).
+			Str("userId", req.UserId).
+			Msg("failed to list deleted files")
+		return nil, status.Errorf(codes.Internal, "failed to list deleted files")
+	}
+
+	response := &storagev1.ListDeletedResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "Deleted files listed successfully",
+		},
+	}
+	for _, record := range records {
+		deleted := &storagev1.DeletedFile{
+			Metadata:  &sharedv1.FileMetadata{FileId: record.ID},
+			DeletedAt: timestamppb.New(record.DeletedAt),
+		}
+		if record.Metadata != nil {
+			deleted.Metadata.OriginalFilename = record.Metadata.OriginalFilename
+			deleted.Metadata.FileSizeBytes = record.Metadata.FileSizeBytes
+			deleted.Metadata.ContentType = record.Metadata.ContentType
+			deleted.Metadata.CreatedAt = record.Metadata.CreatedAt
+			deleted.Metadata.Digests = record.Metadata.Digests
+		}
+		response.Files = append(response.Files, deleted)
+	}
+	return response, nil
+}
+
+// RestoreFile moves a file out of the user's trash
+func (h *FileStorageHandlerImpl) RestoreFile(ctx context.Context, req *storagev1.RestoreFileRequest) (*storagev1.RestoreFileResponse, error) {
+	if err := h.metadataService.RestoreFileMetadata(ctx, req.UserId, req.FileId); err != nil {
+		h.logger.Error().
+			Str("method", "RestoreFile").
+			Err(err).
+			Str("fileId", req.FileId).
+			Msg("failed to restore file")
+		if status.Code(err) == codes.NotFound {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Internal, "failed to restore file")
+	}
+
+	return &storagev1.RestoreFileResponse{
+		BaseResponse: &sharedv1.Response{
+			Message: "File restored successfully",
+		},
+		FileId: req.FileId,
+	}, nil
+}
+
+// rolesFromContext returns the caller's roles from the JWT claims attached
+// by the auth interceptor, if any
+func rolesFromContext(ctx context.Context) []string {
+	claims, ok := auth.GetClaimsFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return claims.Roles
+}
+
 var _ FileStorageHandler = (*FileStorageHandlerImpl)(nil)
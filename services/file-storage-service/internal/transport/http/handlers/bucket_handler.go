@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BucketSettings apply to every upload into a bucket; the zero value
+// restricts nothing
+type BucketSettings struct {
+	AllowedTypes     []string `json:"allowed_types"`
+	MaxFileSizeBytes int64    `json:"max_file_size_bytes"`
+	RetentionSeconds int64    `json:"retention_seconds"`
+	Versioning       bool     `json:"versioning"`
+}
+
+// BucketResponse describes a bucket
+type BucketResponse struct {
+	Name      string         `json:"name"`
+	OwnerID   string         `json:"owner_id"`
+	Settings  BucketSettings `json:"settings"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// BucketHandler serves bucket CRUD. The bucket is named in the path and the
+// user acting on it by the userId query parameter.
+type BucketHandler struct {
+	metadataService metadata.MetadataService
+	logger          *logger.Logger
+}
+
+func NewBucketHandler(metadataService metadata.MetadataService, logger *logger.Logger) BucketHandler {
+	return BucketHandler{
+		metadataService: metadataService,
+		logger:          logger,
+	}
+}
+
+// CreateBucket creates the bucket with the settings in the request body,
+// which may be empty
+func (h *BucketHandler) CreateBucket(w http.ResponseWriter, r *http.Request) {
+	var settings BucketSettings
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid bucket settings", http.StatusBadRequest)
+			return
+		}
+	}
+
+	bucket := &domain.Bucket{
+		Name:     chi.URLParam(r, "bucket"),
+		OwnerID:  r.URL.Query().Get("userId"),
+		Settings: settings.toDomain(),
+	}
+	if err := h.metadataService.CreateBucket(r.Context(), bucket); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeBucket(w, http.StatusCreated, bucket)
+}
+
+func (h *BucketHandler) GetBucket(w http.ResponseWriter, r *http.Request) {
+	bucket, err := h.metadataService.GetBucket(r.Context(), r.URL.Query().Get("userId"), chi.URLParam(r, "bucket"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeBucket(w, http.StatusOK, bucket)
+}
+
+// GetBuckets lists the user's buckets by name
+func (h *BucketHandler) GetBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := h.metadataService.ListBuckets(r.Context(), r.URL.Query().Get("userId"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	response := make([]*BucketResponse, 0, len(buckets))
+	for _, bucket := range buckets {
+		response = append(response, bucketResponse(bucket))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateBucket replaces the bucket's settings with those in the request body
+func (h *BucketHandler) UpdateBucket(w http.ResponseWriter, r *http.Request) {
+	var settings BucketSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid bucket settings", http.StatusBadRequest)
+		return
+	}
+
+	bucket, err := h.metadataService.UpdateBucket(r.Context(), r.URL.Query().Get("userId"), chi.URLParam(r, "bucket"), settings.toDomain())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeBucket(w, http.StatusOK, bucket)
+}
+
+// DeleteBucket deletes the bucket once it holds no files
+func (h *BucketHandler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
+	if err := h.metadataService.DeleteBucket(r.Context(), r.URL.Query().Get("userId"), chi.URLParam(r, "bucket")); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BucketHandler) writeBucket(w http.ResponseWriter, code int, bucket *domain.Bucket) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(bucketResponse(bucket))
+}
+
+func (h *BucketHandler) writeError(w http.ResponseWriter, err error) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.PermissionDenied:
+		http.Error(w, "Bucket not owned by user", http.StatusForbidden)
+	case codes.NotFound:
+		http.Error(w, "Bucket not found", http.StatusNotFound)
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+	case codes.Unimplemented:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotImplemented)
+	default:
+		h.logger.Error().Err(err).Msg("Bucket request failed")
+		http.Error(w, "Bucket request failed", http.StatusInternalServerError)
+	}
+}
+
+func (s BucketSettings) toDomain() domain.BucketSettings {
+	return domain.BucketSettings{
+		AllowedTypes: s.AllowedTypes,
+		MaxFileSize:  s.MaxFileSizeBytes,
+		Retention:    time.Duration(s.RetentionSeconds) * time.Second,
+		Versioning:   s.Versioning,
+	}
+}
+
+func bucketResponse(bucket *domain.Bucket) *BucketResponse {
+	return &BucketResponse{
+		Name:    bucket.Name,
+		OwnerID: bucket.OwnerID,
+		Settings: BucketSettings{
+			AllowedTypes:     bucket.Settings.AllowedTypes,
+			MaxFileSizeBytes: bucket.Settings.MaxFileSize,
+			RetentionSeconds: int64(bucket.Settings.Retention / time.Second),
+			Versioning:       bucket.Settings.Versioning,
+		},
+		CreatedAt: bucket.CreatedAt,
+		UpdatedAt: bucket.UpdatedAt,
+	}
+}
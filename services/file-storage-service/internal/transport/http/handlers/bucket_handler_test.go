@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBucketHandler(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	metadataService := metadata.NewMockMetadataService(gomock.NewController(t))
+	h := NewBucketHandler(metadataService, &testLogger)
+	r := chi.NewRouter()
+	r.Put("/bucket/{bucket}", h.CreateBucket)
+	r.Get("/bucket/{bucket}", h.GetBucket)
+	r.Delete("/bucket/{bucket}", h.DeleteBucket)
+
+	metadataService.EXPECT().CreateBucket(gomock.Any(), &domain.Bucket{
+		Name:     "photos",
+		OwnerID:  "user-1",
+		Settings: domain.BucketSettings{AllowedTypes: []string{"image/*"}, Retention: time.Hour},
+	}).Return(nil)
+	rec := httptest.NewRecorder()
+	body := `{"allowed_types": ["image/*"], "retention_seconds": 3600}`
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/bucket/photos?userId=user-1", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("PUT status = %d, want 201", rec.Code)
+	}
+	var created BucketResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || created.Name != "photos" || created.Settings.RetentionSeconds != 3600 {
+		t.Errorf("PUT response = %+v, %v", created, err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		err    error
+		want   int
+	}{
+		{http.MethodGet, status.Error(codes.PermissionDenied, "user does not own bucket"), http.StatusForbidden},
+		{http.MethodDelete, status.Error(codes.FailedPrecondition, "bucket photos is not empty"), http.StatusConflict},
+		{http.MethodDelete, nil, http.StatusNoContent},
+	} {
+		switch tc.method {
+		case http.MethodGet:
+			metadataService.EXPECT().GetBucket(gomock.Any(), "user-2", "photos").Return(nil, tc.err)
+		case http.MethodDelete:
+			metadataService.EXPECT().DeleteBucket(gomock.Any(), "user-2", "photos").Return(tc.err)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(tc.method, "/bucket/photos?userId=user-2", nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s error %v status = %d, want %d", tc.method, tc.err, rec.Code, tc.want)
+		}
+	}
+}
@@ -1,22 +1,32 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
 )
 
+// TrashPurger is the part of the trash purger the housekeeping endpoint uses
+type TrashPurger interface {
+	Purge(ctx context.Context) (*storage.PurgeResult, error)
+}
+
 type HouseKeepingHandler struct {
 	metadataService metadata.MetadataService
+	trashPurger     TrashPurger
 	logger          *logger.Logger
 }
 
-func NewHouseKeepingHandler(metadataService metadata.MetadataService, logger *logger.Logger) HouseKeepingHandler {
+func NewHouseKeepingHandler(metadataService metadata.MetadataService, trashPurger TrashPurger, logger *logger.Logger) HouseKeepingHandler {
 	return HouseKeepingHandler{
 		metadataService: metadataService,
+		trashPurger:     trashPurger,
 		logger:          logger,
 	}
 }
@@ -35,3 +45,20 @@ func (h *HouseKeepingHandler) CleanupMetadata(w http.ResponseWriter, r *http.Req
 		"timestamp":     time.Now(),
 	})
 }
+
+// PurgeTrash permanently deletes the files whose time in the trash is up
+func (h *HouseKeepingHandler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	result, err := h.trashPurger.Purge(r.Context())
+	if err != nil {
+		if errors.Is(err, storage.ErrPurgeInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.logger.Error().Err(err).Msg("Trash purge failed")
+		http.Error(w, "Trash purge failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+// Reconciler is the part of the storage reconciler the admin endpoint uses
+type Reconciler interface {
+	Run(ctx context.Context, dryRun bool) (*storage.ReconcileResult, error)
+}
+
+type ReconcileHandler struct {
+	reconciler Reconciler
+	logger     *logger.Logger
+}
+
+func NewReconcileHandler(reconciler Reconciler, logger *logger.Logger) ReconcileHandler {
+	return ReconcileHandler{
+		reconciler: reconciler,
+		logger:     logger,
+	}
+}
+
+// Reconcile compares storage with the metadata and reports orphaned files and
+// dangling records. Nothing is changed unless dry_run=false is passed.
+func (h *ReconcileHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	dryRun := true
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+
+	result, err := h.reconciler.Run(r.Context(), dryRun)
+	if err != nil {
+		if errors.Is(err, storage.ErrReconcileInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.logger.Error().Err(err).Msg("Reconciliation failed")
+		http.Error(w, "Reconciliation failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/auth"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retentionAdminRole is the role allowed to change a file's retention
+const retentionAdminRole = "admin"
+
+// RetentionRequest replaces a file's retention. Omitting retain_until clears
+// it, which is only allowed once the retention period has ended.
+type RetentionRequest struct {
+	RetainUntil *time.Time `json:"retain_until"`
+	LegalHold   bool       `json:"legal_hold"`
+	Reason      string     `json:"reason"`
+}
+
+// RetentionChange is an entry of a file's retention audit trail
+type RetentionChange struct {
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold"`
+	Actor       string     `json:"actor"`
+	Reason      string     `json:"reason"`
+	ChangedAt   time.Time  `json:"changed_at"`
+}
+
+// RetentionResponse describes a file's retention and how it came about
+type RetentionResponse struct {
+	FileID      string             `json:"file_id"`
+	RetainUntil *time.Time         `json:"retain_until,omitempty"`
+	LegalHold   bool               `json:"legal_hold"`
+	Retained    bool               `json:"retained"`
+	Changes     []*RetentionChange `json:"changes"`
+}
+
+type RetentionHandler struct {
+	metadataService metadata.MetadataService
+	logger          *logger.Logger
+}
+
+func NewRetentionHandler(metadataService metadata.MetadataService, logger *logger.Logger) RetentionHandler {
+	return RetentionHandler{
+		metadataService: metadataService,
+		logger:          logger,
+	}
+}
+
+// GetRetention reports a file's retention along with its audit trail
+func (h *RetentionHandler) GetRetention(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "fileId")
+	retention, changes, err := h.metadataService.GetFileRetention(r.Context(), fileID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	response := &RetentionResponse{
+		FileID:      fileID,
+		RetainUntil: optionalTime(retention.RetainUntil),
+		LegalHold:   retention.LegalHold,
+		Retained:    retention.Applies(time.Now()),
+		Changes:     make([]*RetentionChange, 0, len(changes)),
+	}
+	for _, change := range changes {
+		response.Changes = append(response.Changes, &RetentionChange{
+			RetainUntil: optionalTime(change.RetainUntil),
+			LegalHold:   change.LegalHold,
+			Actor:       change.Actor,
+			Reason:      change.Reason,
+			ChangedAt:   change.ChangedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SetRetention replaces a file's retention and legal hold. Only an admin may
+// do so, and the change is recorded under the user of their token.
+func (h *RetentionHandler) SetRetention(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if !claims.HasRole(retentionAdminRole) {
+		http.Error(w, "Changing retention requires the admin role", http.StatusForbidden)
+		return
+	}
+
+	var req RetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid retention request", http.StatusBadRequest)
+		return
+	}
+
+	change := &domain.RetentionChange{
+		FileID:    chi.URLParam(r, "fileId"),
+		LegalHold: req.LegalHold,
+		Actor:     claims.UserID,
+		Reason:    req.Reason,
+	}
+	if req.RetainUntil != nil {
+		change.RetainUntil = *req.RetainUntil
+	}
+	if err := h.metadataService.SetFileRetention(r.Context(), change); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RetentionHandler) writeError(w http.ResponseWriter, err error) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, "File not found", http.StatusNotFound)
+	case codes.FailedPrecondition:
+		http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+	case codes.Unimplemented:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotImplemented)
+	default:
+		h.logger.Error().Err(err).Msg("Retention request failed")
+		http.Error(w, "Retention request failed", http.StatusInternalServerError)
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
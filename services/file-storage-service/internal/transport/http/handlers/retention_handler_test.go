@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/auth"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRetentionHandler_SetRetention(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	metadataService := metadata.NewMockMetadataService(gomock.NewController(t))
+	h := NewRetentionHandler(metadataService, &testLogger)
+	r := chi.NewRouter()
+	r.Put("/retention/{fileId}", h.SetRetention)
+
+	// The actor comes from the token, whatever the body says
+	metadataService.EXPECT().SetFileRetention(gomock.Any(), &domain.RetentionChange{
+		FileID:    "file-1",
+		LegalHold: true,
+		Actor:     "admin-1",
+		Reason:    "litigation",
+	}).Return(nil)
+
+	for _, tc := range []struct {
+		name   string
+		claims *auth.Claims
+		want   int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"not an admin", &auth.Claims{UserID: "user-1", Roles: []string{"editor"}}, http.StatusForbidden},
+		{"admin", &auth.Claims{UserID: "admin-1", Roles: []string{"admin"}}, http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"legal_hold": true, "actor": "someone-else", "reason": "litigation"}`
+			req := httptest.NewRequest(http.MethodPut, "/retention/file-1", strings.NewReader(body))
+			if tc.claims != nil {
+				req = req.WithContext(auth.ContextWithClaims(req.Context(), tc.claims))
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("PUT status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+)
+
+const (
+	defaultFindingsLimit = 100
+	maxFindingsLimit     = 1000
+)
+
+// Scrubber is the part of the integrity scrubber the admin endpoints use
+type Scrubber interface {
+	Status() storage.ScrubStatus
+	Findings(ctx context.Context, limit int) ([]*domain.QuarantinedFile, error)
+	Start(ctx context.Context) error
+}
+
+type ScrubHandler struct {
+	scrubber Scrubber
+	logger   *logger.Logger
+}
+
+type quarantinedFileResponse struct {
+	FileID           string    `json:"file_id"`
+	ExpectedChecksum string    `json:"expected_checksum"`
+	ActualChecksum   string    `json:"actual_checksum"`
+	QuarantinePath   string    `json:"quarantine_path"`
+	DetectedAt       time.Time `json:"detected_at"`
+}
+
+func NewScrubHandler(scrubber Scrubber, logger *logger.Logger) ScrubHandler {
+	return ScrubHandler{
+		scrubber: scrubber,
+		logger:   logger,
+	}
+}
+
+// ScrubStatus reports the progress of the integrity scrub and the most recent
+// quarantined files, up to the limit query parameter
+func (h *ScrubHandler) ScrubStatus(w http.ResponseWriter, r *http.Request) {
+	limit := defaultFindingsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxFindingsLimit)
+	}
+
+	findings, err := h.scrubber.Findings(r.Context(), limit)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list quarantined files")
+		http.Error(w, "Failed to list quarantined files", http.StatusInternalServerError)
+		return
+	}
+	quarantined := make([]quarantinedFileResponse, 0, len(findings))
+	for _, finding := range findings {
+		quarantined = append(quarantined, quarantinedFileResponse{
+			FileID:           finding.FileID,
+			ExpectedChecksum: finding.ExpectedChecksum,
+			ActualChecksum:   finding.ActualChecksum,
+			QuarantinePath:   finding.QuarantinePath,
+			DetectedAt:       finding.DetectedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      h.scrubber.Status(),
+		"quarantined": quarantined,
+	})
+}
+
+// StartScrub starts an integrity scrub in the background
+func (h *ScrubHandler) StartScrub(w http.ResponseWriter, r *http.Request) {
+	// The scrub outlives the request
+	if err := h.scrubber.Start(context.WithoutCancel(r.Context())); err != nil {
+		if errors.Is(err, storage.ErrScrubInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to start integrity scrub")
+		http.Error(w, "Failed to start integrity scrub", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(h.scrubber.Status())
+}
@@ -1,26 +1,44 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload"
 	service "github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"google.golang.org/grpc/codes"
 )
 
 const (
-	maxFileSize = 10 * 1024 * 1024 // 10MB
+	maxFileSize     = 10 * 1024 * 1024 // 10MB
+	maxFormOverhead = 1024 * 1024      // multipart headers and form fields
+	maxFieldSize    = 4 * 1024
+
+	// digestFieldPrefix marks the fields carrying an expected digest of the
+	// content, e.g. digest_md5
+	digestFieldPrefix = "digest_"
 )
 
+// requiredUploadFields are the form fields CreateFile needs before the file part
+var requiredUploadFields = []string{"file_id", "storage_upload_token", "file_size"}
+
+// errFieldAfterFile fails the upload when a form field follows the file part
+var errFieldAfterFile = errors.New("form field follows the file part")
+
 type UploadHandler interface {
 	CreateFile(w http.ResponseWriter, r *http.Request)
 	GetFile(w http.ResponseWriter, r *http.Request)
+	HeadFile(w http.ResponseWriter, r *http.Request)
 	DeleteFile(w http.ResponseWriter, r *http.Request)
 	Upload(w http.ResponseWriter, r *http.Request)
 }
@@ -47,20 +65,31 @@ func (h *UploadHandlerImpl) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := make(map[string]string)
+	for name := range r.URL.Query() {
+		query[name] = r.URL.Query().Get(name)
+	}
+	digests := expectedDigests(query)
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		digests[upload.DigestMD5] = contentMD5
+	}
+
 	req := &upload.UploadRequest{
 		FileID:             r.FormValue("fileId"),
 		StorageUploadToken: r.FormValue("token"),
 		FileSizeBytes:      fileSize,
 		FileContent:        r.Body,
 		UserID:             r.FormValue("userId"),
+		ExpectedDigests:    digests,
 	}
 
 	resp, err := h.uploadService.Upload(r.Context(), req)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to upload file")
-		w.WriteHeader(http.StatusInternalServerError)
+		code := uploadErrorStatus(err)
+		w.WriteHeader(code)
 		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Internal server error",
+			"message": http.StatusText(code),
 			"error":   err.Error(),
 		})
 		return
@@ -70,6 +99,12 @@ func (h *UploadHandlerImpl) Upload(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// CreateFile streams a multipart upload straight into the upload service.
+// The file_id, storage_upload_token and file_size fields must precede the
+// file part, so the file content never has to be buffered, as must any
+// digest_<algorithm> fields the content is verified against. A field sent
+// after the file part fails the upload with 400 Bad Request instead of being
+// ignored.
 func (h *UploadHandlerImpl) CreateFile(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
@@ -80,72 +115,201 @@ func (h *UploadHandlerImpl) CreateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form
-	if err := r.ParseMultipartForm(maxFileSize); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to parse multipart form")
-		http.Error(w, "File too large", http.StatusBadRequest)
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize+maxFormOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to read multipart form")
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
 		return
 	}
 
-	fileId := r.FormValue("file_id")
-	if fileId == "" {
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			h.logger.Error().Msg("No file uploaded")
+			http.Error(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to read multipart form")
+			http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+			return
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+			part.Close()
+			if err != nil {
+				h.logger.Error().Err(err).Str("field", part.FormName()).Msg("Failed to read form field")
+				http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		defer part.Close()
+		if !h.validateUploadFields(w, fields) {
+			return
+		}
+
+		content := &filePart{part: part, reader: reader}
+		resp, err := h.uploadService.Upload(ctx, &service.UploadRequest{
+			FileID:             fields["file_id"],
+			StorageUploadToken: fields["storage_upload_token"],
+			// FileSizeBytes:      fileSizeStr,
+			FileContent:     content,
+			ExpectedDigests: expectedDigests(fields),
+		})
+		// The upload service may hide the reader's error, so check the part
+		// too
+		if content.trailing != "" {
+			h.logger.Error().Err(err).Str("field", content.trailing).Msg("Form field follows the file part")
+			http.Error(w, fmt.Sprintf("Form field %s must precede the file part", content.trailing), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to upload file")
+			http.Error(w, "Failed to upload file", uploadErrorStatus(err))
+			return
+		}
+
+		// Return response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&resp)
+		return
+	}
+}
+
+// uploadErrorStatus maps an upload service error to an HTTP status code
+func uploadErrorStatus(err error) int {
+	var uploadErr *service.UploadError
+	if !errors.As(err, &uploadErr) {
+		return http.StatusInternalServerError
+	}
+	switch uploadErr.Code {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition, codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusRequestEntityTooLarge
+	case codes.OutOfRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// expectedDigests collects the digest_<algorithm> fields by algorithm
+func expectedDigests(fields map[string]string) map[string]string {
+	digests := make(map[string]string)
+	for name, value := range fields {
+		if algorithm, ok := strings.CutPrefix(name, digestFieldPrefix); ok && value != "" {
+			digests[algorithm] = value
+		}
+	}
+	return digests
+}
+
+// filePart reads the file part of a multipart upload and, once it ends,
+// fails with errFieldAfterFile if another part follows it
+type filePart struct {
+	part     *multipart.Part
+	reader   *multipart.Reader
+	err      error
+	trailing string
+}
+
+func (p *filePart) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	n, err := p.part.Read(b)
+	if err != io.EOF {
+		return n, err
+	}
+	next, err := p.reader.NextPart()
+	switch {
+	case err == io.EOF:
+		p.err = io.EOF
+	case err != nil:
+		p.err = err
+	default:
+		p.trailing = next.FormName()
+		next.Close()
+		p.err = fmt.Errorf("%w: %s", errFieldAfterFile, p.trailing)
+	}
+	return n, p.err
+}
+
+// validateUploadFields checks the form fields that must precede the file part
+func (h *UploadHandlerImpl) validateUploadFields(w http.ResponseWriter, fields map[string]string) bool {
+	for _, name := range requiredUploadFields {
+		if _, ok := fields[name]; !ok {
+			h.logger.Error().Str("field", name).Msg("Form field missing before the file part")
+			http.Error(w, fmt.Sprintf("Form field %s must precede the file part", name), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if fields["file_id"] == "" {
 		h.logger.Error().Str("field", "file_id").Msg("File ID cannot be empty")
 		http.Error(w, "File ID cannot be empty", http.StatusBadRequest)
-		return
+		return false
 	}
 
 	// TODO: validate storage upload token
-	storageUploadToken := r.FormValue("storage_upload_token")
-	if storageUploadToken == "" {
+	if fields["storage_upload_token"] == "" {
 		h.logger.Error().Str("field", "storage_upload_token").Msg("Storage upload token cannot be empty")
 		http.Error(w, "Storage upload token cannot be empty", http.StatusBadRequest)
-		return
+		return false
 	}
 
-	fileSizeStr := r.FormValue("file_size")
-	if fileSizeStr == "" {
+	if fields["file_size"] == "" {
 		h.logger.Error().Str("field", "file_size").Msg("File size cannot be empty")
 		http.Error(w, "File size cannot be empty", http.StatusBadRequest)
-		return
+		return false
 	}
+	return true
+}
 
-	// Extract file from request
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		h.logger.Error().Err(err).Msg("No file uploaded")
-		http.Error(w, "No file uploaded", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
+func (h *UploadHandlerImpl) GetFile(w http.ResponseWriter, r *http.Request) {
+}
 
-	fileContentBuffer := bytes.NewBuffer(nil)
-	buffer := make([]byte, 4*1024)
-	_, err = io.CopyBuffer(fileContentBuffer, io.LimitReader(file, maxFileSize), buffer)
-	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to read file")
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+// HeadFile describes a stored file in the response headers: its size,
+// modification time, checksum as the ETag and storage class. Only the owner,
+// given by the userId query parameter, may inspect the file.
+func (h *UploadHandlerImpl) HeadFile(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "fileId")
+	userID := r.URL.Query().Get("userId")
+	if fileID == "" || userID == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	resp, err := h.uploadService.Upload(ctx, &service.UploadRequest{
-		FileID:             fileId,
-		StorageUploadToken: storageUploadToken,
-		// FileSizeBytes:      fileSizeStr,
-		FileContent: fileContentBuffer,
-	})
+	info, err := h.uploadService.Stat(r.Context(), userID, fileID)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to upload file")
-		http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+		h.logger.Error().Err(err).Str("fileId", fileID).Msg("Failed to stat file")
+		w.WriteHeader(uploadErrorStatus(err))
 		return
 	}
 
-	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&resp)
-	w.WriteHeader(http.StatusCreated)
-}
-
-func (h *UploadHandlerImpl) GetFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Checksum != "" {
+		w.Header().Set("ETag", strconv.Quote(info.Checksum))
+	}
+	w.Header().Set("X-Storage-Class", info.StorageClass)
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h *UploadHandlerImpl) DeleteFile(w http.ResponseWriter, r *http.Request) {
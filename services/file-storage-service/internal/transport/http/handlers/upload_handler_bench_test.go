@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+)
+
+// zeroReader yields zero bytes forever without allocating
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// streamMultipart writes the upload form into a pipe so the request body is
+// produced on demand rather than held in memory
+func streamMultipart(size int64) (io.Reader, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		mw.WriteField("file_id", "file-id")
+		mw.WriteField("storage_upload_token", "token")
+		mw.WriteField("file_size", fmt.Sprint(size))
+		part, _ := mw.CreateFormFile("file", "data.csv")
+		io.Copy(part, io.LimitReader(zeroReader{}, size))
+		pw.CloseWithError(mw.Close())
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+// BenchmarkUploadHandler_CreateFile shows that the handler streams the file
+// part to the upload service: B/op stays flat as the file size grows.
+func BenchmarkUploadHandler_CreateFile(b *testing.B) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	mockUpload := upload.NewMockUploadService(gomock.NewController(b))
+	mockUpload.EXPECT().Upload(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *upload.UploadRequest) (*upload.UploadResponse, error) {
+			if _, err := io.Copy(io.Discard, req.FileContent); err != nil {
+				return nil, err
+			}
+			return &upload.UploadResponse{FileID: req.FileID}, nil
+		}).AnyTimes()
+	h := NewFileUploadHandler(&testLogger, mockUpload)
+
+	for _, size := range []int64{1 << 20, 4 << 20, 8 << 20} {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				body, contentType := streamMultipart(size)
+				req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", body)
+				req.Header.Set("Content-Type", contentType)
+				rec := httptest.NewRecorder()
+
+				h.CreateFile(rec, req)
+				if rec.Code != http.StatusCreated {
+					b.Fatalf("CreateFile() status = %d, body = %s", rec.Code, rec.Body)
+				}
+			}
+		})
+	}
+}
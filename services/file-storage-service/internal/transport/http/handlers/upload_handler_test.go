@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+)
+
+func TestUploadHandler_HeadFile(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	uploadService := upload.NewMockUploadService(gomock.NewController(t))
+	h := NewFileUploadHandler(&testLogger, uploadService)
+	r := chi.NewRouter()
+	r.Head("/get/{fileId}", h.HeadFile)
+
+	modTime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	uploadService.EXPECT().Stat(gomock.Any(), "user-1", "file-a").Return(&file.ObjectInfo{
+		FileID:       "file-a",
+		Size:         42,
+		ModTime:      modTime,
+		Checksum:     "abc",
+		StorageClass: "cold",
+	}, nil)
+	uploadService.EXPECT().Stat(gomock.Any(), "user-1", "file-b").
+		Return(nil, &upload.UploadError{Code: codes.NotFound, Message: "file not found"})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/get/file-a?userId=user-1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HEAD status = %d, want 200", rec.Code)
+	}
+	want := map[string]string{
+		"Content-Length":  "42",
+		"Last-Modified":   modTime.Format(http.TimeFormat),
+		"ETag":            `"abc"`,
+		"X-Storage-Class": "cold",
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("HEAD returned a body of %d bytes", rec.Body.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/get/file-b?userId=user-1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD missing file status = %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/get/file-a", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("HEAD without a user status = %d, want 400", rec.Code)
+	}
+}
+
+func TestUploadHandler_UploadExpectedDigests(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	uploadService := upload.NewMockUploadService(gomock.NewController(t))
+	h := NewFileUploadHandler(&testLogger, uploadService)
+
+	uploadService.EXPECT().Upload(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *upload.UploadRequest) (*upload.UploadResponse, error) {
+			want := map[string]string{"md5": "XrY7u+Ae7tCTyyK7j1rNww==", "crc32c": "c99465aa"}
+			if !maps.Equal(req.ExpectedDigests, want) {
+				t.Errorf("ExpectedDigests = %v, want %v", req.ExpectedDigests, want)
+			}
+			return nil, &upload.UploadError{Code: codes.InvalidArgument, Message: "upload does not match the expected digest"}
+		})
+
+	req := httptest.NewRequest(http.MethodPost, "/upload?fileId=file-a&token=t&digest_crc32c=c99465aa", strings.NewReader("hello world"))
+	req.Header.Set("Content-Length", "11")
+	req.Header.Set("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+	rec := httptest.NewRecorder()
+	h.Upload(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Upload status = %d, want 400", rec.Code)
+	}
+}
+
+func TestUploadHandler_CreateFileFieldOrder(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	uploadService := upload.NewMockUploadService(gomock.NewController(t))
+	h := NewFileUploadHandler(&testLogger, uploadService)
+
+	// Only the upload with a field after the file part reaches the service,
+	// which must see the content fail
+	uploadService.EXPECT().Upload(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *upload.UploadRequest) (*upload.UploadResponse, error) {
+			_, err := io.Copy(io.Discard, req.FileContent)
+			if !errors.Is(err, errFieldAfterFile) {
+				t.Errorf("reading the file part error = %v, want errFieldAfterFile", err)
+			}
+			return nil, &upload.UploadError{Code: codes.Internal, Message: "failed to store file", Err: err}
+		})
+
+	for name, tt := range map[string]struct {
+		before, after []string
+		wantField     string
+	}{
+		"field after the file part": {
+			before:    []string{"file_id", "storage_upload_token", "file_size"},
+			after:     []string{"digest_md5"},
+			wantField: "digest_md5",
+		},
+		"required field after the file part": {
+			before:    []string{"file_id", "storage_upload_token"},
+			after:     []string{"file_size"},
+			wantField: "file_size",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for _, field := range tt.before {
+				mw.WriteField(field, "value")
+			}
+			part, _ := mw.CreateFormFile("file", "data.csv")
+			part.Write([]byte("hello world"))
+			for _, field := range tt.after {
+				mw.WriteField(field, "value")
+			}
+			mw.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			h.CreateFile(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("CreateFile() status = %d, want 400", rec.Code)
+			}
+			if want := "Form field " + tt.wantField + " must precede the file part"; !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("CreateFile() body = %q, want %q", rec.Body, want)
+			}
+		})
+	}
+}
@@ -7,9 +7,10 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/go-chi/httprate"
 	handler "github.com/yaanno/upload-store-process/services/file-storage-service/internal/transport/http/handlers"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/auth"
 )
 
-func SetupRouter(uploadHandler handler.UploadHandler, healthCheckHandler handler.HealthHandler, housekeepingHandler handler.HouseKeepingHandler) chi.Router {
+func SetupRouter(uploadHandler handler.UploadHandler, healthCheckHandler handler.HealthHandler, housekeepingHandler handler.HouseKeepingHandler, scrubHandler handler.ScrubHandler, reconcileHandler handler.ReconcileHandler, retentionHandler handler.RetentionHandler, bucketHandler handler.BucketHandler, tokenValidator auth.TokenValidator) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(httprate.LimitByIP(100, 1*time.Minute))
@@ -17,9 +18,9 @@ func SetupRouter(uploadHandler handler.UploadHandler, healthCheckHandler handler
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "ETag", "Last-Modified", "X-Storage-Class"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -28,14 +29,22 @@ func SetupRouter(uploadHandler handler.UploadHandler, healthCheckHandler handler
 		// Health check
 		r.Get("/healthz", healthCheckHandler.Healtz)
 		r.Get("/housekeeping", housekeepingHandler.CleanupMetadata)
+		r.Post("/housekeeping/trash", housekeepingHandler.PurgeTrash)
+		r.Get("/scrub", scrubHandler.ScrubStatus)
+		r.Post("/scrub", scrubHandler.StartScrub)
+		r.Post("/reconcile", reconcileHandler.Reconcile)
+		r.Get("/retention/{fileId}", retentionHandler.GetRetention)
+		r.With(auth.HTTPMiddleware(tokenValidator)).Put("/retention/{fileId}", retentionHandler.SetRetention)
 		// Bucket operations
-		// r.Put("/bucket", uploadHandler.CreateBucket)
-		// r.Delete("/bucket", uploadHandler.DeleteBucket)
-		// r.Get("/buckets", uploadHandler.GetBuckets)
-		// r.Get("/bucket", uploadHandler.GetBucket)
+		r.Put("/bucket/{bucket}", bucketHandler.CreateBucket)
+		r.Patch("/bucket/{bucket}", bucketHandler.UpdateBucket)
+		r.Delete("/bucket/{bucket}", bucketHandler.DeleteBucket)
+		r.Get("/buckets", bucketHandler.GetBuckets)
+		r.Get("/bucket/{bucket}", bucketHandler.GetBucket)
 		// File operations
 		r.Put("/upload", uploadHandler.CreateFile)
 		r.Get("/get/:fileId", uploadHandler.GetFile)
+		r.Head("/get/{fileId}", uploadHandler.HeadFile)
 		r.Delete("/delete/:fileId", uploadHandler.DeleteFile)
 	})
 
@@ -0,0 +1,162 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Digest algorithms the upload pipeline can compute
+const (
+	DigestMD5    = "md5"
+	DigestCRC32C = "crc32c"
+	DigestSHA256 = "sha256"
+)
+
+var (
+	// ErrUnsupportedDigest is returned for a digest algorithm not listed above
+	ErrUnsupportedDigest = errors.New("unsupported digest algorithm")
+
+	// ErrDigestMismatch is returned when uploaded content does not match an
+	// expected digest
+	ErrDigestMismatch = errors.New("digest mismatch")
+)
+
+// defaultDigests are computed when no algorithms are configured
+var defaultDigests = []string{DigestSHA256}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var digestHashes = map[string]func() hash.Hash{
+	DigestMD5:    md5.New,
+	DigestCRC32C: func() hash.Hash { return crc32.New(crc32cTable) },
+	DigestSHA256: sha256.New,
+}
+
+// normalizeDigestAlgorithm accepts names like "SHA-256" for "sha256"
+func normalizeDigestAlgorithm(algorithm string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(algorithm)), "-", "")
+}
+
+// ValidateDigestAlgorithms checks that every algorithm can be computed
+func ValidateDigestAlgorithms(algorithms []string) error {
+	for _, algorithm := range algorithms {
+		if _, ok := digestHashes[normalizeDigestAlgorithm(algorithm)]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnsupportedDigest, algorithm)
+		}
+	}
+	return nil
+}
+
+// digestReader computes digests of the content read through it in a single
+// pass. Once the content is read it checks the expected digests and, on a
+// mismatch, fails instead of returning io.EOF so the upload is not kept.
+type digestReader struct {
+	r        io.Reader
+	w        io.Writer
+	hashes   map[string]hash.Hash
+	expected map[string][]byte
+	err      error
+}
+
+// newDigestReader computes algorithms plus any algorithm an expected digest
+// is given for. Expected digests may be hex or base64 encoded.
+func newDigestReader(r io.Reader, algorithms []string, expected map[string]string) (*digestReader, error) {
+	d := &digestReader{
+		r:        r,
+		hashes:   make(map[string]hash.Hash),
+		expected: make(map[string][]byte, len(expected)),
+	}
+	add := func(algorithm string) (hash.Hash, error) {
+		algorithm = normalizeDigestAlgorithm(algorithm)
+		if h, ok := d.hashes[algorithm]; ok {
+			return h, nil
+		}
+		newHash, ok := digestHashes[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedDigest, algorithm)
+		}
+		d.hashes[algorithm] = newHash()
+		return d.hashes[algorithm], nil
+	}
+
+	for _, algorithm := range algorithms {
+		if _, err := add(algorithm); err != nil {
+			return nil, err
+		}
+	}
+	for algorithm, digest := range expected {
+		h, err := add(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := decodeDigest(digest, h.Size())
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s digest: %w", algorithm, err)
+		}
+		d.expected[normalizeDigestAlgorithm(algorithm)] = sum
+	}
+
+	writers := make([]io.Writer, 0, len(d.hashes))
+	for _, h := range d.hashes {
+		writers = append(writers, h)
+	}
+	d.w = io.MultiWriter(writers...)
+	return d, nil
+}
+
+// decodeDigest decodes a hex or base64 digest of size bytes
+func decodeDigest(digest string, size int) ([]byte, error) {
+	digest = strings.TrimSpace(digest)
+	if sum, err := hex.DecodeString(digest); err == nil && len(sum) == size {
+		return sum, nil
+	}
+	if sum, err := base64.StdEncoding.DecodeString(digest); err == nil && len(sum) == size {
+		return sum, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes, hex or base64 encoded", size)
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	// Writing to a hash never fails
+	d.w.Write(p[:n])
+	if err == io.EOF {
+		if d.err = d.verify(); d.err != nil {
+			return n, d.err
+		}
+	}
+	return n, err
+}
+
+// verify compares the digests with the expected ones
+func (d *digestReader) verify() error {
+	for algorithm, expected := range d.expected {
+		if actual := d.hashes[algorithm].Sum(nil); !bytes.Equal(actual, expected) {
+			return fmt.Errorf("%w: %s is %x, expected %x", ErrDigestMismatch, algorithm, actual, expected)
+		}
+	}
+	return nil
+}
+
+// mismatch reports whether the content did not match an expected digest
+func (d *digestReader) mismatch() bool {
+	return errors.Is(d.err, ErrDigestMismatch)
+}
+
+// sums returns the hex-encoded digests keyed by algorithm
+func (d *digestReader) sums() map[string]string {
+	sums := make(map[string]string, len(d.hashes))
+	for algorithm, h := range d.hashes {
+		sums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
@@ -0,0 +1,76 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDigestReader_ComputesDigestsInOnePass(t *testing.T) {
+	d, err := newDigestReader(strings.NewReader("hello world"), []string{"md5", "CRC32C", "SHA-256"}, nil)
+	if err != nil {
+		t.Fatalf("newDigestReader() error = %v", err)
+	}
+	if _, err := io.Copy(io.Discard, d); err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+
+	want := map[string]string{
+		DigestMD5:    "5eb63bbbe01eeed093cb22bb8f5acdc3",
+		DigestCRC32C: "c99465aa",
+		DigestSHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+	}
+	sums := d.sums()
+	if len(sums) != len(want) {
+		t.Errorf("sums() = %v, want %v", sums, want)
+	}
+	for algorithm, digest := range want {
+		if sums[algorithm] != digest {
+			t.Errorf("%s = %q, want %q", algorithm, sums[algorithm], digest)
+		}
+	}
+}
+
+func TestDigestReader_VerifiesExpectedDigests(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]string
+		wantErr  error
+	}{
+		{name: "hex", expected: map[string]string{"md5": "5EB63BBBE01EEED093CB22BB8F5ACDC3"}},
+		{name: "base64", expected: map[string]string{"md5": "XrY7u+Ae7tCTyyK7j1rNww=="}},
+		{name: "mismatch", expected: map[string]string{"crc32c": "00000000"}, wantErr: ErrDigestMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDigestReader(strings.NewReader("hello world"), []string{DigestSHA256}, tt.expected)
+			if err != nil {
+				t.Fatalf("newDigestReader() error = %v", err)
+			}
+			_, err = io.Copy(io.Discard, d)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("read error = %v, want %v", err, tt.wantErr)
+			}
+			if d.mismatch() != (tt.wantErr != nil) {
+				t.Errorf("mismatch() = %v", d.mismatch())
+			}
+			// Algorithms with an expected digest are computed too
+			if len(d.sums()) != 2 {
+				t.Errorf("sums() = %v, want sha256 and the expected algorithm", d.sums())
+			}
+		})
+	}
+}
+
+func TestDigestReader_RejectsInvalidDigests(t *testing.T) {
+	if _, err := newDigestReader(strings.NewReader(""), []string{"sha1"}, nil); !errors.Is(err, ErrUnsupportedDigest) {
+		t.Errorf("unsupported algorithm error = %v, want ErrUnsupportedDigest", err)
+	}
+	if _, err := newDigestReader(strings.NewReader(""), nil, map[string]string{"md5": "abc"}); err == nil {
+		t.Error("malformed expected digest was accepted")
+	}
+	if err := ValidateDigestAlgorithms([]string{"md5", "crc32c", "sha256", "blake3"}); !errors.Is(err, ErrUnsupportedDigest) {
+		t.Errorf("ValidateDigestAlgorithms() error = %v, want ErrUnsupportedDigest", err)
+	}
+}
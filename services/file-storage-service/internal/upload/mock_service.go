@@ -13,6 +13,7 @@ import (
 	context "context"
 	reflect "reflect"
 
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -54,3 +55,18 @@ func (mr *MockUploadServiceMockRecorder) Upload(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockUploadService)(nil).Upload), arg0, arg1)
 }
+
+// Stat mocks base method.
+func (m *MockUploadService) Stat(ctx context.Context, userID, fileID string) (*file.ObjectInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat", ctx, userID, fileID)
+	ret0, _ := ret[0].(*file.ObjectInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockUploadServiceMockRecorder) Stat(ctx, userID, fileID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockUploadService)(nil).Stat), ctx, userID, fileID)
+}
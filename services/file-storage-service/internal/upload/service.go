@@ -2,9 +2,13 @@ package upload
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
 	repository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
 	storage "github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
 	validation "github.com/yaanno/upload-store-process/services/file-storage-service/internal/upload/validation"
@@ -14,22 +18,34 @@ import (
 
 type UploadService interface {
 	Upload(context.Context, *UploadRequest) (*UploadResponse, error)
+	Stat(ctx context.Context, userID, fileID string) (*file.ObjectInfo, error)
 }
 
 type UploadServiceImpl struct {
 	metadataRepo repository.FileMetadataRepository
 	storage      storage.Provider
-	logger       *logger.Logger
+	// quotas is nil when quotas are not enforced
+	quotas repository.QuotaService
+	// digests are the algorithms computed over every upload
+	digests []string
+	logger  *logger.Logger
 }
 
 func NewUploadService(
 	metadataRepo repository.FileMetadataRepository,
 	storage storage.Provider,
+	quotas repository.QuotaService,
+	digests []string,
 	logger *logger.Logger,
 ) *UploadServiceImpl {
+	if len(digests) == 0 {
+		digests = defaultDigests
+	}
 	return &UploadServiceImpl{
 		metadataRepo: metadataRepo,
 		storage:      storage,
+		quotas:       quotas,
+		digests:      digests,
 		logger:       logger,
 	}
 }
@@ -65,32 +81,221 @@ func (s *UploadServiceImpl) Upload(ctx context.Context, req *UploadRequest) (*Up
 		}
 	}
 
-	// Store the file
-	storagePath, err := s.storage.Store(ctx, req.FileID, req.FileContent)
+	// Uploading a new version is refused before anything is stored when
+	// versioning is not enabled
+	var versioner storage.Versioner
+	if metadata.VersionOf != "" {
+		var ok bool
+		if versioner, ok = s.storage.(storage.Versioner); !ok {
+			return nil, &UploadError{
+				Code:    codes.FailedPrecondition,
+				Message: "file versioning is not enabled",
+			}
+		}
+	}
+
+	// Store the file, holding it to the size reserved against the quota and
+	// computing its digests on the way
+	content := &quotaReader{r: req.FileContent, limit: -1}
+	if s.quotas != nil && metadata.Metadata != nil {
+		content.limit = metadata.Metadata.FileSizeBytes
+	}
+	digests, err := newDigestReader(content, s.digests, req.ExpectedDigests)
+	if err != nil {
+		return nil, &UploadError{
+			Code:    codes.InvalidArgument,
+			Message: "invalid expected digest",
+			Err:     err,
+		}
+	}
+	storagePath, err := s.storage.Store(ctx, req.FileID, digests)
+	if err == nil && digests.err != nil {
+		// A layer swallowed the mismatch, so the content was kept anyway
+		if delErr := s.storage.Delete(ctx, req.FileID); delErr != nil {
+			s.logger.Error().Err(delErr).Str("fileId", req.FileID).Msg("Failed to delete mismatched upload")
+		}
+		err = digests.err
+	}
 	if err != nil {
 		metadata.ProcessingStatus = string(file.StatusPending)
+		if s.quotas != nil {
+			// Without its reservation the upload cannot be retried
+			if relErr := s.quotas.Release(ctx, req.FileID); relErr != nil {
+				s.logger.Error().Err(relErr).Str("fileId", req.FileID).Msg("Failed to release quota reservation")
+			}
+			metadata.ProcessingStatus = string(file.StatusFailed)
+		}
 		_ = s.metadataRepo.UpdateFileMetadata(ctx, metadata)
+
+		// Layers may hide the reader's error, so check the count too
+		if errors.Is(err, domain.ErrQuotaExceeded) || content.exceeded() {
+			return nil, &UploadError{
+				Code:    codes.ResourceExhausted,
+				Message: "upload exceeds the reserved size",
+				Err:     err,
+			}
+		}
+		if errors.Is(err, ErrDigestMismatch) || digests.mismatch() {
+			return nil, &UploadError{
+				Code:    codes.InvalidArgument,
+				Message: "upload does not match the expected digest",
+				Err:     err,
+			}
+		}
 		return nil, &UploadError{
-			Code:    codes.Internal,
+			Code:    storage.ErrorCode(err),
 			Message: "failed to store file",
 			Err:     err,
 		}
 	}
 
+	// Reload metadata, as storage layers record what they did on the record
+	if stored, err := s.metadataRepo.RetrieveFileMetadataByID(ctx, req.FileID); err == nil {
+		metadata = stored
+	}
+
+	// A new version of an existing file becomes its current version before
+	// the upload completes, so a failure leaves no hidden complete record
+	if versioner != nil {
+		if err := versioner.AddVersion(ctx, metadata.VersionOf, metadata.ID); err != nil {
+			s.discardUpload(ctx, metadata)
+			if errors.Is(err, domain.ErrFileRetained) {
+				return nil, &UploadError{
+					Code:    codes.FailedPrecondition,
+					Message: "file is under retention",
+					Err:     err,
+				}
+			}
+			s.logger.Error().Err(err).Str("fileID", metadata.VersionOf).Str("versionId", metadata.ID).Msg("Failed to add file version")
+			return nil, &UploadError{
+				Code:    codes.Internal,
+				Message: "failed to add file version",
+				Err:     err,
+			}
+		}
+	}
+
 	// Update metadata
 	metadata.ProcessingStatus = string(file.StatusComplete)
 	metadata.StoragePath = storagePath
 	metadata.UpdatedAt = time.Now().UTC()
 
+	if s.quotas != nil && metadata.Metadata != nil {
+		metadata.Metadata.FileSizeBytes = content.n
+	}
+	sums := digests.sums()
+	if metadata.Metadata != nil {
+		metadata.Metadata.Digests = sums
+	}
+
 	if err := s.metadataRepo.UpdateFileMetadata(ctx, metadata); err != nil {
 		s.logger.Error().Err(err).Str("fileID", metadata.ID).Msg("Failed to update file metadata")
 	}
 
-	return &UploadResponse{
+	if s.quotas != nil && metadata.Metadata != nil {
+		if err := s.quotas.Commit(ctx, metadata.Metadata.UserId, metadata.ID, content.n); err != nil {
+			s.logger.Error().Err(err).Str("fileID", metadata.ID).Msg("Failed to commit quota reservation")
+		}
+	}
+
+	response := &UploadResponse{
 		FileID:      metadata.ID,
 		StoragePath: storagePath,
+		Digests:     sums,
 		Message:     "File uploaded successfully",
-	}, nil
+	}
+	if metadata.VersionOf != "" {
+		response.FileID = metadata.VersionOf
+		response.VersionID = metadata.ID
+	}
+	return response, nil
+}
+
+// discardUpload deletes the stored content of an upload that cannot complete,
+// releases its quota reservation and marks it FAILED
+func (s *UploadServiceImpl) discardUpload(ctx context.Context, metadata *domain.FileMetadataRecord) {
+	if err := s.storage.Delete(ctx, metadata.ID); err != nil {
+		s.logger.Error().Err(err).Str("fileId", metadata.ID).Msg("Failed to delete discarded upload")
+	}
+	if s.quotas != nil {
+		if err := s.quotas.Release(ctx, metadata.ID); err != nil {
+			s.logger.Error().Err(err).Str("fileId", metadata.ID).Msg("Failed to release quota reservation")
+		}
+	}
+	metadata.ProcessingStatus = string(file.StatusFailed)
+	metadata.UpdatedAt = time.Now().UTC()
+	if err := s.metadataRepo.UpdateFileMetadata(ctx, metadata); err != nil {
+		s.logger.Error().Err(err).Str("fileId", metadata.ID).Msg("Failed to mark discarded upload as failed")
+	}
+}
+
+// Stat describes a stored file of userID without reading its content. Files
+// of other users are reported as not found.
+func (s *UploadServiceImpl) Stat(ctx context.Context, userID, fileID string) (*file.ObjectInfo, error) {
+	isOwner, err := s.metadataRepo.IsFileOwnedByUser(ctx, &domain.FileMetadataListOptions{
+		UserID: userID,
+		FileID: fileID,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Str("fileId", fileID).Msg("failed to check file ownership")
+		return nil, &UploadError{
+			Code:    codes.Internal,
+			Message: "failed to check file ownership",
+			Err:     err,
+		}
+	}
+	if !isOwner {
+		return nil, &UploadError{
+			Code:    codes.NotFound,
+			Message: "file not found",
+		}
+	}
+
+	info, err := s.storage.Stat(ctx, fileID)
+	switch storage.ErrorCode(err) {
+	case codes.NotFound:
+		return nil, &UploadError{
+			Code:    codes.NotFound,
+			Message: "file not found",
+			Err:     err,
+		}
+	case codes.InvalidArgument:
+		return nil, &UploadError{
+			Code:    codes.InvalidArgument,
+			Message: "invalid file ID",
+			Err:     err,
+		}
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("fileId", fileID).Msg("failed to stat file")
+		return nil, &UploadError{
+			Code:    codes.Internal,
+			Message: "failed to stat file",
+			Err:     err,
+		}
+	}
+	return info, nil
+}
+
+// quotaReader counts the content read and fails once it exceeds limit; a
+// negative limit disables the check
+type quotaReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.n += int64(n)
+	if q.exceeded() {
+		return n, fmt.Errorf("%w: upload is larger than the %d bytes reserved", domain.ErrQuotaExceeded, q.limit)
+	}
+	return n, err
+}
+
+func (q *quotaReader) exceeded() bool {
+	return q.limit >= 0 && q.n > q.limit
 }
 
 var _ UploadService = (*UploadServiceImpl)(nil)
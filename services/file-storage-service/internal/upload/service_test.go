@@ -0,0 +1,254 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	file "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/file"
+	domain "github.com/yaanno/upload-store-process/services/file-storage-service/internal/domain/metadata"
+	repository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata"
+	memoryRepository "github.com/yaanno/upload-store-process/services/file-storage-service/internal/metadata/implementations/memory"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/retry"
+	"github.com/yaanno/upload-store-process/services/file-storage-service/internal/storage"
+	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
+	"google.golang.org/grpc/codes"
+)
+
+// TestUploadService_InMemory runs an upload from preparation to download
+// with the in-memory metadata repository and storage provider
+func TestUploadService_InMemory(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	ctx := context.Background()
+
+	metadataRepository, err := repository.NewRepository(repository.Memory, memoryRepository.NewDatabase(), &testLogger)
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	metadataService := repository.NewMetadataService(metadataRepository, nil, nil, nil, &testLogger)
+	provider, err := storage.NewProvider(storage.Memory, nil, metadataService, &testLogger)
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	service := NewUploadService(metadataRepository, provider, nil, nil, &testLogger)
+
+	content := "id,name\n1,alice\n"
+	prepared, err := metadataService.PrepareUpload(ctx, &repository.PrepareUploadParams{
+		FileName: "people.csv",
+		FileSize: int64(len(content)),
+		UserID:   "user-1",
+	})
+	if err != nil {
+		t.Fatalf("PrepareUpload() error = %v", err)
+	}
+	request := &UploadRequest{
+		FileID:             prepared.FileID,
+		StorageUploadToken: prepared.UploadToken,
+		FileSizeBytes:      int64(len(content)),
+		FileContent:        strings.NewReader(content),
+		UserID:             "user-1",
+	}
+	if _, err := service.Upload(ctx, request); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	record, err := metadataService.GetFileMetadata(ctx, "user-1", prepared.FileID)
+	if err != nil {
+		t.Fatalf("GetFileMetadata() error = %v", err)
+	}
+	if record.ProcessingStatus != string(file.StatusComplete) || record.Checksum == "" {
+		t.Errorf("record = %+v, want it COMPLETE with a checksum", record)
+	}
+	reader, err := provider.Retrieve(ctx, prepared.FileID)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	defer reader.Close()
+	if got, _ := io.ReadAll(reader); string(got) != content {
+		t.Errorf("Retrieve() = %q, want %q", got, content)
+	}
+	if info, err := service.Stat(ctx, "user-1", prepared.FileID); err != nil || info.Size != int64(len(content)) {
+		t.Errorf("Stat() = %+v, %v; want %d bytes", info, err, len(content))
+	}
+	var statErr *UploadError
+	if _, err := service.Stat(ctx, "user-2", prepared.FileID); !errors.As(err, &statErr) || statErr.Code != codes.NotFound {
+		t.Errorf("Stat() by another user error = %v, want NotFound", err)
+	}
+
+	// A completed upload cannot be repeated, and other users do not see it
+	request.FileContent = strings.NewReader(content)
+	var uploadErr *UploadError
+	if _, err := service.Upload(ctx, request); !errors.As(err, &uploadErr) || uploadErr.Code != codes.FailedPrecondition {
+		t.Errorf("second Upload() error = %v, want FailedPrecondition", err)
+	}
+	if _, err := metadataService.GetFileMetadata(ctx, "user-2", prepared.FileID); err == nil {
+		t.Error("GetFileMetadata() by another user error = nil")
+	}
+}
+
+// failingVersioner fails to add versions to the files of the provider
+type failingVersioner struct {
+	storage.Provider
+}
+
+func (failingVersioner) AddVersion(ctx context.Context, fileID, versionID string) error {
+	return errors.New("version history unavailable")
+}
+
+func (failingVersioner) RestoreVersion(ctx context.Context, fileID, versionID string) error {
+	return nil
+}
+
+func (failingVersioner) ListVersions(ctx context.Context, fileID string) ([]*domain.FileVersion, error) {
+	return nil, nil
+}
+
+// recordingQuotas grants every reservation and records which are committed
+// and released
+type recordingQuotas struct {
+	repository.QuotaService
+	committed, released []string
+}
+
+func (q *recordingQuotas) Reserve(ctx context.Context, userID string, roles []string, fileID string, size int64) error {
+	return nil
+}
+
+func (q *recordingQuotas) Commit(ctx context.Context, userID, fileID string, size int64) error {
+	q.committed = append(q.committed, fileID)
+	return nil
+}
+
+func (q *recordingQuotas) Release(ctx context.Context, fileID string) error {
+	q.released = append(q.released, fileID)
+	return nil
+}
+
+func TestUploadService_FailedVersionLeavesNothing(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	ctx := context.Background()
+
+	metadataRepository, err := repository.NewRepository(repository.Memory, memoryRepository.NewDatabase(), &testLogger)
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	quotas := &recordingQuotas{}
+	metadataService := repository.NewMetadataService(metadataRepository, quotas, nil, nil, &testLogger)
+	provider, err := storage.NewProvider(storage.Memory, nil, metadataService, &testLogger)
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	service := NewUploadService(metadataRepository, failingVersioner{provider}, quotas, nil, &testLogger)
+
+	upload := func(params *repository.PrepareUploadParams, content string) (string, error) {
+		params.FileSize = int64(len(content))
+		prepared, err := metadataService.PrepareUpload(ctx, params)
+		if err != nil {
+			t.Fatalf("PrepareUpload() error = %v", err)
+		}
+		_, err = service.Upload(ctx, &UploadRequest{
+			FileID:             prepared.FileID,
+			StorageUploadToken: prepared.UploadToken,
+			FileSizeBytes:      int64(len(content)),
+			FileContent:        strings.NewReader(content),
+			UserID:             params.UserID,
+		})
+		return prepared.FileID, err
+	}
+	fileID, err := upload(&repository.PrepareUploadParams{FileName: "people.csv", UserID: "user-1"}, "id,name\n1,alice\n")
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	versionID, err := upload(&repository.PrepareUploadParams{VersionOf: fileID, UserID: "user-1"}, "id,name\n1,bob\n")
+	var uploadErr *UploadError
+	if !errors.As(err, &uploadErr) || uploadErr.Code != codes.Internal {
+		t.Fatalf("Upload() of a version error = %v, want Internal", err)
+	}
+	record, err := metadataRepository.RetrieveFileMetadataByID(ctx, versionID)
+	if err != nil || record.ProcessingStatus != string(file.StatusFailed) {
+		t.Errorf("version record = %+v, %v; want it FAILED", record, err)
+	}
+	if _, err := provider.Stat(ctx, versionID); storage.ErrorCode(err) != codes.NotFound {
+		t.Errorf("Stat() of the failed version error = %v, want NotFound", err)
+	}
+	if !slices.Equal(quotas.committed, []string{fileID}) || !slices.Equal(quotas.released, []string{versionID}) {
+		t.Errorf("committed %v and released %v, want %s committed and %s released", quotas.committed, quotas.released, fileID, versionID)
+	}
+}
+
+// flakyStore fails the first failures stores after reading part of the
+// content, as a disk failing mid-write does
+type flakyStore struct {
+	storage.Provider
+	failures int
+}
+
+func (f *flakyStore) Store(ctx context.Context, fileID string, content io.Reader) (string, error) {
+	if f.failures > 0 {
+		f.failures--
+		io.CopyN(io.Discard, content, 3)
+		return "", fmt.Errorf("failed to write file: %w", syscall.EIO)
+	}
+	return f.Provider.Store(ctx, fileID, content)
+}
+
+func TestUploadService_RetriesStreamedUpload(t *testing.T) {
+	testLogger := logger.Logger{Logger: zerolog.New(nil)}
+	ctx := context.Background()
+
+	metadataRepository, err := repository.NewRepository(repository.Memory, memoryRepository.NewDatabase(), &testLogger)
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	metadataService := repository.NewMetadataService(metadataRepository, nil, nil, nil, &testLogger)
+	provider, err := storage.NewProvider(storage.Memory, nil, metadataService, &testLogger)
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	retrying := storage.NewRetryingProvider(&flakyStore{Provider: provider, failures: 2}, retry.Config{
+		Policy: retry.Policy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+	}, &testLogger)
+	service := NewUploadService(metadataRepository, retrying, nil, nil, &testLogger)
+
+	content := "id,name\n1,alice\n"
+	prepared, err := metadataService.PrepareUpload(ctx, &repository.PrepareUploadParams{
+		FileName: "people.csv",
+		FileSize: int64(len(content)),
+		UserID:   "user-1",
+	})
+	if err != nil {
+		t.Fatalf("PrepareUpload() error = %v", err)
+	}
+	// The request body is a stream that cannot be rewound
+	response, err := service.Upload(ctx, &UploadRequest{
+		FileID:             prepared.FileID,
+		StorageUploadToken: prepared.UploadToken,
+		FileSizeBytes:      int64(len(content)),
+		FileContent:        io.MultiReader(strings.NewReader(content)),
+		UserID:             "user-1",
+	})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte(content))); response.Digests["sha256"] != want {
+		t.Errorf("Upload() digests = %v, want sha256 %s", response.Digests, want)
+	}
+
+	reader, err := provider.Retrieve(ctx, prepared.FileID)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	defer reader.Close()
+	if got, _ := io.ReadAll(reader); string(got) != content {
+		t.Errorf("Retrieve() = %q, want %q", got, content)
+	}
+}
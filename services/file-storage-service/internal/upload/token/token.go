@@ -27,6 +27,27 @@ func GenerateSecureFileID() (string, error) {
 	return base64.URLEncoding.EncodeToString(hash[:]), nil
 }
 
+// FileIDLength is the length of the IDs GenerateSecureFileID produces: a
+// SHA-256 digest in padded URL-safe base64
+const FileIDLength = 44
+
+// IsSecureFileID reports whether fileID has the length and alphabet of the
+// IDs GenerateSecureFileID produces. Neither '/' nor '.' is in that alphabet,
+// so such an ID is always a single, plain path element.
+func IsSecureFileID(fileID string) bool {
+	if len(fileID) != FileIDLength {
+		return false
+	}
+	for i := 0; i < len(fileID); i++ {
+		switch c := fileID[i]; {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // generateSecureUploadToken creates a time-limited, secure upload token
 func GenerateSecureUploadToken(fileID string) (string, error) {
 	expirationTimestamp := time.Now().Add(time.Hour).Unix()
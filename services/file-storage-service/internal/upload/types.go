@@ -8,12 +8,19 @@ type UploadRequest struct {
 	FileSizeBytes      int64
 	FileContent        io.Reader
 	UserID             string
+	// ExpectedDigests maps digest algorithms to the hex or base64 digest the
+	// content must match for the upload to complete
+	ExpectedDigests map[string]string
 }
 
 type UploadResponse struct {
 	FileID      string
 	StoragePath string
 	Message     string
+	// Digests maps digest algorithms to the hex-encoded digests of the content
+	Digests map[string]string
+	// VersionID is set when the upload added a version of FileID
+	VersionID string
 }
 
 type PrepareUploadRequest struct {
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"net/http"
 	"reflect"
 	"strings"
 
@@ -84,12 +85,39 @@ func UnaryServerInterceptor(tokenValidator TokenValidator, skipMethods ...string
 		}
 
 		// Attach claims to context
-		ctx = context.WithValue(ctx, "claims", claims)
+		ctx = ContextWithClaims(ctx, claims)
 
 		return handler(ctx, req)
 	}
 }
 
+// HTTPMiddleware attaches the claims of the bearer token in the Authorization
+// header to the request context. Requests without a token pass through
+// without claims, so handlers decide whether they need them.
+func HTTPMiddleware(tokenValidator TokenValidator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := tokenValidator.ValidateToken(strings.TrimPrefix(authHeader, "Bearer "))
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// ContextWithClaims returns a copy of ctx carrying claims
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, "claims", claims)
+}
+
 // GetClaimsFromContext retrieves claims from context
 func GetClaimsFromContext(ctx context.Context) (*Claims, bool) {
 	claims, ok := ctx.Value("claims").(*Claims)
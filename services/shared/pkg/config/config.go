@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/yaanno/upload-store-process/services/shared/pkg/logger"
@@ -30,6 +31,7 @@ type HttpServerConfig struct {
 }
 
 type DatabaseConfig struct {
+	// Driver is sqlite, or memory to keep file metadata in memory
 	Driver   string `mapstructure:"driver"`
 	Path     string `mapstructure:"path"`
 	Host     string `mapstructure:"host"`
@@ -44,14 +46,198 @@ type NATSConfig struct {
 }
 
 type Storage struct {
-	Provider    string `mapstructure:"provider"`
-	BasePath    string `mapstructure:"base_path"`
-	MaxFileSize int64  `mapstructure:"max_file_size"`
+	Provider    string    `mapstructure:"provider"`
+	BasePath    string    `mapstructure:"base_path"`
+	MaxFileSize int64     `mapstructure:"max_file_size"`
+	S3          S3Storage `mapstructure:"s3"`
+	// ShardDepth and ShardWidth fan local files out into subdirectories,
+	// e.g. depth 2 and width 2 store files at ab/cd/<fileID>
+	ShardDepth  int           `mapstructure:"shard_depth"`
+	ShardWidth  int           `mapstructure:"shard_width"`
+	Compression Compression   `mapstructure:"compression"`
+	Encryption  Encryption    `mapstructure:"encryption"`
+	Mirror      MirrorStorage `mapstructure:"mirror"`
+	Tiering     Tiering       `mapstructure:"tiering"`
+	Quotas      Quotas        `mapstructure:"quotas"`
+	Scrub       Scrub         `mapstructure:"scrub"`
+	Reconcile   Reconcile     `mapstructure:"reconcile"`
+	Versioning  Versioning    `mapstructure:"versioning"`
+	Trash       Trash         `mapstructure:"trash"`
+	// CircuitBreaker guards each storage operation separately
+	CircuitBreaker CircuitBreaker `mapstructure:"circuit_breaker"`
+	// Retry retries storage and metadata operations that fail transiently
+	Retry Retry `mapstructure:"retry"`
+}
+
+// CircuitBreakerSettings configures a circuit breaker: MaxFailures failures
+// in a row open it for ResetTimeout, after which up to HalfOpenProbes
+// requests at a time test whether storage has recovered. Zero values keep
+// the defaults.
+type CircuitBreakerSettings struct {
+	MaxFailures    int           `mapstructure:"max_failures"`
+	ResetTimeout   time.Duration `mapstructure:"reset_timeout"`
+	HalfOpenProbes int           `mapstructure:"half_open_probes"`
+}
+
+// CircuitBreaker configures the storage circuit breakers. Operations
+// overrides the settings of single operations, e.g. "store" or "list".
+type CircuitBreaker struct {
+	CircuitBreakerSettings `mapstructure:",squash"`
+	Operations             map[string]CircuitBreakerSettings `mapstructure:"operations"`
+}
+
+// RetrySettings configures retries with exponential backoff: up to
+// MaxAttempts attempts, the first waiting InitialInterval and each following
+// one Multiplier times longer, up to MaxInterval, for no longer than
+// MaxElapsedTime in all. Zero values keep the defaults; a MaxAttempts of 1
+// disables retries.
+type RetrySettings struct {
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+	MaxInterval     time.Duration `mapstructure:"max_interval"`
+	Multiplier      float64       `mapstructure:"multiplier"`
+	MaxElapsedTime  time.Duration `mapstructure:"max_elapsed_time"`
+}
+
+// RetryPolicy configures the retries of a group of operations. Operations
+// overrides the settings of single operations, e.g. "store" or "retrieve".
+type RetryPolicy struct {
+	RetrySettings `mapstructure:",squash"`
+	Operations    map[string]RetrySettings `mapstructure:"operations"`
+}
+
+// Retry configures the retries of storage provider and metadata repository
+// operations
+type Retry struct {
+	Storage  RetryPolicy `mapstructure:"storage"`
+	Metadata RetryPolicy `mapstructure:"metadata"`
+}
+
+// Trash configures the recycle bin deleted files are moved to. Files are
+// purged for good once they have been in the trash for GracePeriod, checked
+// each Interval.
+type Trash struct {
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+	Interval    time.Duration `mapstructure:"interval"`
+	BatchSize   int           `mapstructure:"batch_size"`
+}
+
+// Versioning lets uploads to an existing file add a version of it. Retain is
+// how many versions of a file are kept, the current one included; zero keeps
+// all of them.
+type Versioning struct {
+	Enabled bool `mapstructure:"enabled"`
+	Retain  int  `mapstructure:"retain"`
+}
+
+// Scrub configures the integrity scrubber, which re-reads every stored file
+// each Interval at up to BytesPerSecond, zero being unlimited
+type Scrub struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Interval       time.Duration `mapstructure:"interval"`
+	BytesPerSecond int64         `mapstructure:"bytes_per_second"`
+}
+
+// Reconcile configures the reconciler, which removes stored files without
+// metadata and metadata without stored files each Interval. With DryRun set
+// it only reports them. Files younger than MinAge are never treated as orphans.
+type Reconcile struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Interval  time.Duration `mapstructure:"interval"`
+	DryRun    bool          `mapstructure:"dry_run"`
+	MinAge    time.Duration `mapstructure:"min_age"`
+	BatchSize int           `mapstructure:"batch_size"`
+}
+
+// Quotas caps what each user may store. A per-user entry wins over role
+// limits, which win over Default; a zero limit is unlimited.
+type Quotas struct {
+	Enabled bool                   `mapstructure:"enabled"`
+	Default QuotaLimits            `mapstructure:"default"`
+	Roles   map[string]QuotaLimits `mapstructure:"roles"`
+	Users   map[string]QuotaLimits `mapstructure:"users"`
+}
+
+type QuotaLimits struct {
+	MaxBytes int64 `mapstructure:"max_bytes"`
+	MaxFiles int64 `mapstructure:"max_files"`
+}
+
+// MirrorStorage configures the mirror provider. Each replica is a local
+// filesystem; WriteQuorum replicas must accept a write, zero meaning all.
+// Replicas that missed a file are repaired every RepairInterval.
+type MirrorStorage struct {
+	Replicas        []MirrorReplica `mapstructure:"replicas"`
+	WriteQuorum     int             `mapstructure:"write_quorum"`
+	RepairInterval  time.Duration   `mapstructure:"repair_interval"`
+	RepairBatchSize int             `mapstructure:"repair_batch_size"`
+}
+
+type MirrorReplica struct {
+	Name     string `mapstructure:"name"`
+	BasePath string `mapstructure:"base_path"`
+}
+
+// Compression configures transparent compression of stored files
+type Compression struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Codec   string `mapstructure:"codec"`
+	Level   int    `mapstructure:"level"`
+	// MinSavings is the fraction a file must shrink by to be stored
+	// compressed; 0 compresses whenever it shrinks at all, unset uses the
+	// default
+	MinSavings *float64 `mapstructure:"min_savings"`
+}
+
+// Encryption configures encryption at rest. MasterKeys maps key IDs to
+// base64-encoded 256-bit keys; new files use CurrentKeyID, the others are kept
+// for reading until rotated off.
+type Encryption struct {
+	Enabled      bool              `mapstructure:"enabled"`
+	CurrentKeyID string            `mapstructure:"current_key_id"`
+	MasterKeys   map[string]string `mapstructure:"master_keys"`
+}
+
+// Tiering configures the tiered provider. Tiers are local directories listed
+// hottest first; policies move files to colder tiers every Interval.
+type Tiering struct {
+	Tiers     []StorageTier `mapstructure:"tiers"`
+	Policies  []TierPolicy  `mapstructure:"policies"`
+	Interval  time.Duration `mapstructure:"interval"`
+	BatchSize int           `mapstructure:"batch_size"`
+	// AccessResolution is how often a file's last access time is updated
+	AccessResolution time.Duration `mapstructure:"access_resolution"`
+}
+
+type StorageTier struct {
+	Name     string `mapstructure:"name"`
+	BasePath string `mapstructure:"base_path"`
+}
+
+// TierPolicy moves files into Tier once older than MinAge and unread for MinIdle
+type TierPolicy struct {
+	Tier    string        `mapstructure:"tier"`
+	MinAge  time.Duration `mapstructure:"min_age"`
+	MinIdle time.Duration `mapstructure:"min_idle"`
+}
+
+// S3Storage configures an S3-compatible storage endpoint (path-style addressing)
+type S3Storage struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	Prefix          string `mapstructure:"prefix"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	PartSize        int64  `mapstructure:"part_size"`
 }
 
 type Upload struct {
 	MaxFileSize int64  `mapstructure:"max_file_size"`
 	GRPCAddress string `mapstructure:"grpc_address"`
+	// Digests lists the algorithms computed over every upload: md5, crc32c
+	// and sha256. Defaults to sha256.
+	Digests []string `mapstructure:"digests"`
 }
 
 type JWT struct {